@@ -1,40 +1,103 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/prometheus/common/model"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/exporter"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/snmp"
 	"gopkg.in/yaml.v3"
 	"k8s.io/klog/v2"
 )
 
+// PDU authentication modes
+const (
+	authBasic   = "basic"
+	authSession = "session"
+)
+
+// defaultModule is used by /probe when no module is given, if it is configured
+const defaultModule = "default"
+
+var defaultExporterLabels = map[string]bool{
+	"use_config_name":   false,
+	"serial_number":     true,
+	"snmp_sys_contact":  false,
+	"snmp_sys_name":     false,
+	"snmp_sys_location": false,
+}
+
 // Config for
 type CliConfig struct {
-	Name       string `short:"n" long:"name" env:"PDU_NAME" description:"Name of the endpoint. Only relevant with multiple endpoints. (default: <name from pdu>)"`
-	Address    string `short:"a" long:"address" env:"PDU_ADDRESS" description:"Address of the PDU JSON RPC endpoint"`
-	Timeout    int    `long:"timeout" default:"10" description:"Timeout of PDU RPC requests in seconds"`
-	Username   string `short:"u" long:"username" env:"PDU_USERNAME" description:"Username for PDU access"`
-	Password   string `short:"p" long:"password" env:"PDU_PASSWORD" description:"Password for PDU access"`
-	Metrics    bool   `long:"metrics" description:"Enable prometheus metrics endpoint"`
-	Port       uint   `long:"port" default:"2112" description:"Prometheus metrics port"`
-	Interval   uint   `short:"i" long:"interval" default:"10" description:"Interval between data scrapes"`
-	ConfigPath string `short:"c" long:"config" value-name:"FILE" description:"path to pool config"`
+	Name            string `short:"n" long:"name" env:"PDU_NAME" description:"Name of the endpoint. Only relevant with multiple endpoints. (default: <name from pdu>)"`
+	Address         string `short:"a" long:"address" env:"PDU_ADDRESS" description:"Address of the PDU JSON RPC endpoint"`
+	Timeout         int    `long:"timeout" default:"10" description:"Timeout of PDU RPC requests in seconds"`
+	BulkSize        int    `long:"bulk-size" default:"0" description:"Maximum number of requests per bulk call, larger ones are split, 0 for no limit"`
+	BulkParallelism int    `long:"bulk-parallelism" default:"1" description:"Number of split bulk calls sent to a PDU at the same time"`
+	Username        string `short:"u" long:"username" env:"PDU_USERNAME" description:"Username for PDU access"`
+	Password        string `short:"p" long:"password" env:"PDU_PASSWORD" description:"Password for PDU access"`
+	Auth            string `long:"auth" default:"basic" choice:"basic" choice:"session" description:"Authenticate with basic auth on every request or log in once with a session"`
+	Metrics         bool   `long:"metrics" description:"Enable prometheus metrics endpoint"`
+	Port            uint   `long:"port" default:"2112" description:"Prometheus metrics port"`
+	Interval        uint   `short:"i" long:"interval" default:"10" description:"Interval between data scrapes"`
+	ConfigPath      string `short:"c" long:"config" value-name:"FILE" description:"path to pool config"`
+
+	LegacyMetricNames bool     `long:"legacy-metric-names" description:"Export all readings as gauges with their original names and values"`
+	CheckConfig       bool     `long:"check-config" description:"Validate the config and exit, non-zero if invalid"`
+	Retries           int      `long:"retries" default:"2" description:"Retries of failed PDU requests, with jittered exponential backoff"`
+	BreakerFailures   int      `long:"breaker-failures" default:"3" description:"Consecutive failed polls before polling of a PDU is paused, 0 disables"`
+	BreakerCooldown   uint     `long:"breaker-cooldown" default:"60" description:"Seconds polling of a failing PDU is paused, doubles while it keeps failing"`
+	Events            bool     `long:"events" description:"Long-poll the event service of PDUs for state and configuration changes between polls"`
+	TLSInsecure       bool     `long:"tls-insecure-skip-verify" description:"Do not verify the certificates of PDUs, only for testing"`
+	TLSCAFile         string   `long:"tls-ca-file" value-name:"FILE" description:"PEM certificates to verify PDUs with instead of the system roots"`
+	TLSServerName     string   `long:"tls-server-name" description:"Name to verify PDU certificates against instead of the address host"`
+	TLSFingerprints   []string `long:"tls-fingerprint" value-name:"SHA256" description:"Pinned SHA-256 fingerprint of a PDU certificate, can be repeated"`
+	TLSCertFile       string   `long:"tls-cert-file" value-name:"FILE" description:"Client certificate for mutual TLS"`
+	TLSKeyFile        string   `long:"tls-key-file" value-name:"FILE" description:"Key of the client certificate"`
+	RecordDir         string   `long:"record-dir" value-name:"DIR" description:"Record all PDU requests and responses to a cassette file per PDU in DIR"`
+
+	Outlet OutletCommand `command:"outlet" description:"Switch the power of an outlet and exit"`
+	// command is the name of the subcommand given, empty to run the exporter
+	command string
 }
 
 type FileConfig struct {
 	// Address   string      `json:"address" yaml:"address"`
-	Timeout        int             `json:"timeout" yaml:"timeout"`
-	Username       string          `json:"username" yaml:"username"`
-	Password       string          `json:"password" yaml:"password"`
-	Metrics        bool            `json:"metrics" yaml:"metrics"`
-	Port           uint            `json:"port" yaml:"port"`
-	Interval       uint            `json:"interval" yaml:"interval"`
-	ExporterLabels map[string]bool `json:"exporter_labels" yaml:"exporter_labels"`
+	Timeout         int             `json:"timeout" yaml:"timeout"`
+	BulkSize        int             `json:"bulk_size" yaml:"bulk_size"`
+	BulkParallelism int             `json:"bulk_parallelism" yaml:"bulk_parallelism"`
+	Username        string          `json:"username" yaml:"username"`
+	Password        string          `json:"password" yaml:"password"`
+	Auth            string          `json:"auth" yaml:"auth"`
+	Metrics         bool            `json:"metrics" yaml:"metrics"`
+	Port            uint            `json:"port" yaml:"port"`
+	Interval        uint            `json:"interval" yaml:"interval"`
+	ExporterLabels  map[string]bool `json:"exporter_labels" yaml:"exporter_labels"`
+	// LegacyMetricNames exports gauges without unit suffixes or conversion
+	LegacyMetricNames bool `json:"legacy_metric_names" yaml:"legacy_metric_names"`
+	// Retries of failed PDU requests, nil for the command line setting
+	Retries *int `json:"retries" yaml:"retries"`
+	// BreakerFailures before polling is paused, nil for the command line setting
+	BreakerFailures *int `json:"breaker_failures" yaml:"breaker_failures"`
+	// BreakerCooldown in seconds
+	BreakerCooldown uint `json:"breaker_cooldown" yaml:"breaker_cooldown"`
+	// Events long-polls the event service of the PDUs between polls
+	Events bool `json:"events" yaml:"events"`
+	// TLS for all PDUs and modules without their own setting
+	TLS TLSConfig `json:"tls" yaml:"tls"`
 	// struct {
 	// 	UseConfigName   *bool `json:"use_config_name" yaml:"use_config_name"`
 	// 	SerialNumber    *bool `json:"serial_number" yaml:"serial_number"`
@@ -42,7 +105,10 @@ type FileConfig struct {
 	// 	SNMPSysName     *bool `json:"snmp_sys_name" yaml:"snmp_sys_name"`
 	// 	SNMPSydLocation *bool `json:"snmp_sys_location" yaml:"snmp_sys_location"`
 	// }
-	PduConfig []PduConfig `json:"pdu_config" yaml:"pdu_config"`
+	PduConfig []PduConfig             `json:"pdu_config" yaml:"pdu_config"`
+	FileSD    []FileSDConfig          `json:"file_sd" yaml:"file_sd"`
+	Modules   map[string]ModuleConfig `json:"modules" yaml:"modules"`
+	Admin     AdminConfig             `json:"admin" yaml:"admin"`
 }
 
 type Config struct {
@@ -50,6 +116,12 @@ type Config struct {
 	Port           uint            `json:"port" yaml:"port"`
 	Interval       uint            `json:"interval" yaml:"interval"`
 	ExporterLabels map[string]bool `json:"exporter_labels" yaml:"exporter_labels"`
+	// LegacyMetricNames exports gauges without unit suffixes or conversion
+	LegacyMetricNames bool `json:"legacy_metric_names" yaml:"legacy_metric_names"`
+	Retries           int  `json:"retries" yaml:"retries"`
+	BreakerFailures   int  `json:"breaker_failures" yaml:"breaker_failures"`
+	BreakerCooldown   uint `json:"breaker_cooldown" yaml:"breaker_cooldown"`
+	Events            bool `json:"events" yaml:"events"`
 	// struct {
 	// 	UseConfigName   *bool `json:"use_config_name" yaml:"use_config_name"`
 	// 	SerialNumber    *bool `json:"serial_number" yaml:"serial_number"`
@@ -57,15 +129,91 @@ type Config struct {
 	// 	SNMPSysName     *bool `json:"snmp_sys_name" yaml:"snmp_sys_name"`
 	// 	SNMPSydLocation *bool `json:"snmp_sys_location" yaml:"snmp_sys_location"`
 	// }
+	// PduConfig holds the configured and the discovered PDUs
 	PduConfig []PduConfig `json:"pdu_config" yaml:"pdu_config"`
+	// FileSD with the file patterns resolved against the config file, watched for changes
+	FileSD  []FileSDConfig          `json:"file_sd" yaml:"file_sd"`
+	Modules map[string]ModuleConfig `json:"modules" yaml:"modules"`
+	Admin   AdminConfig             `json:"admin" yaml:"admin"`
+	// RecordDir for PDU cassettes, only set from the command line
+	RecordDir string `json:"-" yaml:"-"`
 }
 
-type PduConfig struct {
-	Name     string `json:"name" yaml:"name"`
-	Address  string `json:"address" yaml:"address"`
-	Timeout  int    `json:"timeout" yaml:"timeout"`
+// ModuleConfig holds the settings used by the /probe endpoint for a target. The credentials
+// are not inherited from the global ones and must be set.
+type ModuleConfig struct {
+	Timeout         int             `json:"timeout" yaml:"timeout"`
+	BulkSize        int             `json:"bulk_size" yaml:"bulk_size"`
+	BulkParallelism int             `json:"bulk_parallelism" yaml:"bulk_parallelism"`
+	Username        string          `json:"username" yaml:"username"`
+	Password        string          `json:"password" yaml:"password"`
+	ExporterLabels  map[string]bool `json:"exporter_labels" yaml:"exporter_labels"`
+	TLS             TLSConfig       `json:"tls" yaml:"tls"`
+	// Auth is basic or session
+	Auth string `json:"auth" yaml:"auth"`
+	// Backend is jsonrpc or prometheus
+	Backend string `json:"backend" yaml:"backend"`
+}
+
+// pduConfig of a probe of target with the module
+func (m ModuleConfig) pduConfig(target string) PduConfig {
+	return PduConfig{
+		Name:            target,
+		Address:         target,
+		Timeout:         m.Timeout,
+		BulkSize:        m.BulkSize,
+		BulkParallelism: m.BulkParallelism,
+		Username:        m.Username,
+		Password:        m.Password,
+		Auth:            m.Auth,
+		TLS:             m.TLS,
+		Backend:         m.Backend,
+	}
+}
+
+// AdminConfig for the outlet power admin endpoint, served on a listener of its own
+type AdminConfig struct {
+	// Listen address of the admin endpoint, e.g. 127.0.0.1:2113, disabled if empty
+	Listen   string `json:"listen" yaml:"listen"`
 	Username string `json:"username" yaml:"username"`
 	Password string `json:"password" yaml:"password"`
+	// AuditLog file for outlet power actions, appended as JSON lines, required with listen
+	AuditLog string `json:"audit_log" yaml:"audit_log"`
+	// TLS of the admin listener, plain HTTP without a certificate
+	TLS AdminTLSConfig `json:"tls" yaml:"tls"`
+}
+
+// Enabled when a listen address is set
+func (a AdminConfig) Enabled() bool {
+	return a.Listen != ""
+}
+
+// PDU backends
+const (
+	backendJSONRPC    = "jsonrpc"
+	backendSNMP       = "snmp"
+	backendPrometheus = "prometheus"
+)
+
+type PduConfig struct {
+	Name    string `json:"name" yaml:"name"`
+	Address string `json:"address" yaml:"address"`
+	Timeout int    `json:"timeout" yaml:"timeout"`
+	// BulkSize limits the requests per bulk call, BulkParallelism the bulk calls in flight
+	BulkSize        int    `json:"bulk_size" yaml:"bulk_size"`
+	BulkParallelism int    `json:"bulk_parallelism" yaml:"bulk_parallelism"`
+	Username        string `json:"username" yaml:"username"`
+	Password        string `json:"password" yaml:"password"`
+	// Auth is basic or session
+	Auth string    `json:"auth" yaml:"auth"`
+	TLS  TLSConfig `json:"tls" yaml:"tls"`
+	// Backend is jsonrpc, snmp or prometheus, SNMP configures the second
+	Backend string     `json:"backend" yaml:"backend"`
+	SNMP    SNMPConfig `json:"snmp" yaml:"snmp"`
+	// Labels are added to every metric of the PDU
+	Labels map[string]string `json:"labels" yaml:"labels"`
+	// Source is the file_sd file the PDU was discovered from, empty for configured PDUs
+	Source string `json:"-" yaml:"-"`
 }
 
 func (cc *PduConfig) Url() string {
@@ -81,25 +229,73 @@ func (cc *PduConfig) Url() string {
 	return cc.Address
 }
 
+// httpBackend if the PDU is polled over HTTP, with JSON-RPC or its dump
+func (cc *PduConfig) httpBackend() bool {
+	return cc.Backend == backendJSONRPC || cc.Backend == backendPrometheus
+}
+
+// hostPort of the address for backends other than HTTP, which is host, host:port or
+// <scheme>://host:port. The port is 0 if missing.
+func (cc *PduConfig) hostPort(scheme string) (string, uint16, error) {
+	addr := strings.TrimPrefix(cc.Address, scheme+"://")
+	if strings.Contains(addr, "://") {
+		return "", 0, fmt.Errorf("unsupported scheme in address %q", cc.Address)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port
+		host, port = strings.Trim(addr, "[]"), "0"
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("host missing in address %q", cc.Address)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in address %q", cc.Address)
+	}
+	return host, uint16(p), nil
+}
+
+// tlsConfig from the command line
+func (cliConf *CliConfig) tlsConfig() TLSConfig {
+	c := TLSConfig{
+		CAFile:       cliConf.TLSCAFile,
+		ServerName:   cliConf.TLSServerName,
+		Fingerprints: cliConf.TLSFingerprints,
+		CertFile:     cliConf.TLSCertFile,
+		KeyFile:      cliConf.TLSKeyFile,
+	}
+	if cliConf.TLSInsecure {
+		c.InsecureSkipVerify = &cliConf.TLSInsecure
+	}
+	return c
+}
+
 func (cliConf *CliConfig) GetConfig() (*Config, error) {
 	conf := &Config{
-		PduConfig: []PduConfig{},
-		ExporterLabels: map[string]bool{
-			"use_config_name":   false,
-			"serial_number":     true,
-			"snmp_sys_contact":  false,
-			"snmp_sys_name":     false,
-			"snmp_sys_location": false,
-		},
+		PduConfig:      []PduConfig{},
+		Modules:        map[string]ModuleConfig{},
+		ExporterLabels: map[string]bool{},
+	}
+	for k, v := range defaultExporterLabels {
+		conf.ExporterLabels[k] = v
 	}
 
-	if cliConf.Address != "" && cliConf.Username != "" && cliConf.Password != "" {
+	if cliConf.Address != "" && (cliConf.Username == "" || cliConf.Password == "") {
+		return nil, errors.New("--address needs --username and --password")
+	}
+	if cliConf.Address != "" {
 		pduConfig := PduConfig{
-			Name:     cliConf.Name,
-			Address:  cliConf.Address,
-			Username: cliConf.Username,
-			Password: cliConf.Password,
-			Timeout:  cliConf.Timeout,
+			Name:            cliConf.Name,
+			Address:         cliConf.Address,
+			Username:        cliConf.Username,
+			Password:        cliConf.Password,
+			Timeout:         cliConf.Timeout,
+			BulkSize:        cliConf.BulkSize,
+			BulkParallelism: cliConf.BulkParallelism,
+			Auth:            cliConf.Auth,
+			TLS:             cliConf.tlsConfig(),
+			Backend:         backendJSONRPC,
 		}
 		conf.PduConfig = append(conf.PduConfig, pduConfig)
 	}
@@ -113,9 +309,10 @@ func (cliConf *CliConfig) GetConfig() (*Config, error) {
 		for k, v := range fileConfig.ExporterLabels {
 			conf.ExporterLabels[k] = v
 		}
+		tlsConf := fileConfig.TLS.inherit(cliConf.tlsConfig())
 
-		for _, pduConf := range fileConfig.PduConfig {
-
+		// withDefaults fills the settings a PDU does not set from the file and command line
+		withDefaults := func(pduConf PduConfig) PduConfig {
 			if pduConf.Username == "" {
 				if fileConfig.Username != "" {
 					pduConf.Username = fileConfig.Username
@@ -140,67 +337,381 @@ func (cliConf *CliConfig) GetConfig() (*Config, error) {
 				}
 			}
 
-			conf.PduConfig = append(conf.PduConfig, pduConf)
+			if pduConf.BulkSize == 0 {
+				if fileConfig.BulkSize != 0 {
+					pduConf.BulkSize = fileConfig.BulkSize
+				} else {
+					pduConf.BulkSize = cliConf.BulkSize
+				}
+			}
+
+			if pduConf.BulkParallelism == 0 {
+				if fileConfig.BulkParallelism != 0 {
+					pduConf.BulkParallelism = fileConfig.BulkParallelism
+				} else {
+					pduConf.BulkParallelism = cliConf.BulkParallelism
+				}
+			}
+
+			if pduConf.Auth == "" {
+				if fileConfig.Auth != "" {
+					pduConf.Auth = fileConfig.Auth
+				} else {
+					pduConf.Auth = cliConf.Auth
+				}
+			}
+
+			pduConf.TLS = pduConf.TLS.inherit(tlsConf)
+
+			if pduConf.Backend == "" {
+				pduConf.Backend = backendJSONRPC
+			}
+			if pduConf.Backend == backendSNMP && pduConf.SNMP.Community == "" && pduConf.SNMP.Version != snmp.Version3 {
+				pduConf.SNMP.Community = defaultCommunity
+			}
+			return pduConf
+		}
+
+		for _, pduConf := range fileConfig.PduConfig {
+			conf.PduConfig = append(conf.PduConfig, withDefaults(pduConf))
+		}
+
+		dir := filepath.Dir(cliConf.ConfigPath)
+		for _, sd := range fileConfig.FileSD {
+			sd = sd.resolve(dir)
+			pdus, err := sd.discover()
+			if err != nil {
+				return nil, err
+			}
+			for _, pduConf := range pdus {
+				conf.PduConfig = append(conf.PduConfig, withDefaults(pduConf))
+			}
+			conf.FileSD = append(conf.FileSD, sd)
 		}
 		conf.Metrics = fileConfig.Metrics
 		conf.Interval = fileConfig.Interval
 		conf.Port = fileConfig.Port
+		conf.LegacyMetricNames = fileConfig.LegacyMetricNames
+		conf.BreakerCooldown = fileConfig.BreakerCooldown
+		conf.Events = fileConfig.Events
+		conf.Retries = cliConf.Retries
+		if fileConfig.Retries != nil {
+			conf.Retries = *fileConfig.Retries
+		}
+		conf.BreakerFailures = cliConf.BreakerFailures
+		if fileConfig.BreakerFailures != nil {
+			conf.BreakerFailures = *fileConfig.BreakerFailures
+		}
+		conf.Admin = fileConfig.Admin
+
+		// modules have credentials of their own, probes must not send those of the
+		// configured PDUs to any target a scraper names
+		for name, modConf := range fileConfig.Modules {
+			if modConf.Timeout == 0 {
+				if fileConfig.Timeout != 0 {
+					modConf.Timeout = fileConfig.Timeout
+				} else if cliConf.Timeout != 0 {
+					modConf.Timeout = cliConf.Timeout
+				}
+			}
+
+			if modConf.BulkSize == 0 {
+				if fileConfig.BulkSize != 0 {
+					modConf.BulkSize = fileConfig.BulkSize
+				} else {
+					modConf.BulkSize = cliConf.BulkSize
+				}
+			}
+
+			if modConf.BulkParallelism == 0 {
+				if fileConfig.BulkParallelism != 0 {
+					modConf.BulkParallelism = fileConfig.BulkParallelism
+				} else {
+					modConf.BulkParallelism = cliConf.BulkParallelism
+				}
+			}
+
+			if modConf.Auth == "" {
+				if fileConfig.Auth != "" {
+					modConf.Auth = fileConfig.Auth
+				} else {
+					modConf.Auth = cliConf.Auth
+				}
+			}
+
+			if modConf.Backend == "" {
+				modConf.Backend = backendJSONRPC
+			}
+
+			modConf.TLS = modConf.TLS.inherit(tlsConf)
+
+			labels := map[string]bool{}
+			for k, v := range conf.ExporterLabels {
+				labels[k] = v
+			}
+			for k, v := range modConf.ExporterLabels {
+				labels[k] = v
+			}
+			modConf.ExporterLabels = labels
+
+			conf.Modules[name] = modConf
+		}
+	} else {
+		conf.Retries = cliConf.Retries
+		conf.BreakerFailures = cliConf.BreakerFailures
 	}
 
 	conf.Metrics = conf.Metrics || cliConf.Metrics
+	conf.LegacyMetricNames = conf.LegacyMetricNames || cliConf.LegacyMetricNames
+	conf.Events = conf.Events || cliConf.Events
+	conf.RecordDir = cliConf.RecordDir
 	if conf.Port == 0 && cliConf.Port != 0 {
 		conf.Port = cliConf.Port
 	}
 	if conf.Interval == 0 && cliConf.Interval != 0 {
 		conf.Interval = cliConf.Interval
 	}
+	if conf.BreakerCooldown == 0 {
+		conf.BreakerCooldown = cliConf.BreakerCooldown
+	}
 
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
 	return conf, nil
 }
 
+// ReadConfigFromFile reads a YAML or JSON config, unknown keys are an error
 func ReadConfigFromFile(confPath string) (*FileConfig, error) {
 	fc := &FileConfig{}
 
 	content, err := os.ReadFile(confPath)
 	if err != nil {
-		log.Printf("[error] %v\n", err)
+		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
 	if strings.HasSuffix(confPath, ".yaml") || strings.HasSuffix(confPath, ".yml") {
-		err := yaml.Unmarshal(content, fc)
-		if err != nil {
-			log.Printf("[error] %v\n", err)
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		dec.KnownFields(true)
+		if err := dec.Decode(fc); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error parsing config file %s: %w", confPath, err)
 		}
 	} else if strings.HasSuffix(confPath, ".json") {
-		err := json.Unmarshal(content, fc)
-		if err != nil {
-			log.Printf("[error] %v\n", err)
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(fc); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error parsing config file %s: %w", confPath, err)
 		}
+	} else {
+		return nil, fmt.Errorf("unknown config file extension for %s, expected .yaml, .yml or .json", confPath)
 	}
 
 	return fc, nil
 }
 
-func LoadConfig(args []string) (*Config, error) {
+// Validate returns an error listing every problem found in the config
+func (conf *Config) Validate() error {
+	errs := []string{}
+
+	if conf.Interval == 0 {
+		errs = append(errs, "interval must be greater than 0")
+	}
+
+	if conf.Retries < 0 {
+		errs = append(errs, "retries must not be negative")
+	}
+	if conf.BreakerFailures < 0 {
+		errs = append(errs, "breaker_failures must not be negative")
+	}
+	if conf.BreakerFailures > 0 && conf.BreakerCooldown == 0 {
+		errs = append(errs, "breaker_cooldown must be greater than 0")
+	}
+
+	for k := range conf.ExporterLabels {
+		if _, ok := defaultExporterLabels[k]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown exporter label %q", k))
+		}
+	}
+
+	// discovery may find PDUs later, a config without any would start up monitoring nothing
+	if len(conf.PduConfig) == 0 && len(conf.FileSD) == 0 && len(conf.Modules) == 0 {
+		errs = append(errs, "no pdus configured, set --address, pdu_config, file_sd or modules")
+	}
+
+	names := map[string]bool{}
+	for i, p := range conf.PduConfig {
+		id := fmt.Sprintf("pdu_config[%d]", i)
+		if p.Source != "" {
+			id = fmt.Sprintf("%s (%s)", p.Source, p.Address)
+		} else if p.Name != "" {
+			id = fmt.Sprintf("%s (%s)", id, p.Name)
+		}
+
+		if !p.httpBackend() && p.Backend != backendSNMP {
+			errs = append(errs, fmt.Sprintf("%s: backend must be %s, %s or %s", id, backendJSONRPC, backendSNMP, backendPrometheus))
+		}
+
+		if p.Address == "" {
+			errs = append(errs, fmt.Sprintf("%s: address is missing", id))
+		} else if p.Backend == backendSNMP {
+			if _, err := newSNMPClient(p, 0); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: snmp: %v", id, err))
+			}
+		} else if strings.Contains(p.Address, "://") && !strings.HasPrefix(p.Address, "http://") && !strings.HasPrefix(p.Address, "https://") {
+			errs = append(errs, fmt.Sprintf("%s: unsupported scheme in address %q", id, p.Address))
+		} else if u, err := url.Parse(p.Url()); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid address %q: %v", id, p.Address, err))
+		} else if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Sprintf("%s: invalid address %q", id, p.Address))
+		}
+
+		if p.Timeout <= 0 {
+			errs = append(errs, fmt.Sprintf("%s: timeout must be greater than 0", id))
+		}
+		if p.BulkSize < 0 {
+			errs = append(errs, fmt.Sprintf("%s: bulk_size must not be negative", id))
+		}
+		if p.BulkParallelism < 1 {
+			errs = append(errs, fmt.Sprintf("%s: bulk_parallelism must be greater than 0", id))
+		}
+
+		if p.Auth != authBasic && p.Auth != authSession {
+			errs = append(errs, fmt.Sprintf("%s: auth must be %s or %s", id, authBasic, authSession))
+		}
+
+		if _, err := p.TLS.Build(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: tls: %v", id, err))
+		}
+
+		for k := range p.Labels {
+			if err := validateTargetLabel(k); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: label %q %v", id, k, err))
+			}
+		}
+
+		key := pduKey(p)
+		if names[key] {
+			errs = append(errs, fmt.Sprintf("%s: duplicate pdu %q", id, key))
+		}
+		names[key] = true
+	}
+
+	for i, sd := range conf.FileSD {
+		if len(sd.Files) == 0 {
+			errs = append(errs, fmt.Sprintf("file_sd[%d]: files is missing", i))
+		}
+		if sd.Pdu.Name != "" || sd.Pdu.Address != "" {
+			errs = append(errs, fmt.Sprintf("file_sd[%d]: name and address are set by the targets", i))
+		}
+	}
+
+	for name, m := range conf.Modules {
+		if m.Username == "" || m.Password == "" {
+			errs = append(errs, fmt.Sprintf("modules.%s: username and password must be set", name))
+		}
+		if m.Auth != authBasic && m.Auth != authSession {
+			errs = append(errs, fmt.Sprintf("modules.%s: auth must be %s or %s", name, authBasic, authSession))
+		}
+		if m.Backend != backendJSONRPC && m.Backend != backendPrometheus {
+			errs = append(errs, fmt.Sprintf("modules.%s: backend must be %s or %s", name, backendJSONRPC, backendPrometheus))
+		}
+		if m.Timeout <= 0 {
+			errs = append(errs, fmt.Sprintf("modules.%s: timeout must be greater than 0", name))
+		}
+		if m.BulkSize < 0 {
+			errs = append(errs, fmt.Sprintf("modules.%s: bulk_size must not be negative", name))
+		}
+		if m.BulkParallelism < 1 {
+			errs = append(errs, fmt.Sprintf("modules.%s: bulk_parallelism must be greater than 0", name))
+		}
+		if _, err := m.TLS.Build(); err != nil {
+			errs = append(errs, fmt.Sprintf("modules.%s: tls: %v", name, err))
+		}
+		for k := range m.ExporterLabels {
+			// unknown global labels are inherited by every module and already reported
+			_, known := defaultExporterLabels[k]
+			_, global := conf.ExporterLabels[k]
+			if !known && !global {
+				errs = append(errs, fmt.Sprintf("modules.%s: unknown exporter label %q", name, k))
+			}
+		}
+	}
+
+	if (conf.Admin.Username == "") != (conf.Admin.Password == "") {
+		errs = append(errs, "admin: username and password must both be set")
+	}
+	if conf.Admin.Enabled() {
+		if conf.Admin.Username == "" && conf.Admin.Password == "" {
+			errs = append(errs, "admin: username and password must be set with listen")
+		}
+		if conf.Admin.AuditLog == "" {
+			errs = append(errs, "admin: audit_log must be set with listen")
+		}
+		if _, err := conf.Admin.TLS.Build(); err != nil {
+			errs = append(errs, fmt.Sprintf("admin: tls: %v", err))
+		}
+	}
+
+	if conf.RecordDir != "" {
+		if fi, err := os.Stat(conf.RecordDir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Sprintf("record dir %q is not a directory", conf.RecordDir))
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// validateTargetLabel name, which must be a valid Prometheus label name not set by the exporter
+func validateTargetLabel(name string) error {
+	if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+		return errors.New("is not a valid label name")
+	}
+	for _, r := range exporter.ReservedLabels {
+		if name == r {
+			return errors.New("is set by the exporter")
+		}
+	}
+	return nil
+}
+
+// LoadConfig parses args and returns the CLI config with the resulting config
+func LoadConfig(args []string) (*CliConfig, *Config, error) {
 	klogFs := flag.NewFlagSet("klog", flag.ContinueOnError)
 	klog.InitFlags(klogFs)
 	cliConf := &CliConfig{}
 
 	p := flags.NewParser(cliConf, flags.Default|flags.IgnoreUnknown)
+	p.SubcommandsOptional = true
 
 	fs, err := p.ParseArgs(args)
 	if err != nil {
 		if _, ok := err.(*flags.Error); !ok {
-			return nil, fmt.Errorf("error parsing args: %v", err)
+			return nil, nil, fmt.Errorf("error parsing args: %v", err)
 		}
-		return nil, err
+		return nil, nil, err
 	}
 
-	klogFs.Parse(fs)
+	if p.Active != nil {
+		cliConf.command = p.Active.Name
+	}
+
+	if err := klogFs.Parse(fs); err != nil {
+		return nil, nil, fmt.Errorf("error parsing args: %v", err)
+	}
 
 	conf, err := cliConf.GetConfig()
 	if err != nil {
-		return nil, err
+		return cliConf, nil, err
 	}
+	logConfig(conf)
+
+	return cliConf, conf, nil
+}
+
+func logConfig(conf *Config) {
 	klog.Infof("Server config: port=%d metrics=%t\n", conf.Port, conf.Metrics)
 	for _, p := range conf.PduConfig {
 		var name string
@@ -209,8 +720,16 @@ func LoadConfig(args []string) (*Config, error) {
 		} else {
 			name = "<no name defined>"
 		}
-		klog.Infof("PDU config: name=%s url=%s\n", name, p.Url())
+		if p.Source != "" {
+			name = fmt.Sprintf("%s (from %s)", name, p.Source)
+		}
+		if !p.httpBackend() {
+			klog.Infof("PDU config: name=%s %s=%s\n", name, p.Backend, p.Address)
+			continue
+		}
+		klog.Infof("PDU config: name=%s url=%s backend=%s\n", name, p.Url(), p.Backend)
+		if p.TLS.Insecure() && strings.HasPrefix(p.Url(), "https://") {
+			klog.Warningf("Certificate verification of %s is disabled, credentials can be intercepted", name)
+		}
 	}
-
-	return conf, nil
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func validPdu(name string) PduConfig {
+	return PduConfig{
+		Name:            name,
+		Address:         name + ".example.com",
+		Timeout:         10,
+		BulkParallelism: 1,
+		Username:        "admin",
+		Password:        "secret",
+		Auth:            authBasic,
+		Backend:         backendJSONRPC,
+	}
+}
+
+func validModule() ModuleConfig {
+	return ModuleConfig{
+		Timeout:         10,
+		BulkParallelism: 1,
+		Username:        "probe",
+		Password:        "secret",
+		Auth:            authBasic,
+		Backend:         backendJSONRPC,
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		// errs listed by Validate, sorted
+		errs []string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *Config) {},
+		},
+		{
+			name: "no pdus",
+			modify: func(c *Config) {
+				c.PduConfig = nil
+			},
+			errs: []string{"no pdus configured, set --address, pdu_config, file_sd or modules"},
+		},
+		{
+			name: "modules only",
+			modify: func(c *Config) {
+				c.PduConfig = nil
+				c.Modules = map[string]ModuleConfig{defaultModule: validModule()}
+			},
+		},
+		{
+			name: "global settings",
+			modify: func(c *Config) {
+				c.Interval = 0
+				c.Retries = -1
+				c.BreakerFailures = 3
+				c.ExporterLabels = map[string]bool{"rack": true}
+			},
+			errs: []string{
+				"breaker_cooldown must be greater than 0",
+				"interval must be greater than 0",
+				"retries must not be negative",
+				`unknown exporter label "rack"`,
+			},
+		},
+		{
+			name: "pdu settings",
+			modify: func(c *Config) {
+				p := &c.PduConfig[0]
+				p.Timeout = 0
+				p.BulkSize = -1
+				p.BulkParallelism = 0
+				p.Auth = "digest"
+				p.Backend = "modbus"
+			},
+			errs: []string{
+				"pdu_config[0] (pdu01): auth must be basic or session",
+				"pdu_config[0] (pdu01): backend must be jsonrpc, snmp or prometheus",
+				"pdu_config[0] (pdu01): bulk_parallelism must be greater than 0",
+				"pdu_config[0] (pdu01): bulk_size must not be negative",
+				"pdu_config[0] (pdu01): timeout must be greater than 0",
+			},
+		},
+		{
+			name: "pdu addresses",
+			modify: func(c *Config) {
+				c.PduConfig[0].Address = ""
+				unnamed := validPdu("")
+				unnamed.Address = "ftp://pdu02.example.com"
+				c.PduConfig = append(c.PduConfig, unnamed)
+			},
+			errs: []string{
+				"pdu_config[0] (pdu01): address is missing",
+				`pdu_config[1]: unsupported scheme in address "ftp://pdu02.example.com"`,
+			},
+		},
+		{
+			name: "duplicate pdus",
+			modify: func(c *Config) {
+				c.PduConfig = append(c.PduConfig, validPdu("pdu01"))
+			},
+			errs: []string{`pdu_config[1] (pdu01): duplicate pdu "pdu01"`},
+		},
+		{
+			name: "pdu labels",
+			modify: func(c *Config) {
+				c.PduConfig[0].Labels = map[string]string{"rack": "r1", "pdu_name": "x", "__meta": "y"}
+			},
+			errs: []string{
+				`pdu_config[0] (pdu01): label "__meta" is not a valid label name`,
+				`pdu_config[0] (pdu01): label "pdu_name" is set by the exporter`,
+			},
+		},
+		{
+			name: "discovered pdus are named by their file",
+			modify: func(c *Config) {
+				p := validPdu("")
+				p.Address = "pdu02.example.com"
+				p.Source = "targets/a.yaml"
+				p.Timeout = 0
+				c.PduConfig = append(c.PduConfig, p)
+			},
+			errs: []string{"targets/a.yaml (pdu02.example.com): timeout must be greater than 0"},
+		},
+		{
+			name: "file_sd",
+			modify: func(c *Config) {
+				c.FileSD = []FileSDConfig{{Pdu: PduConfig{Address: "pdu02.example.com"}}}
+			},
+			errs: []string{
+				"file_sd[0]: files is missing",
+				"file_sd[0]: name and address are set by the targets",
+			},
+		},
+		{
+			name: "modules",
+			modify: func(c *Config) {
+				m := validModule()
+				m.Username = ""
+				m.Auth = "digest"
+				m.Backend = backendSNMP
+				m.Timeout = 0
+				m.ExporterLabels = map[string]bool{"rack": true}
+				c.Modules = map[string]ModuleConfig{"lab": m}
+			},
+			errs: []string{
+				"modules.lab: auth must be basic or session",
+				"modules.lab: backend must be jsonrpc or prometheus",
+				"modules.lab: timeout must be greater than 0",
+				`modules.lab: unknown exporter label "rack"`,
+				"modules.lab: username and password must be set",
+			},
+		},
+		{
+			name: "admin",
+			modify: func(c *Config) {
+				c.Admin = AdminConfig{Listen: ":2113", Username: "admin", Password: "secret"}
+			},
+			errs: []string{"admin: audit_log must be set with listen"},
+		},
+		{
+			name: "admin without credentials",
+			modify: func(c *Config) {
+				c.Admin = AdminConfig{Listen: ":2113", AuditLog: "audit.log", TLS: AdminTLSConfig{CertFile: "admin.crt"}}
+			},
+			errs: []string{
+				"admin: tls: cert_file and key_file must both be set",
+				"admin: username and password must be set with listen",
+			},
+		},
+		{
+			name: "admin disabled without listen",
+			modify: func(c *Config) {
+				c.Admin = AdminConfig{Username: "admin", Password: "secret"}
+			},
+		},
+		{
+			name: "admin without password",
+			modify: func(c *Config) {
+				c.Admin = AdminConfig{Username: "admin"}
+			},
+			errs: []string{"admin: username and password must both be set"},
+		},
+		{
+			name: "record dir",
+			modify: func(c *Config) {
+				c.RecordDir = "/does/not/exist"
+			},
+			errs: []string{`record dir "/does/not/exist" is not a directory`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				Interval:       10,
+				ExporterLabels: map[string]bool{},
+				PduConfig:      []PduConfig{validPdu("pdu01")},
+			}
+			tt.modify(c)
+
+			err := c.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("err = nil, want %v", tt.errs)
+			}
+			errs := strings.Split(strings.TrimPrefix(err.Error(), "invalid config:\n  "), "\n  ")
+			if !reflect.DeepEqual(errs, tt.errs) {
+				t.Errorf("errs = %q, want %q", errs, tt.errs)
+			}
+		})
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,22 +18,47 @@ import (
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/exporter"
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
 
-var cltrs []*exporter.PrometheusCollector
+// configReloadInterval between checks of the config file for changes
+const configReloadInterval = 5 * time.Second
+
+// probeTimeoutOffset is subtracted from the Prometheus scrape timeout for probe deadlines
+const probeTimeoutOffset = 500 * time.Millisecond
+
+var (
+	pdus = newPool()
+
+	confMux     sync.RWMutex
+	currentConf *Config
+
+	// reloadMux serializes reloads from SIGHUP and file changes
+	reloadMux sync.Mutex
+)
 
 func Run() {
-	cltrs = []*exporter.PrometheusCollector{}
-	config, err := LoadConfig(os.Args)
+	cliConf, config, err := LoadConfig(os.Args[1:])
 	if err != nil {
+		if cliConf != nil && cliConf.CheckConfig {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		klog.Exitf("%s", err)
 	}
+	if cliConf.CheckConfig {
+		fmt.Println("config OK")
+		return
+	}
+	if cliConf.command == "outlet" {
+		os.Exit(runOutletCommand(cliConf.Outlet, config))
+	}
 
-	Exporter(config)
+	Exporter(cliConf, config)
 }
 
-func Exporter(conf *Config) {
+func Exporter(cliConf *CliConfig, conf *Config) {
 
 	ctx, cf := context.WithCancel(context.Background())
 	sigs := make(chan os.Signal, 1)
@@ -41,57 +68,165 @@ func Exporter(conf *Config) {
 		cf()
 	}()
 
-	go metrics(*conf)
+	setConfig(conf)
+	pdus.Apply(ctx, conf)
 
-	for _, pduConf := range conf.PduConfig {
-		baseURL, err := url.Parse(pduConf.Url())
-		if err != nil {
-			klog.Exitf("Error parsing URL: %v", err)
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reloads:
+				klog.Info("Received SIGHUP, reloading config")
+				reload(ctx, cliConf)
+			}
 		}
+	}()
+	if cliConf.ConfigPath != "" {
+		go watchConfig(ctx, cliConf)
+	}
 
-		q := raritan.Client{
-			RPCClient: rpc.NewClient(time.Duration(pduConf.Timeout)*time.Second, rpc.Auth{
-				Username: pduConf.Username,
-				Password: pduConf.Password,
-			}),
-			BaseURL: *baseURL,
-		}
+	go metrics(*conf)
+	go adminServer(conf.Admin)
 
-		collector := &exporter.PrometheusCollector{
-			Name: pduConf.Name,
-		}
-		collector.Labels.UseConfigName = conf.ExporterLabels["use_config_name"]
-		collector.Labels.SerialNumber = conf.ExporterLabels["serial_number"]
-		collector.Labels.SNMPSysContact = conf.ExporterLabels["snmp_sys_contact"]
-		collector.Labels.SNMPSysName = conf.ExporterLabels["snmp_sys_name"]
-		collector.Labels.SNMPSydLocation = conf.ExporterLabels["snmp_sys_location"]
+	<-ctx.Done()
+	pdus.Wait()
+}
+
+// reload reads the config again and applies it to the running pollers.
+// The metrics and admin servers keep running with their initial settings.
+func reload(ctx context.Context, cliConf *CliConfig) {
+	reloadMux.Lock()
+	defer reloadMux.Unlock()
 
-		enableSNMP := collector.Labels.SNMPSydLocation || collector.Labels.SNMPSysContact || collector.Labels.SNMPSysName
+	conf, err := cliConf.GetConfig()
+	if err != nil {
+		klog.Errorf("Failed to reload config, keeping current config: %v", err)
+		return
+	}
+	logConfig(conf)
+
+	old := getConfig()
+	if conf.Port != old.Port || conf.Metrics != old.Metrics {
+		klog.Warningf("Changes to port and metrics settings require a restart")
+	}
+	if conf.Admin.Listen != old.Admin.Listen || conf.Admin.TLS != old.Admin.TLS {
+		klog.Warningf("Changes to the admin listen and tls settings require a restart")
+	}
 
-		ls, cPduInfo, cSnmpInfo, err := exporter.Run(ctx, q, conf.Interval, enableSNMP)
+	setConfig(conf)
+	pdus.Apply(ctx, conf)
+}
+
+// watchConfig reloads the config when the file or a file_sd file is modified, added or removed
+func watchConfig(ctx context.Context, cliConf *CliConfig) {
+	last, _ := configFiles(cliConf)
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		files, err := configFiles(cliConf)
 		if err != nil {
-			klog.Errorf("failed to connect to %s, skipping pdu", pduConf.Name)
+			klog.Errorf("Failed to check config file: %v", err)
+			return
 		}
-
-		go func() {
-			for l := range ls {
-				collector.SetLogs(l)
+		changed := ""
+		for path, fi := range files {
+			if l, ok := last[path]; !ok || !fi.ModTime().Equal(l.ModTime()) || fi.Size() != l.Size() {
+				changed = path
+				break
 			}
-		}()
-		go func() {
-			for pduInfo := range cPduInfo {
-				collector.SetPduInfo(pduInfo)
+		}
+		for path := range last {
+			if _, ok := files[path]; !ok {
+				changed = path
+				break
 			}
-		}()
-		go func() {
-			for snmpInfo := range cSnmpInfo {
-				collector.SetSnmpInfo(snmpInfo)
+		}
+		if last != nil && changed == "" {
+			return
+		}
+		last = files
+		klog.Infof("%s changed, reloading config", changed)
+		reload(ctx, cliConf)
+
+		// file_sd files added by the reloaded config are not a change
+		if files, err := configFiles(cliConf); err == nil {
+			for path, fi := range files {
+				if _, ok := last[path]; !ok {
+					last[path] = fi
+				}
+			}
+		}
+	}, configReloadInterval)
+}
+
+// configFiles are the config file and the file_sd files of the current config by path
+func configFiles(cliConf *CliConfig) (map[string]os.FileInfo, error) {
+	fi, err := os.Stat(cliConf.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]os.FileInfo{cliConf.ConfigPath: fi}
+	if conf := getConfig(); conf != nil {
+		for _, path := range fileSDPaths(conf.FileSD) {
+			// removed since the glob, picked up by the next check
+			if fi, err := os.Stat(path); err == nil {
+				files[path] = fi
 			}
-		}()
-		cltrs = append(cltrs, collector)
+		}
 	}
+	return files, nil
+}
 
-	<-ctx.Done()
+func setConfig(conf *Config) {
+	confMux.Lock()
+	currentConf = conf
+	confMux.Unlock()
+}
+
+func getConfig() *Config {
+	confMux.RLock()
+	defer confMux.RUnlock()
+	return currentConf
+}
+
+// newClient for the PDU at address, recording its requests in stats unless nil
+func newClient(address string, timeout int, username, password string, tlsConf TLSConfig, stats *exporter.RPCStats) (*raritan.Client, error) {
+	baseURL, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := tlsConf.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	rpcClient := rpc.NewClient(time.Duration(timeout)*time.Second, rpc.Auth{
+		Username: username,
+		Password: password,
+	}, tlsConfig)
+	if stats != nil {
+		rpcClient = exporter.InstrumentRPC(rpcClient, baseURL.Host, stats)
+	}
+	return &raritan.Client{
+		RPCClient: rpcClient,
+		BaseURL:   *baseURL,
+	}, nil
+}
+
+func newCollector(name string, exporterLabels map[string]bool, legacyNames bool, targetLabels map[string]string) *exporter.PrometheusCollector {
+	collector := &exporter.PrometheusCollector{
+		Name:         name,
+		LegacyNames:  legacyNames,
+		TargetLabels: targetLabels,
+	}
+	collector.Labels.UseConfigName = exporterLabels["use_config_name"]
+	collector.Labels.SerialNumber = exporterLabels["serial_number"]
+	collector.Labels.SNMPSysContact = exporterLabels["snmp_sys_contact"]
+	collector.Labels.SNMPSysName = exporterLabels["snmp_sys_name"]
+	collector.Labels.SNMPSydLocation = exporterLabels["snmp_sys_location"]
+	return collector
 }
 
 func metrics(c Config) {
@@ -107,6 +242,7 @@ func metrics(c Config) {
 		fmt.Fprint(w, `PDU Metrics are at <a href="/metrics">/metrics<a>`)
 	})
 	r.HandleFunc("/metrics", metricsHandler)
+	r.HandleFunc("/probe", probeHandler)
 
 	if err := http.ListenAndServe(fmt.Sprintf(":%d", c.Port), r); err != nil {
 		klog.Errorf("HTTP server error: %v", err)
@@ -129,7 +265,7 @@ func metricsHandler(w http.ResponseWriter, r *http.Request) {
 
 	registry := prometheus.NewRegistry()
 	all := listContains(endpointFilter, "all") || len(endpointFilter) == 0
-	for _, collector := range cltrs {
+	for _, collector := range pdus.Collectors() {
 		if all || collector.Match(endpointFilter) {
 			registry.MustRegister(collector)
 		}
@@ -139,6 +275,69 @@ func metricsHandler(w http.ResponseWriter, r *http.Request) {
 	h.ServeHTTP(w, r)
 }
 
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	c := getConfig()
+	params := r.URL.Query()
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = defaultModule
+	}
+	module, ok := c.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	// the stats of a probe are only exported in its response, the target is not a label of /metrics
+	stats := exporter.NewRPCStats()
+	backend, q, err := newBackend(runnerConfig{
+		Pdu:     module.pduConfig(target),
+		Retries: c.Retries,
+	}, stats)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid target %q: %v", target, err), http.StatusBadRequest)
+		return
+	}
+	if q != nil {
+		defer logout(q)
+	}
+
+	collector := newCollector(target, module.ExporterLabels, c.LegacyMetricNames, nil)
+	collector.RPC = stats
+	enableSNMP := collector.Labels.SNMPSydLocation || collector.Labels.SNMPSysContact || collector.Labels.SNMPSysName
+
+	ctx := r.Context()
+	// leave a little of the Prometheus scrape timeout for rendering the response
+	if v, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil && v > 0 {
+		timeout := time.Duration(v * float64(time.Second))
+		if timeout > probeTimeoutOffset {
+			timeout -= probeTimeoutOffset
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	poller := exporter.NewPoller(backend, 0, enableSNMP)
+	if err := poller.Poll(ctx); err != nil {
+		klog.Errorf("Probe of %s failed: %v", target, err)
+	}
+	collector.Poller = poller
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, r)
+}
+
 func logMW(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		klog.V(1).Infof("%s - %s (%s)", r.Method, r.URL.RequestURI(), r.RemoteAddr)
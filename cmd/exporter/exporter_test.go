@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// stubCassette is recorded from raritan-stub, see TestPollerReplay
+const stubCassette = "../../internal/exporter/testdata/stub.jsonl"
+
+// pduServer answers JSON-RPC requests from the stub cassette and records the
+// basic auth users of the requests. Outlet power actions succeed and are recorded.
+type pduServer struct {
+	*httptest.Server
+	mux      sync.Mutex
+	users    []string
+	switches []string
+	// onSwitch is called with the RID of every power action before it is answered
+	onSwitch func(rid string)
+}
+
+func newPDUServer(t *testing.T) *pduServer {
+	t.Helper()
+	is, err := rpc.LoadCassette(stubCassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer, err := rpc.NewReplayer(is)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &pduServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		s.mux.Lock()
+		s.users = append(s.users, user)
+		s.mux.Unlock()
+
+		req := rpc.Request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Method == "setPowerState" || req.Method == "cyclePowerState" {
+			s.mux.Lock()
+			s.switches = append(s.switches, req.Method+" "+r.URL.Path)
+			onSwitch := s.onSwitch
+			s.mux.Unlock()
+			if onSwitch != nil {
+				onSwitch(r.URL.Path)
+			}
+			fmt.Fprint(w, `{"result":{"_ret_":0}}`)
+			return
+		}
+		res, err := replayer.Call(r.Context(), *r.URL, req)
+		if errors.Is(err, rpc.ErrNoInteraction) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Users of the requests so far, without duplicates in order of their first request
+func (s *pduServer) Users() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	users := []string{}
+	for _, u := range s.users {
+		if !listContains(users, u) {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// Switches are the power actions so far as method and RID
+func (s *pduServer) Switches() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]string{}, s.switches...)
+}
+
+// OnSwitch sets the function called on power actions
+func (s *pduServer) OnSwitch(f func(rid string)) {
+	s.mux.Lock()
+	s.onSwitch = f
+	s.mux.Unlock()
+}
+
+func TestProbeHandler(t *testing.T) {
+	module := func(username string, useConfigName bool) ModuleConfig {
+		m := validModule()
+		m.Username = username
+		m.ExporterLabels = map[string]bool{"use_config_name": useConfigName}
+		return m
+	}
+	modules := map[string]ModuleConfig{defaultModule: module("probe", false), "lab": module("lab", true)}
+	tests := []struct {
+		name    string
+		modules map[string]ModuleConfig
+		query   string
+		status  int
+		// users the PDU saw, empty if it was not polled
+		users []string
+		// pduName label of the metrics, %s for the target
+		pduName string
+	}{
+		{
+			name:    "default module",
+			modules: modules,
+			query:   "target=%s",
+			status:  http.StatusOK,
+			users:   []string{"probe"},
+			pduName: "Fake Name",
+		},
+		{
+			name:    "module by name",
+			modules: modules,
+			query:   "target=%s&module=lab",
+			status:  http.StatusOK,
+			users:   []string{"lab"},
+			pduName: "%s",
+		},
+		{
+			name:    "no default module",
+			modules: map[string]ModuleConfig{"lab": modules["lab"]},
+			query:   "target=%s",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "unknown module",
+			modules: modules,
+			query:   "target=%s&module=other",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "target missing",
+			modules: modules,
+			query:   "module=" + defaultModule,
+			status:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdu := newPDUServer(t)
+			setConfig(&Config{Modules: tt.modules})
+			t.Cleanup(func() { setConfig(nil) })
+
+			query := strings.ReplaceAll(tt.query, "%s", pdu.URL)
+			w := httptest.NewRecorder()
+			probeHandler(w, httptest.NewRequest(http.MethodGet, "/probe?"+query, nil))
+
+			body, _ := io.ReadAll(w.Result().Body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, body)
+			}
+			users := pdu.Users()
+			if len(tt.users) == 0 {
+				if len(users) > 0 {
+					t.Errorf("PDU polled by %q, want no requests", users)
+				}
+				return
+			}
+			if strings.Join(users, ",") != strings.Join(tt.users, ",") {
+				t.Errorf("PDU polled by %q, want %q", users, tt.users)
+			}
+			active := `pdu_status_pdu_active{pdu_name="` + strings.ReplaceAll(tt.pduName, "%s", pdu.URL) + `"} 1`
+			if !strings.Contains(string(body), active) {
+				t.Errorf("response has no %s:\n%s", active, body)
+			}
+		})
+	}
+}
+
+func TestProbeScrapeTimeout(t *testing.T) {
+	// the PDU holds requests until they are cancelled
+	done := make(chan struct{})
+	pdu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer pdu.Close()
+	defer close(done)
+	setConfig(&Config{Modules: map[string]ModuleConfig{defaultModule: validModule()}})
+	defer setConfig(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/probe?target="+pdu.URL, nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "1")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	probeHandler(w, req)
+
+	// the module timeout is 10s, the probe ends at the scrape timeout less the offset
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("probe took %s, want less than the scrape timeout", d)
+	}
+	body, _ := io.ReadAll(w.Result().Body)
+	active := `pdu_status_pdu_active{pdu_name="` + pdu.URL + `"} 0`
+	if w.Code != http.StatusOK || !strings.Contains(string(body), active) {
+		t.Errorf("status %d without %s:\n%s", w.Code, active, body)
+	}
+}
+
+func TestReload(t *testing.T) {
+	pdu := newPDUServer(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(config string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(strings.ReplaceAll(config, "%s", pdu.URL)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pduConfig := func(names ...string) string {
+		config := "interval: 3600\nusername: test\npassword: test\npdu_config:\n"
+		for _, n := range names {
+			config += "  - {name: " + n + ", address: \"%s\"}\n"
+		}
+		return config
+	}
+
+	write(pduConfig("pdu01"))
+	cliConf, conf, err := LoadConfig([]string{"-c", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	setConfig(conf)
+	pdus.Apply(ctx, conf)
+	defer func() {
+		cancel()
+		pdus.Wait()
+		setConfig(nil)
+	}()
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{name: "pdu added", config: pduConfig("pdu01", "pdu02"), want: []string{"pdu01", "pdu02"}},
+		{name: "invalid config is not applied", config: pduConfig("pdu01", "pdu01"), want: []string{"pdu01", "pdu02"}},
+		{name: "unknown key is not applied", config: pduConfig("pdu01") + "intervall: 10\n", want: []string{"pdu01", "pdu02"}},
+		{name: "pdu removed", config: pduConfig("pdu02"), want: []string{"pdu02"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(tt.config)
+			reload(ctx, cliConf)
+
+			names := []string{}
+			for _, pc := range getConfig().PduConfig {
+				names = append(names, pc.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("config has %q, want %q", names, tt.want)
+			}
+			running := []string{}
+			for k := range runners(pdus) {
+				running = append(running, k)
+			}
+			sort.Strings(running)
+			if strings.Join(running, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("pollers = %q, want %q", running, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml": "file_sd: [{files: [\"targets/*.yaml\"], pdu: {username: test, password: test}}]\n",
+	})
+	if err := os.Mkdir(filepath.Join(dir, "targets"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	cliConf, conf, err := LoadConfig([]string{"-c", filepath.Join(dir, "config.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(conf)
+	defer setConfig(nil)
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{name: "config only", want: []string{"config.yaml"}},
+		{name: "file_sd file added", files: map[string]string{"targets/a.yaml": "- targets: [pdu01]\n"}, want: []string{"config.yaml", "targets/a.yaml"}},
+		{name: "unmatched file", files: map[string]string{"targets/b.json": "[]"}, want: []string{"config.yaml", "targets/a.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			files, err := configFiles(cliConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for path := range files {
+				rel, _ := filepath.Rel(dir, path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileSDConfig discovers PDUs from Prometheus file_sd files, every target becomes a PDU
+type FileSDConfig struct {
+	// Files are paths or globs of JSON or YAML file_sd files, relative to the config file
+	Files []string `json:"files" yaml:"files"`
+	// Pdu settings of the discovered PDUs, the address and name are those of the targets
+	Pdu PduConfig `json:"pdu" yaml:"pdu"`
+}
+
+// fileSDGroup is a target group of a file_sd file
+type fileSDGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// resolve relative file patterns against dir
+func (c FileSDConfig) resolve(dir string) FileSDConfig {
+	files := make([]string, len(c.Files))
+	for i, f := range c.Files {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(dir, f)
+		}
+		files[i] = f
+	}
+	c.Files = files
+	return c
+}
+
+// paths of the files matching the patterns, sorted and without duplicates
+func (c FileSDConfig) paths() ([]string, error) {
+	seen := map[string]bool{}
+	paths := []string{}
+	for _, pattern := range c.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file_sd pattern %q: %w", pattern, err)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				paths = append(paths, m)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// discover the PDUs of the file_sd files. Target labels override the labels of the settings,
+// meta labels starting with __ are dropped.
+func (c FileSDConfig) discover() ([]PduConfig, error) {
+	paths, err := c.paths()
+	if err != nil {
+		return nil, err
+	}
+
+	pdus := []PduConfig{}
+	for _, path := range paths {
+		groups, err := readFileSD(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			labels := map[string]string{}
+			for k, v := range c.Pdu.Labels {
+				labels[k] = v
+			}
+			for k, v := range g.Labels {
+				if !strings.HasPrefix(k, "__") {
+					labels[k] = v
+				}
+			}
+			for _, t := range g.Targets {
+				pdu := c.Pdu
+				pdu.Address = t
+				pdu.Labels = labels
+				pdu.Source = path
+				pdus = append(pdus, pdu)
+			}
+		}
+	}
+	return pdus, nil
+}
+
+// readFileSD reads the target groups of a YAML or JSON file_sd file, unknown keys are an error
+func readFileSD(path string) ([]fileSDGroup, error) {
+	groups := []fileSDGroup{}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file_sd file: %w", err)
+	}
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		dec.KnownFields(true)
+		if err := dec.Decode(&groups); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error parsing file_sd file %s: %w", path, err)
+		}
+	} else if strings.HasSuffix(path, ".json") {
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&groups); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error parsing file_sd file %s: %w", path, err)
+		}
+	} else {
+		return nil, fmt.Errorf("unknown file_sd file extension for %s, expected .yaml, .yml or .json", path)
+	}
+
+	return groups, nil
+}
+
+// fileSDPaths of all file_sd configs, patterns that fail are skipped
+func fileSDPaths(sds []FileSDConfig) []string {
+	paths := []string{}
+	for _, sd := range sds {
+		p, err := sd.paths()
+		if err != nil {
+			continue
+		}
+		paths = append(paths, p...)
+	}
+	return paths
+}
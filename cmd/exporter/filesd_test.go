@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles to dir, returning dir
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFileSDDiscover(t *testing.T) {
+	// discovered is the address, labels and source file of a PDU
+	type discovered struct {
+		address string
+		labels  map[string]string
+		source  string
+	}
+	tests := []struct {
+		name   string
+		files  map[string]string
+		globs  []string
+		labels map[string]string
+		want   []discovered
+		err    bool
+	}{
+		{
+			name: "yaml",
+			files: map[string]string{
+				"a.yaml": "- targets: [pdu01, pdu02]\n  labels: {rack: r1}\n",
+			},
+			globs: []string{"*.yaml"},
+			want: []discovered{
+				{"pdu01", map[string]string{"rack": "r1"}, "a.yaml"},
+				{"pdu02", map[string]string{"rack": "r1"}, "a.yaml"},
+			},
+		},
+		{
+			name: "json",
+			files: map[string]string{
+				"a.json": `[{"targets": ["pdu01"], "labels": {"rack": "r1"}}]`,
+			},
+			globs: []string{"*.json"},
+			want: []discovered{
+				{"pdu01", map[string]string{"rack": "r1"}, "a.json"},
+			},
+		},
+		{
+			name: "target labels override the settings, meta labels are dropped",
+			files: map[string]string{
+				"a.yml": "- targets: [pdu01]\n  labels: {site: fra1, __meta_rack: r1}\n",
+			},
+			globs:  []string{"*.yml"},
+			labels: map[string]string{"site": "default", "env": "prod"},
+			want: []discovered{
+				{"pdu01", map[string]string{"site": "fra1", "env": "prod"}, "a.yml"},
+			},
+		},
+		{
+			name: "files sorted without duplicates",
+			files: map[string]string{
+				"b.yaml": "- targets: [pdu02]\n",
+				"a.yaml": "- targets: [pdu01]\n",
+			},
+			globs: []string{"b.yaml", "*.yaml"},
+			want: []discovered{
+				{"pdu01", map[string]string{}, "a.yaml"},
+				{"pdu02", map[string]string{}, "b.yaml"},
+			},
+		},
+		{
+			name:  "empty file",
+			files: map[string]string{"a.yaml": ""},
+			globs: []string{"*.yaml"},
+			want:  []discovered{},
+		},
+		{
+			name:  "no matches",
+			globs: []string{"*.yaml"},
+			want:  []discovered{},
+		},
+		{
+			name:  "unknown key",
+			files: map[string]string{"a.yaml": "- targets: [pdu01]\n  label: {rack: r1}\n"},
+			globs: []string{"*.yaml"},
+			err:   true,
+		},
+		{
+			name:  "unknown extension",
+			files: map[string]string{"a.txt": "pdu01\n"},
+			globs: []string{"*.txt"},
+			err:   true,
+		},
+		{
+			name:  "invalid pattern",
+			globs: []string{"[.yaml"},
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			sd := FileSDConfig{
+				Files: tt.globs,
+				Pdu: PduConfig{
+					Username: "admin",
+					Labels:   tt.labels,
+				},
+			}.resolve(dir)
+
+			pdus, err := sd.discover()
+			if tt.err {
+				if err == nil {
+					t.Fatalf("discovered %v, want an error", pdus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]discovered, len(pdus))
+			for i, p := range pdus {
+				if p.Username != "admin" {
+					t.Errorf("%s: username = %q, want the one of the settings", p.Address, p.Username)
+				}
+				got[i] = discovered{p.Address, p.Labels, filepath.Base(p.Source)}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("discovered %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTargetLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"rack", true},
+		{"site_1", true},
+		{"_private", true},
+		{"__meta_rack", false},
+		{"1rack", false},
+		{"rack-name", false},
+		{"", false},
+		{"pdu_name", false},
+		{"label", false},
+		{"pole", false},
+		{"address", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTargetLabel(tt.name)
+			if (err == nil) != tt.valid {
+				t.Errorf("validateTargetLabel = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goji/httpauth"
+	"github.com/gorilla/mux"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"k8s.io/klog/v2"
+)
+
+// OutletCommand switches the power of an outlet from the command line
+type OutletCommand struct {
+	PDU      string `long:"pdu" description:"Name of the PDU in the config, may be omitted with a single PDU"`
+	Outlet   string `long:"outlet" required:"true" description:"Label, name or resource id of the outlet"`
+	Action   string `long:"action" required:"true" choice:"on" choice:"off" choice:"cycle" description:"Power action"`
+	AuditLog string `long:"audit-log" value-name:"FILE" description:"Audit log file (default: admin.audit_log from config)"`
+}
+
+// switchTimeout of an outlet power action, which a disconnecting admin client does not cancel
+const switchTimeout = 60 * time.Second
+
+// Results of audit entries
+const (
+	auditStarted = "started"
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+// auditEntry is written as a JSON line before every outlet power action, with result started,
+// and after it with its result. Both entries of an action have the same ID.
+type auditEntry struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Source string    `json:"source"`
+	PDU    string    `json:"pdu"`
+	Outlet string    `json:"outlet"`
+	Action string    `json:"action"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// auditMux serializes the writes of audit entries, not the actions between them
+var auditMux sync.Mutex
+
+// switchOutlet performs action on an outlet, writing audit log entries before and after it.
+// The action is refused if the audit log cannot be written.
+func switchOutlet(ctx context.Context, client *raritan.Client, auditPath string, entry auditEntry) error {
+	action, err := raritan.ParsePowerAction(entry.Action)
+	if err != nil {
+		return err
+	}
+	if auditPath == "" {
+		return errors.New("no audit log configured, refusing outlet action")
+	}
+
+	f, err := os.OpenFile(auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log, refusing outlet action: %w", err)
+	}
+	defer f.Close()
+
+	start := time.Now().UTC()
+	entry.ID = strconv.FormatInt(start.UnixNano(), 36)
+	entry.Time = start
+	entry.Result = auditStarted
+	if err := writeAudit(f, entry); err != nil {
+		return fmt.Errorf("error writing audit log, refusing outlet action: %w", err)
+	}
+
+	outlet, err := client.FindOutlet(ctx, entry.Outlet)
+	if err == nil {
+		err = client.SwitchOutlet(ctx, outlet.Resource, action)
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.Result = auditSuccess
+	if err != nil {
+		entry.Result = auditFailure
+		entry.Error = err.Error()
+	}
+	if werr := writeAudit(f, entry); werr != nil {
+		klog.Errorf("Error writing audit log: %v", werr)
+	}
+	return err
+}
+
+// writeAudit entry to f and the log, synced so it survives a crash during the action
+func writeAudit(f *os.File, entry auditEntry) error {
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Audit: %s", bs)
+	auditMux.Lock()
+	defer auditMux.Unlock()
+	if _, err := f.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// adminServer serves the admin endpoint on the listen address of c, with TLS if configured
+func adminServer(c AdminConfig) {
+	if !c.Enabled() {
+		return
+	}
+	tlsConfig, err := c.TLS.Build()
+	if err != nil {
+		klog.Errorf("Admin server error: %v", err)
+		return
+	}
+	srv := &http.Server{
+		Addr:      c.Listen,
+		Handler:   adminRouter(),
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig == nil {
+		klog.Warningf("Starting admin server on %s without TLS, credentials are sent in cleartext", c.Listen)
+		err = srv.ListenAndServe()
+	} else {
+		klog.V(1).Infof("Starting admin server on %s", c.Listen)
+		err = srv.ListenAndServeTLS("", "")
+	}
+	if err != nil {
+		klog.Errorf("Admin server error: %v", err)
+	}
+}
+
+// adminRouter of the admin endpoint
+func adminRouter() http.Handler {
+	r := mux.NewRouter()
+	r.Use(logMW)
+	r.Handle("/admin/outlets", adminAuth(http.HandlerFunc(adminOutletHandler))).Methods(http.MethodPost)
+	return r
+}
+
+// adminAuth checks basic auth against the admin credentials of the current config
+func adminAuth(next http.Handler) http.Handler {
+	return httpauth.BasicAuth(httpauth.AuthOptions{
+		Realm: "PDU Exporter Admin",
+		AuthFunc: func(user, pass string, r *http.Request) bool {
+			admin := getConfig().Admin
+			if !admin.Enabled() {
+				return false
+			}
+			givenUser := sha256.Sum256([]byte(user))
+			givenPass := sha256.Sum256([]byte(pass))
+			wantUser := sha256.Sum256([]byte(admin.Username))
+			wantPass := sha256.Sum256([]byte(admin.Password))
+			return subtle.ConstantTimeCompare(givenUser[:], wantUser[:]) == 1 &&
+				subtle.ConstantTimeCompare(givenPass[:], wantPass[:]) == 1
+		},
+	})(next)
+}
+
+// adminOutletHandler switches outlet power, params pdu, outlet and action
+func adminOutletHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pduName := r.Form.Get("pdu")
+	outlet := r.Form.Get("outlet")
+	action := r.Form.Get("action")
+	if pduName == "" || outlet == "" || action == "" {
+		http.Error(w, "pdu, outlet and action parameters are required", http.StatusBadRequest)
+		return
+	}
+	if _, err := raritan.ParsePowerAction(action); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, ok := pdus.Client(pduName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown pdu %q", pduName), http.StatusNotFound)
+		return
+	}
+	if client == nil {
+		http.Error(w, fmt.Sprintf("pdu %q is not polled with JSON-RPC, which switching needs", pduName), http.StatusNotImplemented)
+		return
+	}
+
+	// not cancelled when the client disconnects, the action must not stop halfway
+	ctx, cf := context.WithTimeout(context.Background(), switchTimeout)
+	defer cf()
+	user, _, _ := r.BasicAuth()
+	err := switchOutlet(ctx, client, getConfig().Admin.AuditLog, auditEntry{
+		User:   user,
+		Source: r.RemoteAddr,
+		PDU:    pduName,
+		Outlet: outlet,
+		Action: action,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	fmt.Fprintf(w, "%s %s on %s: ok\n", action, outlet, pduName)
+}
+
+// runOutletCommand switches an outlet of a configured PDU, returning the exit code
+func runOutletCommand(cmd OutletCommand, conf *Config) int {
+	var pduConf *PduConfig
+	for i, p := range conf.PduConfig {
+		if cmd.PDU == "" && len(conf.PduConfig) == 1 || cmd.PDU != "" && pduKey(p) == cmd.PDU {
+			pduConf = &conf.PduConfig[i]
+			break
+		}
+	}
+	if pduConf == nil {
+		if cmd.PDU == "" {
+			fmt.Fprintln(os.Stderr, "--pdu is required unless exactly one PDU is configured")
+		} else {
+			fmt.Fprintf(os.Stderr, "unknown pdu %q\n", cmd.PDU)
+		}
+		return 1
+	}
+	if !pduConf.httpBackend() {
+		fmt.Fprintf(os.Stderr, "pdu %q is polled with %s, switching needs JSON-RPC\n", pduKey(*pduConf), pduConf.Backend)
+		return 1
+	}
+
+	// like the client of a poller, with session auth, retries and bulk settings
+	client, err := newRPCClient(runnerConfig{
+		Pdu:     *pduConf,
+		Retries: conf.Retries,
+	}, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer logout(client)
+
+	auditPath := cmd.AuditLog
+	if auditPath == "" {
+		auditPath = conf.Admin.AuditLog
+	}
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	if err := switchOutlet(context.Background(), client, auditPath, auditEntry{
+		User:   username,
+		Source: "cli",
+		PDU:    pduKey(*pduConf),
+		Outlet: cmd.Outlet,
+		Action: cmd.Action,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s %s on %s: ok\n", cmd.Action, cmd.Outlet, pduKey(*pduConf))
+	return 0
+}
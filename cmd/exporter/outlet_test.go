@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAudit entries of the audit log at path, none if it does not exist
+func readAudit(t *testing.T, path string) []auditEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries := []auditEntry{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e := auditEntry{}
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("invalid audit entry %s: %v", s.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAdminOutletHandler(t *testing.T) {
+	pdu := newPDUServer(t)
+	pduConf := validPdu("pdu01")
+	pduConf.Address = pdu.URL
+	pduConf.Username = "test"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pdus.Apply(ctx, &Config{Interval: 3600, PduConfig: []PduConfig{pduConf}})
+	defer func() {
+		cancel()
+		pdus.Wait()
+		setConfig(nil)
+	}()
+	srv := httptest.NewServer(adminRouter())
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		user     string
+		query    string
+		disabled bool
+		// noAudit makes the audit log unwritable
+		noAudit  bool
+		status   int
+		switched string
+		// results of the audit entries
+		results []string
+		err     string
+	}{
+		{
+			name:     "cycle by label",
+			user:     "ops",
+			query:    "pdu=pdu01&outlet=O1&action=cycle",
+			status:   http.StatusOK,
+			switched: "cyclePowerState /model/outlet/1",
+			results:  []string{auditStarted, auditSuccess},
+		},
+		{
+			name:     "off by resource id",
+			user:     "ops",
+			query:    "pdu=pdu01&outlet=/model/outlet/0&action=OFF",
+			status:   http.StatusOK,
+			switched: "setPowerState /model/outlet/0",
+			results:  []string{auditStarted, auditSuccess},
+		},
+		{
+			name:    "unknown outlet",
+			user:    "ops",
+			query:   "pdu=pdu01&outlet=O9&action=on",
+			status:  http.StatusBadGateway,
+			results: []string{auditStarted, auditFailure},
+			err:     `outlet "O9" not found`,
+		},
+		{
+			name:    "audit log not writable",
+			user:    "ops",
+			query:   "pdu=pdu01&outlet=O1&action=on",
+			noAudit: true,
+			status:  http.StatusBadGateway,
+		},
+		{name: "wrong credentials", user: "other", query: "pdu=pdu01&outlet=O1&action=on", status: http.StatusUnauthorized},
+		{name: "disabled", user: "ops", query: "pdu=pdu01&outlet=O1&action=on", disabled: true, status: http.StatusUnauthorized},
+		{name: "get", method: http.MethodGet, user: "ops", query: "pdu=pdu01&outlet=O1&action=on", status: http.StatusMethodNotAllowed},
+		{name: "missing outlet", user: "ops", query: "pdu=pdu01&action=on", status: http.StatusBadRequest},
+		{name: "unknown action", user: "ops", query: "pdu=pdu01&outlet=O1&action=toggle", status: http.StatusBadRequest},
+		{name: "unknown pdu", user: "ops", query: "pdu=pdu02&outlet=O1&action=on", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auditLog := filepath.Join(t.TempDir(), "audit.log")
+			if tt.noAudit {
+				auditLog = filepath.Join(t.TempDir(), "missing", "audit.log")
+			}
+			admin := AdminConfig{Listen: ":0", Username: "ops", Password: "secret", AuditLog: auditLog}
+			if tt.disabled {
+				admin.Listen = ""
+			}
+			setConfig(&Config{Admin: admin})
+			before := len(pdu.Switches())
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req, err := http.NewRequest(method, srv.URL+"/admin/outlets?"+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetBasicAuth(tt.user, "secret")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d: %s", res.StatusCode, tt.status, body)
+			}
+
+			switches := pdu.Switches()[before:]
+			if tt.switched == "" && len(switches) > 0 || tt.switched != "" && strings.Join(switches, ",") != tt.switched {
+				t.Errorf("switches = %q, want %q", switches, tt.switched)
+			}
+
+			entries := readAudit(t, auditLog)
+			if len(entries) != len(tt.results) {
+				t.Fatalf("%d audit entries, want %d: %+v", len(entries), len(tt.results), entries)
+			}
+			for i, e := range entries {
+				if e.Result != tt.results[i] {
+					t.Errorf("entry %d: result = %s, want %s", i, e.Result, tt.results[i])
+				}
+				if e.ID != entries[0].ID || e.User != "ops" || e.PDU != "pdu01" || e.Source == "" {
+					t.Errorf("entry %d: %+v, want id %s of user ops on pdu01 with source", i, e, entries[0].ID)
+				}
+				if i > 0 && !strings.HasPrefix(e.Error, tt.err) {
+					t.Errorf("entry %d: error = %q, want %q", i, e.Error, tt.err)
+				}
+			}
+		})
+	}
+}
+
+// TestSwitchOutletConcurrent checks that an action waiting for the PDU does not block
+// the audit log for others
+func TestSwitchOutletConcurrent(t *testing.T) {
+	pdu := newPDUServer(t)
+	release := make(chan struct{})
+	waiting := make(chan struct{})
+	pdu.OnSwitch(func(rid string) {
+		if rid == "/model/outlet/0" {
+			close(waiting)
+			<-release
+		}
+	})
+	client, err := newClient(pdu.URL, 10, "test", "test", TLSConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	entry := func(outlet string) auditEntry {
+		return auditEntry{User: "ops", Source: "test", PDU: "pdu01", Outlet: outlet, Action: "cycle"}
+	}
+
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- switchOutlet(context.Background(), client, auditLog, entry("O0"))
+	}()
+	<-waiting
+
+	done := make(chan error, 1)
+	go func() {
+		done <- switchOutlet(context.Background(), client, auditLog, entry("O1"))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("switching O1 while O0 is switched: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("switching O1 is blocked while O0 is switched")
+		close(release)
+		<-done
+		<-blocked
+		return
+	}
+	close(release)
+	if err := <-blocked; err != nil {
+		t.Errorf("switching O0: %v", err)
+	}
+
+	// O0 started, O1 started and succeeded, O0 succeeded
+	got := []string{}
+	for _, e := range readAudit(t, auditLog) {
+		got = append(got, e.Outlet+" "+e.Result)
+	}
+	want := []string{"O0 started", "O1 started", "O1 success", "O0 success"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("audit entries = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/exporter"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// pool of pollers, one per configured PDU
+type pool struct {
+	mux  sync.RWMutex
+	pdus map[string]*pduRunner
+	wg   sync.WaitGroup
+}
+
+// logoutTimeout of closing the session when a poller stops
+const logoutTimeout = 5 * time.Second
+
+// eventPollTimeout is added to the request timeout of event polls, which the PDU holds
+// until an event arrives or its own poll timeout passes
+const eventPollTimeout = 60
+
+// maxBreakerCooldown limits the pause of polling a PDU that keeps failing
+const maxBreakerCooldown = 15 * time.Minute
+
+// retryBackoff of PDU requests, Steps is the number of retries
+func retryBackoff(retries int) wait.Backoff {
+	return wait.Backoff{
+		Duration: 500 * time.Millisecond,
+		Factor:   2,
+		Jitter:   0.5,
+		Steps:    retries,
+		Cap:      5 * time.Second,
+	}
+}
+
+// runnerConfig is everything a running poller depends on, a change requires a restart
+type runnerConfig struct {
+	Pdu             PduConfig
+	Interval        uint
+	ExporterLabels  map[string]bool
+	LegacyNames     bool
+	RecordDir       string
+	Retries         int
+	BreakerFailures int
+	BreakerCooldown uint
+	Events          bool
+}
+
+type pduRunner struct {
+	conf      runnerConfig
+	collector *exporter.PrometheusCollector
+	cancel    context.CancelFunc
+	done      chan struct{}
+	// client for outlet switching, nil for PDUs not polled over HTTP
+	client *raritan.Client
+}
+
+func newPool() *pool {
+	return &pool{
+		pdus: map[string]*pduRunner{},
+	}
+}
+
+// pduKey identifies a PDU across config reloads
+func pduKey(c PduConfig) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if !c.httpBackend() {
+		return c.Address
+	}
+	return c.Url()
+}
+
+// cassetteName is a file name for the cassette of the PDU with key
+func cassetteName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, key) + ".jsonl"
+}
+
+// Apply starts pollers for new PDUs, stops removed ones and restarts changed ones
+func (p *pool) Apply(ctx context.Context, conf *Config) {
+	want := map[string]runnerConfig{}
+	for _, pduConf := range conf.PduConfig {
+		want[pduKey(pduConf)] = runnerConfig{
+			Pdu:             pduConf,
+			Interval:        conf.Interval,
+			ExporterLabels:  conf.ExporterLabels,
+			LegacyNames:     conf.LegacyMetricNames,
+			RecordDir:       conf.RecordDir,
+			Retries:         conf.Retries,
+			BreakerFailures: conf.BreakerFailures,
+			BreakerCooldown: conf.BreakerCooldown,
+			Events:          conf.Events,
+		}
+	}
+
+	stopped := []*pduRunner{}
+	p.mux.Lock()
+	for k, r := range p.pdus {
+		if w, ok := want[k]; ok && reflect.DeepEqual(w, r.conf) {
+			delete(want, k)
+			continue
+		}
+		klog.Infof("Stopping poller for %s", k)
+		r.cancel()
+		stopped = append(stopped, r)
+		delete(p.pdus, k)
+	}
+	for k, w := range want {
+		r, err := p.start(ctx, w)
+		if err != nil {
+			klog.Errorf("Failed to start poller for %s, skipping pdu: %v", k, err)
+			continue
+		}
+		klog.Infof("Started poller for %s", k)
+		p.pdus[k] = r
+	}
+	p.mux.Unlock()
+
+	// wait outside the lock so scrapes are not blocked by in-flight polls
+	for _, r := range stopped {
+		<-r.done
+	}
+}
+
+// newBackend of the PDU, with the JSON-RPC client recording to stats for PDUs polled over HTTP
+func newBackend(conf runnerConfig, stats *exporter.RPCStats) (backend raritan.Backend, q *raritan.Client, err error) {
+	switch conf.Pdu.Backend {
+	case backendSNMP:
+		backend, err = newSNMPClient(conf.Pdu, conf.Retries)
+	case backendPrometheus:
+		backend, q, err = newDumpBackend(conf, stats)
+	default:
+		q, err = newRPCClient(conf, stats)
+		backend = q
+	}
+	return backend, q, err
+}
+
+func (p *pool) start(ctx context.Context, conf runnerConfig) (*pduRunner, error) {
+	stats := exporter.NewRPCStats()
+	backend, q, err := newBackend(conf, stats)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Pdu.Backend != backendJSONRPC && (conf.Events || conf.RecordDir != "") {
+		klog.Warningf("Events and recording need JSON-RPC, polling %s with %s only", pduKey(conf.Pdu), conf.Pdu.Backend)
+	}
+
+	collector := newCollector(conf.Pdu.Name, conf.ExporterLabels, conf.LegacyNames, conf.Pdu.Labels)
+	if q != nil {
+		collector.RPC = stats
+	}
+	enableSNMP := collector.Labels.SNMPSydLocation || collector.Labels.SNMPSysContact || collector.Labels.SNMPSysName
+
+	poller := exporter.NewPoller(backend, conf.Interval, enableSNMP)
+	poller.SetBreaker(conf.BreakerFailures, wait.Backoff{
+		Duration: time.Duration(conf.BreakerCooldown) * time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      maxBreakerCooldown,
+	})
+	if conf.Events && conf.Pdu.Backend == backendJSONRPC {
+		// a client of its own so the long polls do not hit the request timeout,
+		// they are not recorded as replaying them would not wait for events
+		ev, err := newClient(conf.Pdu.Url(), conf.Pdu.Timeout+eventPollTimeout, conf.Pdu.Username, conf.Pdu.Password, conf.Pdu.TLS, stats)
+		if err != nil {
+			return nil, err
+		}
+		ev.Retry = q.Retry
+		ev.Session = q.Session
+		poller.SetEvents(*ev)
+	}
+	collector.Poller = poller
+
+	ctx, cf := context.WithCancel(ctx)
+	r := &pduRunner{
+		conf:      conf,
+		client:    q,
+		collector: collector,
+		cancel:    cf,
+		done:      make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(r.done)
+		poller.Run(ctx)
+		if q != nil {
+			logout(q)
+		}
+	}()
+	return r, nil
+}
+
+// newRPCClient for a PDU with the JSON-RPC backend, recording its requests in stats
+func newRPCClient(conf runnerConfig, stats *exporter.RPCStats) (*raritan.Client, error) {
+	q, err := newClient(conf.Pdu.Url(), conf.Pdu.Timeout, conf.Pdu.Username, conf.Pdu.Password, conf.Pdu.TLS, stats)
+	if err != nil {
+		return nil, err
+	}
+	q.Retry = retryBackoff(conf.Retries)
+	q.BulkSize = conf.Pdu.BulkSize
+	q.BulkParallelism = conf.Pdu.BulkParallelism
+	if conf.Pdu.Auth == authSession {
+		q.Session = &raritan.Session{}
+	}
+	if conf.RecordDir != "" {
+		path := filepath.Join(conf.RecordDir, cassetteName(pduKey(conf.Pdu)))
+		klog.Infof("Recording %s to %s", pduKey(conf.Pdu), path)
+		q.RPCClient = rpc.NewRecorder(q.RPCClient, path)
+	}
+	return q, nil
+}
+
+// logout of the session of a stopped poller, the PDU limits the number of open sessions
+func logout(q *raritan.Client) {
+	ctx, cf := context.WithTimeout(context.Background(), logoutTimeout)
+	defer cf()
+	if err := q.Logout(ctx); err != nil {
+		klog.Warningf("Failed to log out of %s: %v", q.BaseURL.String(), err)
+	}
+}
+
+// Collectors of all running pollers, ordered by PDU key
+func (p *pool) Collectors() []*exporter.PrometheusCollector {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	keys := make([]string, 0, len(p.pdus))
+	for k := range p.pdus {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	cs := make([]*exporter.PrometheusCollector, len(keys))
+	for i, k := range keys {
+		cs[i] = p.pdus[k].collector
+	}
+	return cs
+}
+
+// Client of the running poller for the PDU with key name, nil for PDUs not polled over HTTP
+func (p *pool) Client(name string) (*raritan.Client, bool) {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	r, ok := p.pdus[name]
+	if !ok {
+		return nil, false
+	}
+	return r.client, true
+}
+
+// Wait for all pollers to stop after the context passed to Apply is cancelled
+func (p *pool) Wait() {
+	p.wg.Wait()
+}
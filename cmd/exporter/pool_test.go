@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// runners of the pool by PDU key
+func runners(p *pool) map[string]*pduRunner {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	rs := map[string]*pduRunner{}
+	for k, r := range p.pdus {
+		rs[k] = r
+	}
+	return rs
+}
+
+func TestPoolApply(t *testing.T) {
+	pdu := newPDUServer(t)
+	conf := func(pdus ...PduConfig) *Config {
+		return &Config{Interval: 3600, PduConfig: pdus}
+	}
+	named := func(name string) PduConfig {
+		p := validPdu(name)
+		p.Address = pdu.URL
+		return p
+	}
+	changed := named("pdu02")
+	changed.Timeout = 20
+
+	// each step applies a config, keeping the pollers of kept and restarting those of restarted
+	tests := []struct {
+		name      string
+		conf      *Config
+		kept      []string
+		restarted []string
+	}{
+		{name: "start", conf: conf(named("pdu01"), named("pdu02"))},
+		{name: "unchanged", conf: conf(named("pdu01"), named("pdu02")), kept: []string{"pdu01", "pdu02"}},
+		{name: "changed pdu", conf: conf(named("pdu01"), changed), kept: []string{"pdu01"}, restarted: []string{"pdu02"}},
+		{name: "changed interval", conf: &Config{Interval: 60, PduConfig: []PduConfig{named("pdu01"), changed}}, restarted: []string{"pdu01", "pdu02"}},
+		{name: "removed", conf: &Config{Interval: 60, PduConfig: []PduConfig{named("pdu01")}}, kept: []string{"pdu01"}},
+		{name: "none", conf: conf()},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := newPool()
+	defer func() {
+		cancel()
+		p.Wait()
+	}()
+	prev := map[string]*pduRunner{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.Apply(ctx, tt.conf)
+			cur := runners(p)
+
+			keys := []string{}
+			for k := range cur {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			want := []string{}
+			for _, pc := range tt.conf.PduConfig {
+				want = append(want, pc.Name)
+			}
+			if strings.Join(keys, ",") != strings.Join(want, ",") {
+				t.Fatalf("pollers = %q, want %q", keys, want)
+			}
+			for _, k := range tt.kept {
+				if cur[k] != prev[k] {
+					t.Errorf("poller of %s restarted, want it kept", k)
+				}
+			}
+			for _, k := range tt.restarted {
+				if cur[k] == prev[k] {
+					t.Errorf("poller of %s kept, want it restarted", k)
+				}
+			}
+			// replaced and removed pollers have stopped when Apply returns
+			for k, r := range prev {
+				if cur[k] == r {
+					continue
+				}
+				select {
+				case <-r.done:
+				default:
+					t.Errorf("poller of %s still running", k)
+				}
+			}
+			prev = cur
+		})
+	}
+}
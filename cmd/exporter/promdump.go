@@ -0,0 +1,30 @@
+package main
+
+import (
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/exporter"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/promdump"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// newDumpBackend for a PDU with the prometheus backend, which reads the dump of PDUs whose firmware
+// serves one and falls back to the returned JSON-RPC client otherwise. Requests are not recorded.
+func newDumpBackend(conf runnerConfig, stats *exporter.RPCStats) (*promdump.Fallback, *raritan.Client, error) {
+	conf.RecordDir = ""
+	q, err := newRPCClient(conf, stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig, err := conf.Pdu.TLS.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	dump := promdump.NewClient(q.BaseURL, time.Duration(conf.Pdu.Timeout)*time.Second, rpc.Auth{
+		Username: conf.Pdu.Username,
+		Password: conf.Pdu.Password,
+	}, tlsConfig)
+	dump.Retry = q.Retry
+	return &promdump.Fallback{Dump: dump, RPC: q}, q, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/snmp"
+)
+
+// defaultCommunity of SNMPv2c agents
+const defaultCommunity = "public"
+
+// SNMPConfig of a PDU polled with the SNMP backend
+type SNMPConfig struct {
+	// Version is 2c or 3
+	Version   string `json:"version" yaml:"version"`
+	Community string `json:"community" yaml:"community"`
+	// Username, protocols and passwords for SNMPv3, empty protocols disable authentication or privacy
+	Username     string `json:"username" yaml:"username"`
+	AuthProtocol string `json:"auth_protocol" yaml:"auth_protocol"`
+	AuthPassword string `json:"auth_password" yaml:"auth_password"`
+	PrivProtocol string `json:"priv_protocol" yaml:"priv_protocol"`
+	PrivPassword string `json:"priv_password" yaml:"priv_password"`
+	ContextName  string `json:"context_name" yaml:"context_name"`
+	// PDUID within a daisy chain, 1 for the first
+	PDUID int `json:"pdu_id" yaml:"pdu_id"`
+}
+
+// newSNMPClient for a PDU with the SNMP backend
+func newSNMPClient(cc PduConfig, retries int) (*snmp.Client, error) {
+	host, port, err := cc.hostPort(backendSNMP)
+	if err != nil {
+		return nil, err
+	}
+	return snmp.NewClient(host, snmp.Config{
+		Port:         port,
+		Version:      cc.SNMP.Version,
+		Community:    cc.SNMP.Community,
+		Username:     cc.SNMP.Username,
+		AuthProtocol: cc.SNMP.AuthProtocol,
+		AuthPassword: cc.SNMP.AuthPassword,
+		PrivProtocol: cc.SNMP.PrivProtocol,
+		PrivPassword: cc.SNMP.PrivPassword,
+		ContextName:  cc.SNMP.ContextName,
+		PDUID:        cc.SNMP.PDUID,
+		Timeout:      time.Duration(cc.Timeout) * time.Second,
+		Retries:      retries,
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig for HTTPS connections to PDUs. Unset fields of a PDU or module are
+// inherited from the global config, which inherits from the command line.
+type TLSConfig struct {
+	// InsecureSkipVerify disables all certificate checks, nil to inherit
+	InsecureSkipVerify *bool `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	// CAFile with PEM certificates to verify the PDU with instead of the system roots
+	CAFile string `json:"ca_file" yaml:"ca_file"`
+	// ServerName to verify the certificate against instead of the address host
+	ServerName string `json:"server_name" yaml:"server_name"`
+	// Fingerprints of pinned certificates as SHA-256 hex, colons are allowed.
+	// The leaf certificate must match one, the chain and host name are not verified,
+	// so they cannot be combined with CAFile or ServerName.
+	Fingerprints []string `json:"fingerprints" yaml:"fingerprints"`
+	// CertFile and KeyFile of a client certificate for mutual TLS
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+}
+
+// inherit unset fields from parent
+func (c TLSConfig) inherit(parent TLSConfig) TLSConfig {
+	if c.InsecureSkipVerify == nil {
+		c.InsecureSkipVerify = parent.InsecureSkipVerify
+	}
+	// pins replace the chain verification, so they are not inherited together with it
+	if len(c.Fingerprints) == 0 && c.CAFile == "" && c.ServerName == "" {
+		c.Fingerprints = parent.Fingerprints
+	}
+	if len(c.Fingerprints) == 0 {
+		if c.CAFile == "" {
+			c.CAFile = parent.CAFile
+		}
+		if c.ServerName == "" {
+			c.ServerName = parent.ServerName
+		}
+	}
+	// a client certificate is only inherited as a pair
+	if c.CertFile == "" && c.KeyFile == "" {
+		c.CertFile = parent.CertFile
+		c.KeyFile = parent.KeyFile
+	}
+	return c
+}
+
+// Insecure when certificate verification is disabled
+func (c TLSConfig) Insecure() bool {
+	return c.InsecureSkipVerify != nil && *c.InsecureSkipVerify
+}
+
+// Build the TLS client config, reading the CA and client certificate files
+func (c TLSConfig) Build() (*tls.Config, error) {
+	if len(c.Fingerprints) > 0 && (c.CAFile != "" || c.ServerName != "") {
+		return nil, errors.New("fingerprints replace the verification with ca_file and server_name, set either")
+	}
+
+	conf := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.Insecure(),
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("cert_file and key_file must both be set")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(c.Fingerprints) > 0 {
+		pins := make([][]byte, 0, len(c.Fingerprints))
+		for _, f := range c.Fingerprints {
+			pin, err := parseFingerprint(f)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, pin)
+		}
+		// the pin replaces the chain verification, PDUs mostly use self-signed certificates
+		conf.InsecureSkipVerify = true
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no peer certificate to match the pinned fingerprints")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			for _, pin := range pins {
+				if bytes.Equal(pin, sum[:]) {
+					return nil
+				}
+			}
+			return fmt.Errorf("certificate fingerprint %s is not pinned", hex.EncodeToString(sum[:]))
+		}
+	}
+
+	return conf, nil
+}
+
+// parseFingerprint of a SHA-256 digest in hex, optionally separated by colons
+func parseFingerprint(f string) ([]byte, error) {
+	bs, err := hex.DecodeString(strings.ReplaceAll(f, ":", ""))
+	if err != nil || len(bs) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", f)
+	}
+	return bs, nil
+}
+
+// AdminTLSConfig for the admin listener
+type AdminTLSConfig struct {
+	// CertFile and KeyFile of the server certificate, the listener is plain HTTP without them
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+	// ClientCAFile with PEM certificates, clients must present a certificate signed by one of them
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file"`
+}
+
+// Build the TLS server config, nil for plain HTTP
+func (c AdminTLSConfig) Build() (*tls.Config, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.ClientCAFile != "" {
+			return nil, errors.New("client_ca_file needs cert_file and key_file")
+		}
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file must both be set")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading server certificate: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA file: %w", err)
+		}
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", c.ClientCAFile)
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
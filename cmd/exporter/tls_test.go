@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, written to CertFile and KeyFile as PEM
+type testCert struct {
+	Cert     *x509.Certificate
+	Key      *ecdsa.PrivateKey
+	CertFile string
+	KeyFile  string
+}
+
+// TLS returns the certificate for a tls.Config
+func (c testCert) TLS() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.Cert.Raw}, PrivateKey: c.Key}
+}
+
+// newTestCert for 127.0.0.1 written to dir, a CA if parent is nil, else signed by parent
+func newTestCert(t *testing.T, dir, name string, parent *testCert) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Cert, parent.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testCert{
+		Cert:     cert,
+		Key:      key,
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	if err := os.WriteFile(c.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestAdminTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", &ca)
+	client := newTestCert(t, dir, "client", &ca)
+	other := newTestCert(t, dir, "other", nil)
+
+	tests := []struct {
+		name string
+		conf AdminTLSConfig
+		// client certificate, if any
+		cert *testCert
+		// err of Build or of the request
+		buildErr string
+		err      string
+	}{
+		{
+			name: "server certificate",
+			conf: AdminTLSConfig{CertFile: server.CertFile, KeyFile: server.KeyFile},
+		},
+		{
+			name: "client certificate",
+			conf: AdminTLSConfig{CertFile: server.CertFile, KeyFile: server.KeyFile, ClientCAFile: ca.CertFile},
+			cert: &client,
+		},
+		{
+			name: "client certificate missing",
+			conf: AdminTLSConfig{CertFile: server.CertFile, KeyFile: server.KeyFile, ClientCAFile: ca.CertFile},
+			err:  "certificate required",
+		},
+		{
+			name: "client certificate of another CA",
+			conf: AdminTLSConfig{CertFile: server.CertFile, KeyFile: server.KeyFile, ClientCAFile: ca.CertFile},
+			cert: &other,
+			err:  "unknown certificate authority",
+		},
+		{
+			name:     "key missing",
+			conf:     AdminTLSConfig{CertFile: server.CertFile},
+			buildErr: "cert_file and key_file must both be set",
+		},
+		{
+			name:     "client CA without certificate",
+			conf:     AdminTLSConfig{ClientCAFile: ca.CertFile},
+			buildErr: "client_ca_file needs cert_file and key_file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := tt.conf.Build()
+			if tt.buildErr != "" {
+				if err == nil || err.Error() != tt.buildErr {
+					t.Fatalf("err = %v, want %s", err, tt.buildErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			srv := httptest.NewUnstartedServer(adminRouter())
+			srv.TLS = conf
+			srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+			srv.StartTLS()
+			defer srv.Close()
+
+			clientConf := &tls.Config{RootCAs: x509.NewCertPool()}
+			clientConf.RootCAs.AddCert(ca.Cert)
+			if tt.cert != nil {
+				// sent even if not signed by a CA the server accepts
+				cert := tt.cert.TLS()
+				clientConf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				}
+			}
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConf}}
+			res, err := c.Post(srv.URL+"/admin/outlets", "", nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			// the request is authenticated after the handshake
+			if res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestTLSConfigBuild(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", &ca)
+	client := newTestCert(t, dir, "client", &ca)
+	sum := sha256.Sum256(server.Cert.Raw)
+	pin := hex.EncodeToString(sum[:])
+	// as shown by openssl x509 -fingerprint
+	colonPin := strings.ToUpper(pin)
+	for i := len(pin) - 2; i > 0; i -= 2 {
+		colonPin = colonPin[:i] + ":" + colonPin[i:]
+	}
+	insecure := true
+
+	tests := []struct {
+		name string
+		conf TLSConfig
+		// clientAuth makes the PDU require a client certificate signed by the CA
+		clientAuth bool
+		buildErr   string
+		err        string
+	}{
+		{name: "system roots", conf: TLSConfig{}, err: "certificate signed by unknown authority"},
+		{name: "ca file", conf: TLSConfig{CAFile: ca.CertFile}},
+		{name: "server name mismatch", conf: TLSConfig{CAFile: ca.CertFile, ServerName: "pdu01.example.com"}, err: "wanted to match pdu01.example.com"},
+		{name: "insecure", conf: TLSConfig{InsecureSkipVerify: &insecure}},
+		{name: "pinned", conf: TLSConfig{Fingerprints: []string{"00" + pin[2:], pin}}},
+		{name: "pinned with colons", conf: TLSConfig{Fingerprints: []string{colonPin}}},
+		{name: "not pinned", conf: TLSConfig{Fingerprints: []string{"00" + pin[2:]}}, err: "certificate fingerprint " + pin + " is not pinned"},
+		{name: "pin with ca file", conf: TLSConfig{Fingerprints: []string{pin}, CAFile: ca.CertFile}, buildErr: "fingerprints replace the verification"},
+		{name: "invalid pin", conf: TLSConfig{Fingerprints: []string{"abc"}}, buildErr: `invalid SHA-256 fingerprint "abc"`},
+		{name: "client certificate", conf: TLSConfig{CAFile: ca.CertFile, CertFile: client.CertFile, KeyFile: client.KeyFile}, clientAuth: true},
+		{name: "client certificate missing", conf: TLSConfig{CAFile: ca.CertFile}, clientAuth: true, err: "certificate required"},
+		{name: "client key missing", conf: TLSConfig{CertFile: client.CertFile}, buildErr: "cert_file and key_file must both be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := tt.conf.Build()
+			if tt.buildErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.buildErr) {
+					t.Fatalf("err = %v, want %s", err, tt.buildErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pdu := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			pdu.TLS = &tls.Config{Certificates: []tls.Certificate{server.TLS()}}
+			if tt.clientAuth {
+				pdu.TLS.ClientCAs = x509.NewCertPool()
+				pdu.TLS.ClientCAs.AddCert(ca.Cert)
+				pdu.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			pdu.Config.ErrorLog = log.New(io.Discard, "", 0)
+			pdu.StartTLS()
+			defer pdu.Close()
+
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: conf}}
+			res, err := c.Get(pdu.URL)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+		})
+	}
+}
+
+func TestTLSConfigInherit(t *testing.T) {
+	yes, no := true, false
+	parent := TLSConfig{
+		InsecureSkipVerify: &no,
+		CAFile:             "ca.crt",
+		ServerName:         "pdu.example.com",
+		CertFile:           "client.crt",
+		KeyFile:            "client.key",
+	}
+	pinned := TLSConfig{Fingerprints: []string{"aa"}, CertFile: "client.crt", KeyFile: "client.key"}
+
+	tests := []struct {
+		name   string
+		conf   TLSConfig
+		parent TLSConfig
+		want   TLSConfig
+	}{
+		{name: "unset", parent: parent, want: parent},
+		{
+			name:   "own settings",
+			conf:   TLSConfig{InsecureSkipVerify: &yes, ServerName: "pdu01", CertFile: "pdu01.crt", KeyFile: "pdu01.key"},
+			parent: parent,
+			want:   TLSConfig{InsecureSkipVerify: &yes, CAFile: "ca.crt", ServerName: "pdu01", CertFile: "pdu01.crt", KeyFile: "pdu01.key"},
+		},
+		{
+			name:   "pins replace the ca file",
+			conf:   TLSConfig{Fingerprints: []string{"bb"}},
+			parent: parent,
+			want:   TLSConfig{InsecureSkipVerify: &no, Fingerprints: []string{"bb"}, CertFile: "client.crt", KeyFile: "client.key"},
+		},
+		{name: "pins inherited", parent: pinned, want: pinned},
+		{
+			name:   "ca file replaces pins",
+			conf:   TLSConfig{CAFile: "pdu01-ca.crt"},
+			parent: pinned,
+			want:   TLSConfig{CAFile: "pdu01-ca.crt", CertFile: "client.crt", KeyFile: "client.key"},
+		},
+		{
+			name:   "client certificate only as a pair",
+			conf:   TLSConfig{KeyFile: "pdu01.key"},
+			parent: pinned,
+			want:   TLSConfig{Fingerprints: []string{"aa"}, KeyFile: "pdu01.key"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conf.inherit(tt.parent); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inherit = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
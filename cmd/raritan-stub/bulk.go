@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"net/url"
 
@@ -28,7 +27,8 @@ type bulkResponse struct {
 	JSON     *rpc.Response
 }
 
-func bulkHandler(c rpc.Client, port uint) http.HandlerFunc {
+// bulkHandler performs the bulk requests with c against the stub itself at baseURL
+func bulkHandler(c rpc.Client, baseURL url.URL) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req, err := jsonRequest(w, r)
 		if err != nil {
@@ -47,12 +47,22 @@ func bulkHandler(c rpc.Client, port uint) http.HandlerFunc {
 			return
 		}
 
-		baseURL, _ := url.Parse(fmt.Sprintf("http://localhost:%d", port))
-
+		ctx := r.Context()
 		res := make([]bulkResponse, len(bulk.Requests))
 		for i, r := range bulk.Requests {
+			if f, ok := injectFault(FaultBulkStatus, r.RID); ok {
+				code := f.Status
+				if code == 0 {
+					code = 500
+				}
+				res[i] = bulkResponse{
+					StatCode: code,
+				}
+				continue
+			}
+
 			path, _ := url.Parse(r.RID)
-			r, err := c.Call(*baseURL.ResolveReference(path), r.JSON)
+			r, err := c.Call(ctx, *baseURL.ResolveReference(path), r.JSON)
 			code := 200
 			if err != nil {
 				klog.Errorf("Error performing bulk call: %v", err)
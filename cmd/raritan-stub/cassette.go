@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/klog/v2"
+)
+
+func loadCassette(path string) (*rpc.Replayer, error) {
+	is, err := rpc.LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Serving %d recorded interactions from %s", len(is), path)
+	return rpc.NewReplayer(is)
+}
+
+// cassetteHandler answers every request with the recorded response for its path and request
+func cassetteHandler(replayer *rpc.Replayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		res, err := replayer.Call(r.Context(), *r.URL, *req)
+		se := rpc.StatusError{}
+		if errors.Is(err, rpc.ErrNoInteraction) {
+			klog.Warning(err)
+			http.NotFound(w, r)
+			return
+		} else if errors.As(err, &se) {
+			http.Error(w, se.Status, se.StatusCode)
+			return
+		} else if err != nil {
+			// the recorded call failed without a response
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		jsonResponse(w, *res)
+	}
+}
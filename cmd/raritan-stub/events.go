@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/klog/v2"
+)
+
+// eventControlPath publishes events posted to it, faults are never injected on it
+const eventControlPath = "/_stub/events"
+
+const (
+	// channelExpiry removes channels that have not been polled for this long
+	channelExpiry = 5 * time.Minute
+	// maxQueuedEvents per channel, older events are dropped
+	maxQueuedEvents = 1000
+	// stateWatchInterval of comparing sensor states for state change events
+	stateWatchInterval = time.Second
+	// outletResourceType of outlets in event sources
+	outletResourceType = "pdumodel.Outlet_2_1_4"
+)
+
+// event as serialized by the PDU, value holds the event specific fields
+type event struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type stateChangedEvent struct {
+	Source     raritan.Resource `json:"source"`
+	OldReading raritan.Reading  `json:"oldReading"`
+	NewReading raritan.Reading  `json:"newReading"`
+}
+
+type powerStateChangedEvent struct {
+	Source        raritan.Resource `json:"source"`
+	OldPowerState int              `json:"oldPowerState"`
+	NewPowerState int              `json:"newPowerState"`
+}
+
+type pollEventsResult struct {
+	Return int     `json:"_ret_"`
+	Events []event `json:"events"`
+}
+
+type eventChannel struct {
+	// types demanded without interface versions
+	types    map[string]bool
+	queue    []event
+	notify   chan struct{}
+	lastPoll time.Time
+}
+
+// eventHub of the event service at /event_service, channels are served at /event_channel/{id}
+type eventHub struct {
+	mux      sync.Mutex
+	timeout  time.Duration
+	channels map[int]*eventChannel
+	nextID   int
+}
+
+// events of the stub, outlet switching and sensor state changes are published to it
+var events = &eventHub{
+	channels: map[int]*eventChannel{},
+}
+
+// eventName strips the interface versions from an event type
+func eventName(t string) string {
+	return raritan.Event{Type: t}.Name()
+}
+
+// publish an event to the channels that demanded its type
+func (h *eventHub) publish(typ string, value interface{}) {
+	bs, err := json.Marshal(value)
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	e := event{
+		Type:  typ,
+		Value: bs,
+	}
+	name := eventName(typ)
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for id, ch := range h.channels {
+		if time.Since(ch.lastPoll) > channelExpiry {
+			klog.Infof("Event channel %d expired", id)
+			delete(h.channels, id)
+			continue
+		}
+		if !ch.types[name] {
+			continue
+		}
+		ch.queue = append(ch.queue, e)
+		if len(ch.queue) > maxQueuedEvents {
+			ch.queue = ch.queue[len(ch.queue)-maxQueuedEvents:]
+		}
+		select {
+		case ch.notify <- struct{}{}:
+		default:
+		}
+	}
+	klog.V(1).Infof("Published %s: %s", typ, bs)
+}
+
+// listening reports if any channel is open
+func (h *eventHub) listening() bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return len(h.channels) > 0
+}
+
+func (h *eventHub) open() int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.nextID++
+	h.channels[h.nextID] = &eventChannel{
+		types:    map[string]bool{},
+		notify:   make(chan struct{}, 1),
+		lastPoll: time.Now(),
+	}
+	klog.Infof("Opened event channel %d, %d open", h.nextID, len(h.channels))
+	return h.nextID
+}
+
+func (h *eventHub) channel(id int) (*eventChannel, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	ch, ok := h.channels[id]
+	if ok {
+		ch.lastPoll = time.Now()
+	}
+	return ch, ok
+}
+
+func (h *eventHub) demand(ch *eventChannel, types []string, demand bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for _, t := range types {
+		if demand {
+			ch.types[eventName(t)] = true
+		} else {
+			delete(ch.types, eventName(t))
+		}
+	}
+}
+
+// take the queued events of the channel
+func (h *eventHub) take(ch *eventChannel) []event {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	evs := ch.queue
+	ch.queue = nil
+	ch.lastPoll = time.Now()
+	return evs
+}
+
+// poll waits up to the poll timeout for events on the channel
+func (h *eventHub) poll(r *http.Request, ch *eventChannel) []event {
+	if evs := h.take(ch); len(evs) > 0 {
+		return evs
+	}
+	t := time.NewTimer(h.timeout)
+	defer t.Stop()
+	select {
+	case <-ch.notify:
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+	return h.take(ch)
+}
+
+func eventServiceHandler(h *eventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		switch method := req.Method; method {
+		case "newChannel":
+			raritanResultJSON(w, raritan.Resource{
+				RID:  fmt.Sprintf("/event_channel/%d", h.open()),
+				Type: "event.Channel_1_0_0",
+			})
+		default:
+			jsonMethodNotFound(w, method)
+		}
+	}
+}
+
+func eventChannelHandler(h *eventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		ch, ok := h.channel(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		switch method := req.Method; method {
+		case "demandEventType", "cancelEventType":
+			t, ok := req.Params["typeId"].(string)
+			if !ok {
+				jsonInvalidParams(w)
+				return
+			}
+			h.demand(ch, []string{t}, method == "demandEventType")
+			raritanResultJSON(w, 0)
+		case "demandEventTypes", "cancelEventTypes":
+			ts, ok := req.Params["typeIds"].([]interface{})
+			if !ok {
+				jsonInvalidParams(w)
+				return
+			}
+			types := make([]string, 0, len(ts))
+			for _, t := range ts {
+				s, ok := t.(string)
+				if !ok {
+					jsonInvalidParams(w)
+					return
+				}
+				types = append(types, s)
+			}
+			h.demand(ch, types, method == "demandEventTypes")
+			raritanResultJSON(w, 0)
+		case "pollEvents":
+			jsonResult(w, pollEventsResult{
+				Events: nonNilEvents(h.poll(r, ch)),
+			})
+		case "pollEventsNb":
+			jsonResult(w, pollEventsResult{
+				Events: nonNilEvents(h.take(ch)),
+			})
+		default:
+			jsonMethodNotFound(w, method)
+		}
+	}
+}
+
+func nonNilEvents(evs []event) []event {
+	if evs == nil {
+		return []event{}
+	}
+	return evs
+}
+
+func jsonInvalidParams(w http.ResponseWriter) {
+	jsonError(w, rpc.Error{
+		Code:    -32602,
+		Message: "Invalid params",
+	})
+}
+
+// eventsHandler publishes the events posted as JSON, a single event or a list
+func eventsHandler(h *eventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		evs := []event{}
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			if err := json.Unmarshal(raw, &evs); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			e := event{}
+			if err := json.Unmarshal(raw, &e); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			evs = append(evs, e)
+		}
+		for _, e := range evs {
+			if e.Type == "" {
+				http.Error(w, "event type missing", http.StatusBadRequest)
+				return
+			}
+		}
+		for _, e := range evs {
+			h.publish(e.Type, e.Value)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// watchStates publishes state changes of the sensors while channels are open. State
+// sensors report every change of their value, numeric sensors changes of their status.
+func watchStates(t *topology) {
+	rids := make([]string, 0, len(t.sensors))
+	for rid := range t.sensors {
+		rids = append(rids, rid)
+	}
+	sort.Strings(rids)
+
+	last := map[string]raritan.Reading{}
+	for range time.Tick(stateWatchInterval) {
+		if !events.listening() {
+			last = map[string]raritan.Reading{}
+			continue
+		}
+		for _, rid := range rids {
+			s := t.sensors[rid]
+			r := s.reading()
+			prev, ok := last[rid]
+			last[rid] = r
+			if !ok {
+				continue
+			}
+			numeric := s.spec.Readingtype == 0
+			if numeric && prev.Status == r.Status || !numeric && prev.Value == r.Value {
+				continue
+			}
+			kind := "sensors.StateSensor_4_0_2.StateChangedEvent"
+			if numeric {
+				kind = "sensors.NumericSensor_4_0_2.StateChangedEvent"
+			}
+			events.publish(kind, stateChangedEvent{
+				Source:     s.resource,
+				OldReading: prev,
+				NewReading: r,
+			})
+		}
+	}
+}
+
+// publishPowerState of an outlet after it was switched
+func publishPowerState(id string, old, state int) {
+	if old == state {
+		return
+	}
+	events.publish(outletResourceType+".PowerStateChangedEvent", powerStateChangedEvent{
+		Source: raritan.Resource{
+			RID:  "/model/outlet/" + id,
+			Type: outletResourceType,
+		},
+		OldPowerState: old,
+		NewPowerState: state,
+	})
+}
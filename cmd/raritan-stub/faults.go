@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// Fault kinds injected by the stub
+const (
+	// FaultLatency delays responses
+	FaultLatency = "latency"
+	// FaultHTTPError responds with an HTTP error status
+	FaultHTTPError = "http_error"
+	// FaultRPCError responds with a JSON-RPC error object
+	FaultRPCError = "rpc_error"
+	// FaultMalformedJSON responds with truncated JSON
+	FaultMalformedJSON = "malformed_json"
+	// FaultDropConnection closes the connection without a response
+	FaultDropConnection = "drop_connection"
+	// FaultBulkStatus sets a non-200 StatCode on bulk response entries
+	FaultBulkStatus = "bulk_status"
+	// FaultSensorUnavailable reports sensor readings as not available
+	FaultSensorUnavailable = "sensor_unavailable"
+)
+
+var faultKinds = []string{
+	FaultLatency, FaultHTTPError, FaultRPCError, FaultMalformedJSON,
+	FaultDropConnection, FaultBulkStatus, FaultSensorUnavailable,
+}
+
+// faultControlPath is the runtime control endpoint, faults are never injected on it
+const faultControlPath = "/_stub/faults"
+
+// Faults by kind
+type Faults map[string]*Fault
+
+// Fault injected into matching requests
+type Fault struct {
+	// Rate is the probability of injecting the fault per request, default 1
+	Rate *float64 `yaml:"rate,omitempty"`
+	// Schedule restricts the fault to recurring time windows
+	Schedule *FaultSchedule `yaml:"schedule,omitempty"`
+	// Path is a regular expression matched against the request path or bulk resource id
+	Path string `yaml:"path,omitempty"`
+	// Latency added for latency faults
+	Latency time.Duration `yaml:"latency,omitempty"`
+	// Status for http_error (default 503) and bulk_status (default 500) faults
+	Status int `yaml:"status,omitempty"`
+	// Code for rpc_error faults, default -32000
+	Code int `yaml:"code,omitempty"`
+
+	path  *regexp.Regexp
+	since time.Time
+}
+
+// FaultSchedule is active for the duration For at the start of Every period
+type FaultSchedule struct {
+	Every time.Duration `yaml:"every"`
+	For   time.Duration `yaml:"for"`
+}
+
+// activeFaults set from the fault file and the control endpoint
+var activeFaults = struct {
+	sync.RWMutex
+	faults Faults
+}{faults: Faults{}}
+
+// LoadFaults from a YAML file
+func LoadFaults(path string) (Faults, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening faults: %w", err)
+	}
+	defer f.Close()
+	return decodeFaults(f)
+}
+
+func decodeFaults(r io.Reader) (Faults, error) {
+	fs := Faults{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&fs); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing faults: %w", err)
+	}
+	return fs, fs.validate()
+}
+
+func isFaultKind(kind string) bool {
+	for _, k := range faultKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs Faults) validate() error {
+	for kind, f := range fs {
+		if !isFaultKind(kind) {
+			return fmt.Errorf("unknown fault %q, must be one of %s", kind, strings.Join(faultKinds, ", "))
+		}
+		if f == nil {
+			return fmt.Errorf("fault %s is empty", kind)
+		}
+		if f.Rate != nil && (*f.Rate < 0 || *f.Rate > 1) {
+			return fmt.Errorf("fault %s rate must be between 0 and 1", kind)
+		}
+		if s := f.Schedule; s != nil && (s.Every <= 0 || s.For <= 0) {
+			return fmt.Errorf("fault %s schedule requires every and for", kind)
+		}
+		if f.Path != "" {
+			re, err := regexp.Compile(f.Path)
+			if err != nil {
+				return fmt.Errorf("fault %s path: %w", kind, err)
+			}
+			f.path = re
+		}
+	}
+	return nil
+}
+
+// setFaults replaces all active faults, schedules start now
+func setFaults(fs Faults) {
+	now := time.Now()
+	for _, f := range fs {
+		f.since = now
+	}
+	activeFaults.Lock()
+	activeFaults.faults = fs
+	activeFaults.Unlock()
+	klog.Infof("Active faults: %s", strings.Join(fs.kinds(), ", "))
+}
+
+func (fs Faults) kinds() []string {
+	ks := []string{}
+	for _, k := range faultKinds {
+		if _, ok := fs[k]; ok {
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+// injectFault returns the fault of kind if it should be injected for path
+func injectFault(kind string, path string) (*Fault, bool) {
+	activeFaults.RLock()
+	f, ok := activeFaults.faults[kind]
+	activeFaults.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	if f.path != nil && !f.path.MatchString(path) {
+		return nil, false
+	}
+	if s := f.Schedule; s != nil && time.Since(f.since)%s.Every >= s.For {
+		return nil, false
+	}
+	if f.Rate != nil && rand.Float64() >= *f.Rate {
+		return nil, false
+	}
+	klog.V(1).Infof("Injecting fault %s for %s", kind, path)
+	return f, true
+}
+
+// faultInjector applies request level faults before passing requests on
+func faultInjector(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if path == faultControlPath || path == eventControlPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if f, ok := injectFault(FaultLatency, path); ok {
+			time.Sleep(f.Latency)
+		}
+		if _, ok := injectFault(FaultDropConnection, path); ok {
+			dropConnection(w)
+			return
+		}
+		if f, ok := injectFault(FaultHTTPError, path); ok {
+			status := f.Status
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if f, ok := injectFault(FaultRPCError, path); ok {
+			code := f.Code
+			if code == 0 {
+				code = -32000
+			}
+			jsonError(w, rpc.Error{
+				Code:    code,
+				Message: "Injected fault",
+			})
+			return
+		}
+		if _, ok := injectFault(FaultMalformedJSON, path); ok {
+			if _, err := w.Write([]byte(`{"jsonrpc":"2.0","result":{"_ret_":`)); err != nil {
+				klog.Error(err)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// dropConnection closes the underlying connection without writing a response
+func dropConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		klog.Error("Connection does not support hijacking, cannot drop it")
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	conn.Close()
+}
+
+// faultsHandler shows (GET), replaces (PUT, POST) or clears (DELETE) the active faults
+func faultsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		fs, err := decodeFaults(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setFaults(fs)
+	case http.MethodDelete:
+		setFaults(Faults{})
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	activeFaults.RLock()
+	bs, err := yaml.Marshal(activeFaults.faults)
+	activeFaults.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(bs); err != nil {
+		klog.Error(err)
+	}
+}
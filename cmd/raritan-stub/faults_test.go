@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// withFaults activates the faults in YAML for the test
+func withFaults(t *testing.T, faults string) {
+	t.Helper()
+	fs, err := decodeFaults(strings.NewReader(faults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFaults(fs)
+	t.Cleanup(func() { setFaults(Faults{}) })
+}
+
+// callStub calls method on path of a server for h with the exporter's JSON-RPC client
+func callStub(t *testing.T, h http.Handler, path string, req rpc.Request) (*rpc.Response, error) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rpc.NewClient(5*time.Second, rpc.Auth{}, nil).Call(context.Background(), *u, req)
+}
+
+func TestDecodeFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults string
+		err    string
+	}{
+		{name: "valid", faults: "latency: {latency: 1s, rate: 0.5}\nhttp_error: {status: 500, path: ^/bulk$, schedule: {every: 1m, for: 10s}}\n"},
+		{name: "unknown kind", faults: "timeout: {}\n", err: `unknown fault "timeout"`},
+		{name: "unknown field", faults: "latency: {delay: 1s}\n", err: "field delay not found"},
+		{name: "empty", faults: "latency:\n", err: "fault latency is empty"},
+		{name: "rate", faults: "rpc_error: {rate: 2}\n", err: "fault rpc_error rate must be between 0 and 1"},
+		{name: "schedule", faults: "rpc_error: {schedule: {every: 1m}}\n", err: "fault rpc_error schedule requires every and for"},
+		{name: "path", faults: "rpc_error: {path: \"(\"}\n", err: "fault rpc_error path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeFaults(strings.NewReader(tt.faults))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestInjectFault(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults string
+		// since shifts the start of the schedules into the past
+		since time.Duration
+		path  string
+		want  bool
+	}{
+		{name: "always", faults: "rpc_error: {}\n", path: "/bulk", want: true},
+		{name: "other kind", faults: "http_error: {}\n", path: "/bulk"},
+		{name: "path matches", faults: "rpc_error: {path: ^/model/outlet/}\n", path: "/model/outlet/1", want: true},
+		{name: "path does not match", faults: "rpc_error: {path: ^/model/outlet/}\n", path: "/model/inlet/0"},
+		{name: "rate 0", faults: "rpc_error: {rate: 0}\n", path: "/bulk"},
+		{name: "in schedule", faults: "rpc_error: {schedule: {every: 1h, for: 10m}}\n", since: 65 * time.Minute, path: "/bulk", want: true},
+		{name: "out of schedule", faults: "rpc_error: {schedule: {every: 1h, for: 10m}}\n", since: 15 * time.Minute, path: "/bulk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFaults(t, tt.faults)
+			for _, f := range activeFaults.faults {
+				f.since = f.since.Add(-tt.since)
+			}
+			if _, got := injectFault(FaultRPCError, tt.path); got != tt.want {
+				t.Errorf("injected = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raritanResultJSON(w, 0)
+	})
+	tests := []struct {
+		name   string
+		faults string
+		path   string
+		// check the response and error of the exporter's client
+		check func(t *testing.T, res *rpc.Response, err error)
+	}{
+		{
+			name:   "http error",
+			faults: "http_error: {status: 502}\n",
+			check: func(t *testing.T, res *rpc.Response, err error) {
+				var se rpc.StatusError
+				if !errors.As(err, &se) || se.StatusCode != http.StatusBadGateway {
+					t.Errorf("err = %v, want status 502", err)
+				}
+			},
+		},
+		{
+			name:   "rpc error",
+			faults: "rpc_error: {code: -32601}\n",
+			check: func(t *testing.T, res *rpc.Response, err error) {
+				if err != nil || res.Error == nil || res.Error.Code != -32601 {
+					t.Errorf("response %+v, err %v, want RPC error -32601", res, err)
+				}
+			},
+		},
+		{
+			name:   "malformed json",
+			faults: "malformed_json: {}\n",
+			check: func(t *testing.T, res *rpc.Response, err error) {
+				if err == nil || !strings.Contains(err.Error(), "Error unmarshalling response") {
+					t.Errorf("err = %v, want an unmarshalling error", err)
+				}
+			},
+		},
+		{
+			name:   "dropped connection",
+			faults: "drop_connection: {}\n",
+			check: func(t *testing.T, res *rpc.Response, err error) {
+				if err == nil || !strings.Contains(err.Error(), "Error performing request") {
+					t.Errorf("err = %v, want a connection error", err)
+				}
+			},
+		},
+		{
+			name:   "latency",
+			faults: "latency: {latency: 100ms}\n",
+			check: func(t *testing.T, res *rpc.Response, err error) {
+				if err != nil || !res.IsSuccess() {
+					t.Errorf("response %+v, err %v, want a result", res, err)
+				}
+			},
+		},
+		{
+			name:   "control endpoint",
+			faults: "http_error: {}\n",
+			path:   faultControlPath,
+			check: func(t *testing.T, res *rpc.Response, err error) {
+				if err != nil || !res.IsSuccess() {
+					t.Errorf("response %+v, err %v, want a result", res, err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFaults(t, tt.faults)
+			path := tt.path
+			if path == "" {
+				path = "/model/pdu/0"
+			}
+			start := time.Now()
+			res, err := callStub(t, faultInjector(ok), path, rpc.Request{Method: "getMetaData"})
+			tt.check(t, res, err)
+			if f, ok := activeFaults.faults[FaultLatency]; ok && time.Since(start) < f.Latency {
+				t.Errorf("response after %s, want at least %s", time.Since(start), f.Latency)
+			}
+		})
+	}
+}
+
+func TestFaultsHandler(t *testing.T) {
+	t.Cleanup(func() { setFaults(Faults{}) })
+	srv := httptest.NewServer(http.HandlerFunc(faultsHandler))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		kinds  []string
+	}{
+		{name: "set", method: http.MethodPut, body: "latency: {latency: 1s}\nrpc_error: {rate: 0.1}\n", status: http.StatusOK, kinds: []string{FaultLatency, FaultRPCError}},
+		{name: "get", method: http.MethodGet, status: http.StatusOK, kinds: []string{FaultLatency, FaultRPCError}},
+		{name: "invalid is not applied", method: http.MethodPost, body: "timeout: {}\n", status: http.StatusBadRequest, kinds: []string{FaultLatency, FaultRPCError}},
+		{name: "replace", method: http.MethodPost, body: "bulk_status: {status: 404}\n", status: http.StatusOK, kinds: []string{FaultBulkStatus}},
+		{name: "clear", method: http.MethodDelete, status: http.StatusOK, kinds: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			activeFaults.RLock()
+			kinds := activeFaults.faults.kinds()
+			activeFaults.RUnlock()
+			if strings.Join(kinds, ",") != strings.Join(tt.kinds, ",") {
+				t.Errorf("faults = %q, want %q", kinds, tt.kinds)
+			}
+		})
+	}
+}
+
+// ridClient returns the RID of every call as result
+type ridClient struct{}
+
+func (ridClient) Call(ctx context.Context, u url.URL, req rpc.Request) (*rpc.Response, error) {
+	ret := json.RawMessage(fmt.Sprintf(`{"_ret_":%q}`, u.Path))
+	return &rpc.Response{Result: &ret}, nil
+}
+
+func (ridClient) BatchCall(ctx context.Context, u url.URL, reqs []rpc.Request) ([]rpc.Response, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestBulkStatusFault(t *testing.T) {
+	withFaults(t, "bulk_status: {status: 404, path: ^/model/outlet/1$}\n")
+	res, err := callStub(t, bulkHandler(ridClient{}, url.URL{Scheme: "http", Host: "localhost"}), "/bulk", rpc.Request{
+		Method: "performBulk",
+		Params: map[string]interface{}{
+			"requests": []map[string]interface{}{
+				{"rid": "/model/outlet/0", "json": map[string]interface{}{"method": "getState"}},
+				{"rid": "/model/outlet/1", "json": map[string]interface{}{"method": "getState"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulk := bulkResult{}
+	if err := json.Unmarshal(*res.Result, &bulk); err != nil {
+		t.Fatal(err)
+	}
+	codes := []int{}
+	for _, r := range bulk.Responses {
+		codes = append(codes, r.StatCode)
+	}
+	if fmt.Sprint(codes) != "[200 404]" {
+		t.Errorf("status codes = %v, want [200 404]", codes)
+	}
+}
+
+func TestSensorUnavailableFault(t *testing.T) {
+	topo, err := loadTopology(t, "outlets: [{label: \"1\", sensors: {current: {value: {generator: constant, value: 2}}}}, {label: \"2\", sensors: {current: {value: {generator: constant, value: 2}}}}]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withFaults(t, "sensor_unavailable: {path: ^/model/outlet/1/}\n")
+
+	for path, want := range map[string]bool{"/model/outlet/0/current": true, "/model/outlet/1/current": false} {
+		res, err := callStub(t, sensorHandler(topo), path, rpc.Request{Method: "getReading"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := struct {
+			Return raritan.Reading `json:"_ret_"`
+		}{}
+		if err := json.Unmarshal(*res.Result, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret.Return.Available != want {
+			t.Errorf("%s: available = %t, want %t", path, ret.Return.Available, want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fixture describing a complete PDU served by the stub
+type Fixture struct {
+	Name              string              `yaml:"name"`
+	Nameplate         NameplateFixture    `yaml:"nameplate"`
+	Firmware          string              `yaml:"firmware"`
+	Hardware          string              `yaml:"hardware"`
+	CtrlBoardSerial   string              `yaml:"ctrl_board_serial"`
+	MacAddress        string              `yaml:"mac_address"`
+	MeteredOutlets    bool                `yaml:"metered_outlets"`
+	SwitchableOutlets bool                `yaml:"switchable_outlets"`
+	Inlets            []InletFixture      `yaml:"inlets"`
+	Outlets           []OutletFixture     `yaml:"outlets"`
+	OCPs              []OCPFixture        `yaml:"ocps"`
+	Peripherals       []PeripheralFixture `yaml:"peripherals"`
+}
+
+// NameplateFixture of the PDU
+type NameplateFixture struct {
+	Manufacturer string `yaml:"manufacturer"`
+	Model        string `yaml:"model"`
+	PartNumber   string `yaml:"part_number"`
+	SerialNumber string `yaml:"serial_number"`
+}
+
+// SensorsFixture by sensor name, a null sensor is reported as not present on the device
+type SensorsFixture map[string]*SensorFixture
+
+// InletFixture with optional poles for multi-phase inlets
+type InletFixture struct {
+	Label    string         `yaml:"label"`
+	Name     string         `yaml:"name"`
+	PlugType string         `yaml:"plug_type"`
+	Sensors  SensorsFixture `yaml:"sensors"`
+	Poles    []PoleFixture  `yaml:"poles"`
+}
+
+// PoleFixture of an inlet, line is 0 (L1) to 3 (N)
+type PoleFixture struct {
+	Label   string         `yaml:"label"`
+	Line    int            `yaml:"line"`
+	Sensors SensorsFixture `yaml:"sensors"`
+}
+
+// OutletFixture of the PDU
+type OutletFixture struct {
+	Label          string         `yaml:"label"`
+	Name           string         `yaml:"name"`
+	ReceptacleType string         `yaml:"receptacle_type"`
+	Sensors        SensorsFixture `yaml:"sensors"`
+}
+
+// OCPFixture is an over current protector of the PDU
+type OCPFixture struct {
+	Label      string         `yaml:"label"`
+	Name       string         `yaml:"name"`
+	MaxTripCnt int            `yaml:"max_trip_count"`
+	Sensors    SensorsFixture `yaml:"sensors"`
+}
+
+// PeripheralFixture is an external sensor device with a single sensor, devices with
+// several sensors are one fixture per sensor with the same serial and position
+type PeripheralFixture struct {
+	Name     string            `yaml:"name"`
+	Serial   string            `yaml:"serial"`
+	Channel  int               `yaml:"channel"`
+	Position []PositionFixture `yaml:"position"`
+	Sensor   SensorFixture     `yaml:"sensor"`
+}
+
+// PositionFixture element of a peripheral device, type is onboard, port, hub or chain
+type PositionFixture struct {
+	Type string `yaml:"type"`
+	Port string `yaml:"port"`
+}
+
+// SensorFixture describes a single sensor, empty fields default based on the sensor name
+type SensorFixture struct {
+	// Type is numeric, accumulating, state, trip, residual or a full resource type
+	Type       string             `yaml:"type"`
+	SensorType *int               `yaml:"sensor_type"`
+	Unit       *int               `yaml:"unit"`
+	Decdigits  *int               `yaml:"decdigits"`
+	Thresholds *ThresholdsFixture `yaml:"thresholds"`
+	Value      ValueFixture       `yaml:"value"`
+}
+
+// ThresholdsFixture of a numeric sensor, only set thresholds are active
+type ThresholdsFixture struct {
+	UpperCritical *float64 `yaml:"upper_critical"`
+	UpperWarning  *float64 `yaml:"upper_warning"`
+	LowerWarning  *float64 `yaml:"lower_warning"`
+	LowerCritical *float64 `yaml:"lower_critical"`
+}
+
+// ValueFixture is the generator for sensor readings
+type ValueFixture struct {
+	// Generator is random (default), constant, sine or counter
+	Generator string `yaml:"generator"`
+	// Value for constant, start value for counter
+	Value float64 `yaml:"value"`
+	// Min and Max for random and sine, random is exponentially distributed if both are zero
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+	// Period of sine
+	Period time.Duration `yaml:"period"`
+	// Rate of counter increase per second
+	Rate float64 `yaml:"rate"`
+}
+
+// LoadFixture from a YAML file
+func LoadFixture(path string) (*Fixture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening fixture: %w", err)
+	}
+	defer f.Close()
+
+	fixture := &Fixture{}
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(fixture); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing fixture %s: %w", path, err)
+	}
+	return fixture, nil
+}
+
+// startTime of the stub, counters increase from here
+var startTime = time.Now()
+
+// generator returns a function producing the sensor readings
+func (v ValueFixture) generator() (func() float64, error) {
+	switch v.Generator {
+	case "", "random":
+		if v.Min == 0 && v.Max == 0 {
+			return rand.ExpFloat64, nil
+		}
+		return func() float64 {
+			return v.Min + rand.Float64()*(v.Max-v.Min)
+		}, nil
+	case "constant":
+		return func() float64 {
+			return v.Value
+		}, nil
+	case "sine":
+		if v.Period <= 0 {
+			return nil, fmt.Errorf("sine generator requires a period")
+		}
+		return func() float64 {
+			phase := 2 * math.Pi * float64(time.Since(startTime)) / float64(v.Period)
+			return v.Min + (v.Max-v.Min)*(1+math.Sin(phase))/2
+		}, nil
+	case "counter":
+		return func() float64 {
+			return v.Value + v.Rate*time.Since(startTime).Seconds()
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown value generator %q", v.Generator)
+	}
+}
+
+// sensorSet with present sensors and sensors reported as not present
+func sensorSet(present []string, absent []string) SensorsFixture {
+	s := SensorsFixture{}
+	for _, n := range present {
+		s[n] = &SensorFixture{}
+	}
+	for _, n := range absent {
+		s[n] = nil
+	}
+	return s
+}
+
+// defaultFixture built from the stub flags
+func defaultFixture(conf Config) *Fixture {
+	f := &Fixture{
+		Name: conf.PduName,
+		Nameplate: NameplateFixture{
+			Manufacturer: "Fake Manufacturer",
+			Model:        "Fake Model",
+			PartNumber:   "Fake Part Number",
+			SerialNumber: conf.PduSerial,
+		},
+		CtrlBoardSerial:   "FAKECTRLBOARDSERIAL",
+		MacAddress:        "FAKEMACADDRESS",
+		MeteredOutlets:    true,
+		SwitchableOutlets: true,
+	}
+
+	for i := 0; i < int(conf.PduInlets); i++ {
+		in := InletFixture{
+			Label:    fmt.Sprintf("I%d", i),
+			PlugType: "Fake Plug Type",
+			Sensors:  sensorSet(defaultInletSensors, absentInletSensors),
+		}
+		// poles are only served for multi-phase inlets
+		if conf.PduPhases > 1 {
+			for p := 0; p < int(conf.PduPhases); p++ {
+				in.Poles = append(in.Poles, PoleFixture{
+					Label:   fmt.Sprintf("L%d", p+1),
+					Line:    p,
+					Sensors: sensorSet(defaultPoleSensors, absentPoleSensors),
+				})
+			}
+		}
+		f.Inlets = append(f.Inlets, in)
+	}
+
+	for i := 0; i < int(conf.PduOutlets); i++ {
+		f.Outlets = append(f.Outlets, OutletFixture{
+			Label:          fmt.Sprintf("O%d", i),
+			ReceptacleType: "Fake Receptacle Type",
+			Sensors:        sensorSet(defaultOutletSensors, absentOutletSensors),
+		})
+	}
+
+	for i := 0; i < int(conf.PduInlets); i++ {
+		f.OCPs = append(f.OCPs, OCPFixture{
+			Label:      fmt.Sprintf("C%d", i),
+			MaxTripCnt: 1000,
+			Sensors:    sensorSet(defaultOCPSensors, absentOCPSensors),
+		})
+	}
+
+	for i := 0; i < int(conf.PduPeripherals); i++ {
+		p := peripheralTypes[i%len(peripheralTypes)]
+		sensorType, unit := p.spec.Type, p.spec.Unit
+		f.Peripherals = append(f.Peripherals, PeripheralFixture{
+			Name:   fmt.Sprintf("%s %d", p.name, i),
+			Serial: fmt.Sprintf("FAKEPERIPHERAL%d", i),
+			Position: []PositionFixture{
+				{Type: "port", Port: "1"},
+				{Type: "hub", Port: fmt.Sprintf("%d", i/len(peripheralTypes)+1)},
+			},
+			Sensor: SensorFixture{
+				Type:       p.kind,
+				SensorType: &sensorType,
+				Unit:       &unit,
+			},
+		})
+	}
+	return f
+}
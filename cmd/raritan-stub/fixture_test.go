@@ -0,0 +1,238 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+// loadTopology of a fixture in YAML
+func loadTopology(t *testing.T, fixture string) (*topology, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.yaml")
+	if err := os.WriteFile(path, []byte(fixture), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	return newTopology(f)
+}
+
+func TestLoadFixture(t *testing.T) {
+	f, err := LoadFixture(filepath.Join("..", "..", "config", "stub-fixture.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	topo, err := newTopology(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topo.metadata.Nameplate.Model != "PX3-5190R" || topo.metadata.FwRevision != "3.6.1.5-46887" {
+		t.Errorf("metadata = %+v", topo.metadata)
+	}
+	if len(topo.inlets) != 1 || len(topo.outlets) != 2 || len(topo.ocps) != 1 || len(topo.peripherals) != 4 {
+		t.Errorf("%d inlets, %d outlets, %d ocps, %d peripherals, want 1, 2, 1, 4",
+			len(topo.inlets), len(topo.outlets), len(topo.ocps), len(topo.peripherals))
+	}
+	if topo.outlets[1].name != "db01" {
+		t.Errorf("outlet 1 name = %q, want db01", topo.outlets[1].name)
+	}
+	if r, ok := topo.inlets[0].sensors["residualCurrent"]; !ok || r != nil {
+		t.Errorf("residualCurrent = %v, want a null sensor", r)
+	}
+}
+
+func TestNewTopology(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		// check the topology
+		check func(t *testing.T, topo *topology)
+		err   string
+	}{
+		{
+			name: "inlet with poles",
+			fixture: `
+inlets:
+  - label: I1
+    sensors: {voltage: {}, residualCurrent: null}
+    poles:
+      - {label: L1, line: 0, sensors: {current: {}}}
+      - {label: L2, line: 1, sensors: {current: {}}}
+`,
+			check: func(t *testing.T, topo *topology) {
+				in := topo.inlets[0]
+				if in.sensors["voltage"].RID != "/model/inlet/0/voltage" {
+					t.Errorf("voltage = %+v", in.sensors["voltage"])
+				}
+				if len(in.poles) != 2 || in.poles[1]["label"] != "L2" || in.poles[1]["line"] != 1 {
+					t.Fatalf("poles = %v", in.poles)
+				}
+				current := in.poles[1]["current"].(*raritan.Resource)
+				if current.RID != "/model/inlet/0/pole/1/current" {
+					t.Errorf("pole current = %+v", current)
+				}
+				if _, ok := topo.sensors[current.RID]; !ok {
+					t.Errorf("pole current %s is not served", current.RID)
+				}
+				if _, ok := topo.sensors["/model/inlet/0/residualCurrent"]; ok {
+					t.Error("null sensor residualCurrent is served")
+				}
+			},
+		},
+		{
+			name: "sensor kinds by name",
+			fixture: `
+outlets:
+  - {label: "1", sensors: {current: {}, outletState: {}}}
+ocps:
+  - {label: C1, sensors: {trip: {}}}
+`,
+			check: func(t *testing.T, topo *topology) {
+				for rid, want := range map[string]struct {
+					resType     string
+					readingtype int
+					unit        int
+				}{
+					"/model/outlet/0/current":     {"sensors.NumericSensor_4_0_2", 0, raritan.UnitAmpere},
+					"/model/outlet/0/outletState": {"sensors.StateSensor_4_0_2", 1, raritan.UnitNone},
+					"/tfwopaque/0/trip":           {"pdumodel.OverCurrentProtectorTripSensor_1_0_5", 1, raritan.UnitNone},
+				} {
+					s, ok := topo.sensors[rid]
+					if !ok {
+						t.Errorf("%s is not served", rid)
+						continue
+					}
+					if s.resource.Type != want.resType || s.spec.Readingtype != want.readingtype || s.spec.Unit != want.unit {
+						t.Errorf("%s: type %s, spec %+v, want %+v", rid, s.resource.Type, s.spec, want)
+					}
+				}
+			},
+		},
+		{
+			name: "sensor settings",
+			fixture: `
+outlets:
+  - label: "1"
+    sensors:
+      current:
+        unit: 9
+        sensor_type: 3
+        decdigits: 1
+        thresholds: {upper_critical: 16, lower_warning: 5}
+        value: {generator: constant, value: 4.5}
+`,
+			check: func(t *testing.T, topo *topology) {
+				s := topo.sensors["/model/outlet/0/current"]
+				if s.spec.Unit != 9 || s.spec.Type != 3 || s.decdigits != 1 {
+					t.Errorf("spec %+v, decdigits %d, want unit 9, type 3, decdigits 1", s.spec, s.decdigits)
+				}
+				want := raritan.SensorThresholds{UpperCriticalActive: true, UpperCritical: 16, LowerWarningActive: true, LowerWarning: 5}
+				if s.thresholds != want {
+					t.Errorf("thresholds = %+v, want %+v", s.thresholds, want)
+				}
+				if r := s.reading(); r.Value != 4.5 || !r.Status.BelowLowerWarning {
+					t.Errorf("reading = %+v, want 4.5 below lower warning", r)
+				}
+			},
+		},
+		{
+			name: "peripherals",
+			fixture: `
+peripherals:
+  - name: Rack Rear
+    serial: S1
+    channel: 1
+    position: [{type: port, port: "2"}, {type: chain, port: "3"}]
+    sensor: {sensor_type: 8, unit: 7}
+`,
+			check: func(t *testing.T, topo *topology) {
+				d := topo.peripherals[0].device
+				if d.DeviceID.Serial != "S1" || d.DeviceID.Channel != 1 || d.DeviceID.Type.Unit != raritan.UnitDegreeCelsius {
+					t.Errorf("device id = %+v", d.DeviceID)
+				}
+				want := []raritan.PeripheralPosition{
+					{PortType: raritan.PortTypeDevicePort, Port: "2"},
+					{PortType: raritan.PortTypeDaisyChain, Port: "3"},
+				}
+				if len(d.Position) != 2 || d.Position[0] != want[0] || d.Position[1] != want[1] {
+					t.Errorf("position = %+v, want %+v", d.Position, want)
+				}
+				if d.Device == nil || d.Device.RID != "/model/peripheraldevice/0" {
+					t.Errorf("device = %+v", d.Device)
+				}
+			},
+		},
+		{
+			name:    "unknown field",
+			fixture: "outlets: [{label: \"1\", sensor: {}}]\n",
+			err:     "field sensor not found",
+		},
+		{
+			name:    "unknown generator",
+			fixture: "outlets: [{label: \"1\", sensors: {current: {value: {generator: square}}}}]\n",
+			err:     `outlet 0: sensor current: unknown value generator "square"`,
+		},
+		{
+			name:    "unknown position type",
+			fixture: "peripherals: [{serial: S1, position: [{type: usb, port: \"1\"}]}]\n",
+			err:     `peripheral 0: unknown position type "usb"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topo, err := loadTopology(t, tt.fixture)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, topo)
+		})
+	}
+}
+
+func TestValueGenerator(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    ValueFixture
+		min, max float64
+		err      bool
+	}{
+		{name: "constant", value: ValueFixture{Generator: "constant", Value: 230}, min: 230, max: 230},
+		{name: "random", value: ValueFixture{Min: 4, Max: 6}, min: 4, max: 6},
+		{name: "sine", value: ValueFixture{Generator: "sine", Min: 21, Max: 25, Period: time.Hour}, min: 21, max: 25},
+		{name: "counter", value: ValueFixture{Generator: "counter", Value: 1000, Rate: 1}, min: 1000, max: 1000 + time.Since(startTime).Seconds() + 60},
+		{name: "sine without period", value: ValueFixture{Generator: "sine", Min: 21, Max: 25}, err: true},
+		{name: "unknown", value: ValueFixture{Generator: "square"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read, err := tt.value.generator()
+			if tt.err {
+				if err == nil {
+					t.Fatal("err = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				if v := read(); v < tt.min || v > tt.max {
+					t.Fatalf("value %v not in [%v, %v]", v, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
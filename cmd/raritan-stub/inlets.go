@@ -1,83 +1,58 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 
-	"github.com/gorilla/mux"
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
 	"k8s.io/klog/v2"
 )
 
-var inletSensors = func() map[string]func(id string) *raritan.Resource {
-	numericSensor := "sensors.NumericSensor_4_0_2"
-	stateSensor := "sensors.StateSensor_4_0_2"
-	residualSensor := "ResidualCurrentStateSensor_2_0_2"
-
-	// nil value implies empty response
-	sensors := map[string]*string{
-		"voltage":                 &numericSensor,
-		"current":                 &numericSensor,
-		"peakCurrent":             &numericSensor,
-		"residualCurrent":         &numericSensor,
-		"residualDCCurrent":       nil,
-		"activePower":             &numericSensor,
-		"reactivePower":           &numericSensor,
-		"apparentPower":           &numericSensor,
-		"powerFactor":             &numericSensor,
-		"displacementPowerFactor": nil,
-		"activeEnergy":            &numericSensor,
-		"apparentEnergy":          &numericSensor,
-		"unbalancedCurrent":       &numericSensor,
-		"lineFrequency":           &numericSensor,
-		"phaseAngle":              nil,
-		"powerQuality":            &stateSensor,
-		"surgeProtectorStatus":    &stateSensor,
-		"residualCurrentStatus":   &residualSensor,
+// sensors of the default inlets, absent sensors imply empty response
+var (
+	defaultInletSensors = []string{
+		"voltage", "current", "peakCurrent", "residualCurrent", "activePower", "reactivePower",
+		"apparentPower", "powerFactor", "activeEnergy", "apparentEnergy", "unbalancedCurrent",
+		"lineFrequency", "powerQuality", "surgeProtectorStatus", "residualCurrentStatus",
 	}
+	absentInletSensors = []string{"residualDCCurrent", "displacementPowerFactor", "phaseAngle"}
+)
 
-	ms := map[string]func(id string) *raritan.Resource{}
-	for k, v := range sensors {
-		n := k
-		t := v
-		ms[k] = func(id string) *raritan.Resource {
-			if t == nil {
-				return nil
-			}
-			return &raritan.Resource{
-				RID:  fmt.Sprintf("/model/inlet/%s/%s", id, n),
-				Type: *t,
-			}
-		}
+// sensors of the default inlet poles, absent sensors imply empty response
+var (
+	defaultPoleSensors = []string{
+		"voltage", "voltageLN", "current", "peakCurrent", "activePower", "reactivePower",
+		"apparentPower", "powerFactor", "activeEnergy", "apparentEnergy",
 	}
-	return ms
-}()
+	absentPoleSensors = []string{"displacementPowerFactor", "phaseAngle", "unbalancedCurrent"}
+)
 
-func inletsHandler(w http.ResponseWriter, r *http.Request) {
-	req, err := jsonRequest(w, r)
-	if err != nil {
-		klog.Error(err)
-		return
-	}
+func inletsHandler(t *topology) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := resourceIndex(w, r, len(t.inlets))
+		if !ok {
+			return
+		}
+		in := t.inlets[id]
 
-	vars := mux.Vars(r)
-	id := vars["id"]
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
 
-	switch method := req.Method; method {
-	case "getMetaData":
-		raritanResultJSON(w, raritan.InletMetadata{
-			Label:    fmt.Sprintf("I%s", id),
-			PlugType: "Fake Plug Type",
-		})
-	case "getSettings":
-		raritanResultJSON(w, raritan.InletSettings{})
-	case "getSensors":
-		sens := make(map[string]*raritan.Resource, len(inletSensors))
-		for k, v := range inletSensors {
-			sens[k] = v(id)
+		switch method := req.Method; method {
+		case "getMetaData":
+			raritanResultJSON(w, in.metadata)
+		case "getSettings":
+			raritanResultJSON(w, raritan.InletSettings{
+				Name: in.name,
+			})
+		case "getSensors":
+			raritanResultJSON(w, in.sensors)
+		case "getPoles":
+			raritanResultJSON(w, in.poles)
+		default:
+			jsonMethodNotFound(w, method)
 		}
-		raritanResultJSON(w, sens)
-	default:
-		jsonMethodNotFound(w, method)
 	}
 }
@@ -1,84 +1,100 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
 	"k8s.io/klog/v2"
 )
 
-var outletSensors = func() map[string]func(id string) *raritan.Resource {
-	numericSensor := "sensors.NumericSensor_4_0_2"
-	stateSensor := "sensors.StateSensor_4_0_2"
-
-	// nil value implies empty response
-	sensors := map[string]*string{
-		"voltage":                 &numericSensor,
-		"current":                 &numericSensor,
-		"peakCurrent":             &numericSensor,
-		"maximumCurrent":          &numericSensor,
-		"unbalancedCurrent":       &numericSensor,
-		"activePower":             &numericSensor,
-		"reactivePower":           &numericSensor,
-		"apparentPower":           &numericSensor,
-		"powerFactor":             &numericSensor,
-		"displacementPowerFactor": nil,
-		"activeEnergy":            &numericSensor,
-		"apparentEnergy":          &numericSensor,
-		"phaseAngle":              nil,
-		"lineFrequency":           nil,
-		"outletState":             &stateSensor,
+// sensors of the default outlets, absent sensors imply empty response
+var (
+	defaultOutletSensors = []string{
+		"voltage", "current", "peakCurrent", "maximumCurrent", "unbalancedCurrent", "activePower",
+		"reactivePower", "apparentPower", "powerFactor", "activeEnergy", "apparentEnergy", "outletState",
 	}
+	absentOutletSensors = []string{"displacementPowerFactor", "phaseAngle", "lineFrequency"}
+)
 
-	ms := map[string]func(id string) *raritan.Resource{}
-	for k, v := range sensors {
-		n := k
-		t := v
-		ms[k] = func(id string) *raritan.Resource {
-			if t == nil {
-				return nil
-			}
-			return &raritan.Resource{
-				RID:  fmt.Sprintf("/model/outlet/%s/%s", id, n),
-				Type: *t,
-			}
-		}
-	}
-	return ms
-}()
+// cycleDelay is how long an outlet stays off when power cycled
+const cycleDelay = 2 * time.Second
+
+// outletStates holds the power state of switched outlets, outlets are on by default
+var outletStates = struct {
+	sync.Mutex
+	states map[string]int
+}{states: map[string]int{}}
 
-func outletsHandler(w http.ResponseWriter, r *http.Request) {
-	req, err := jsonRequest(w, r)
-	if err != nil {
-		klog.Error(err)
-		return
+func outletPowerState(id string) int {
+	outletStates.Lock()
+	defer outletStates.Unlock()
+	if s, ok := outletStates.states[id]; ok {
+		return s
 	}
+	return raritan.PowerStateOn
+}
+
+func setOutletPowerState(id string, state int) {
+	old := outletPowerState(id)
+	outletStates.Lock()
+	outletStates.states[id] = state
+	outletStates.Unlock()
+	klog.V(1).Infof("Outlet %s power state set to %d", id, state)
+	publishPowerState(id, old, state)
+}
 
-	vars := mux.Vars(r)
-	id := vars["id"]
+func outletsHandler(t *topology) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i, ok := resourceIndex(w, r, len(t.outlets))
+		if !ok {
+			return
+		}
+		o := t.outlets[i]
+		id := strconv.Itoa(i)
 
-	switch method := req.Method; method {
-	case "getMetaData":
-		raritanResultJSON(w, raritan.OutletMetadata{
-			Label:          fmt.Sprintf("O%s", id),
-			ReceptacleType: "Fake Receptacle Type",
-		})
-	case "getSettings":
-		raritanResultJSON(w, raritan.OutletSettings{})
-	case "getState":
-		raritanResultJSON(w, raritan.OutletState{
-			Available:  true,
-			PowerState: 1,
-		})
-	case "getSensors":
-		sens := make(map[string]*raritan.Resource, len(outletSensors))
-		for k, v := range outletSensors {
-			sens[k] = v(id)
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		switch method := req.Method; method {
+		case "getMetaData":
+			raritanResultJSON(w, o.metadata)
+		case "getSettings":
+			raritanResultJSON(w, raritan.OutletSettings{
+				Name: o.name,
+			})
+		case "getState":
+			raritanResultJSON(w, raritan.OutletState{
+				Available:  true,
+				PowerState: uint(outletPowerState(id)),
+			})
+		case "setPowerState":
+			pstate, ok := req.Params["pstate"].(float64)
+			if !ok || (int(pstate) != raritan.PowerStateOff && int(pstate) != raritan.PowerStateOn) {
+				jsonError(w, rpc.Error{
+					Code:    -32602,
+					Message: "Invalid params",
+				})
+				return
+			}
+			setOutletPowerState(id, int(pstate))
+			raritanResultJSON(w, 0)
+		case "cyclePowerState":
+			setOutletPowerState(id, raritan.PowerStateOff)
+			time.AfterFunc(cycleDelay, func() {
+				setOutletPowerState(id, raritan.PowerStateOn)
+			})
+			raritanResultJSON(w, 0)
+		case "getSensors":
+			raritanResultJSON(w, o.sensors)
+		default:
+			jsonMethodNotFound(w, method)
 		}
-		raritanResultJSON(w, sens)
-	default:
-		jsonMethodNotFound(w, method)
 	}
 }
@@ -11,7 +11,7 @@ import (
 const NumOutlets = 8
 const NumOCPs = 2
 
-func pduHandler(conf Config) http.HandlerFunc {
+func pduHandler(t *topology) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req, err := jsonRequest(w, r)
 		if err != nil {
@@ -21,40 +21,23 @@ func pduHandler(conf Config) http.HandlerFunc {
 
 		switch method := req.Method; method {
 		case "getMetaData":
-			raritanResultJSON(w, raritan.PDUMetadata{
-				Nameplate: raritan.PDUNameplate{
-					Manufacturer: "Fake Manufacturer",
-					Model:        "Fake Model",
-					PartNumber:   "Fake Part Number",
-					SerialNumber: conf.PduSerial,
-				},
-				CtrlBoardSerial:      "FAKECTRLBOARDSERIAL",
-				HasMeteredOutlets:    true,
-				HasSwitchableOutlets: true,
-				MacAddress:           "FAKEMACADDRESS",
-			})
+			raritanResultJSON(w, t.metadata)
 		case "getSettings":
 			raritanResultJSON(w, raritan.PDUSettings{
-				Name: conf.PduName,
+				Name: t.name,
 			})
 		case "getInlets":
-			raritanResultJSON(w, []raritan.Resource{
-				{
-					RID:  "/model/inlet/0",
-					Type: "Inlet_2_0_3",
-				},
-			})
-			ocps := make([]raritan.Resource, int(conf.PduInlets))
-			for i := 0; i < int(conf.PduInlets); i++ {
-				ocps[i] = raritan.Resource{
+			inlets := make([]raritan.Resource, len(t.inlets))
+			for i := range t.inlets {
+				inlets[i] = raritan.Resource{
 					RID:  fmt.Sprintf("/model/inlet/%d", i),
 					Type: "Inlet_2_0_3",
 				}
 			}
-			raritanResultJSON(w, ocps)
+			raritanResultJSON(w, inlets)
 		case "getOutlets":
-			outlets := make([]raritan.Resource, int(conf.PduOutlets))
-			for i := 0; i < int(conf.PduOutlets); i++ {
+			outlets := make([]raritan.Resource, len(t.outlets))
+			for i := range t.outlets {
 				outlets[i] = raritan.Resource{
 					RID:  fmt.Sprintf("/model/outlet/%d", i),
 					Type: "Outlet_2_1_4",
@@ -62,8 +45,8 @@ func pduHandler(conf Config) http.HandlerFunc {
 			}
 			raritanResultJSON(w, outlets)
 		case "getOverCurrentProtectors":
-			ocps := make([]raritan.Resource, int(conf.PduInlets))
-			for i := 0; i < int(conf.PduInlets); i++ {
+			ocps := make([]raritan.Resource, len(t.ocps))
+			for i := range t.ocps {
 				ocps[i] = raritan.Resource{
 					RID:  fmt.Sprintf("/tfwopaque/OverCurrentProtector/%d", i),
 					Type: "pdumodel.OverCurrentProtector_3_0_4",
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"k8s.io/klog/v2"
+)
+
+// peripheralTypes are cycled through for the default devices
+var peripheralTypes = []struct {
+	name string
+	spec raritan.SensorTypeSpec
+	kind string
+}{
+	{"Temperature", raritan.SensorTypeSpec{Type: 8, Unit: 7}, "numeric"},
+	{"Humidity", raritan.SensorTypeSpec{Type: 9, Unit: 9}, "numeric"},
+	{"Air Flow", raritan.SensorTypeSpec{Type: 10, Unit: 10}, "numeric"},
+	{"Contact Closure", raritan.SensorTypeSpec{Type: 12, Unit: 0}, "state"},
+	{"Water Leak", raritan.SensorTypeSpec{Type: 16, Unit: 0}, "state"},
+}
+
+func peripheralDeviceManagerHandler(t *topology) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		switch method := req.Method; method {
+		case "getDeviceSlots":
+			slots := make([]raritan.Resource, len(t.peripherals))
+			for i := range t.peripherals {
+				slots[i] = raritan.Resource{
+					RID:  fmt.Sprintf("/model/peripheraldeviceslot/%d", i),
+					Type: "peripheral.DeviceSlot_2_0_3",
+				}
+			}
+			raritanResultJSON(w, slots)
+		default:
+			jsonMethodNotFound(w, method)
+		}
+	}
+}
+
+func peripheralDeviceSlotHandler(t *topology) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := resourceIndex(w, r, len(t.peripherals))
+		if !ok {
+			return
+		}
+		p := t.peripherals[id]
+
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		switch method := req.Method; method {
+		case "getDevice":
+			raritanResultJSON(w, p.device)
+		case "getSettings":
+			raritanResultJSON(w, raritan.PeripheralSettings{
+				Name: p.name,
+			})
+		default:
+			jsonMethodNotFound(w, method)
+		}
+	}
+}
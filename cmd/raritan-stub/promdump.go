@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/promdump"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"k8s.io/klog/v2"
+)
+
+// dumpFamilies collects the samples of a dump by family
+type dumpFamilies map[string]*dto.MetricFamily
+
+// add a sample, NaN if the sensor_unavailable fault is injected for the sensor
+func (fs dumpFamilies) add(name string, s *stubSensor, labels map[string]string) {
+	f, ok := fs[name]
+	if !ok {
+		f = &dto.MetricFamily{
+			Name: proto.String(name),
+			Help: proto.String("Raritan PDU sensor reading"),
+			Type: dto.MetricType_GAUGE.Enum(),
+		}
+		fs[name] = f
+	}
+
+	v := math.NaN()
+	if _, ok := injectFault(FaultSensorUnavailable, s.resource.RID); !ok {
+		// the dump shows readings with the precision of the sensor
+		p := math.Pow10(s.decdigits)
+		v = math.Round(s.read()*p) / p
+	}
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(v)}}
+	for k, l := range labels {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(k), Value: proto.String(l)})
+	}
+	sort.Slice(m.Label, func(i, j int) bool {
+		return m.Label[i].GetName() < m.Label[j].GetName()
+	})
+	f.Metric = append(f.Metric, m)
+}
+
+// addComponent sensors with the labels of the component
+func (fs dumpFamilies) addComponent(t *topology, prefix string, sensors map[string]*raritan.Resource, labels map[string]string) {
+	for n, res := range sensors {
+		if res == nil {
+			continue
+		}
+		s := t.sensors[res.RID]
+		fs.add(promdump.SensorFamily(prefix, n, s.spec.Unit), s, labels)
+	}
+}
+
+// newDump of the topology in the format of the promdump package
+func newDump(t *topology) dumpFamilies {
+	fs := dumpFamilies{
+		promdump.FamilyInfo: &dto.MetricFamily{
+			Name: proto.String(promdump.FamilyInfo),
+			Help: proto.String("Raritan PDU information"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{
+					{Name: proto.String(promdump.LabelInfoFirmware), Value: proto.String(t.metadata.FwRevision)},
+					{Name: proto.String(promdump.LabelInfoHardware), Value: proto.String(t.metadata.HwRevision)},
+					{Name: proto.String(promdump.LabelInfoMAC), Value: proto.String(t.metadata.MacAddress)},
+					{Name: proto.String(promdump.LabelInfoManufacturer), Value: proto.String(t.metadata.Nameplate.Manufacturer)},
+					{Name: proto.String(promdump.LabelInfoModel), Value: proto.String(t.metadata.Nameplate.Model)},
+					{Name: proto.String(promdump.LabelInfoName), Value: proto.String(t.name)},
+					{Name: proto.String(promdump.LabelInfoPartNumber), Value: proto.String(t.metadata.Nameplate.PartNumber)},
+					{Name: proto.String(promdump.LabelInfoSerial), Value: proto.String(t.metadata.Nameplate.SerialNumber)},
+				},
+				Gauge: &dto.Gauge{Value: proto.Float64(1)},
+			}},
+		},
+	}
+
+	for _, in := range t.inlets {
+		fs.addComponent(t, promdump.InletPrefix, in.sensors, map[string]string{
+			promdump.LabelComponent:     in.metadata.Label,
+			promdump.LabelComponentName: in.name,
+		})
+		for _, pole := range in.poles {
+			label, _ := pole["label"].(string)
+			line, _ := pole["line"].(int)
+			sensors := map[string]*raritan.Resource{}
+			for k, v := range pole {
+				if res, ok := v.(*raritan.Resource); ok {
+					sensors[k] = res
+				}
+			}
+			labels := map[string]string{
+				promdump.LabelComponent: in.metadata.Label,
+				promdump.LabelPole:      label,
+			}
+			if line >= 0 && line < len(promdump.Lines) {
+				labels[promdump.LabelLine] = promdump.Lines[line]
+			}
+			fs.addComponent(t, promdump.PolePrefix, sensors, labels)
+		}
+	}
+	for _, o := range t.ocps {
+		fs.addComponent(t, promdump.OCPPrefix, o.sensors, map[string]string{
+			promdump.LabelComponent:     o.metadata.Label,
+			promdump.LabelComponentName: o.name,
+		})
+	}
+	for _, o := range t.outlets {
+		fs.addComponent(t, promdump.OutletPrefix, o.sensors, map[string]string{
+			promdump.LabelComponent:     o.metadata.Label,
+			promdump.LabelComponentName: o.name,
+		})
+	}
+	for i, p := range t.peripherals {
+		port := []string{}
+		for _, pos := range p.device.Position {
+			if pos.PortType == raritan.PortTypeDevicePort || pos.PortType == raritan.PortTypeHubPort {
+				port = append(port, pos.Port)
+			}
+		}
+		spec := p.device.DeviceID.Type
+		fs.add(promdump.ExternalFamily(spec.Type, spec.Unit), t.sensors[p.device.Device.RID], map[string]string{
+			promdump.LabelExternalID:          strconv.Itoa(i + 1),
+			promdump.LabelExternalSerial:      p.device.DeviceID.Serial,
+			promdump.LabelExternalName:        p.name,
+			promdump.LabelExternalDescription: "",
+			promdump.LabelExternalPort:        strings.Join(port, "-"),
+			promdump.LabelExternalChannel:     strconv.Itoa(p.device.DeviceID.Channel),
+		})
+	}
+	return fs
+}
+
+// dumpHandler serves the Prometheus dump of the topology, not found if the firmware
+// of the fixture is older than the first serving one
+func dumpHandler(t *topology) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !promdump.HasDump(t.metadata.FwRevision) {
+			http.NotFound(w, r)
+			return
+		}
+
+		fs := newDump(t)
+		names := make([]string, 0, len(fs))
+		for n := range fs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", string(expfmt.FmtText))
+		for _, n := range names {
+			if _, err := expfmt.MetricFamilyToText(w, fs[n]); err != nil {
+				klog.Errorf("Error writing dump: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"math/rand"
 	"net/http"
 	"time"
 
@@ -9,23 +8,119 @@ import (
 	"k8s.io/klog/v2"
 )
 
-func sensorHandler(w http.ResponseWriter, r *http.Request) {
-	req, err := jsonRequest(w, r)
-	if err != nil {
-		klog.Error(err)
-		return
+// sensorTypes for numeric sensors by name, used for metadata
+var sensorTypes = map[string]raritan.SensorTypeSpec{
+	"voltage":           {Type: 1, Unit: raritan.UnitVolt},
+	"voltageLN":         {Type: 1, Unit: raritan.UnitVolt},
+	"current":           {Type: 2, Unit: raritan.UnitAmpere},
+	"peakCurrent":       {Type: 2, Unit: raritan.UnitAmpere},
+	"maximumCurrent":    {Type: 2, Unit: raritan.UnitAmpere},
+	"residualCurrent":   {Type: 2, Unit: raritan.UnitAmpere},
+	"unbalancedCurrent": {Type: 3, Unit: raritan.UnitPercent},
+	"activePower":       {Type: 4, Unit: raritan.UnitWatt},
+	"reactivePower":     {Type: 4, Unit: raritan.UnitVoltAmpReactive},
+	"apparentPower":     {Type: 4, Unit: raritan.UnitVoltAmp},
+	"powerFactor":       {Type: 5, Unit: raritan.UnitNone},
+	"activeEnergy":      {Type: 6, Unit: raritan.UnitWattHour},
+	"apparentEnergy":    {Type: 6, Unit: raritan.UnitVoltAmpHour},
+	"lineFrequency":     {Type: 7, Unit: raritan.UnitHz},
+}
+
+// thresholds for numeric sensors by unit, others have none active
+var unitThresholds = map[int]raritan.SensorThresholds{
+	raritan.UnitVolt: {
+		UpperCriticalActive: true, UpperCritical: 254,
+		UpperWarningActive: true, UpperWarning: 247,
+		LowerWarningActive: true, LowerWarning: 194,
+		LowerCriticalActive: true, LowerCritical: 188,
+	},
+	raritan.UnitAmpere: {
+		UpperCriticalActive: true, UpperCritical: 2,
+		UpperWarningActive: true, UpperWarning: 1.5,
+	},
+	raritan.UnitDegreeCelsius: {
+		UpperCriticalActive: true, UpperCritical: 32,
+		UpperWarningActive: true, UpperWarning: 27,
+		LowerWarningActive: true, LowerWarning: 18,
+		LowerCriticalActive: true, LowerCritical: 15,
+	},
+}
+
+// readingStatus of value against thresholds
+func readingStatus(t raritan.SensorThresholds, value float64) raritan.ReadingStatus {
+	return raritan.ReadingStatus{
+		AboveUpperCritical: t.UpperCriticalActive && value > t.UpperCritical,
+		AboveUpperWarning:  t.UpperWarningActive && value > t.UpperWarning,
+		BelowLowerWarning:  t.LowerWarningActive && value < t.LowerWarning,
+		BelowLowerCritical: t.LowerCriticalActive && value < t.LowerCritical,
+	}
+}
+
+// reading of the sensor now, with a status for numeric sensors
+func (s *stubSensor) reading() raritan.Reading {
+	r := raritan.Reading{
+		Timestamp: uint(time.Now().Unix()),
+		Available: true,
+		Value:     s.read(),
+	}
+	if s.spec.Readingtype == 0 {
+		r.Status = readingStatus(s.thresholds, r.Value)
 	}
+	return r
+}
+
+// sensorHandler serves all sensors of the topology by resource id
+func sensorHandler(t *topology) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := t.sensors[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		// unavailable sensors report no value
+		if _, ok := injectFault(FaultSensorUnavailable, r.URL.Path); ok && (req.Method == "getReading" || req.Method == "getState") {
+			raritanResultJSON(w, raritan.Reading{
+				Timestamp: uint(time.Now().Unix()),
+			})
+			return
+		}
 
-	switch method := req.Method; method {
-	case "getReading":
-		fallthrough
-	case "getState":
-		raritanResultJSON(w, raritan.Reading{
-			Timestamp: uint(time.Now().Unix()),
-			Available: true,
-			Value:     rand.ExpFloat64(),
-		})
-	default:
-		jsonMethodNotFound(w, method)
+		switch method := req.Method; method {
+		case "getReading":
+			value := s.read()
+			raritanResultJSON(w, raritan.Reading{
+				Timestamp: uint(time.Now().Unix()),
+				Available: true,
+				Value:     value,
+				Status:    readingStatus(s.thresholds, value),
+			})
+		case "getState":
+			raritanResultJSON(w, raritan.Reading{
+				Timestamp: uint(time.Now().Unix()),
+				Available: true,
+				Value:     s.read(),
+			})
+		case "getMetaData":
+			meta := raritan.SensorMetadata{
+				Type:      s.spec,
+				Decdigits: s.decdigits,
+			}
+			meta.ThresholdCaps.HasUpperCritical = s.thresholds.UpperCriticalActive
+			meta.ThresholdCaps.HasUpperWarning = s.thresholds.UpperWarningActive
+			meta.ThresholdCaps.HasLowerWarning = s.thresholds.LowerWarningActive
+			meta.ThresholdCaps.HasLowerCritical = s.thresholds.LowerCriticalActive
+			raritanResultJSON(w, meta)
+		case "getThresholds":
+			raritanResultJSON(w, s.thresholds)
+		default:
+			jsonMethodNotFound(w, method)
+		}
 	}
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// sessionHeader carries the token of requests authenticated by a session
+const sessionHeader = "X-SessionToken"
+
+type stubSession struct {
+	id       int
+	username string
+	created  time.Time
+	lastUsed time.Time
+}
+
+// sessionStore of the session manager at /session, sessions expire when idle for timeout
+type sessionStore struct {
+	mux      sync.Mutex
+	timeout  time.Duration
+	sessions map[string]*stubSession
+	nextID   int
+}
+
+type sessionInfo struct {
+	SessionID    int    `json:"sessionId"`
+	Username     string `json:"username"`
+	RemoteIP     string `json:"remoteIp"`
+	ClientType   string `json:"clientType"`
+	CreationTime int64  `json:"creationTime"`
+	Timeout      int    `json:"timeout"`
+	Idle         int    `json:"idle"`
+	UserIdle     int    `json:"userIdle"`
+}
+
+type newSessionResult struct {
+	Return  int         `json:"_ret_"`
+	Session sessionInfo `json:"session"`
+	Token   string      `json:"token"`
+}
+
+func newSessionStore(timeout time.Duration) *sessionStore {
+	return &sessionStore{
+		timeout:  timeout,
+		sessions: map[string]*stubSession{},
+	}
+}
+
+// valid reports if token belongs to an open session and marks it used
+func (s *sessionStore) valid(token string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ss, ok := s.sessions[token]
+	if !ok {
+		return false
+	}
+	if s.timeout > 0 && time.Since(ss.lastUsed) > s.timeout {
+		klog.Infof("Session %d of %s expired", ss.id, ss.username)
+		delete(s.sessions, token)
+		return false
+	}
+	ss.lastUsed = time.Now()
+	return true
+}
+
+func (s *sessionStore) open(username string) (string, *stubSession, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(bs)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.nextID++
+	ss := &stubSession{
+		id:       s.nextID,
+		username: username,
+		created:  time.Now(),
+		lastUsed: time.Now(),
+	}
+	s.sessions[token] = ss
+	klog.Infof("Opened session %d for %s, %d open", ss.id, username, len(s.sessions))
+	return token, ss, nil
+}
+
+func (s *sessionStore) close(token string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if ss, ok := s.sessions[token]; ok {
+		delete(s.sessions, token)
+		klog.Infof("Closed session %d of %s, %d open", ss.id, ss.username, len(s.sessions))
+	}
+}
+
+// sessionAuth accepts requests with a valid session token, others need basic auth.
+// Unknown and expired tokens are unauthorized like on the PDU.
+func (s *sessionStore) sessionAuth(basic func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withBasic := basic(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get(sessionHeader)
+			if token == "" {
+				withBasic.ServeHTTP(w, r)
+				return
+			}
+			if !s.valid(token) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func sessionHandler(s *sessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := jsonRequest(w, r)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		switch method := req.Method; method {
+		case "newSession":
+			username, _, _ := r.BasicAuth()
+			token, ss, err := s.open(username)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jsonResult(w, newSessionResult{
+				Session: sessionInfo{
+					SessionID:    ss.id,
+					Username:     ss.username,
+					RemoteIP:     r.RemoteAddr,
+					ClientType:   "JSON-RPC",
+					CreationTime: ss.created.Unix(),
+					Timeout:      int(s.timeout / time.Second),
+				},
+				Token: token,
+			})
+		case "closeCurrentSession":
+			s.close(r.Header.Get(sessionHeader))
+			jsonResult(w, struct{}{})
+		default:
+			jsonMethodNotFound(w, method)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/snmp"
+	"k8s.io/klog/v2"
+)
+
+// stubPDUID of the stubbed PDU in the PDU2-MIB tables
+const stubPDUID = 1
+
+const (
+	// maxBulkVariables in a GETBULK response
+	maxBulkVariables = 500
+	// defaultMaxRepetitions of GETBULK requests, gosnmp decodes max-repetitions as 0
+	defaultMaxRepetitions = 25
+)
+
+// mibObject of the agent, value is read on each request
+type mibObject struct {
+	oid   []int
+	value func() (gosnmp.Asn1BER, interface{})
+}
+
+// snmpAgent serves the topology as the PDU2-MIB over SNMPv2c
+type snmpAgent struct {
+	community string
+	started   time.Time
+	// objects sorted by OID
+	objects []mibObject
+}
+
+var (
+	// snmpSensorTypes of component sensors by name
+	snmpSensorTypes = map[string]int{}
+	// snmpExternalTypes of external sensors by JSON-RPC sensor type
+	snmpExternalTypes = map[int]int{}
+	// snmpUnits by JSON-RPC unit
+	snmpUnits = map[int]int{}
+)
+
+func init() {
+	for t, st := range snmp.SensorTypes {
+		if st.Name != "" {
+			snmpSensorTypes[st.Name] = t
+		} else {
+			snmpExternalTypes[st.Type] = t
+		}
+	}
+	for u, ru := range snmp.Units {
+		snmpUnits[ru] = u
+	}
+}
+
+func newSNMPAgent(t *topology, community string) *snmpAgent {
+	a := &snmpAgent{
+		community: community,
+		started:   time.Now(),
+	}
+
+	a.add(snmp.OIDSysUpTime, func() (gosnmp.Asn1BER, interface{}) {
+		return gosnmp.TimeTicks, uint32(time.Since(a.started) / (10 * time.Millisecond))
+	})
+	a.add(snmp.OIDSysContact, octets("SysContact"))
+	a.add(snmp.OIDSysName, octets("SysName"))
+	a.add(snmp.OIDSysLocation, octets("SysLocation"))
+
+	a.add(oidIndex(snmp.OIDPDUManufacturer, stubPDUID), octets(t.metadata.Nameplate.Manufacturer))
+	a.add(oidIndex(snmp.OIDPDUModel, stubPDUID), octets(t.metadata.Nameplate.Model))
+	a.add(oidIndex(snmp.OIDPDUSerialNumber, stubPDUID), octets(t.metadata.Nameplate.SerialNumber))
+	mac, _ := net.ParseMAC(t.metadata.MacAddress)
+	a.add(oidIndex(snmp.OIDPDUMACAddress, stubPDUID), octets(string(mac)))
+	a.add(oidIndex(snmp.OIDPDUName, stubPDUID), octets(t.name))
+	a.add(oidIndex(snmp.OIDBoardFirmwareVersion, stubPDUID, 1, 1), octets(t.metadata.FwRevision))
+
+	for i, in := range t.inlets {
+		id := i + 1
+		a.add(oidIndex(snmp.OIDInletLabel, stubPDUID, id), octets(in.metadata.Label))
+		a.add(oidIndex(snmp.OIDInletName, stubPDUID, id), octets(in.name))
+		a.add(oidIndex(snmp.OIDInletPlug, stubPDUID, id), octets(in.metadata.PlugType))
+		a.addSensors(t, snmp.OIDInletSensorConfiguration, snmp.OIDInletSensorMeasurements, []int{stubPDUID, id}, in.sensors)
+		for p, pole := range in.poles {
+			a.addPole(t, []int{stubPDUID, id, p + 1}, pole)
+		}
+	}
+	for i, o := range t.ocps {
+		id := i + 1
+		a.add(oidIndex(snmp.OIDOCPLabel, stubPDUID, id), octets(o.metadata.Label))
+		a.add(oidIndex(snmp.OIDOCPName, stubPDUID, id), octets(o.name))
+		a.addSensors(t, snmp.OIDOCPSensorConfiguration, snmp.OIDOCPSensorMeasurements, []int{stubPDUID, id}, o.sensors)
+	}
+	for i, o := range t.outlets {
+		id := i + 1
+		a.add(oidIndex(snmp.OIDOutletLabel, stubPDUID, id), octets(o.metadata.Label))
+		a.add(oidIndex(snmp.OIDOutletName, stubPDUID, id), octets(o.name))
+		a.add(oidIndex(snmp.OIDOutletReceptacle, stubPDUID, id), octets(o.metadata.ReceptacleType))
+		a.addSensors(t, snmp.OIDOutletSensorConfiguration, snmp.OIDOutletSensorMeasurements, []int{stubPDUID, id}, o.sensors)
+	}
+	for i, p := range t.peripherals {
+		a.addExternal(t, i+1, p)
+	}
+
+	sort.Slice(a.objects, func(i, j int) bool {
+		return compareOID(a.objects[i].oid, a.objects[j].oid) < 0
+	})
+	return a
+}
+
+func (a *snmpAgent) add(oid string, value func() (gosnmp.Asn1BER, interface{})) {
+	a.objects = append(a.objects, mibObject{
+		oid:   parseOID(oid),
+		value: value,
+	})
+}
+
+// addSensors of the component at index, those without a sensor type in the MIB are not served
+func (a *snmpAgent) addSensors(t *topology, config, measurements string, index []int, sensors map[string]*raritan.Resource) {
+	for name, res := range sensors {
+		typ, ok := snmpSensorTypes[name]
+		if res == nil || !ok {
+			continue
+		}
+		s := t.sensors[res.RID]
+		cols := snmp.ComponentSensorColumns
+		idx := append(append([]int{}, index...), typ)
+		a.addSensorConfig(config, cols, idx, s, gosnmp.Gauge32)
+		a.addMeasurements(measurements, idx, s, typ, gosnmp.Gauge32)
+	}
+}
+
+// addPole of an inlet with its line and sensors, the MIB numbers lines from 1
+func (a *snmpAgent) addPole(t *topology, idx []int, pole map[string]interface{}) {
+	line, _ := pole["line"].(int)
+	node, _ := pole["nodeId"].(int)
+	a.add(oidIndex(snmp.OIDInletPoleLine, idx...), integer(line+1))
+	a.add(oidIndex(snmp.OIDInletPoleNode, idx...), integer(node))
+
+	sensors := map[string]*raritan.Resource{}
+	for k, v := range pole {
+		if res, ok := v.(*raritan.Resource); ok {
+			sensors[k] = res
+		}
+	}
+	a.addSensors(t, snmp.OIDInletPoleSensorConfiguration, snmp.OIDInletPoleSensorMeasurements, idx, sensors)
+}
+
+// addExternal sensor of a peripheral device
+func (a *snmpAgent) addExternal(t *topology, id int, p peripheralNode) {
+	typ, ok := snmpExternalTypes[p.device.DeviceID.Type.Type]
+	if !ok {
+		klog.Infof("Peripheral device %s of type %d is not served over SNMP", p.device.DeviceID.Serial, p.device.DeviceID.Type.Type)
+		return
+	}
+	s := t.sensors[p.device.Device.RID]
+	config := snmp.OIDExternalSensorConfiguration
+	idx := []int{stubPDUID, id}
+	port := []string{}
+	for _, pos := range p.device.Position {
+		if pos.PortType == raritan.PortTypeDevicePort || pos.PortType == raritan.PortTypeHubPort {
+			port = append(port, pos.Port)
+		}
+	}
+
+	a.add(oidIndex(config, append([]int{snmp.ExternalSensorType}, idx...)...), integer(typ))
+	a.add(oidIndex(config, append([]int{snmp.ExternalSensorSerialNumber}, idx...)...), octets(p.device.DeviceID.Serial))
+	a.add(oidIndex(config, append([]int{snmp.ExternalSensorName}, idx...)...), octets(p.name))
+	a.add(oidIndex(config, append([]int{snmp.ExternalSensorDescription}, idx...)...), octets(""))
+	a.add(oidIndex(config, append([]int{snmp.ExternalSensorChannel}, idx...)...), integer(p.device.DeviceID.Channel))
+	a.add(oidIndex(config, append([]int{snmp.ExternalSensorPort}, idx...)...), octets(strings.Join(port, "-")))
+	a.addSensorConfig(config, snmp.ExternalSensorColumns, idx, s, gosnmp.Integer)
+	a.addMeasurements(snmp.OIDExternalSensorMeasurements, idx, s, typ, gosnmp.Integer)
+}
+
+// addSensorConfig columns of a sensor, thresholds are only served for numeric sensors.
+// Values of components are unsigned, those of external sensors signed.
+func (a *snmpAgent) addSensorConfig(config string, cols snmp.SensorColumns, idx []int, s *stubSensor, valueType gosnmp.Asn1BER) {
+	column := func(c int) string {
+		return oidIndex(config, append([]int{c}, idx...)...)
+	}
+	unit, ok := snmpUnits[s.spec.Unit]
+	if !ok {
+		unit = -1
+	}
+	a.add(column(cols.Units), integer(unit))
+	a.add(column(cols.DecimalDigits), func() (gosnmp.Asn1BER, interface{}) {
+		return gosnmp.Gauge32, uint32(s.decdigits)
+	})
+	if s.spec.Readingtype != 0 {
+		return
+	}
+
+	th := s.thresholds
+	scaled := func(v float64) func() (gosnmp.Asn1BER, interface{}) {
+		return func() (gosnmp.Asn1BER, interface{}) {
+			return scaledValue(v, s.decdigits, valueType)
+		}
+	}
+	a.add(column(cols.UpperCriticalThreshold), scaled(th.UpperCritical))
+	a.add(column(cols.UpperWarningThreshold), scaled(th.UpperWarning))
+	a.add(column(cols.LowerWarningThreshold), scaled(th.LowerWarning))
+	a.add(column(cols.LowerCriticalThreshold), scaled(th.LowerCritical))
+	a.add(column(cols.Hysteresis), scaled(th.DeassertionHysteresis))
+	a.add(column(cols.StateChangeDelay), func() (gosnmp.Asn1BER, interface{}) {
+		return gosnmp.Gauge32, uint32(th.AssertionTimeout)
+	})
+	var bits byte
+	for _, b := range []struct {
+		active bool
+		bit    byte
+	}{
+		{th.LowerCriticalActive, snmp.ThresholdLowerCritical},
+		{th.LowerWarningActive, snmp.ThresholdLowerWarning},
+		{th.UpperWarningActive, snmp.ThresholdUpperWarning},
+		{th.UpperCriticalActive, snmp.ThresholdUpperCritical},
+	} {
+		if b.active {
+			bits |= b.bit
+		}
+	}
+	a.add(column(cols.EnabledThresholds), octets(string([]byte{bits})))
+}
+
+// addMeasurements of a sensor, unavailable sensors are reported by the sensor_unavailable fault
+func (a *snmpAgent) addMeasurements(measurements string, idx []int, s *stubSensor, typ int, valueType gosnmp.Asn1BER) {
+	column := func(c int) string {
+		return oidIndex(measurements, append([]int{c}, idx...)...)
+	}
+	a.add(column(snmp.MeasurementIsAvailable), func() (gosnmp.Asn1BER, interface{}) {
+		// TruthValue
+		if _, ok := injectFault(FaultSensorUnavailable, s.resource.RID); ok {
+			return gosnmp.Integer, 2
+		}
+		return gosnmp.Integer, 1
+	})
+	a.add(column(snmp.MeasurementState), func() (gosnmp.Asn1BER, interface{}) {
+		return gosnmp.Integer, sensorState(s, typ, s.read())
+	})
+	a.add(column(snmp.MeasurementValue), func() (gosnmp.Asn1BER, interface{}) {
+		if s.spec.Readingtype != 0 {
+			return valueType, zero(valueType)
+		}
+		return scaledValue(s.read(), s.decdigits, valueType)
+	})
+	a.add(column(snmp.MeasurementTimeStamp), func() (gosnmp.Asn1BER, interface{}) {
+		return gosnmp.Gauge32, uint32(time.Now().Unix())
+	})
+}
+
+// sensorState of a reading as sensorStateEnumeration
+func sensorState(s *stubSensor, typ int, v float64) int {
+	if s.spec.Readingtype == 0 {
+		st := readingStatus(s.thresholds, v)
+		switch {
+		case st.AboveUpperCritical:
+			return snmp.StateAboveUpperCritical
+		case st.BelowLowerCritical:
+			return snmp.StateBelowLowerCritical
+		case st.AboveUpperWarning:
+			return snmp.StateAboveUpperWarning
+		case st.BelowLowerWarning:
+			return snmp.StateBelowLowerWarning
+		}
+		return snmp.StateNormal
+	}
+	switch snmp.SensorTypes[typ].Type {
+	case 13:
+		// onOffSensor
+		if v == raritan.PowerStateOn {
+			return snmp.StateOn
+		}
+		return snmp.StateOff
+	case 15, 16, 17:
+		// vibration, water leak and smoke detectors are alarmed or ok
+		if v != 0 {
+			return 11
+		}
+		return 12
+	}
+	return int(v)
+}
+
+func scaledValue(v float64, digits int, valueType gosnmp.Asn1BER) (gosnmp.Asn1BER, interface{}) {
+	n := math.Round(v * math.Pow10(digits))
+	if valueType == gosnmp.Integer {
+		return gosnmp.Integer, int(n)
+	}
+	if n < 0 {
+		n = 0
+	}
+	return valueType, uint32(n)
+}
+
+func zero(valueType gosnmp.Asn1BER) interface{} {
+	if valueType == gosnmp.Integer {
+		return 0
+	}
+	return uint32(0)
+}
+
+func octets(s string) func() (gosnmp.Asn1BER, interface{}) {
+	return func() (gosnmp.Asn1BER, interface{}) {
+		return gosnmp.OctetString, []byte(s)
+	}
+}
+
+func integer(i int) func() (gosnmp.Asn1BER, interface{}) {
+	return func() (gosnmp.Asn1BER, interface{}) {
+		return gosnmp.Integer, i
+	}
+}
+
+// oidIndex appends indexes to an OID
+func oidIndex(oid string, idx ...int) string {
+	for _, i := range idx {
+		oid += "." + strconv.Itoa(i)
+	}
+	return oid
+}
+
+func parseOID(oid string) []int {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	ns := make([]int, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return ns
+		}
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+func formatOID(oid []int) string {
+	return oidIndex("", oid...)
+}
+
+func compareOID(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// get the object with oid
+func (a *snmpAgent) get(oid []int) gosnmp.SnmpPDU {
+	i := sort.Search(len(a.objects), func(i int) bool {
+		return compareOID(a.objects[i].oid, oid) >= 0
+	})
+	if i < len(a.objects) && compareOID(a.objects[i].oid, oid) == 0 {
+		return a.objects[i].pdu()
+	}
+	return gosnmp.SnmpPDU{Name: formatOID(oid), Type: gosnmp.NoSuchObject}
+}
+
+// next object after oid
+func (a *snmpAgent) next(oid []int) gosnmp.SnmpPDU {
+	i := sort.Search(len(a.objects), func(i int) bool {
+		return compareOID(a.objects[i].oid, oid) > 0
+	})
+	if i < len(a.objects) {
+		return a.objects[i].pdu()
+	}
+	return gosnmp.SnmpPDU{Name: formatOID(oid), Type: gosnmp.EndOfMibView}
+}
+
+func (o mibObject) pdu() gosnmp.SnmpPDU {
+	t, v := o.value()
+	return gosnmp.SnmpPDU{Name: formatOID(o.oid), Type: t, Value: v}
+}
+
+// handle a request, nil responses are dropped like those with a wrong community
+func (a *snmpAgent) handle(req *gosnmp.SnmpPacket) *gosnmp.SnmpPacket {
+	if req.Version != gosnmp.Version2c && req.Version != gosnmp.Version1 || req.Community != a.community {
+		klog.V(1).Infof("Dropping SNMP request with version %s and community %q", req.Version, req.Community)
+		return nil
+	}
+	resp := &gosnmp.SnmpPacket{
+		Version:   req.Version,
+		Community: req.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: req.RequestID,
+		Variables: []gosnmp.SnmpPDU{},
+	}
+
+	switch req.PDUType {
+	case gosnmp.GetRequest:
+		for _, v := range req.Variables {
+			resp.Variables = append(resp.Variables, a.get(parseOID(v.Name)))
+		}
+	case gosnmp.GetNextRequest:
+		for _, v := range req.Variables {
+			resp.Variables = append(resp.Variables, a.next(parseOID(v.Name)))
+		}
+	case gosnmp.GetBulkRequest:
+		nonRepeaters := int(req.NonRepeaters)
+		if nonRepeaters > len(req.Variables) {
+			nonRepeaters = len(req.Variables)
+		}
+		for _, v := range req.Variables[:nonRepeaters] {
+			resp.Variables = append(resp.Variables, a.next(parseOID(v.Name)))
+		}
+		maxRepetitions := int(req.MaxRepetitions)
+		if maxRepetitions == 0 {
+			maxRepetitions = defaultMaxRepetitions
+		}
+		last := []gosnmp.SnmpPDU{}
+		last = append(last, req.Variables[nonRepeaters:]...)
+		for r := 0; r < maxRepetitions && len(last) > 0; r++ {
+			if len(resp.Variables)+len(last) > maxBulkVariables {
+				break
+			}
+			done := true
+			for i, v := range last {
+				n := a.next(parseOID(v.Name))
+				if n.Type != gosnmp.EndOfMibView {
+					done = false
+				}
+				resp.Variables = append(resp.Variables, n)
+				last[i] = n
+			}
+			if done {
+				break
+			}
+		}
+	default:
+		// sets are not supported, the PDU is read-only over SNMP here
+		resp.Error = gosnmp.ReadOnly
+		resp.ErrorIndex = 1
+		resp.Variables = req.Variables
+	}
+	return resp
+}
+
+// serveSNMP on the UDP port until it fails
+func serveSNMP(a *snmpAgent, port uint) error {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	klog.Infof("Serving SNMP on udp port %d", port)
+	return a.serve(conn)
+}
+
+// serve requests received on conn until reading fails
+func (a *snmpAgent) serve(conn net.PacketConn) error {
+	decoder := &gosnmp.GoSNMP{}
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		req, err := decoder.SnmpDecodePacket(buf[:n])
+		if err != nil {
+			klog.V(1).Infof("Invalid SNMP request from %s: %v", addr, err)
+			continue
+		}
+		klog.V(2).Infof("SNMP request %#x from %s with %d variables", req.PDUType, addr, len(req.Variables))
+		resp := a.handle(req)
+		if resp == nil {
+			continue
+		}
+		out, err := resp.MarshalMsg()
+		if err != nil {
+			klog.Errorf("Error marshalling SNMP response: %v", err)
+			continue
+		}
+		if _, err := conn.WriteTo(out, addr); err != nil {
+			klog.Errorf("Error sending SNMP response to %s: %v", addr, err)
+		}
+	}
+}
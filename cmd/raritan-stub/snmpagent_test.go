@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/snmp"
+)
+
+// snmpFixture has constant values, scaled by the decimal digits of their sensors in the MIB
+const snmpFixture = `
+name: pdu-snmp
+firmware: 4.0.20.5-49999
+nameplate: {manufacturer: Raritan, model: PX3-5493V, serial_number: QNP7654321}
+mac_address: 00:0d:5d:00:00:02
+inlets:
+  - label: I1
+    plug_type: IEC 60309 3P+N+E
+    sensors:
+      voltage: {decdigits: 1, value: {generator: constant, value: 230.4}}
+      current:
+        decdigits: 3
+        thresholds: {upper_warning: 12.5, upper_critical: 16}
+        value: {generator: constant, value: 5.125}
+    poles:
+      - {label: L1, line: 0, sensors: {current: {decdigits: 2, value: {generator: constant, value: 1.25}}}}
+      - {label: L2, line: 1, sensors: {current: {decdigits: 2, value: {generator: constant, value: 2.5}}}}
+outlets:
+  - label: "1"
+    name: web01
+    receptacle_type: IEC 60320 C13
+    sensors:
+      current: {decdigits: 2, value: {generator: constant, value: 0.75}}
+      outletState: {}
+  - label: "2"
+    sensors:
+      outletState: {}
+ocps:
+  - label: C1
+    sensors:
+      trip: {value: {generator: constant, value: 0}}
+peripherals:
+  - name: Cold Aisle
+    serial: AEI7A00003
+    position: [{type: port, port: "1"}, {type: hub, port: "3"}]
+    sensor:
+      sensor_type: 8
+      unit: 7
+      decdigits: 1
+      value: {generator: constant, value: -5.5}
+`
+
+// snmpClient of the agent of the topology, served on a free port of localhost.
+// Outlet 2 is switched off.
+func snmpClient(t *testing.T, fixture string) *snmp.Client {
+	t.Helper()
+	outletStates.Lock()
+	outletStates.states["1"] = raritan.PowerStateOff
+	outletStates.Unlock()
+	t.Cleanup(func() {
+		outletStates.Lock()
+		delete(outletStates.states, "1")
+		outletStates.Unlock()
+	})
+	topo, err := loadTopology(t, fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go newSNMPAgent(topo, "public").serve(conn)
+
+	c, err := snmp.NewClient("127.0.0.1", snmp.Config{
+		Port:      uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Community: "public",
+		Timeout:   time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSNMPDiscovery(t *testing.T) {
+	ctx := context.Background()
+	c := snmpClient(t, snmpFixture)
+
+	pdu, err := c.GetPDUInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu.Nameplate.Model != "PX3-5493V" || pdu.Nameplate.SerialNumber != "QNP7654321" || pdu.FwRevision != "4.0.20.5-49999" ||
+		pdu.MacAddress != "00:0d:5d:00:00:02" || pdu.Name != "pdu-snmp" {
+		t.Errorf("PDU info = %+v", pdu)
+	}
+
+	ins, err := c.GetPDUInlets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inInfo, err := c.GetInletsInfo(ctx, ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inInfo) != 1 || inInfo[0].Label != "I1" || inInfo[0].PlugType != "IEC 60309 3P+N+E" {
+		t.Fatalf("inlets = %+v", inInfo)
+	}
+	if got := sensorRIDs(inInfo[0].Sensors); got != "map[current:inlet/1/1 voltage:inlet/1/4]" {
+		t.Errorf("inlet sensors = %s", got)
+	}
+	poles, err := c.GetInletPoles(ctx, ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(poles) != 1 || len(poles[0]) != 2 {
+		t.Fatalf("poles = %+v", poles)
+	}
+	for i, want := range []string{"L1", "L2"} {
+		p := poles[0][i]
+		if p.Label != want || p.Line != i {
+			t.Errorf("pole %d: label %s, line %d, want %s, %d", i, p.Label, p.Line, want, i)
+		}
+		if got, want := sensorRIDs(p.Sensors), fmt.Sprintf("map[current:pole/1/%d/1]", i+1); got != want {
+			t.Errorf("pole %d sensors = %s, want %s", i, got, want)
+		}
+	}
+
+	outs, err := c.GetPDUOutlets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outInfo, err := c.GetOutletsInfo(ctx, outs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outInfo) != 2 {
+		t.Fatalf("outlets = %+v", outInfo)
+	}
+	if o := outInfo[0]; o.Label != "1" || o.Name != "web01" || o.ReceptacleType != "IEC 60320 C13" ||
+		!o.OutletState.Available || o.OutletState.PowerState != raritan.PowerStateOn {
+		t.Errorf("outlet 1 = %+v", o)
+	}
+	if o := outInfo[1]; !o.OutletState.Available || o.OutletState.PowerState != raritan.PowerStateOff {
+		t.Errorf("outlet 2 state = %+v, want off", o.OutletState)
+	}
+	if got := sensorRIDs(outInfo[0].Sensors); got != "map[current:outlet/1/1 outletState:outlet/1/14]" {
+		t.Errorf("outlet 1 sensors = %s", got)
+	}
+
+	ocps, err := c.GetPDUOCP(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocpInfo, err := c.GetOCPInfo(ctx, ocps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ocpInfo) != 1 || ocpInfo[0].Label != "C1" || sensorRIDs(ocpInfo[0].Sensors) != "map[trip:ocp/1/15]" {
+		t.Errorf("ocps = %+v", ocpInfo)
+	}
+
+	slots, err := c.GetPDUPeripheralSlots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	periph, err := c.GetPeripheralsInfo(ctx, slots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(periph) != 1 {
+		t.Fatalf("peripherals = %+v", periph)
+	}
+	d := periph[0]
+	if d.Name != "Cold Aisle" || d.DeviceID.Serial != "AEI7A00003" || d.DeviceID.Type.Type != 8 ||
+		d.DeviceID.Type.Unit != raritan.UnitDegreeCelsius || d.Device == nil || d.Device.RID != "external/1/10" {
+		t.Errorf("peripheral = %+v", d)
+	}
+	wantPos := []raritan.PeripheralPosition{
+		{PortType: raritan.PortTypeDevicePort, Port: "1"},
+		{PortType: raritan.PortTypeHubPort, Port: "3"},
+	}
+	if fmt.Sprint(d.Position) != fmt.Sprint(wantPos) {
+		t.Errorf("position = %+v, want %+v", d.Position, wantPos)
+	}
+}
+
+// sensorRIDs by name, formatted sorted by name
+func sensorRIDs(s raritan.Sensors) string {
+	rids := map[string]string{}
+	for name, res := range s {
+		rids[name] = res.RID
+	}
+	return fmt.Sprint(rids)
+}
+
+func TestSNMPReadings(t *testing.T) {
+	ctx := context.Background()
+	c := snmpClient(t, snmpFixture)
+	numeric := func(rid string) raritan.Resource {
+		return raritan.Resource{RID: rid, Type: snmp.ResourceNumericSensor}
+	}
+	sens := []raritan.Resource{
+		numeric("inlet/1/4"),
+		numeric("inlet/1/1"),
+		numeric("pole/1/2/1"),
+		numeric("outlet/1/1"),
+		{RID: "outlet/2/14", Type: snmp.ResourceStateSensor},
+		numeric("external/1/10"),
+	}
+
+	rs, err := c.GetSensorReadings(ctx, sens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{230.4, 5.125, 2.5, 0.75, raritan.PowerStateOff, -5.5} {
+		if !rs[i].Available || rs[i].Value != want {
+			t.Errorf("%s: reading %+v, want %v", sens[i].RID, rs[i], want)
+		}
+	}
+
+	ms, err := c.GetSensorsMetadata(ctx, sens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := ms[1]; m == nil || m.Decdigits != 3 || m.Type.Unit != raritan.UnitAmpere || !m.ThresholdCaps.HasUpperCritical {
+		t.Errorf("inlet current metadata = %+v", m)
+	}
+	if ms[4] != nil {
+		t.Errorf("outlet state metadata = %+v, want none", ms[4])
+	}
+
+	ts, err := c.GetSensorsThresholds(ctx, sens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := raritan.SensorThresholds{UpperCriticalActive: true, UpperCritical: 16, UpperWarningActive: true, UpperWarning: 12.5}
+	if ts[1] == nil || *ts[1] != want {
+		t.Errorf("inlet current thresholds = %+v, want %+v", ts[1], want)
+	}
+}
+
+func TestSNMPSensorUnavailable(t *testing.T) {
+	c := snmpClient(t, snmpFixture)
+	withFaults(t, "sensor_unavailable: {path: ^/model/outlet/0/}\n")
+
+	sens := []raritan.Resource{
+		{RID: "outlet/1/1", Type: snmp.ResourceNumericSensor},
+		{RID: "inlet/1/1", Type: snmp.ResourceNumericSensor},
+		{RID: "outlet/3/1", Type: snmp.ResourceNumericSensor},
+	}
+	rs, err := c.GetSensorReadings(context.Background(), sens)
+	if !raritan.IsPartial(err) {
+		t.Fatalf("err = %v, want the missing outlet 3 as partial result", err)
+	}
+	if rs[0].Available || !rs[1].Available || rs[2].Available {
+		t.Errorf("readings = %+v, want only the inlet available", rs)
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/goji/httpauth"
 	"github.com/gorilla/mux"
 	"github.com/jessevdk/go-flags"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/promdump"
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
 	"k8s.io/klog"
 )
@@ -21,6 +28,24 @@ type Config struct {
 	PduInlets  uint   `long:"pdu-inlets" env:"PDU_INLETS" default:"2"  description:"Number of inlets"`
 	PduName    string `long:"pdu-name" env:"PDU_NAME" default:"Fake Name" description:"Name of the pdu"`
 	PduSerial  string `long:"pdu-serial" env:"PDU_SERIAL" default:"FAKESERIALNUMBER" description:"Serial of the pdu"`
+
+	PduPhases      uint `long:"pdu-phases" env:"PDU_PHASES" default:"3" description:"Number of phases per inlet, poles are served for more than one"`
+	PduPeripherals uint `long:"pdu-peripherals" env:"PDU_PERIPHERALS" default:"5" description:"Number of peripheral devices"`
+
+	Fixture string `long:"fixture" env:"PDU_FIXTURE" description:"YAML file describing the PDU, replaces the --pdu-* topology flags"`
+	Faults  string `long:"faults" env:"PDU_FAULTS" description:"YAML file with faults to inject, see also the /_stub/faults endpoint"`
+	// Cassette replaces the topology with recorded responses
+	Cassette       string `long:"cassette" env:"PDU_CASSETTE" description:"Serve the responses recorded in a cassette file instead of a fake PDU"`
+	SessionTimeout uint   `long:"session-timeout" env:"PDU_SESSION_TIMEOUT" default:"1800" description:"Seconds an idle session token stays valid, 0 never expires"`
+	EventTimeout   uint   `long:"event-poll-timeout" env:"PDU_EVENT_POLL_TIMEOUT" default:"30" description:"Seconds a poll of an event channel waits for events"`
+	// SNMPPort serves the topology as the PDU2-MIB over SNMPv2c, not with a cassette
+	SNMPPort      uint   `long:"snmp-port" env:"PDU_SNMP_PORT" default:"0" description:"UDP port of an SNMPv2c agent serving the PDU2-MIB, 0 disables it"`
+	SNMPCommunity string `long:"snmp-community" env:"PDU_SNMP_COMMUNITY" default:"public" description:"Community of the SNMP agent"`
+	// TLSCert and TLSKey serve HTTPS like a real PDU
+	TLSCert string `long:"tls-cert" env:"PDU_TLS_CERT" description:"Certificate to serve HTTPS with"`
+	TLSKey  string `long:"tls-key" env:"PDU_TLS_KEY" description:"Key of the HTTPS certificate"`
+	// TLSClientCA must also sign the stub certificate, bulk requests present it to the stub itself
+	TLSClientCA string `long:"tls-client-ca" env:"PDU_TLS_CLIENT_CA" description:"Require client certificates signed by these PEM certificates"`
 }
 
 func Execute() {
@@ -39,22 +64,105 @@ func Execute() {
 
 	klog.V(1).Infof("Using config: %+v", conf)
 
-	auth := httpauth.SimpleBasicAuth(conf.Username, conf.Password)
-	bulkClient := rpc.NewClient(0, rpc.Auth{
-		Username: conf.Username,
-		Password: conf.Password,
-	})
+	if conf.Faults != "" {
+		fs, err := LoadFaults(conf.Faults)
+		if err != nil {
+			klog.Exit(err)
+		}
+		setFaults(fs)
+	}
 
+	sessions := newSessionStore(time.Duration(conf.SessionTimeout) * time.Second)
 	r := mux.NewRouter()
-	r.HandleFunc("/model/pdu/0", pduHandler(*conf))
-	r.HandleFunc("/bulk", bulkHandler(bulkClient, conf.Port))
-	r.HandleFunc("/model/inlet/{id:[0-9]+}", inletsHandler)
-	r.HandleFunc("/model/outlet/{id:[0-9]+}", outletsHandler)
-	r.HandleFunc("/tfwopaque/{type}/{id:[0-9]+}", ocpHandler)
-	r.HandleFunc("/tfwopaque/{id:[0-9]+}/{sensor}", sensorHandler)
-	r.HandleFunc("/model/{type}/{id:[0-9]+}/{sensor}", sensorHandler)
-	r.HandleFunc("/snmp", snmpHandler)
-	klog.Exit(http.ListenAndServe(fmt.Sprintf(":%d", conf.Port), logger(auth(r))))
+	r.HandleFunc(faultControlPath, faultsHandler)
+	events.timeout = time.Duration(conf.EventTimeout) * time.Second
+	r.HandleFunc(eventControlPath, eventsHandler(events))
+	if conf.Cassette != "" {
+		replayer, err := loadCassette(conf.Cassette)
+		if err != nil {
+			klog.Exit(err)
+		}
+		r.PathPrefix("/").Handler(cassetteHandler(replayer))
+	} else {
+		fixture := defaultFixture(*conf)
+		if conf.Fixture != "" {
+			if fixture, err = LoadFixture(conf.Fixture); err != nil {
+				klog.Exit(err)
+			}
+		}
+		t, err := newTopology(fixture)
+		if err != nil {
+			klog.Exitf("Invalid fixture: %v", err)
+		}
+
+		// bulk requests are sent back to the stub, which trusts its own certificate
+		baseURL := url.URL{Scheme: "http", Host: fmt.Sprintf("localhost:%d", conf.Port)}
+		var tlsConfig *tls.Config
+		if conf.TLSCert != "" {
+			baseURL.Scheme = "https"
+			cert, err := tls.LoadX509KeyPair(conf.TLSCert, conf.TLSKey)
+			if err != nil {
+				klog.Exit(err)
+			}
+			tlsConfig = &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{cert},
+			}
+		}
+		bulkClient := rpc.NewClient(0, rpc.Auth{
+			Username: conf.Username,
+			Password: conf.Password,
+		}, tlsConfig)
+
+		r.HandleFunc("/session", sessionHandler(sessions))
+		r.HandleFunc("/event_service", eventServiceHandler(events))
+		r.HandleFunc("/event_channel/{id:[0-9]+}", eventChannelHandler(events))
+		r.HandleFunc("/model/pdu/0", pduHandler(t))
+		r.HandleFunc("/bulk", bulkHandler(bulkClient, baseURL))
+		r.HandleFunc("/model/inlet/{id:[0-9]+}", inletsHandler(t))
+		r.HandleFunc("/model/inlet/{id:[0-9]+}/pole/{pole:[0-9]+}/{sensor}", sensorHandler(t))
+		r.HandleFunc("/model/outlet/{id:[0-9]+}", outletsHandler(t))
+		r.HandleFunc("/tfwopaque/{type}/{id:[0-9]+}", ocpHandler(t))
+		r.HandleFunc("/tfwopaque/{id:[0-9]+}/{sensor}", sensorHandler(t))
+		r.HandleFunc("/model/{type}/{id:[0-9]+}/{sensor}", sensorHandler(t))
+		r.HandleFunc("/snmp", snmpHandler)
+		r.HandleFunc("/model/peripheraldevicemanager", peripheralDeviceManagerHandler(t))
+		r.HandleFunc("/model/peripheraldeviceslot/{id:[0-9]+}", peripheralDeviceSlotHandler(t))
+		r.HandleFunc("/model/peripheraldevice/{id:[0-9]+}", sensorHandler(t))
+		r.HandleFunc(promdump.Path, dumpHandler(t))
+		go watchStates(t)
+
+		if conf.SNMPPort != 0 {
+			agent := newSNMPAgent(t, conf.SNMPCommunity)
+			go func() {
+				klog.Exit(serveSNMP(agent, conf.SNMPPort))
+			}()
+		}
+	}
+
+	auth := sessions.sessionAuth(httpauth.SimpleBasicAuth(conf.Username, conf.Password))
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", conf.Port),
+		Handler: logger(auth(faultInjector(r))),
+	}
+	if conf.TLSCert == "" {
+		klog.Exit(srv.ListenAndServe())
+	}
+	if conf.TLSClientCA != "" {
+		pem, err := os.ReadFile(conf.TLSClientCA)
+		if err != nil {
+			klog.Exit(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			klog.Exitf("No certificates found in %s", conf.TLSClientCA)
+		}
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+	klog.Exit(srv.ListenAndServeTLS(conf.TLSCert, conf.TLSKey))
 }
 
 func logger(next http.Handler) http.Handler {
@@ -64,3 +172,13 @@ func logger(next http.Handler) http.Handler {
 		klog.V(2).Infof("HTTP Response: Headers: %v", w.Header())
 	})
 }
+
+// resourceIndex from the id route variable, responds not found if it is out of range
+func resourceIndex(w http.ResponseWriter, r *http.Request, n int) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 0 || id >= n {
+		http.NotFound(w, r)
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+// sensorResourceTypes for the sensor type shorthands in fixtures
+var sensorResourceTypes = map[string]string{
+	"numeric":      "sensors.NumericSensor_4_0_2",
+	"accumulating": "sensors.AccumulatingNumericSensor_2_0_3",
+	"state":        "sensors.StateSensor_4_0_2",
+	"trip":         "pdumodel.OverCurrentProtectorTripSensor_1_0_5",
+	"residual":     "ResidualCurrentStateSensor_2_0_2",
+}
+
+// sensorKinds of the well known sensor names, numeric sensors are listed in sensorTypes
+var sensorKinds = map[string]string{
+	"powerQuality":          "state",
+	"surgeProtectorStatus":  "state",
+	"residualCurrentStatus": "residual",
+	"outletState":           "state",
+	"trip":                  "trip",
+}
+
+// peripheralPortTypes for the position types in fixtures
+var peripheralPortTypes = map[string]int{
+	"onboard": raritan.PortTypeOnboard,
+	"port":    raritan.PortTypeDevicePort,
+	"hub":     raritan.PortTypeHubPort,
+	"chain":   raritan.PortTypeDaisyChain,
+}
+
+// topology of the stubbed PDU, resolved from a fixture
+type topology struct {
+	metadata    raritan.PDUMetadata
+	name        string
+	inlets      []inletNode
+	outlets     []outletNode
+	ocps        []ocpNode
+	peripherals []peripheralNode
+	// sensors by resource id
+	sensors map[string]*stubSensor
+}
+
+type inletNode struct {
+	metadata raritan.InletMetadata
+	name     string
+	sensors  map[string]*raritan.Resource
+	poles    []map[string]interface{}
+}
+
+type outletNode struct {
+	metadata raritan.OutletMetadata
+	name     string
+	sensors  map[string]*raritan.Resource
+}
+
+type ocpNode struct {
+	metadata raritan.OCPMetadata
+	name     string
+	sensors  map[string]*raritan.Resource
+}
+
+type peripheralNode struct {
+	device raritan.PeripheralDevice
+	name   string
+}
+
+// stubSensor serving readings
+type stubSensor struct {
+	resource   raritan.Resource
+	spec       raritan.SensorTypeSpec
+	decdigits  int
+	thresholds raritan.SensorThresholds
+	read       func() float64
+}
+
+func newTopology(f *Fixture) (*topology, error) {
+	t := &topology{
+		metadata: raritan.PDUMetadata{
+			Nameplate: raritan.PDUNameplate{
+				Manufacturer: f.Nameplate.Manufacturer,
+				Model:        f.Nameplate.Model,
+				PartNumber:   f.Nameplate.PartNumber,
+				SerialNumber: f.Nameplate.SerialNumber,
+			},
+			CtrlBoardSerial:      f.CtrlBoardSerial,
+			HwRevision:           f.Hardware,
+			FwRevision:           f.Firmware,
+			MacAddress:           f.MacAddress,
+			HasMeteredOutlets:    f.MeteredOutlets,
+			HasSwitchableOutlets: f.SwitchableOutlets,
+		},
+		name:    f.Name,
+		sensors: map[string]*stubSensor{},
+	}
+
+	for i, in := range f.Inlets {
+		sens, err := t.addSensors(fmt.Sprintf("/model/inlet/%d/%%s", i), in.Sensors)
+		if err != nil {
+			return nil, fmt.Errorf("inlet %d: %w", i, err)
+		}
+		node := inletNode{
+			metadata: raritan.InletMetadata{
+				Label:    in.Label,
+				PlugType: in.PlugType,
+			},
+			name:    in.Name,
+			sensors: sens,
+			poles:   []map[string]interface{}{},
+		}
+		for p, pole := range in.Poles {
+			sens, err := t.addSensors(fmt.Sprintf("/model/inlet/%d/pole/%d/%%s", i, p), pole.Sensors)
+			if err != nil {
+				return nil, fmt.Errorf("inlet %d pole %d: %w", i, p, err)
+			}
+			m := map[string]interface{}{
+				"label":  pole.Label,
+				"line":   pole.Line,
+				"nodeId": p,
+			}
+			for k, v := range sens {
+				m[k] = v
+			}
+			node.poles = append(node.poles, m)
+		}
+		t.inlets = append(t.inlets, node)
+	}
+
+	for i, o := range f.Outlets {
+		id := fmt.Sprintf("%d", i)
+		sens, err := t.addSensors(fmt.Sprintf("/model/outlet/%d/%%s", i), o.Sensors)
+		if err != nil {
+			return nil, fmt.Errorf("outlet %d: %w", i, err)
+		}
+		// the outlet state follows the switched power state
+		if s, ok := t.sensors[fmt.Sprintf("/model/outlet/%d/outletState", i)]; ok {
+			s.read = func() float64 {
+				return float64(outletPowerState(id))
+			}
+		}
+		t.outlets = append(t.outlets, outletNode{
+			metadata: raritan.OutletMetadata{
+				Label:          o.Label,
+				ReceptacleType: o.ReceptacleType,
+			},
+			name:    o.Name,
+			sensors: sens,
+		})
+	}
+
+	for i, o := range f.OCPs {
+		sens, err := t.addSensors(fmt.Sprintf("/tfwopaque/%d/%%s", i), o.Sensors)
+		if err != nil {
+			return nil, fmt.Errorf("ocp %d: %w", i, err)
+		}
+		t.ocps = append(t.ocps, ocpNode{
+			metadata: raritan.OCPMetadata{
+				Label:      o.Label,
+				MaxTripCnt: o.MaxTripCnt,
+			},
+			name:    o.Name,
+			sensors: sens,
+		})
+	}
+
+	for i, p := range f.Peripherals {
+		rid := fmt.Sprintf("/model/peripheraldevice/%d", i)
+		res, s, err := newStubSensor(rid, "", p.Sensor)
+		if err != nil {
+			return nil, fmt.Errorf("peripheral %d: %w", i, err)
+		}
+		t.sensors[rid] = s
+
+		position := make([]raritan.PeripheralPosition, len(p.Position))
+		for j, pos := range p.Position {
+			portType, ok := peripheralPortTypes[pos.Type]
+			if !ok {
+				return nil, fmt.Errorf("peripheral %d: unknown position type %q", i, pos.Type)
+			}
+			position[j] = raritan.PeripheralPosition{
+				PortType: portType,
+				Port:     pos.Port,
+			}
+		}
+		t.peripherals = append(t.peripherals, peripheralNode{
+			device: raritan.PeripheralDevice{
+				DeviceID: raritan.PeripheralDeviceID{
+					Serial:  p.Serial,
+					Type:    s.spec,
+					Channel: p.Channel,
+				},
+				Position: position,
+				Device:   res,
+			},
+			name: p.Name,
+		})
+	}
+	return t, nil
+}
+
+// addSensors resolves the sensors of a component, ridFormat contains the sensor name verb
+func (t *topology) addSensors(ridFormat string, sensors SensorsFixture) (map[string]*raritan.Resource, error) {
+	names := make([]string, 0, len(sensors))
+	for n := range sensors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	res := make(map[string]*raritan.Resource, len(sensors))
+	for _, n := range names {
+		f := sensors[n]
+		if f == nil {
+			res[n] = nil
+			continue
+		}
+		rid := fmt.Sprintf(ridFormat, n)
+		r, s, err := newStubSensor(rid, n, *f)
+		if err != nil {
+			return nil, fmt.Errorf("sensor %s: %w", n, err)
+		}
+		res[n] = r
+		t.sensors[rid] = s
+	}
+	return res, nil
+}
+
+// newStubSensor with defaults for well known sensor names
+func newStubSensor(rid string, name string, f SensorFixture) (*raritan.Resource, *stubSensor, error) {
+	spec, numeric := sensorTypes[name]
+	kind := f.Type
+	if kind == "" {
+		kind = "numeric"
+		if k, ok := sensorKinds[name]; ok && !numeric {
+			kind = k
+		}
+	}
+	resType, ok := sensorResourceTypes[kind]
+	if !ok {
+		resType = kind
+	}
+	res := &raritan.Resource{
+		RID:  rid,
+		Type: resType,
+	}
+
+	spec.Readingtype = 0
+	if k, ok := raritan.LookupSensorKind(resType); !ok || !k.Numeric {
+		spec.Readingtype = 1
+	}
+	if f.SensorType != nil {
+		spec.Type = *f.SensorType
+	}
+	if f.Unit != nil {
+		spec.Unit = *f.Unit
+	}
+
+	s := &stubSensor{
+		resource:   *res,
+		spec:       spec,
+		decdigits:  3,
+		thresholds: unitThresholds[spec.Unit],
+	}
+	if f.Decdigits != nil {
+		s.decdigits = *f.Decdigits
+	}
+	if f.Thresholds != nil {
+		s.thresholds = f.Thresholds.thresholds()
+	}
+
+	read, err := f.Value.generator()
+	if err != nil {
+		return nil, nil, err
+	}
+	s.read = read
+	return res, s, nil
+}
+
+func (t ThresholdsFixture) thresholds() raritan.SensorThresholds {
+	th := raritan.SensorThresholds{}
+	if t.UpperCritical != nil {
+		th.UpperCriticalActive, th.UpperCritical = true, *t.UpperCritical
+	}
+	if t.UpperWarning != nil {
+		th.UpperWarningActive, th.UpperWarning = true, *t.UpperWarning
+	}
+	if t.LowerWarning != nil {
+		th.LowerWarningActive, th.LowerWarning = true, *t.LowerWarning
+	}
+	if t.LowerCritical != nil {
+		th.LowerCriticalActive, th.LowerCritical = true, *t.LowerCritical
+	}
+	return th
+}
@@ -4,11 +4,15 @@ go 1.15
 
 require (
 	github.com/goji/httpauth v0.0.0-20160601135302-2da839ab0f4d
+	github.com/golang/protobuf v1.4.2
 	github.com/gorilla/mux v1.8.0
+	github.com/gosnmp/gosnmp v1.32.0
 	github.com/iancoleman/strcase v0.1.1
 	github.com/jessevdk/go-flags v1.4.1-0.20200711081900-c17162fe8fd7
 	github.com/mitchellh/mapstructure v1.3.3
 	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.10.0
 	golang.org/x/sys v0.0.0-20200828081204-131dc92a58d5 // indirect
 	gopkg.in/yaml.v3 v3.0.1
 	k8s.io/apimachinery v0.19.0
@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// BreakerState of the circuit breaker of a poller
+type BreakerState int
+
+const (
+	// BreakerClosed polls every interval
+	BreakerClosed BreakerState = iota
+	// BreakerOpen pauses polling until the cooldown has passed
+	BreakerOpen
+	// BreakerHalfOpen polls once to probe if the PDU has recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker pauses polling after Threshold consecutive failures. The cooldown
+// starts at Cooldown.Duration and grows with every failed probe.
+type breaker struct {
+	threshold int
+	cooldown  wait.Backoff
+
+	state    BreakerState
+	failures int
+	backoff  wait.Backoff
+	until    time.Time
+}
+
+func newBreaker(threshold int, cooldown wait.Backoff) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		backoff:   cooldown,
+	}
+}
+
+// allow a poll now, moves an open breaker to half-open once the cooldown has passed
+func (b *breaker) allow(now time.Time) bool {
+	if b.threshold <= 0 || b.state != BreakerOpen {
+		return true
+	}
+	if now.Before(b.until) {
+		return false
+	}
+	b.state = BreakerHalfOpen
+	return true
+}
+
+// record the result of a poll, returns the cooldown if the breaker opened
+func (b *breaker) record(now time.Time, err error) (time.Duration, bool) {
+	if err == nil {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.backoff = b.cooldown
+		return 0, false
+	}
+
+	b.failures++
+	if b.threshold <= 0 || (b.state == BreakerClosed && b.failures < b.threshold) {
+		return 0, false
+	}
+	d := b.backoff.Step()
+	b.state = BreakerOpen
+	b.until = now.Add(d)
+	return d, true
+}
@@ -0,0 +1,119 @@
+package exporter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestBreaker(t *testing.T) {
+	cooldown := wait.Backoff{
+		Duration: 10 * time.Second,
+		Factor:   2,
+		Steps:    100,
+		Cap:      time.Minute,
+	}
+	// step polls at the given second unless the breaker denies it
+	type step struct {
+		at      int
+		fail    bool
+		allowed bool
+		state   BreakerState
+		// opened is the cooldown in seconds if the breaker opened, 0 otherwise
+		opened int
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps: []step{
+				{at: 0, fail: true, allowed: true, state: BreakerClosed},
+				{at: 1, fail: true, allowed: true, state: BreakerClosed},
+				{at: 2, fail: true, allowed: true, state: BreakerClosed},
+			},
+		},
+		{
+			name:      "success resets the failures",
+			threshold: 3,
+			steps: []step{
+				{at: 0, fail: true, allowed: true, state: BreakerClosed},
+				{at: 1, fail: true, allowed: true, state: BreakerClosed},
+				{at: 2, allowed: true, state: BreakerClosed},
+				{at: 3, fail: true, allowed: true, state: BreakerClosed},
+				{at: 4, fail: true, allowed: true, state: BreakerClosed},
+			},
+		},
+		{
+			name:      "opens at the threshold",
+			threshold: 3,
+			steps: []step{
+				{at: 0, fail: true, allowed: true, state: BreakerClosed},
+				{at: 1, fail: true, allowed: true, state: BreakerClosed},
+				{at: 2, fail: true, allowed: true, state: BreakerOpen, opened: 10},
+				{at: 5, state: BreakerOpen},
+				{at: 11, state: BreakerOpen},
+			},
+		},
+		{
+			name:      "failed probes grow the cooldown up to its cap",
+			threshold: 1,
+			steps: []step{
+				{at: 0, fail: true, allowed: true, state: BreakerOpen, opened: 10},
+				{at: 10, fail: true, allowed: true, state: BreakerOpen, opened: 20},
+				{at: 29, state: BreakerOpen},
+				{at: 30, fail: true, allowed: true, state: BreakerOpen, opened: 40},
+				{at: 70, fail: true, allowed: true, state: BreakerOpen, opened: 60},
+				{at: 130, fail: true, allowed: true, state: BreakerOpen, opened: 60},
+			},
+		},
+		{
+			name:      "successful probe closes and resets the cooldown",
+			threshold: 2,
+			steps: []step{
+				{at: 0, fail: true, allowed: true, state: BreakerClosed},
+				{at: 1, fail: true, allowed: true, state: BreakerOpen, opened: 10},
+				{at: 11, fail: true, allowed: true, state: BreakerOpen, opened: 20},
+				{at: 31, allowed: true, state: BreakerClosed},
+				{at: 32, fail: true, allowed: true, state: BreakerClosed},
+				{at: 33, fail: true, allowed: true, state: BreakerOpen, opened: 10},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(tt.threshold, cooldown)
+			start := time.Now()
+			for _, s := range tt.steps {
+				now := start.Add(time.Duration(s.at) * time.Second)
+				prev := b.state
+				allowed := b.allow(now)
+				if allowed != s.allowed {
+					t.Fatalf("at %ds: allowed = %v, want %v", s.at, allowed, s.allowed)
+				}
+				// an open breaker probes once half-open
+				if allowed && prev == BreakerOpen && b.state != BreakerHalfOpen {
+					t.Fatalf("at %ds: state = %s, want half-open", s.at, b.state)
+				}
+				if allowed {
+					var err error
+					if s.fail {
+						err = errors.New("poll failed")
+					}
+					d, opened := b.record(now, err)
+					if opened != (s.opened > 0) || d != time.Duration(s.opened)*time.Second {
+						t.Fatalf("at %ds: cooldown = %s, opened = %v, want %ds", s.at, d, opened, s.opened)
+					}
+				}
+				if b.state != s.state {
+					t.Fatalf("at %ds: state = %s, want %s", s.at, b.state, s.state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package exporter
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// closeEventsTimeout of closing the event channel when the poller stops
+const closeEventsTimeout = 5 * time.Second
+
+// minEventPoll is the shortest time between polls of an event channel that returned no
+// events, in case the PDU answers immediately instead of holding the request
+const minEventPoll = time.Second
+
+// eventTypes the poller subscribes to, all but state changes invalidate the sensors
+var eventTypes = []string{
+	raritan.EventStateSensorStateChanged,
+	raritan.EventNumericSensorStateChanged,
+	raritan.EventOutletPowerStateChanged,
+	raritan.EventSensorMetadataChanged,
+	raritan.EventSensorThresholdsChanged,
+	raritan.EventOutletSettingsChanged,
+	raritan.EventInletSettingsChanged,
+	raritan.EventOCPSettingsChanged,
+	raritan.EventPDUSettingsChanged,
+	raritan.EventDeviceAdded,
+	raritan.EventDeviceRemoved,
+}
+
+// eventBackoff between attempts to reconnect to the event channel
+var eventBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      time.Minute,
+}
+
+// SetEvents long-polls the event channel of the PDU with client while running, its timeout
+// has to exceed the poll timeout of the PDU. Must be called before Run.
+func (p *Poller) SetEvents(client raritan.Client) {
+	p.events = &client
+}
+
+// watchEvents handles events of the PDU until ctx is done, the channel is recreated on errors
+func (p *Poller) watchEvents(ctx context.Context) {
+	address := p.events.Address()
+	backoff := eventBackoff
+	var ch *raritan.EventChannel
+	failing := false
+
+	fail := func(err error) {
+		if !failing {
+			klog.Warningf("Event channel of %s failed, polling only: %v", address, err)
+		} else {
+			klog.V(1).Infof("Event channel of %s failed: %v", address, err)
+		}
+		failing = true
+		ch = nil
+		sleep(ctx, backoff.Step())
+	}
+
+	for ctx.Err() == nil {
+		if ch == nil {
+			c, err := p.events.NewEventChannel(ctx, eventTypes)
+			if err != nil {
+				if ctx.Err() == nil {
+					fail(err)
+				}
+				continue
+			}
+			ch = c
+			// events may have been missed while there was no channel
+			atomic.StoreInt32(&p.rediscover, 1)
+			klog.Infof("Receiving events of %s on %s", address, ch.RID)
+		}
+
+		start := time.Now()
+		evs, err := p.events.PollEvents(ctx, ch)
+		if err != nil {
+			if ctx.Err() == nil {
+				fail(err)
+			}
+			continue
+		}
+		failing = false
+		backoff = eventBackoff
+
+		p.handleEvents(evs)
+		if len(evs) == 0 {
+			sleep(ctx, minEventPoll-time.Since(start))
+		}
+	}
+
+	// the PDU would keep queueing events for a stopped poller
+	if ch != nil {
+		cctx, cancel := context.WithTimeout(context.Background(), closeEventsTimeout)
+		defer cancel()
+		if err := p.events.CloseEventChannel(cctx, ch); err != nil {
+			klog.Warningf("Failed to close event channel of %s: %v", address, err)
+		}
+	}
+	klog.V(1).Infof("Stopped watching events of %s", address)
+}
+
+// handleEvents updates state sensors in the snapshot and flags the sensors for
+// rediscovery on configuration changes
+func (p *Poller) handleEvents(evs []raritan.Event) {
+	for _, e := range evs {
+		src := e.Value.Source.RID
+		klog.V(2).Infof("Event %s of %s from %s", e.Type, src, p.events.Address())
+		switch e.Name() {
+		case raritan.EventStateSensorStateChanged, raritan.EventNumericSensorStateChanged:
+			if e.Value.NewReading == nil {
+				continue
+			}
+			p.updateReading(func(s SensorLog) bool {
+				return s.Resource.RID == src
+			}, *e.Value.NewReading)
+		case raritan.EventOutletPowerStateChanged:
+			if e.Value.NewPowerState == nil {
+				continue
+			}
+			p.updateReading(func(s SensorLog) bool {
+				return s.Parent == src && s.Sensor == "outletState"
+			}, raritan.Reading{
+				Timestamp: uint(time.Now().Unix()),
+				Available: true,
+				Value:     float64(*e.Value.NewPowerState),
+			})
+		default:
+			p.invalidate()
+		}
+	}
+}
+
+// invalidate the sensors and poll right away to rediscover them
+func (p *Poller) invalidate() {
+	atomic.StoreInt32(&p.rediscover, 1)
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// updateReading of the sensors matching in the latest snapshot. Unavailable readings
+// are left to the next poll, which drops the sensor.
+func (p *Poller) updateReading(match func(SensorLog) bool, r raritan.Reading) {
+	if !r.Available {
+		return
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	// scrapes may still hold the logs of the snapshot
+	var logs []SensorLog
+	for i, s := range p.snapshot.Logs {
+		if !match(s) {
+			continue
+		}
+		if logs == nil {
+			logs = make([]SensorLog, len(p.snapshot.Logs))
+			copy(logs, p.snapshot.Logs)
+		}
+		logs[i].Time = time.Unix(int64(r.Timestamp), 0)
+		logs[i].Value = r.Value
+		logs[i].Status = r.Status
+	}
+	if logs != nil {
+		p.snapshot.Logs = logs
+	}
+}
+
+// sleep for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,238 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// eventRPC is the event service of a PDU. Polls wait for queued events, or fail
+// while failPolls is above 0. Requests are recorded as "method path".
+type eventRPC struct {
+	mux       sync.Mutex
+	channels  int
+	requests  []string
+	types     map[string][]string
+	failPolls int
+	queue     chan []raritan.Event
+}
+
+func newEventRPC() *eventRPC {
+	return &eventRPC{types: map[string][]string{}, queue: make(chan []raritan.Event, 10)}
+}
+
+func (e *eventRPC) Call(ctx context.Context, u url.URL, req rpc.Request) (*rpc.Response, error) {
+	e.mux.Lock()
+	e.requests = append(e.requests, req.Method+" "+u.Path)
+	var ret interface{} = map[string]interface{}{"_ret_": 0}
+	switch req.Method {
+	case "newChannel":
+		e.channels++
+		ret = map[string]interface{}{"_ret_": raritan.Resource{RID: fmt.Sprintf("/event_channel/%d", e.channels), Type: "event.Channel_1_0_0"}}
+	case "demandEventTypes", "cancelEventTypes":
+		e.types[req.Method+" "+u.Path] = req.Params["typeIds"].([]string)
+	case "pollEvents":
+		if e.failPolls > 0 {
+			e.failPolls--
+			e.mux.Unlock()
+			return nil, rpc.StatusError{StatusCode: 404, Status: "404 Not Found"}
+		}
+		e.mux.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case evs := <-e.queue:
+			return eventResponse(map[string]interface{}{"_ret_": 0, "events": evs})
+		}
+	}
+	e.mux.Unlock()
+	return eventResponse(ret)
+}
+
+func (e *eventRPC) BatchCall(ctx context.Context, u url.URL, reqs []rpc.Request) ([]rpc.Response, error) {
+	return nil, errors.New("not supported")
+}
+
+// Requests so far
+func (e *eventRPC) Requests() []string {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return append([]string{}, e.requests...)
+}
+
+func eventResponse(v interface{}) (*rpc.Response, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(bs)
+	return &rpc.Response{Result: &raw}, nil
+}
+
+// eventLogs are sensor logs of a state sensor and of the state of an outlet
+func eventLogs() []SensorLog {
+	return []SensorLog{
+		{Resource: raritan.Resource{RID: "/model/inlet/0/surgeProtectorStatus"}, Sensor: "surgeProtectorStatus", Value: 0},
+		{Resource: raritan.Resource{RID: "/model/outlet/0/outletState"}, Parent: "/model/outlet/0", Sensor: "outletState", Value: 1},
+		{Resource: raritan.Resource{RID: "/model/outlet/1/outletState"}, Parent: "/model/outlet/1", Sensor: "outletState", Value: 1},
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	off := 0
+	tests := []struct {
+		name  string
+		event raritan.Event
+		// values of the eventLogs after the event
+		values     []float64
+		rediscover bool
+	}{
+		{
+			name: "state sensor changed",
+			event: raritan.Event{
+				Type:  "sensors.StateSensor_4_0_2.StateChangedEvent",
+				Value: raritan.EventValue{Source: raritan.Resource{RID: "/model/inlet/0/surgeProtectorStatus"}, NewReading: &raritan.Reading{Timestamp: 100, Available: true, Value: 1}},
+			},
+			values: []float64{1, 1, 1},
+		},
+		{
+			name: "unavailable reading",
+			event: raritan.Event{
+				Type:  "sensors.StateSensor_4_0_2.StateChangedEvent",
+				Value: raritan.EventValue{Source: raritan.Resource{RID: "/model/inlet/0/surgeProtectorStatus"}, NewReading: &raritan.Reading{Timestamp: 100, Value: 1}},
+			},
+			values: []float64{0, 1, 1},
+		},
+		{
+			name: "outlet switched",
+			event: raritan.Event{
+				Type:  "pdumodel.Outlet_2_1_4.PowerStateChangedEvent",
+				Value: raritan.EventValue{Source: raritan.Resource{RID: "/model/outlet/1"}, NewPowerState: &off},
+			},
+			values: []float64{0, 1, 0},
+		},
+		{
+			name: "settings changed",
+			event: raritan.Event{
+				Type:  "pdumodel.Outlet_2_1_4.SettingsChangedEvent",
+				Value: raritan.EventValue{Source: raritan.Resource{RID: "/model/outlet/1"}},
+			},
+			values:     []float64{0, 1, 1},
+			rediscover: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPoller(nil, 0, false)
+			p.SetEvents(raritan.Client{})
+			logs := eventLogs()
+			p.setSnapshot(Snapshot{Logs: logs})
+
+			p.handleEvents([]raritan.Event{tt.event})
+
+			got := []float64{}
+			for _, l := range p.Snapshot().Logs {
+				got = append(got, l.Value)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.values) {
+				t.Errorf("values = %v, want %v", got, tt.values)
+			}
+			// scrapes may still hold the previous logs
+			if fmt.Sprint(logs) != fmt.Sprint(eventLogs()) {
+				t.Error("logs of the previous snapshot were modified")
+			}
+			if rediscover := atomic.LoadInt32(&p.rediscover) == 1; rediscover != tt.rediscover {
+				t.Errorf("rediscover = %t, want %t", rediscover, tt.rediscover)
+			}
+			select {
+			case <-p.wake:
+				if !tt.rediscover {
+					t.Error("poller woken without rediscovery")
+				}
+			default:
+				if tt.rediscover {
+					t.Error("poller not woken for rediscovery")
+				}
+			}
+		})
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	backoff := eventBackoff
+	eventBackoff.Duration = 10 * time.Millisecond
+	t.Cleanup(func() { eventBackoff = backoff })
+	service := newEventRPC()
+	service.failPolls = 1
+	p := NewPoller(nil, 0, false)
+	p.SetEvents(raritan.Client{RPCClient: service})
+	p.setSnapshot(Snapshot{Logs: eventLogs()})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.watchEvents(ctx)
+		close(done)
+	}()
+
+	// the first poll fails, the channel is recreated after the backoff
+	service.queue <- []raritan.Event{{
+		Type:  "sensors.StateSensor_4_0_2.StateChangedEvent",
+		Value: raritan.EventValue{Source: raritan.Resource{RID: "/model/inlet/0/surgeProtectorStatus"}, NewReading: &raritan.Reading{Timestamp: 100, Available: true, Value: 1}},
+	}}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Snapshot().Logs[0].Value != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("event not applied to the snapshot")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&p.rediscover) != 1 {
+		t.Error("sensors not rediscovered after the channel was created")
+	}
+	// the next poll waits for events until the poller stops
+	for len(service.Requests()) < 7 {
+		if time.Now().After(deadline) {
+			t.Fatal("channel not polled again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchEvents did not stop")
+	}
+
+	want := []string{
+		"newChannel /event_service",
+		"demandEventTypes /event_channel/1",
+		"pollEvents /event_channel/1",
+		"newChannel /event_service",
+		"demandEventTypes /event_channel/2",
+		"pollEvents /event_channel/2",
+		"pollEvents /event_channel/2",
+		"cancelEventTypes /event_channel/2",
+	}
+	if got := service.Requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+	service.mux.Lock()
+	defer service.mux.Unlock()
+	for _, k := range []string{"demandEventTypes /event_channel/2", "cancelEventTypes /event_channel/2"} {
+		if fmt.Sprint(service.types[k]) != fmt.Sprint(eventTypes) {
+			t.Errorf("%s types = %v, want %v", k, service.types[k], eventTypes)
+		}
+	}
+}
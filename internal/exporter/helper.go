@@ -3,14 +3,18 @@ package exporter
 import (
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/iancoleman/strcase"
 )
 
 func snakeCase() func(string) string {
 	ms := map[string]string{}
+	var mux sync.Mutex
 
 	return func(v string) string {
+		mux.Lock()
+		defer mux.Unlock()
 		t, ok := ms[v]
 		if !ok {
 			sc := strcase.ToSnake(v)
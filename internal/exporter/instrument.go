@@ -0,0 +1,156 @@
+package exporter
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+const (
+	selfSubsystem = "exporter"
+)
+
+// rpcDurationBuckets of the latency histogram, in seconds
+var rpcDurationBuckets = []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// RPCStats of the requests sent to a PDU. They are exported by the collector of the PDU,
+// so they go away with it when the PDU is removed.
+type RPCStats struct {
+	mux       sync.Mutex
+	requests  map[rpcResult]uint64
+	durations map[rpcMethod]*rpcDuration
+}
+
+type rpcMethod struct {
+	address string
+	method  string
+}
+
+type rpcResult struct {
+	rpcMethod
+	result string
+}
+
+type rpcDuration struct {
+	count uint64
+	sum   float64
+	// buckets counts the requests up to each of rpcDurationBuckets
+	buckets []uint64
+}
+
+// NewRPCStats without requests
+func NewRPCStats() *RPCStats {
+	return &RPCStats{
+		requests:  map[rpcResult]uint64{},
+		durations: map[rpcMethod]*rpcDuration{},
+	}
+}
+
+// observe a request
+func (s *RPCStats) observe(m rpcMethod, result string, d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requests[rpcResult{m, result}]++
+
+	du, ok := s.durations[m]
+	if !ok {
+		du = &rpcDuration{buckets: make([]uint64, len(rpcDurationBuckets))}
+		s.durations[m] = du
+	}
+	du.count++
+	du.sum += d.Seconds()
+	for i, b := range rpcDurationBuckets {
+		if d.Seconds() <= b {
+			du.buckets[i]++
+		}
+	}
+}
+
+// rpcDescs of the request metrics, with the target labels of the PDU
+type rpcDescs struct {
+	requests *prometheus.Desc
+	duration *prometheus.Desc
+}
+
+func newRPCDescs(targetLabels map[string]string) *rpcDescs {
+	return &rpcDescs{
+		requests: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, selfSubsystem, "rpc_requests_total"),
+			"Number of JSON RPC requests sent to PDUs",
+			[]string{"pdu_name", "address", "method", "result"},
+			targetLabels,
+		),
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, selfSubsystem, "rpc_request_duration_seconds"),
+			"Latency of JSON RPC requests sent to PDUs",
+			[]string{"pdu_name", "address", "method"},
+			targetLabels,
+		),
+	}
+}
+
+func (d *rpcDescs) collect(metric chan<- prometheus.Metric, name string, s *RPCStats) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	results := make([]rpcResult, 0, len(s.requests))
+	for r := range s.requests {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.address != b.address {
+			return a.address < b.address
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.result < b.result
+	})
+	for _, r := range results {
+		metric <- prometheus.MustNewConstMetric(d.requests, prometheus.CounterValue, float64(s.requests[r]),
+			name, r.address, r.method, r.result)
+	}
+
+	for m, du := range s.durations {
+		buckets := make(map[float64]uint64, len(rpcDurationBuckets))
+		for i, b := range rpcDurationBuckets {
+			buckets[b] = du.buckets[i]
+		}
+		metric <- prometheus.MustNewConstHistogram(d.duration, du.count, du.sum, buckets, name, m.address, m.method)
+	}
+}
+
+type instrumentedClient struct {
+	rpc.Client
+	address string
+	stats   *RPCStats
+}
+
+// InstrumentRPC wraps an RPC client to record request counts and latencies in stats
+func InstrumentRPC(c rpc.Client, address string, stats *RPCStats) rpc.Client {
+	return &instrumentedClient{
+		Client:  c,
+		address: address,
+		stats:   stats,
+	}
+}
+
+func (c *instrumentedClient) Call(ctx context.Context, u url.URL, req rpc.Request) (*rpc.Response, error) {
+	start := time.Now()
+	res, err := c.Client.Call(ctx, u, req)
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	} else if res.IsError() {
+		result = "rpc_error"
+	}
+	c.stats.observe(rpcMethod{c.address, req.Method}, result, time.Since(start))
+	return res, err
+}
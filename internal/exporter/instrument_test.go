@@ -0,0 +1,124 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// resultClient answers calls by their method with a success, an RPC error or an error
+type resultClient struct {
+	rpc.Client
+}
+
+func (resultClient) Call(ctx context.Context, u url.URL, req rpc.Request) (*rpc.Response, error) {
+	switch req.Method {
+	case "getMetaData":
+		return &rpc.Response{}, nil
+	case "getSettings":
+		return &rpc.Response{Error: &rpc.Error{Code: -32601, Message: "Method not found"}}, nil
+	}
+	return nil, errors.New("connection refused")
+}
+
+func TestInstrumentRPC(t *testing.T) {
+	stats := NewRPCStats()
+	c := InstrumentRPC(resultClient{}, "pdu01:443", stats)
+	for _, method := range []string{"getMetaData", "getMetaData", "getSettings", "getInlets"} {
+		c.Call(context.Background(), url.URL{Path: "/model/pdu/0"}, rpc.Request{Method: method})
+	}
+	fams := gather(t, &PrometheusCollector{RPC: stats}, nil)
+
+	requests := map[string]float64{}
+	for _, m := range fams["pdu_exporter_rpc_requests_total"].GetMetric() {
+		if a := labelValue(m, "address"); a != "pdu01:443" {
+			t.Errorf("address = %q, want pdu01:443", a)
+		}
+		requests[labelValue(m, "method")+" "+labelValue(m, "result")] = m.GetCounter().GetValue()
+	}
+	want := map[string]float64{
+		"getMetaData success":   2,
+		"getSettings rpc_error": 1,
+		"getInlets error":       1,
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+
+	counts := map[string]uint64{}
+	for _, m := range fams["pdu_exporter_rpc_request_duration_seconds"].GetMetric() {
+		h := m.GetHistogram()
+		counts[labelValue(m, "method")] = h.GetSampleCount()
+		// the fake answers right away
+		if b := h.GetBucket()[0]; b.GetCumulativeCount() != h.GetSampleCount() {
+			t.Errorf("%s: %d of %d requests in the first bucket", labelValue(m, "method"), b.GetCumulativeCount(), h.GetSampleCount())
+		}
+	}
+	if want := map[string]uint64{"getMetaData": 2, "getSettings": 1, "getInlets": 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("durations = %v, want %v", counts, want)
+	}
+}
+
+func TestCollectPollStats(t *testing.T) {
+	b := polePDU(nil)
+	c := &PrometheusCollector{Name: "pdu01"}
+	c.Labels.UseConfigName = true
+	c.Poller = NewPoller(b, 0, false)
+
+	tests := []struct {
+		name string
+		// cancel the poll so it fails
+		cancel bool
+		want   map[string]float64
+	}{
+		{
+			name:   "failed",
+			cancel: true,
+			want: map[string]float64{
+				"pdu_exporter_consecutive_errors":             1,
+				"pdu_exporter_last_success_timestamp_seconds": 0,
+				"pdu_exporter_sensors":                        0,
+				"pdu_status_pdu_active":                       0,
+			},
+		},
+		{
+			name: "successful",
+			want: map[string]float64{
+				"pdu_exporter_consecutive_errors": 0,
+				"pdu_exporter_sensors":            4,
+				"pdu_status_pdu_active":           1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancel {
+				cancel()
+			}
+			c.Poller.Poll(ctx)
+			cancel()
+
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(c)
+			fams, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]float64{}
+			for _, f := range fams {
+				if _, ok := tt.want[f.GetName()]; ok {
+					got[f.GetName()] = f.Metric[0].GetGauge().GetValue()
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stats = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// sensorRefreshFactor is the number of intervals between sensor discoveries
+const sensorRefreshFactor = 10
+
+// Snapshot of the latest poll of a PDU. PDUInfo is nil if the PDU is unreachable.
+type Snapshot struct {
+	PDUInfo  *raritan.PDUInfo
+	SNMPInfo *raritan.SNMPInfo
+	Logs     []SensorLog
+	Stats    PollStats
+}
+
+// PollStats about the poller itself
+type PollStats struct {
+	// Duration of the last poll
+	Duration time.Duration
+	// LastSuccess is the end time of the last poll without errors
+	LastSuccess time.Time
+	// ConsecutiveErrors since the last successful poll
+	ConsecutiveErrors int
+	// Sensors currently discovered
+	Sensors int
+	// Breaker state of the poller
+	Breaker BreakerState
+	// FailedItems counts the requests that failed while the rest of their bulk call succeeded
+	FailedItems map[FailedItem]uint64
+}
+
+// FailedItem is a request of a bulk call, with the labels of its sensor if it reads one
+type FailedItem struct {
+	RID    string
+	Method string
+	Type   string
+	Label  string
+	Sensor string
+}
+
+// Poller scrapes a single PDU and keeps the latest results
+type Poller struct {
+	client      raritan.Backend
+	interval    time.Duration
+	pollForSNMP bool
+
+	// sensors, discovered and breaker are only used from the polling goroutine
+	sensors    []SensorLog
+	discovered time.Time
+	breaker    *breaker
+
+	// events client, nil unless the event channel is watched
+	events *raritan.Client
+	// rediscover is set to 1 when events invalidate the sensors
+	rediscover int32
+	// wake polls right away
+	wake chan struct{}
+
+	mux      sync.RWMutex
+	snapshot Snapshot
+	stats    PollStats
+}
+
+// NewPoller returns a poller for client, interval in seconds
+func NewPoller(client raritan.Backend, interval uint, pollForSNMP bool) *Poller {
+	return &Poller{
+		client:      client,
+		interval:    time.Second * time.Duration(interval),
+		pollForSNMP: pollForSNMP,
+		breaker:     newBreaker(0, wait.Backoff{}),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// SetBreaker pauses polling after threshold consecutive failures, 0 disables it.
+// Polling resumes with a single probe after the cooldown, which grows while the
+// PDU keeps failing. Must be called before Run.
+func (p *Poller) SetBreaker(threshold int, cooldown wait.Backoff) {
+	p.breaker = newBreaker(threshold, cooldown)
+}
+
+// Run polls the PDU every interval until ctx is cancelled
+func (p *Poller) Run(ctx context.Context) {
+	address := p.client.Address()
+	var wg sync.WaitGroup
+	if p.events != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.watchEvents(ctx)
+		}()
+	}
+
+	// poll every interval, or right away when events invalidated the sensors
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			continue
+		case <-t.C:
+		case <-p.wake:
+			if !t.Stop() {
+				select {
+				case <-t.C:
+				default:
+				}
+			}
+		}
+		p.runOnce(ctx, address)
+		t.Reset(p.interval)
+	}
+	wg.Wait()
+	klog.V(1).Infof("Stopped polling %s", p.client.Address())
+}
+
+// runOnce polls unless the breaker is open and records the result
+func (p *Poller) runOnce(ctx context.Context, address string) {
+	if !p.breaker.allow(time.Now()) {
+		return
+	}
+	state := p.breaker.state
+
+	err := p.Poll(ctx)
+	// stopped while polling, the error says nothing about the PDU
+	if ctx.Err() != nil {
+		return
+	}
+	cooldown, opened := p.breaker.record(time.Now(), err)
+	p.setBreakerState(p.breaker.state)
+
+	switch {
+	case opened && state == BreakerHalfOpen:
+		klog.V(1).Infof("%s is still failing, next attempt in %s: %v", address, cooldown.Round(time.Second), err)
+	case opened:
+		klog.Warningf("Pausing polling of %s for %s after %d failures: %v", address, cooldown.Round(time.Second), p.breaker.failures, err)
+	case err != nil:
+		klog.Errorf("%s\n", err)
+	case state == BreakerHalfOpen:
+		klog.Infof("Resumed polling of %s", address)
+	}
+}
+
+// Poll scrapes the PDU once, discovering sensors first if due. A poll is
+// aborted when ctx is done or, for periodic pollers, after one interval.
+func (p *Poller) Poll(ctx context.Context) error {
+	if p.interval > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.interval)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := p.poll(ctx)
+
+	p.mux.Lock()
+	p.stats.Duration = time.Since(start)
+	p.stats.Sensors = len(p.sensors)
+	if err != nil {
+		p.stats.ConsecutiveErrors++
+	} else {
+		p.stats.ConsecutiveErrors = 0
+		p.stats.LastSuccess = time.Now()
+	}
+	p.mux.Unlock()
+	return err
+}
+
+func (p *Poller) poll(ctx context.Context) error {
+	// Check if PDU is online and refresh info
+	if err := p.client.ConnectionCheck(ctx); err != nil {
+		p.setSnapshot(Snapshot{})
+		return err
+	}
+
+	pduInfo, err := getPduInfo(ctx, p.client)
+	if err != nil {
+		p.setSnapshot(Snapshot{})
+		return err
+	}
+	snap := Snapshot{
+		PDUInfo: pduInfo,
+	}
+
+	if p.pollForSNMP {
+		snmpInfo, err := getSnmpInfo(ctx, p.client)
+		if err != nil {
+			p.setSnapshot(snap)
+			return err
+		}
+		snap.SNMPInfo = snmpInfo
+	}
+
+	// Check for new sensors, events may have invalidated them
+	rediscover := atomic.SwapInt32(&p.rediscover, 0) == 1
+	if rediscover || p.sensors == nil || time.Since(p.discovered) >= p.interval*sensorRefreshFactor {
+		sens, failed, err := getSensors(ctx, p.client, p.sensors)
+		if err != nil {
+			klog.Errorf("Error polling sensors for %s: %v\n", p.client.Address(), err)
+			if rediscover {
+				atomic.StoreInt32(&p.rediscover, 1)
+			}
+		} else {
+			p.sensors = sens
+			p.discovered = time.Now()
+			p.countFailed(failed)
+		}
+	}
+
+	logs, failed, err := pollReadings(ctx, p.client, p.sensors)
+	p.countFailed(failed)
+	snap.Logs = logs
+	p.setSnapshot(snap)
+	return err
+}
+
+// countFailed requests, labelled with their sensor if known
+func (p *Poller) countFailed(failed raritan.BulkErrors) {
+	if len(failed) == 0 {
+		return
+	}
+	klog.V(1).Infof("Skipping %d failed requests to %s: %v", len(failed), p.client.Address(), failed)
+
+	sensors := map[string]SensorLog{}
+	for _, s := range p.sensors {
+		sensors[s.Resource.RID] = s
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.stats.FailedItems == nil {
+		p.stats.FailedItems = map[FailedItem]uint64{}
+	}
+	for _, f := range failed {
+		item := FailedItem{
+			RID:    f.RID,
+			Method: f.Method,
+		}
+		if s, ok := sensors[f.RID]; ok {
+			item.Type = s.Type
+			item.Label = s.Label
+			item.Sensor = s.Sensor
+		}
+		p.stats.FailedItems[item]++
+	}
+}
+
+func (p *Poller) setBreakerState(s BreakerState) {
+	p.mux.Lock()
+	p.stats.Breaker = s
+	p.mux.Unlock()
+}
+
+// Snapshot returns the results of the latest poll
+func (p *Poller) Snapshot() Snapshot {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	s := p.snapshot
+	s.Stats = p.stats
+	s.Stats.FailedItems = make(map[FailedItem]uint64, len(p.stats.FailedItems))
+	for k, v := range p.stats.FailedItems {
+		s.Stats.FailedItems[k] = v
+	}
+	return s
+}
+
+func (p *Poller) setSnapshot(s Snapshot) {
+	p.mux.Lock()
+	p.snapshot = s
+	p.mux.Unlock()
+}
@@ -0,0 +1,425 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// replayPoller polls the interactions of the cassette at path. The connection check
+// dials the PDU, so the client points at a listener that accepts and ignores it.
+func replayPoller(t *testing.T, path string) *Poller {
+	t.Helper()
+	is, err := rpc.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rpc.NewReplayer(is)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	client := &raritan.Client{
+		RPCClient: r,
+		BaseURL:   url.URL{Scheme: "http", Host: l.Addr().String()},
+	}
+	return NewPoller(client, 0, false)
+}
+
+// testdata/stub.jsonl is recorded from raritan-stub with one single-phase inlet,
+// two outlets and one peripheral, see Recording and Replaying in the README.
+func TestPollerReplay(t *testing.T) {
+	p := replayPoller(t, "testdata/stub.jsonl")
+	// the second poll is answered by the last recorded responses
+	for i := 0; i < 2; i++ {
+		if err := p.Poll(context.Background()); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+	}
+
+	snap := p.Snapshot()
+	if snap.PDUInfo == nil || snap.PDUInfo.Nameplate.SerialNumber != "FAKESERIALNUMBER" {
+		t.Fatalf("PDUInfo = %+v, want serial number FAKESERIALNUMBER", snap.PDUInfo)
+	}
+	if len(snap.Logs) != 42 {
+		t.Errorf("got %d sensor logs, want 42", len(snap.Logs))
+	}
+	if snap.Stats.ConsecutiveErrors != 0 {
+		t.Errorf("got %d consecutive errors, want 0", snap.Stats.ConsecutiveErrors)
+	}
+
+	tests := []struct {
+		typ    string
+		label  string
+		sensor string
+		value  float64
+	}{
+		{"inlet", "I0", "voltage", 0.5107464681351914},
+		{"inlet", "I0", "activeEnergy", 0.4433471547179668},
+		{"outlet", "O0", "current", 1.379409341098047},
+		{"outlet", "O1", "outletState", 1},
+		{"ocp", "C0", "trip", 2.536205085274473},
+		{"peripheral", "Temperature 0", "temperature", 1.2125639909236336},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ+"/"+tt.label+"/"+tt.sensor, func(t *testing.T) {
+			for _, l := range snap.Logs {
+				if l.Type == tt.typ && l.Label == tt.label && l.Sensor == tt.sensor {
+					if l.Value != tt.value {
+						t.Errorf("value = %v, want %v", l.Value, tt.value)
+					}
+					return
+				}
+			}
+			t.Errorf("no reading")
+		})
+	}
+}
+
+func TestPollerReplayMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.jsonl")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := replayPoller(t, path)
+	if err := p.Poll(context.Background()); err == nil {
+		t.Fatal("poll of an empty cassette succeeded")
+	}
+	if snap := p.Snapshot(); snap.PDUInfo != nil {
+		t.Errorf("PDUInfo = %+v, want nil", snap.PDUInfo)
+	}
+}
+
+// fakeBackend is a PDU with inlets, their poles and outlets, whose sensors read
+// values. Numeric sensors are in volts unless metadata is set for them.
+type fakeBackend struct {
+	inlets      []raritan.InletInfo
+	outlets     []raritan.OutletInfo
+	peripherals []raritan.PeripheralInfo
+	values      map[string]float64
+	meta        map[string]*raritan.SensorMetadata
+	// polesErr is returned by GetInletPoles
+	polesErr error
+	// metaErr is returned by GetSensorsMetadata
+	metaErr error
+	// block readings until their context is done
+	block bool
+	// reads counts the calls of GetSensorReadings
+	reads int32
+}
+
+// numericSensor resource for rid
+func numericSensor(rid string) raritan.Resource {
+	return raritan.Resource{RID: rid, Type: "sensors.NumericSensor_4_0_3"}
+}
+
+func (b *fakeBackend) Address() string                           { return "fake" }
+func (b *fakeBackend) ConnectionCheck(ctx context.Context) error { return ctx.Err() }
+func (b *fakeBackend) GetSNMPInfo(context.Context) (*raritan.SNMPInfo, error) {
+	return &raritan.SNMPInfo{}, nil
+}
+
+func (b *fakeBackend) GetPDUInfo(ctx context.Context) (*raritan.PDUInfo, error) {
+	info := &raritan.PDUInfo{}
+	info.Name = "fake"
+	return info, ctx.Err()
+}
+
+func (b *fakeBackend) GetPDUInlets(context.Context) ([]raritan.Resource, error) {
+	res := make([]raritan.Resource, len(b.inlets))
+	for i, in := range b.inlets {
+		res[i] = in.Resource
+	}
+	return res, nil
+}
+
+func (b *fakeBackend) GetInletsInfo(_ context.Context, ins []raritan.Resource) ([]raritan.InletInfo, error) {
+	info := make([]raritan.InletInfo, len(b.inlets))
+	for i, in := range b.inlets {
+		in.Poles = nil
+		info[i] = in
+	}
+	return info, nil
+}
+
+func (b *fakeBackend) GetInletPoles(_ context.Context, ins []raritan.Resource) ([][]raritan.InletPole, error) {
+	if b.polesErr != nil {
+		return nil, b.polesErr
+	}
+	poles := make([][]raritan.InletPole, len(b.inlets))
+	for i, in := range b.inlets {
+		poles[i] = in.Poles
+	}
+	return poles, nil
+}
+
+func (b *fakeBackend) GetPDUOutlets(context.Context) ([]raritan.Resource, error) {
+	res := make([]raritan.Resource, len(b.outlets))
+	for i, o := range b.outlets {
+		res[i] = o.Resource
+	}
+	return res, nil
+}
+
+func (b *fakeBackend) GetOutletsInfo(context.Context, []raritan.Resource) ([]raritan.OutletInfo, error) {
+	return b.outlets, nil
+}
+
+func (b *fakeBackend) GetPDUOCP(context.Context) ([]raritan.Resource, error) { return nil, nil }
+func (b *fakeBackend) GetOCPInfo(context.Context, []raritan.Resource) ([]raritan.OCPInfo, error) {
+	return nil, nil
+}
+func (b *fakeBackend) GetPDUPeripheralSlots(context.Context) ([]raritan.Resource, error) {
+	res := make([]raritan.Resource, len(b.peripherals))
+	for i, p := range b.peripherals {
+		res[i] = p.Resource
+	}
+	return res, nil
+}
+
+func (b *fakeBackend) GetPeripheralsInfo(context.Context, []raritan.Resource) ([]raritan.PeripheralInfo, error) {
+	return b.peripherals, nil
+}
+
+func (b *fakeBackend) GetSensorReadings(ctx context.Context, sens []raritan.Resource) ([]raritan.Reading, error) {
+	atomic.AddInt32(&b.reads, 1)
+	if b.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	rs := make([]raritan.Reading, len(sens))
+	for i, s := range sens {
+		v, ok := b.values[s.RID]
+		rs[i] = raritan.Reading{Timestamp: 100, Available: ok, Value: v}
+	}
+	return rs, nil
+}
+
+func (b *fakeBackend) GetSensorsMetadata(_ context.Context, sens []raritan.Resource) ([]*raritan.SensorMetadata, error) {
+	if b.metaErr != nil {
+		return nil, b.metaErr
+	}
+	meta := make([]*raritan.SensorMetadata, len(sens))
+	for i, s := range sens {
+		meta[i] = b.meta[s.RID]
+		if meta[i] == nil {
+			meta[i] = &raritan.SensorMetadata{Type: raritan.SensorTypeSpec{Type: 1, Unit: raritan.UnitVolt}}
+		}
+	}
+	return meta, nil
+}
+
+func (b *fakeBackend) GetSensorsThresholds(_ context.Context, sens []raritan.Resource) ([]*raritan.SensorThresholds, error) {
+	return make([]*raritan.SensorThresholds, len(sens)), nil
+}
+
+// polePDU has a three-phase inlet whose poles read their current, reading errors
+// of the poles in polesErr
+func polePDU(polesErr error) *fakeBackend {
+	in := raritan.InletInfo{
+		Resource: raritan.Resource{RID: "/model/inlet/0"},
+		Sensors:  raritan.Sensors{"voltage": numericSensor("/inlet/0/voltage")},
+	}
+	in.Label = "I1"
+	values := map[string]float64{"/inlet/0/voltage": 230}
+	for line, label := range []string{"L1", "L2", "L3"} {
+		rid := "/inlet/0/pole/" + label + "/current"
+		in.Poles = append(in.Poles, raritan.InletPole{
+			Label:   label,
+			Line:    line,
+			NodeID:  line,
+			Sensors: raritan.Sensors{"current": numericSensor(rid)},
+		})
+		values[rid] = float64(line + 1)
+	}
+	return &fakeBackend{
+		inlets:   []raritan.InletInfo{in},
+		values:   values,
+		polesErr: polesErr,
+	}
+}
+
+func TestPollerInletPoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		polesErr error
+		// want readings by type, label and the line and pole labels
+		want map[string]float64
+	}{
+		{
+			name: "poles",
+			want: map[string]float64{
+				"inlet I1 ":           230,
+				"inlet_pole I1 L1/L1": 1,
+				"inlet_pole I1 L2/L2": 2,
+				"inlet_pole I1 L3/L3": 3,
+			},
+		},
+		{
+			name:     "inlets without poles",
+			polesErr: errors.New("method not found"),
+			want:     map[string]float64{"inlet I1 ": 230},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPoller(polePDU(tt.polesErr), 0, false)
+			if err := p.Poll(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]float64{}
+			for _, l := range p.Snapshot().Logs {
+				pole := ""
+				if l.Labels != nil {
+					pole = l.Labels["line"] + "/" + l.Labels["pole"]
+				}
+				got[l.Type+" "+l.Label+" "+pole] = l.Value
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readings = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollerPollCancelled(t *testing.T) {
+	b := polePDU(nil)
+	b.block = true
+	p := NewPoller(b, 0, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- p.Poll(ctx)
+	}()
+	for atomic.LoadInt32(&b.reads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll did not return after its context was cancelled")
+	}
+	if stats := p.Snapshot().Stats; stats.ConsecutiveErrors != 1 {
+		t.Errorf("got %d consecutive errors, want 1", stats.ConsecutiveErrors)
+	}
+}
+
+func TestPollerRunStops(t *testing.T) {
+	tests := []struct {
+		name  string
+		block bool
+	}{
+		{name: "between polls"},
+		{name: "while polling", block: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := polePDU(nil)
+			b.block = tt.block
+			// polls once right away, then after an hour
+			p := NewPoller(b, 3600, false)
+			p.SetBreaker(1, wait.Backoff{Duration: time.Hour, Steps: 1})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				p.Run(ctx)
+			}()
+			for atomic.LoadInt32(&b.reads) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run did not return after its context was cancelled")
+			}
+			// a poll stopped by the context says nothing about the PDU
+			if state := p.Snapshot().Stats.Breaker; state != BreakerClosed {
+				t.Errorf("breaker = %s, want closed", state)
+			}
+		})
+	}
+}
+
+func TestPollerMetadataFallback(t *testing.T) {
+	temperature := raritan.PeripheralInfo{Resource: raritan.Resource{RID: "/model/peripheraldeviceslot/0"}}
+	temperature.Name = "Rack Front"
+	temperature.DeviceID.Type = raritan.SensorTypeSpec{Type: 8, Unit: raritan.UnitDegreeCelsius}
+	device := numericSensor("/tfwopaque/temperature")
+	temperature.Device = &device
+
+	tests := []struct {
+		name string
+		// metaErr of the first and the second discovery
+		metaErrs [2]error
+		// want the units of the metadata by sensor after the second discovery, -1 without
+		want map[string]int
+	}{
+		{
+			name: "read",
+			want: map[string]int{"voltage": raritan.UnitVolt, "temperature": raritan.UnitVolt},
+		},
+		{
+			name:     "kept from the previous discovery",
+			metaErrs: [2]error{nil, errors.New("timeout")},
+			want:     map[string]int{"voltage": raritan.UnitVolt, "temperature": raritan.UnitVolt},
+		},
+		{
+			name:     "peripherals fall back to their device type",
+			metaErrs: [2]error{errors.New("timeout"), errors.New("timeout")},
+			want:     map[string]int{"voltage": -1, "temperature": raritan.UnitDegreeCelsius},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := polePDU(nil)
+			b.inlets[0].Poles = nil
+			b.peripherals = []raritan.PeripheralInfo{temperature}
+			b.values[device.RID] = 23.5
+			p := NewPoller(b, 0, false)
+			for _, err := range tt.metaErrs {
+				b.metaErr = err
+				atomic.StoreInt32(&p.rediscover, 1)
+				if err := p.Poll(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := map[string]int{}
+			for _, l := range p.Snapshot().Logs {
+				got[l.Sensor] = -1
+				if l.Metadata != nil {
+					got[l.Sensor] = l.Metadata.Type.Unit
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("units = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
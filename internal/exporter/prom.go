@@ -2,11 +2,11 @@ package exporter
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
 )
 
 const (
@@ -14,101 +14,263 @@ const (
 )
 
 type PrometheusCollector struct {
-	Name     string
-	PDUInfo  *raritan.PDUInfo
-	SNMPINfo *raritan.SNMPInfo
-	Labels   struct {
+	Name   string
+	Poller *Poller
+	// LegacyNames exports every reading as a gauge with the raw value and no unit suffix
+	LegacyNames bool
+	Labels      struct {
 		UseConfigName   bool
 		SerialNumber    bool
 		SNMPSysContact  bool
 		SNMPSysName     bool
 		SNMPSydLocation bool
 	}
-	logs        []SensorLog
+	// TargetLabels are added to every metric of the PDU, names must not be in ReservedLabels
+	TargetLabels map[string]string
+	// RPC stats of the clients of the PDU, nil if it is not polled with JSON-RPC
+	RPC         *RPCStats
 	mux         sync.RWMutex
 	metricNames func(string) string
+	stats       *statsDescs
+	rpc         *rpcDescs
 }
 
-func (c *PrometheusCollector) SetLogs(logs []SensorLog) {
-	c.mux.Lock()
-	c.logs = logs
-	c.mux.Unlock()
+// ReservedLabels are set by the collector and cannot be target labels
+var ReservedLabels = []string{
+	"pdu_name", "pdu_serial_number", "snmp_sys_name", "snmp_sys_location", "snmp_sys_contact",
+	"type", "sensor", "label", "line", "pole", "position", "chain", "serial", "channel", "level", "rid", "method",
+	"address", "result",
 }
 
-func (c *PrometheusCollector) SetPduInfo(pduInfo *raritan.PDUInfo) {
+func (c *PrometheusCollector) Describe(desc chan<- *prometheus.Desc) {}
+
+func (c *PrometheusCollector) Collect(metric chan<- prometheus.Metric) {
+	snap := c.Poller.Snapshot()
+
 	c.mux.Lock()
-	c.PDUInfo = pduInfo
-	if !c.Labels.UseConfigName && c.PDUInfo != nil {
-		c.Name = c.PDUInfo.Name
+	if c.metricNames == nil {
+		c.metricNames = snakeCase()
+	}
+	if c.stats == nil {
+		c.stats = newStatsDescs(c.TargetLabels)
+		c.rpc = newRPCDescs(c.TargetLabels)
 	}
+	// PDUs without a config name, e.g. discovered ones, use the name of the PDU
+	if (!c.Labels.UseConfigName || c.Name == "") && snap.PDUInfo != nil {
+		c.Name = snap.PDUInfo.Name
+	}
+	name := c.Name
+	metricNames := c.metricNames
+	stats := c.stats
+	rpcDescs := c.rpc
 	c.mux.Unlock()
-}
 
-func (c *PrometheusCollector) SetSnmpInfo(snmpInfo *raritan.SNMPInfo) {
-	c.mux.Lock()
-	c.SNMPINfo = snmpInfo
-	c.mux.Unlock()
-}
+	stats.collect(metric, name, snap.Stats)
+	if c.RPC != nil {
+		rpcDescs.collect(metric, name, c.RPC)
+	}
 
-func (c *PrometheusCollector) Describe(desc chan<- *prometheus.Desc) {}
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "status", "pdu_active"),
+		"PDU status",
+		[]string{"pdu_name"},
+		c.TargetLabels,
+	)
+	if snap.PDUInfo == nil {
+		metric <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(0), name)
+		return
+	}
+	metric <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(1), name)
 
-func (c *PrometheusCollector) Collect(metric chan<- prometheus.Metric) {
-	if c.metricNames == nil {
-		c.metricNames = snakeCase()
+	labels := prometheus.Labels{}
+	for k, v := range c.TargetLabels {
+		labels[k] = v
+	}
+	labels["pdu_name"] = name
+	if c.Labels.SerialNumber {
+		labels["pdu_serial_number"] = snap.PDUInfo.Nameplate.SerialNumber
+	}
+	if snap.SNMPInfo != nil && c.Labels.SNMPSysName {
+		labels["snmp_sys_name"] = snap.SNMPInfo.SysName
+	}
+	if snap.SNMPInfo != nil && c.Labels.SNMPSydLocation {
+		labels["snmp_sys_location"] = snap.SNMPInfo.SysLocation
+	}
+	if snap.SNMPInfo != nil && c.Labels.SNMPSysContact {
+		labels["snmp_sys_contact"] = snap.SNMPInfo.SysContact
 	}
 
-	if c.PDUInfo != nil {
-		desc := prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "status", "pdu_active"),
-			"PDU status",
-			[]string{"pdu_name"},
-			nil,
+	for _, l := range snap.Logs {
+		help := fmt.Sprintf("%s sensor reading for %s", strings.ReplaceAll(l.Type, "_", " "), l.Sensor)
+		fqName := prometheus.BuildFQName(namespace, strings.ToLower(l.Type), metricNames(l.Sensor))
+		valueType, value := prometheus.GaugeValue, l.Value
+		if !c.LegacyNames {
+			var suffix string
+			var ok bool
+			suffix, valueType, value, ok = sensorValue(l)
+			if !ok {
+				continue
+			}
+			if suffix != "" {
+				fqName += "_" + suffix
+			}
+		}
+		labelNames, labelValues := sensorLabels(l)
+		metric <- prometheus.NewMetricWithTimestamp(l.Time,
+			prometheus.MustNewConstMetric(
+				prometheus.NewDesc(fqName, help, labelNames, labels),
+				valueType, value, labelValues...,
+			),
 		)
-		metric <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(1), c.Name)
 
-		labels := prometheus.Labels{}
-		if !c.Labels.UseConfigName {
-			labels["pdu_name"] = c.PDUInfo.Name
-		} else {
-			labels["pdu_name"] = c.Name
-		}
-		if c.Labels.SerialNumber {
-			labels["pdu_serial_number"] = c.PDUInfo.Nameplate.SerialNumber
-		}
-		if c.SNMPINfo != nil && c.Labels.SNMPSysName {
-			labels["snmp_sys_name"] = c.SNMPINfo.SysName
+		if t := l.Thresholds; t != nil && (t.UpperCriticalActive || t.UpperWarningActive || t.LowerWarningActive || t.LowerCriticalActive) {
+			c.collectThresholds(metric, l, metricNames(l.Sensor), labels)
 		}
-		if c.SNMPINfo != nil && c.Labels.SNMPSydLocation {
-			labels["snmp_sys_location"] = c.SNMPINfo.SysLocation
+	}
+}
+
+// Alarm states of a numeric sensor
+const (
+	alarmNormal = iota
+	alarmWarning
+	alarmCritical
+)
+
+// collectThresholds exports the active thresholds and the alarm state of a sensor
+func (c *PrometheusCollector) collectThresholds(metric chan<- prometheus.Metric, l SensorLog, sensor string, labels prometheus.Labels) {
+	labelNames, labelValues := sensorLabels(l)
+	labelNames = append([]string{"type", "sensor"}, labelNames...)
+	labelValues = append([]string{strings.ToLower(l.Type), sensor}, labelValues...)
+
+	thresholdDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "sensor", "threshold"),
+		"Threshold configured on the PDU for a sensor, in the same unit as the sensor metric",
+		append([]string{"level"}, labelNames...),
+		labels,
+	)
+	t := l.Thresholds
+	for _, th := range []struct {
+		level  string
+		active bool
+		value  float64
+	}{
+		{"upper_critical", t.UpperCriticalActive, t.UpperCritical},
+		{"upper_warning", t.UpperWarningActive, t.UpperWarning},
+		{"lower_warning", t.LowerWarningActive, t.LowerWarning},
+		{"lower_critical", t.LowerCriticalActive, t.LowerCritical},
+	} {
+		if !th.active {
+			continue
 		}
-		if c.SNMPINfo != nil && c.Labels.SNMPSysContact {
-			labels["snmp_sys_contact"] = c.SNMPINfo.SysContact
+		v := th.value
+		if !c.LegacyNames {
+			_, _, v = convertValue(l.Metadata, v)
 		}
+		metric <- prometheus.MustNewConstMetric(thresholdDesc, prometheus.GaugeValue, v, append([]string{th.level}, labelValues...)...)
+	}
 
-		c.mux.RLock()
-		defer c.mux.RUnlock()
-		for _, l := range c.logs {
-			help := fmt.Sprintf("%s sensor reading for %s", l.Type, l.Sensor)
-			fqName := prometheus.BuildFQName(namespace, strings.ToLower(l.Type), c.metricNames(l.Sensor))
-			metric <- prometheus.NewMetricWithTimestamp(l.Time,
-				prometheus.MustNewConstMetric(
-					prometheus.NewDesc(fqName, help, []string{"label"}, labels),
-					prometheus.GaugeValue, l.Value, l.Label,
-				),
-			)
-		}
-	} else {
-		desc := prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "status", "pdu_active"),
-			"PDU status",
+	state := alarmNormal
+	if l.Status.AboveUpperCritical || l.Status.BelowLowerCritical {
+		state = alarmCritical
+	} else if l.Status.AboveUpperWarning || l.Status.BelowLowerWarning {
+		state = alarmWarning
+	}
+	alarmDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "sensor", "alarm_state"),
+		"Alarm state of a sensor reading against its thresholds: 0 normal, 1 warning, 2 critical",
+		labelNames,
+		labels,
+	)
+	metric <- prometheus.NewMetricWithTimestamp(l.Time,
+		prometheus.MustNewConstMetric(alarmDesc, prometheus.GaugeValue, float64(state), labelValues...),
+	)
+}
+
+// statsDescs of the poller stats of a PDU
+type statsDescs struct {
+	scrapeDuration    *prometheus.Desc
+	lastSuccess       *prometheus.Desc
+	consecutiveErrors *prometheus.Desc
+	sensors           *prometheus.Desc
+	breakerState      *prometheus.Desc
+	failedItems       *prometheus.Desc
+}
+
+func newStatsDescs(targetLabels map[string]string) *statsDescs {
+	return &statsDescs{
+		scrapeDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, selfSubsystem, "scrape_duration_seconds"),
+			"Duration of the last poll of the PDU",
 			[]string{"pdu_name"},
-			nil,
-		)
-		metric <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(0), c.Name)
+			targetLabels,
+		),
+		lastSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, selfSubsystem, "last_success_timestamp_seconds"),
+			"Unix time of the last successful poll of the PDU, 0 if never successful",
+			[]string{"pdu_name"},
+			targetLabels,
+		),
+		consecutiveErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, selfSubsystem, "consecutive_errors"),
+			"Number of failed polls of the PDU since the last successful one",
+			[]string{"pdu_name"},
+			targetLabels,
+		),
+		sensors: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, selfSubsystem, "sensors"),
+			"Number of discovered sensors on the PDU",
+			[]string{"pdu_name"},
+			targetLabels,
+		),
+		breakerState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, selfSubsystem, "circuit_breaker_state"),
+			"State of the circuit breaker of the PDU poller, 0 closed, 1 open (polling paused), 2 half-open",
+			[]string{"pdu_name"},
+			targetLabels,
+		),
+		failedItems: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, selfSubsystem, "failed_items_total"),
+			"Number of requests of bulk calls that failed while the others succeeded, with the labels of the sensor if the request was for one",
+			[]string{"pdu_name", "rid", "method", "type", "label", "sensor"},
+			targetLabels,
+		),
 	}
+}
 
+func (d *statsDescs) collect(metric chan<- prometheus.Metric, name string, s PollStats) {
+	lastSuccess := float64(0)
+	if !s.LastSuccess.IsZero() {
+		lastSuccess = float64(s.LastSuccess.UnixNano()) / 1e9
+	}
+	metric <- prometheus.MustNewConstMetric(d.scrapeDuration, prometheus.GaugeValue, s.Duration.Seconds(), name)
+	metric <- prometheus.MustNewConstMetric(d.lastSuccess, prometheus.GaugeValue, lastSuccess, name)
+	metric <- prometheus.MustNewConstMetric(d.consecutiveErrors, prometheus.GaugeValue, float64(s.ConsecutiveErrors), name)
+	metric <- prometheus.MustNewConstMetric(d.sensors, prometheus.GaugeValue, float64(s.Sensors), name)
+	metric <- prometheus.MustNewConstMetric(d.breakerState, prometheus.GaugeValue, float64(s.Breaker), name)
+	for item, n := range s.FailedItems {
+		metric <- prometheus.MustNewConstMetric(d.failedItems, prometheus.CounterValue, float64(n),
+			name, item.RID, item.Method, strings.ToLower(item.Type), item.Label, item.Sensor)
+	}
+}
+
+// sensorLabels returns the variable label names and values for a sensor log
+func sensorLabels(l SensorLog) ([]string, []string) {
+	names := []string{"label"}
+	for k := range l.Labels {
+		names = append(names, k)
+	}
+	sort.Strings(names[1:])
+
+	values := make([]string, len(names))
+	values[0] = l.Label
+	for i, k := range names[1:] {
+		values[i+1] = l.Labels[k]
+	}
+	return names, values
 }
 
 func (c *PrometheusCollector) Match(patterns []string) bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
 	return matchAnyFilter(c.Name, patterns)
 }
@@ -0,0 +1,207 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+// gather the metrics of a collector for a PDU whose latest poll read logs, by family name
+func gather(t *testing.T, c *PrometheusCollector, logs []SensorLog) map[string]*dto.MetricFamily {
+	t.Helper()
+	c.Name = "pdu01"
+	c.Labels.UseConfigName = true
+	c.Poller = NewPoller(nil, 0, false)
+	c.Poller.setSnapshot(Snapshot{
+		PDUInfo: &raritan.PDUInfo{},
+		Logs:    logs,
+	})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	fams, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, f := range fams {
+		byName[f.GetName()] = f
+	}
+	return byName
+}
+
+// labelValue of the metric, empty if it has no such label
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestCollectPeripheralChannels(t *testing.T) {
+	logs := []SensorLog{}
+	for channel := 0; channel < 2; channel++ {
+		p := raritan.PeripheralInfo{}
+		p.DeviceID.Serial = "AEI1"
+		p.DeviceID.Channel = channel
+		p.Position = []raritan.PeripheralPosition{{PortType: raritan.PortTypeDevicePort, Port: "1"}}
+		logs = append(logs, SensorLog{
+			Type:   "peripheral",
+			Label:  "AEI1",
+			Sensor: "temperature",
+			Time:   time.Now(),
+			Value:  float64(20 + channel),
+			Labels: peripheralLabels(p),
+		})
+	}
+
+	fam := gather(t, &PrometheusCollector{LegacyNames: true}, logs)["pdu_peripheral_temperature"]
+	if fam == nil || len(fam.Metric) != 2 {
+		t.Fatalf("got %v, want a series per channel", fam)
+	}
+	got := map[string]float64{}
+	for _, m := range fam.Metric {
+		got[labelValue(m, "channel")] = m.GetGauge().GetValue()
+	}
+	if want := map[string]float64{"0": 20, "1": 21}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values by channel = %v, want %v", got, want)
+	}
+}
+
+func TestCollectInletPoles(t *testing.T) {
+	meta := &raritan.SensorMetadata{Decdigits: 1}
+	meta.Type.Unit = raritan.UnitAmpere
+	logs := []SensorLog{{
+		Type:     "inlet_pole",
+		Label:    "I1",
+		Sensor:   "current",
+		Time:     time.Now(),
+		Value:    4.2,
+		Labels:   map[string]string{"line": "L2", "pole": "L2"},
+		Metadata: meta,
+		Thresholds: &raritan.SensorThresholds{
+			UpperCriticalActive: true,
+			UpperCritical:       16,
+		},
+	}}
+	fams := gather(t, &PrometheusCollector{}, logs)
+
+	tests := []struct {
+		family string
+		labels map[string]string
+		value  float64
+	}{
+		{"pdu_inlet_pole_current_amperes", map[string]string{"label": "I1", "line": "L2", "pole": "L2"}, 4.2},
+		{"pdu_sensor_threshold", map[string]string{"type": "inlet_pole", "sensor": "current", "level": "upper_critical", "line": "L2"}, 16},
+		{"pdu_sensor_alarm_state", map[string]string{"type": "inlet_pole", "sensor": "current", "pole": "L2"}, alarmNormal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.family, func(t *testing.T) {
+			fam := fams[tt.family]
+			if fam == nil || len(fam.Metric) != 1 {
+				t.Fatalf("got %v, want one series", fam)
+			}
+			m := fam.Metric[0]
+			for k, v := range tt.labels {
+				if labelValue(m, k) != v {
+					t.Errorf("%s = %q, want %q", k, labelValue(m, k), v)
+				}
+			}
+			if v := m.GetGauge().GetValue(); v != tt.value {
+				t.Errorf("value = %v, want %v", v, tt.value)
+			}
+		})
+	}
+}
+
+func TestCollectThresholds(t *testing.T) {
+	percent := &raritan.SensorMetadata{Decdigits: 0}
+	percent.Type.Unit = raritan.UnitPercent
+	tests := []struct {
+		name       string
+		thresholds *raritan.SensorThresholds
+		status     raritan.ReadingStatus
+		// want thresholds by level, nil if no thresholds or alarm state are exported
+		want  map[string]float64
+		alarm float64
+	}{
+		{
+			name: "no thresholds",
+		},
+		{
+			name:       "inactive thresholds",
+			thresholds: &raritan.SensorThresholds{UpperCritical: 90, UpperWarning: 80},
+		},
+		{
+			name:       "normal",
+			thresholds: &raritan.SensorThresholds{UpperCriticalActive: true, UpperCritical: 90, UpperWarning: 80},
+			want:       map[string]float64{"upper_critical": 0.9},
+			alarm:      alarmNormal,
+		},
+		{
+			name: "warning",
+			thresholds: &raritan.SensorThresholds{
+				UpperCriticalActive: true, UpperCritical: 90,
+				UpperWarningActive: true, UpperWarning: 80,
+				LowerWarningActive: true, LowerWarning: 20,
+				LowerCriticalActive: true, LowerCritical: 10,
+			},
+			status: raritan.ReadingStatus{AboveUpperWarning: true},
+			want:   map[string]float64{"upper_critical": 0.9, "upper_warning": 0.8, "lower_warning": 0.2, "lower_critical": 0.1},
+			alarm:  alarmWarning,
+		},
+		{
+			name:       "critical beats warning",
+			thresholds: &raritan.SensorThresholds{LowerCriticalActive: true, LowerCritical: 10},
+			status:     raritan.ReadingStatus{BelowLowerWarning: true, BelowLowerCritical: true},
+			want:       map[string]float64{"lower_critical": 0.1},
+			alarm:      alarmCritical,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := []SensorLog{{
+				Type:       "inlet",
+				Label:      "I1",
+				Sensor:     "unbalancedCurrent",
+				Time:       time.Now(),
+				Value:      85,
+				Metadata:   percent,
+				Thresholds: tt.thresholds,
+				Status:     tt.status,
+			}}
+			fams := gather(t, &PrometheusCollector{}, logs)
+
+			if v := fams["pdu_inlet_unbalanced_current_ratio"].GetMetric()[0].GetGauge().GetValue(); v != 0.85 {
+				t.Errorf("reading = %v, want 0.85", v)
+			}
+			if tt.want == nil {
+				if fams["pdu_sensor_threshold"] != nil || fams["pdu_sensor_alarm_state"] != nil {
+					t.Errorf("got thresholds %v, alarm state %v, want none", fams["pdu_sensor_threshold"], fams["pdu_sensor_alarm_state"])
+				}
+				return
+			}
+
+			got := map[string]float64{}
+			for _, m := range fams["pdu_sensor_threshold"].GetMetric() {
+				if s := labelValue(m, "sensor"); s != "unbalanced_current" {
+					t.Errorf("sensor = %q, want unbalanced_current", s)
+				}
+				got[labelValue(m, "level")] = m.GetGauge().GetValue()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("thresholds = %v, want %v", got, tt.want)
+			}
+			alarm := fams["pdu_sensor_alarm_state"].GetMetric()
+			if len(alarm) != 1 || alarm[0].GetGauge().GetValue() != tt.alarm {
+				t.Errorf("alarm state = %v, want %v", alarm, tt.alarm)
+			}
+		})
+	}
+}
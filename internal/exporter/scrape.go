@@ -2,11 +2,14 @@ package exporter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
 
@@ -17,99 +20,77 @@ type SensorLog struct {
 	Time     time.Time
 	Value    float64
 	Resource raritan.Resource
+	// Parent is the inlet, outlet, overcurrent protector or peripheral slot of the sensor
+	Parent string
+	// Labels are additional metric labels for the sensor
+	Labels map[string]string
+	// Metadata for numeric sensors, nil if not available
+	Metadata *raritan.SensorMetadata
+	// Thresholds for numeric sensors, nil if not available
+	Thresholds *raritan.SensorThresholds
+	// Status of the last reading
+	Status raritan.ReadingStatus
 }
 
 func (l SensorLog) String() string {
 	return fmt.Sprintf("%s: %s, sensor: %s, val: %f, unix: %d", l.Type, l.Label, l.Sensor, l.Value, l.Time.Unix())
 }
 
-func Run(ctx context.Context, client raritan.Client, interval uint, pollForSNMP bool) (<-chan []SensorLog, <-chan *raritan.PDUInfo, <-chan *raritan.SNMPInfo, error) {
-	sc := make(chan []SensorLog, 1)
-
-	// poll sensors every 10 x interval
-	go wait.UntilWithContext(ctx, func(ctx context.Context) {
-		if err := pollSensors(client, sc); err != nil {
-			klog.Errorf("Error polling sensors for %s\n", client.BaseURL.String())
-			sc <- nil
-		}
-	}, time.Second*time.Duration(interval*10))
-
-	// sensor length might change over time - best guess here
-	// consumer should outpace the polling anyway
-	log := make(chan []SensorLog)
-	cPduInfo := make(chan *raritan.PDUInfo)
-	cSnmpInfo := make(chan *raritan.SNMPInfo)
-	sens := <-sc
-
-	go wait.UntilWithContext(ctx, func(_ context.Context) {
-		// Check if PDU is online and refresh info
-		if err := client.ConnectionCheck(); err != nil {
-			klog.Errorf("%s\n", err)
-			return
-		}
-
-		pduInfo, err := getPduInfo(client)
-		cPduInfo <- pduInfo
-		if err != nil {
-			klog.Errorf("%s\n", err)
-			return
-		}
-
-		if pollForSNMP {
-			snmpInfo, err := getSnmpInfo(client)
-			cSnmpInfo <- snmpInfo
-			if err != nil {
-				klog.Errorf("%s\n", err)
-				return
-			}
-		}
-
-		// Check for new sensors
-		select {
-		case sens = <-sc:
-		default:
-		}
-
-		logs, err := pollReadings(client, sens)
-		if err != nil {
-			klog.Errorf("%s", err)
-		}
-		log <- logs
-	}, time.Second*time.Duration(interval))
-
-	return log, cPduInfo, cSnmpInfo, nil
+// collectFailed adds the failed requests of partial results to failed, other errors are returned
+func collectFailed(err error, failed *raritan.BulkErrors) error {
+	var be raritan.BulkErrors
+	if errors.As(err, &be) {
+		*failed = append(*failed, be...)
+		return nil
+	}
+	return err
 }
 
-func getSensorInfo(client raritan.Client) ([]raritan.InletInfo, []raritan.OutletInfo, []raritan.OCPInfo, error) {
-	ins, err := client.GetPDUInlets()
+func getSensorInfo(ctx context.Context, client raritan.Backend, failed *raritan.BulkErrors) ([]raritan.InletInfo, []raritan.OutletInfo, []raritan.OCPInfo, error) {
+	ins, err := client.GetPDUInlets(ctx)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("error requesting PDU Inlets: %w", err)
 	}
 
-	insInfo, err := client.GetInletsInfo(ins)
-	if err != nil {
+	insInfo, err := client.GetInletsInfo(ctx, ins)
+	if err := collectFailed(err, failed); err != nil {
 		return nil, nil, nil, fmt.Errorf("error getting Inlet info: %w", err)
 	}
 
+	// not every inlet supports poles, so failures here are not fatal
+	poles, err := client.GetInletPoles(ctx, ins)
+	if err := collectFailed(err, failed); err != nil {
+		klog.Warningf("Skipping inlet poles for %s: %v", client.Address(), err)
+	} else {
+		// inlets with failed requests are missing from insInfo
+		byRID := map[string][]raritan.InletPole{}
+		for i, in := range ins {
+			byRID[in.RID] = poles[i]
+		}
+		for i := range insInfo {
+			insInfo[i].Poles = byRID[insInfo[i].RID]
+		}
+	}
+
 	klog.V(1).Infof("PDU Inlets: %+v", insInfo)
 
-	ols, err := client.GetPDUOutlets()
+	ols, err := client.GetPDUOutlets(ctx)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("error requesting PDU Outlets: %w", err)
 	}
 
-	olsInfo, err := client.GetOutletsInfo(ols)
-	if err != nil {
+	olsInfo, err := client.GetOutletsInfo(ctx, ols)
+	if err := collectFailed(err, failed); err != nil {
 		return nil, nil, nil, fmt.Errorf("error getting Outlet info: %w", err)
 	}
 
-	ocp, err := client.GetPDUOCP()
+	ocp, err := client.GetPDUOCP(ctx)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("error requesting PDU OverCurrentProtectors: %w", err)
 	}
 
-	ocpInfo, err := client.GetOCPInfo(ocp)
-	if err != nil {
+	ocpInfo, err := client.GetOCPInfo(ctx, ocp)
+	if err := collectFailed(err, failed); err != nil {
 		return nil, nil, nil, fmt.Errorf("error getting OverCurrentProtectors info: %w", err)
 	}
 
@@ -117,25 +98,103 @@ func getSensorInfo(client raritan.Client) ([]raritan.InletInfo, []raritan.Outlet
 	return insInfo, olsInfo, ocpInfo, nil
 }
 
-func getSensors(client raritan.Client) ([]SensorLog, error) {
-	iis, ois, ocp, err := getSensorInfo(client)
+func getPeripheralInfo(ctx context.Context, client raritan.Backend, failed *raritan.BulkErrors) ([]raritan.PeripheralInfo, error) {
+	slots, err := client.GetPDUPeripheralSlots(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error requesting PDU peripheral device slots: %w", err)
+	}
+
+	info, err := client.GetPeripheralsInfo(ctx, slots)
+	if err := collectFailed(err, failed); err != nil {
+		return nil, fmt.Errorf("error getting peripheral device info: %w", err)
+	}
+
+	klog.V(1).Infof("PDU peripheral devices: %+v", info)
+	return info, nil
+}
+
+// peripheralLabels returns position, chain, serial and channel labels for a device. The channel
+// tells apart the sensors of one device with several, which have the same serial and position.
+func peripheralLabels(p raritan.PeripheralInfo) map[string]string {
+	position := []string{}
+	chain := ""
+	for _, pos := range p.Position {
+		switch pos.PortType {
+		case raritan.PortTypeOnboard:
+			position = append(position, "onboard")
+		case raritan.PortTypeDevicePort:
+			position = append(position, "port"+pos.Port)
+		case raritan.PortTypeHubPort:
+			position = append(position, "hub"+pos.Port)
+		case raritan.PortTypeDaisyChain:
+			chain = pos.Port
+		}
+	}
+	return map[string]string{
+		"position": strings.Join(position, "/"),
+		"chain":    chain,
+		"serial":   p.DeviceID.Serial,
+		"channel":  strconv.Itoa(p.DeviceID.Channel),
+	}
+}
+
+// unknownSensorTypes that have been logged, each is logged once per process
+var unknownSensorTypes sync.Map
+
+// knownSensors filters out sensors of types without a registered kind
+func knownSensors(sens []SensorLog) []SensorLog {
+	known := make([]SensorLog, 0, len(sens))
+	for _, s := range sens {
+		if raritan.IsKnownSensor(s.Resource) {
+			known = append(known, s)
+			continue
+		}
+		if _, logged := unknownSensorTypes.LoadOrStore(s.Resource.Type, true); !logged {
+			klog.Warningf("Skipping sensors of unknown type %s, e.g. %s", s.Resource.Type, s.Resource.RID)
+		}
+	}
+	return known
+}
+
+// getSensors discovers the sensors of the PDU. Resources with failed requests are
+// skipped and returned in failed. Sensors whose metadata cannot be read keep the
+// metadata of their previous discovery in prev.
+func getSensors(ctx context.Context, client raritan.Backend, prev []SensorLog) ([]SensorLog, raritan.BulkErrors, error) {
+	failed := raritan.BulkErrors{}
+	iis, ois, ocp, err := getSensorInfo(ctx, client, &failed)
+	if err != nil {
+		return nil, nil, err
 	}
 	sens := []SensorLog{}
 	for _, i := range iis {
+		label := i.Label
+		if i.Name != "" {
+			label = i.Name
+		}
 		for k, v := range i.Sensors {
-			label := i.Label
-			if i.Name != "" {
-				label = i.Name
-			}
 			sens = append(sens, SensorLog{
 				Resource: v,
 				Sensor:   k,
 				Type:     "inlet",
 				Label:    label,
+				Parent:   i.RID,
 			})
 		}
+		for _, p := range i.Poles {
+			for k, v := range p.Sensors {
+				sens = append(sens, SensorLog{
+					Resource: v,
+					Sensor:   k,
+					Type:     "inlet_pole",
+					Label:    label,
+					Parent:   i.RID,
+					Labels: map[string]string{
+						"line": p.LineName(),
+						"pole": p.Label,
+					},
+				})
+			}
+		}
 	}
 	for _, o := range ois {
 		for k, v := range o.Sensors {
@@ -148,6 +207,7 @@ func getSensors(client raritan.Client) ([]SensorLog, error) {
 				Sensor:   k,
 				Type:     "outlet",
 				Label:    label,
+				Parent:   o.RID,
 			})
 		}
 	}
@@ -162,33 +222,85 @@ func getSensors(client raritan.Client) ([]SensorLog, error) {
 				Sensor:   k,
 				Type:     "ocp",
 				Label:    label,
+				Parent:   o.RID,
 			})
 		}
 	}
-	return sens, nil
-}
 
-func pollSensors(client raritan.Client, sl chan<- []SensorLog) error {
-	sensors, err := getSensors(client)
+	// not every PDU has a peripheral device manager, so failures here are not fatal
+	pis, err := getPeripheralInfo(ctx, client, &failed)
 	if err != nil {
-		return err
+		klog.Warningf("Skipping peripheral devices for %s: %v", client.Address(), err)
+	}
+	// the device type has the unit of peripheral sensors, without the digits of the metadata
+	fallback := map[string]*raritan.SensorMetadata{}
+	for _, s := range prev {
+		if s.Metadata != nil {
+			fallback[s.Resource.RID] = s.Metadata
+		}
 	}
-	sl <- sensors
-	return nil
+	for _, p := range pis {
+		if _, ok := fallback[p.Device.RID]; !ok {
+			fallback[p.Device.RID] = &raritan.SensorMetadata{Type: p.DeviceID.Type, Decdigits: -1}
+		}
+		label := p.Name
+		if label == "" {
+			label = p.DeviceID.Serial
+		}
+		sens = append(sens, SensorLog{
+			Resource: *p.Device,
+			Sensor:   raritan.SensorTypeName(p.DeviceID.Type.Type),
+			Type:     "peripheral",
+			Label:    label,
+			Parent:   p.RID,
+			Labels:   peripheralLabels(p),
+		})
+	}
+
+	sens = knownSensors(sens)
+
+	res := make([]raritan.Resource, len(sens))
+	for i, s := range sens {
+		res[i] = s.Resource
+	}
+	meta, err := client.GetSensorsMetadata(ctx, res)
+	if err := collectFailed(err, &failed); err != nil {
+		klog.Warningf("Skipping sensor metadata for %s: %v", client.Address(), err)
+		meta = nil
+	}
+	for i := range sens {
+		if meta != nil && meta[i] != nil {
+			sens[i].Metadata = meta[i]
+		} else {
+			sens[i].Metadata = fallback[sens[i].Resource.RID]
+		}
+	}
+
+	thresholds, err := client.GetSensorsThresholds(ctx, res)
+	if err := collectFailed(err, &failed); err != nil {
+		klog.Warningf("Skipping sensor thresholds for %s: %v", client.Address(), err)
+	} else {
+		for i := range sens {
+			sens[i].Thresholds = thresholds[i]
+		}
+	}
+	return sens, failed, nil
 }
 
-func pollReadings(client raritan.Client, sens []SensorLog) ([]SensorLog, error) {
+// pollReadings of the sensors, failed readings are skipped and returned in failed
+func pollReadings(ctx context.Context, client raritan.Backend, sens []SensorLog) ([]SensorLog, raritan.BulkErrors, error) {
 	if sens == nil {
-		return nil, fmt.Errorf("no sensors available for %s", client.BaseURL.String())
+		return nil, nil, fmt.Errorf("no sensors available for %s", client.Address())
 	}
 	res := make([]raritan.Resource, len(sens))
 	for i, s := range sens {
 		res[i] = s.Resource
 	}
 
-	rs, err := client.GetSensorReadings(res)
-	if err != nil {
-		return nil, fmt.Errorf("error getting sensor data: %w", err)
+	failed := raritan.BulkErrors{}
+	rs, err := client.GetSensorReadings(ctx, res)
+	if err := collectFailed(err, &failed); err != nil {
+		return nil, nil, fmt.Errorf("error getting sensor data: %w", err)
 	}
 	logs := []SensorLog{}
 	for i, r := range rs {
@@ -200,24 +312,25 @@ func pollReadings(client raritan.Client, sens []SensorLog) ([]SensorLog, error)
 
 		s.Time = time.Unix(int64(r.Timestamp), 0)
 		s.Value = r.Value
+		s.Status = r.Status
 		logs = append(logs, s)
 	}
-	return logs, nil
+	return logs, failed, nil
 }
 
-func getPduInfo(client raritan.Client) (*raritan.PDUInfo, error) {
-	pduInfo, err := client.GetPDUInfo()
+func getPduInfo(ctx context.Context, client raritan.Backend) (*raritan.PDUInfo, error) {
+	pduInfo, err := client.GetPDUInfo(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get PDU info from %s: %s", client.BaseURL.String(), err)
+		return nil, fmt.Errorf("failed to get PDU info from %s: %s", client.Address(), err)
 	}
 
 	return pduInfo, nil
 }
 
-func getSnmpInfo(client raritan.Client) (*raritan.SNMPInfo, error) {
-	snmpInfo, err := client.GetSNMPInfo()
+func getSnmpInfo(ctx context.Context, client raritan.Backend) (*raritan.SNMPInfo, error) {
+	snmpInfo, err := client.GetSNMPInfo(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get SNMP info from %s: %s", client.BaseURL.String(), err)
+		return nil, fmt.Errorf("failed to get SNMP info from %s: %s", client.Address(), err)
 	}
 
 	return snmpInfo, nil
@@ -0,0 +1,53 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+func TestPeripheralLabels(t *testing.T) {
+	pos := func(portType int, port string) raritan.PeripheralPosition {
+		return raritan.PeripheralPosition{PortType: portType, Port: port}
+	}
+	tests := []struct {
+		name     string
+		position []raritan.PeripheralPosition
+		channel  int
+		want     map[string]string
+	}{
+		{
+			name:     "onboard",
+			position: []raritan.PeripheralPosition{pos(raritan.PortTypeOnboard, "")},
+			want:     map[string]string{"position": "onboard", "chain": "", "serial": "AEI1", "channel": "0"},
+		},
+		{
+			name:     "behind a hub",
+			position: []raritan.PeripheralPosition{pos(raritan.PortTypeDevicePort, "1"), pos(raritan.PortTypeHubPort, "2")},
+			want:     map[string]string{"position": "port1/hub2", "chain": "", "serial": "AEI1", "channel": "0"},
+		},
+		{
+			name:     "daisy chained",
+			position: []raritan.PeripheralPosition{pos(raritan.PortTypeDaisyChain, "2"), pos(raritan.PortTypeDevicePort, "1")},
+			want:     map[string]string{"position": "port1", "chain": "2", "serial": "AEI1", "channel": "0"},
+		},
+		{
+			name:     "second channel",
+			position: []raritan.PeripheralPosition{pos(raritan.PortTypeDevicePort, "1")},
+			channel:  1,
+			want:     map[string]string{"position": "port1", "chain": "", "serial": "AEI1", "channel": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := raritan.PeripheralInfo{}
+			p.DeviceID.Serial = "AEI1"
+			p.DeviceID.Channel = tt.channel
+			p.Position = tt.position
+			if got := peripheralLabels(p); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("labels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
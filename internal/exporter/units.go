@@ -0,0 +1,85 @@
+package exporter
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+// unit describes how a Raritan sensor unit is exported in Prometheus base units
+type unit struct {
+	// suffix appended to the metric name
+	suffix string
+	// scale converts the reading to the base unit
+	scale float64
+	// counter for cumulative readings
+	counter bool
+}
+
+var units = map[int]unit{
+	raritan.UnitVolt:                {"volts", 1, false},
+	raritan.UnitAmpere:              {"amperes", 1, false},
+	raritan.UnitWatt:                {"watts", 1, false},
+	raritan.UnitVoltAmp:             {"volt_amperes", 1, false},
+	raritan.UnitWattHour:            {"joules", 3600, true},
+	raritan.UnitVoltAmpHour:         {"volt_ampere_seconds", 3600, true},
+	raritan.UnitDegreeCelsius:       {"celsius", 1, false},
+	raritan.UnitHz:                  {"hertz", 1, false},
+	raritan.UnitPercent:             {"ratio", 0.01, false},
+	raritan.UnitMeterPerSec:         {"meters_per_second", 1, false},
+	raritan.UnitPascal:              {"pascals", 1, false},
+	raritan.UnitMeter:               {"meters", 1, false},
+	raritan.UnitHour:                {"seconds", 3600, false},
+	raritan.UnitMinute:              {"seconds", 60, false},
+	raritan.UnitSecond:              {"seconds", 1, false},
+	raritan.UnitVoltAmpReactive:     {"volt_amperes_reactive", 1, false},
+	raritan.UnitVoltAmpReactiveHour: {"volt_ampere_reactive_seconds", 3600, true},
+	raritan.UnitGramPerCubicMeter:   {"grams_per_cubic_meter", 1, false},
+	raritan.UnitDegree:              {"degrees", 1, false},
+}
+
+// sensorValue returns the metric name suffix, value type and value in base units for a reading.
+// Sensors with an unknown unit are exported as is. Numeric sensors without metadata are not
+// exported, false is returned for them, as their unit and so their metric name is unknown.
+func sensorValue(l SensorLog) (string, prometheus.ValueType, float64, bool) {
+	if l.Metadata == nil {
+		if k, _ := raritan.LookupSensorKind(l.Resource.Type); k.Numeric {
+			return "", prometheus.GaugeValue, 0, false
+		}
+	}
+	suffix, valueType, v := convertValue(l.Metadata, l.Value)
+	return suffix, valueType, v, true
+}
+
+// convertValue converts v as read from a sensor with metadata meta to base units
+func convertValue(meta *raritan.SensorMetadata, v float64) (string, prometheus.ValueType, float64) {
+	if meta == nil {
+		return "", prometheus.GaugeValue, v
+	}
+
+	u, ok := units[meta.Type.Unit]
+	if !ok {
+		return "", prometheus.GaugeValue, round(v, meta.Decdigits)
+	}
+
+	// scaling down needs more digits to keep the precision of the reading
+	digits := meta.Decdigits
+	if u.scale < 1 {
+		digits += int(math.Round(-math.Log10(u.scale)))
+	}
+	v = round(v*u.scale, digits)
+	if u.counter {
+		return u.suffix + "_total", prometheus.CounterValue, v
+	}
+	return u.suffix, prometheus.GaugeValue, v
+}
+
+// round to the given number of decimal digits, negative digits leave v unchanged
+func round(v float64, digits int) float64 {
+	if digits < 0 {
+		return v
+	}
+	p := math.Pow(10, float64(digits))
+	return math.Round(v*p) / p
+}
@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+func TestConvertValue(t *testing.T) {
+	meta := func(unit, digits int) *raritan.SensorMetadata {
+		m := &raritan.SensorMetadata{Decdigits: digits}
+		m.Type.Unit = unit
+		return m
+	}
+	tests := []struct {
+		name      string
+		meta      *raritan.SensorMetadata
+		value     float64
+		suffix    string
+		valueType prometheus.ValueType
+		want      float64
+	}{
+		{"no metadata", nil, 1.23456, "", prometheus.GaugeValue, 1.23456},
+		{"unknown unit rounded", meta(raritan.UnitNone, 2), 1.23456, "", prometheus.GaugeValue, 1.23},
+		{"volts", meta(raritan.UnitVolt, 1), 230.04, "volts", prometheus.GaugeValue, 230},
+		{"amperes", meta(raritan.UnitAmpere, 3), 4.2, "amperes", prometheus.GaugeValue, 4.2},
+		{"watt hours to joules", meta(raritan.UnitWattHour, 0), 1000, "joules_total", prometheus.CounterValue, 3600000},
+		{"volt ampere hours to seconds", meta(raritan.UnitVoltAmpHour, 1), 0.5, "volt_ampere_seconds_total", prometheus.CounterValue, 1800},
+		{"reactive hours to seconds", meta(raritan.UnitVoltAmpReactiveHour, 0), 2, "volt_ampere_reactive_seconds_total", prometheus.CounterValue, 7200},
+		// scaling down keeps the digits of the reading
+		{"percent to ratio", meta(raritan.UnitPercent, 1), 45.5, "ratio", prometheus.GaugeValue, 0.455},
+		{"percent without digits", meta(raritan.UnitPercent, 0), 37, "ratio", prometheus.GaugeValue, 0.37},
+		{"hours to seconds", meta(raritan.UnitHour, 0), 2, "seconds", prometheus.GaugeValue, 7200},
+		{"minutes to seconds", meta(raritan.UnitMinute, 0), 3, "seconds", prometheus.GaugeValue, 180},
+		{"celsius", meta(raritan.UnitDegreeCelsius, 1), 23.45, "celsius", prometheus.GaugeValue, 23.5},
+		{"negative digits unrounded", meta(raritan.UnitWatt, -1), 1.23456, "watts", prometheus.GaugeValue, 1.23456},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suffix, valueType, v := convertValue(tt.meta, tt.value)
+			if suffix != tt.suffix || valueType != tt.valueType || v != tt.want {
+				t.Errorf("convertValue = %q, %v, %v, want %q, %v, %v", suffix, valueType, v, tt.suffix, tt.valueType, tt.want)
+			}
+		})
+	}
+}
+
+func TestSensorValue(t *testing.T) {
+	meta := &raritan.SensorMetadata{Decdigits: 1}
+	meta.Type.Unit = raritan.UnitVolt
+	tests := []struct {
+		name     string
+		resType  string
+		meta     *raritan.SensorMetadata
+		suffix   string
+		exported bool
+	}{
+		{"numeric", "sensors.NumericSensor_4_0_3", meta, "volts", true},
+		// its name would lose the unit suffix until the metadata is read again
+		{"numeric without metadata", "sensors.NumericSensor_4_0_3", nil, "", false},
+		{"state without metadata", "sensors.StateSensor_4_0_3", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := SensorLog{Resource: raritan.Resource{Type: tt.resType}, Metadata: tt.meta, Value: 230}
+			suffix, _, _, exported := sensorValue(l)
+			if suffix != tt.suffix || exported != tt.exported {
+				t.Errorf("sensorValue = %q, %v, want %q, %v", suffix, exported, tt.suffix, tt.exported)
+			}
+		})
+	}
+}
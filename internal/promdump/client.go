@@ -0,0 +1,420 @@
+// Package promdump reads Raritan PDUs from the Prometheus dump of firmware 4.0.10 and later,
+// as an alternative to JSON-RPC
+package promdump
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Client reads a PDU from its dump. ConnectionCheck fetches the dump, the other methods read
+// the one fetched last, so a poll reads a single consistent dump.
+type Client struct {
+	BaseURL url.URL
+	// Retry of failed requests, Steps is the number of retries. Only connection
+	// errors and server errors are retried.
+	Retry wait.Backoff
+
+	httpClient *http.Client
+	auth       rpc.Auth
+
+	mux  sync.Mutex
+	dump *dump
+}
+
+var _ raritan.Backend = &Client{}
+
+// NewClient for the PDU at baseURL, tlsConfig nil verifies with the system roots
+func NewClient(baseURL url.URL, timeout time.Duration, auth rpc.Auth, tlsConfig *tls.Config) *Client {
+	return &Client{
+		BaseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		auth: auth,
+	}
+}
+
+// Address of the dump
+func (c *Client) Address() string {
+	u := c.BaseURL
+	u.Path = Path
+	return u.String()
+}
+
+// fetch and parse the dump, non 2xx responses return an rpc.StatusError
+func (c *Client) fetch(ctx context.Context) (*dump, error) {
+	r, err := http.NewRequestWithContext(ctx, "GET", c.Address(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating dump request: %w", err)
+	}
+	r.SetBasicAuth(c.auth.Username, c.auth.Password)
+
+	res, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error performing request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, rpc.StatusError{
+			StatusCode: res.StatusCode,
+			Status:     res.Status,
+		}
+	}
+	return parse(res.Body, time.Now())
+}
+
+// retryFetch with retries
+func (c *Client) retryFetch(ctx context.Context) (*dump, error) {
+	backoff := c.Retry
+	for retry := 0; ; retry++ {
+		d, err := c.fetch(ctx)
+		if err == nil || retry >= c.Retry.Steps || !retryable(ctx, err) {
+			return d, err
+		}
+
+		t := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
+
+// retryable errors are transport errors and server errors, unless ctx is done.
+// Dumps that cannot be parsed are not.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se rpc.StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500
+	}
+	var ue *url.Error
+	var ne net.Error
+	return errors.As(err, &ue) || errors.As(err, &ne)
+}
+
+// ConnectionCheck fetches the dump read by the other methods
+func (c *Client) ConnectionCheck(ctx context.Context) error {
+	d, err := c.retryFetch(ctx)
+	if err != nil {
+		return err
+	}
+	c.mux.Lock()
+	c.dump = d
+	c.mux.Unlock()
+	return nil
+}
+
+// current dump, fetched if there is none yet
+func (c *Client) current(ctx context.Context) (*dump, error) {
+	c.mux.Lock()
+	d := c.dump
+	c.mux.Unlock()
+	if d != nil {
+		return d, nil
+	}
+	if err := c.ConnectionCheck(ctx); err != nil {
+		return nil, err
+	}
+	return c.current(ctx)
+}
+
+// missing resource of the dump, reported like a failed request of a bulk call
+func missing(rid string) raritan.BulkItemError {
+	return raritan.BulkItemError{
+		RID:    rid,
+		Method: "dump",
+		Err:    errors.New("not in dump"),
+	}
+}
+
+// GetPDUInfo returns the nameplate and name of the PDU
+func (c *Client) GetPDUInfo(ctx context.Context) (*raritan.PDUInfo, error) {
+	d, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info := *d.info
+	return &info, nil
+}
+
+// GetSNMPInfo returns empty settings, the dump has none
+func (c *Client) GetSNMPInfo(ctx context.Context) (*raritan.SNMPInfo, error) {
+	return &raritan.SNMPInfo{}, nil
+}
+
+// list the components of a kind, ordered by label
+func (c *Client) list(ctx context.Context, comp component) ([]raritan.Resource, error) {
+	d, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]raritan.Resource, len(d.nodes[comp.kind]))
+	for i, n := range d.nodes[comp.kind] {
+		res[i] = n.Resource
+	}
+	return res, nil
+}
+
+// info of the components, missing ones are skipped with BulkErrors returned for them
+func (c *Client) info(ctx context.Context, res []raritan.Resource) ([]*node, error) {
+	d, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ns := make([]*node, 0, len(res))
+	failed := raritan.BulkErrors{}
+	for _, r := range res {
+		n, ok := d.byRID[r.RID]
+		if !ok {
+			failed = append(failed, missing(r.RID))
+			continue
+		}
+		ns = append(ns, n)
+	}
+	if len(failed) > 0 {
+		return ns, failed
+	}
+	return ns, nil
+}
+
+// GetPDUInlets returns the inlets of the PDU
+func (c *Client) GetPDUInlets(ctx context.Context) ([]raritan.Resource, error) {
+	return c.list(ctx, inlets)
+}
+
+// GetInletsInfo returns info for the inlets, missing ones are skipped with BulkErrors returned for them
+func (c *Client) GetInletsInfo(ctx context.Context, ins []raritan.Resource) ([]raritan.InletInfo, error) {
+	ns, err := c.info(ctx, ins)
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+	ret := make([]raritan.InletInfo, len(ns))
+	for i, n := range ns {
+		ret[i] = raritan.InletInfo{
+			Resource: n.Resource,
+			InletMetadata: raritan.InletMetadata{
+				Label: n.label,
+			},
+			InletSettings: raritan.InletSettings{
+				Name: n.name,
+			},
+			Sensors: n.sensors,
+		}
+	}
+	return ret, err
+}
+
+// GetInletPoles returns the poles for each inlet, in the same order as ins. Poles of
+// missing inlets are nil, with BulkErrors returned for them.
+func (c *Client) GetInletPoles(ctx context.Context, ins []raritan.Resource) ([][]raritan.InletPole, error) {
+	d, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	poles := make([][]raritan.InletPole, len(ins))
+	failed := raritan.BulkErrors{}
+	for i, in := range ins {
+		n, ok := d.byRID[in.RID]
+		if !ok {
+			failed = append(failed, missing(in.RID))
+			continue
+		}
+		poles[i] = n.poles
+	}
+	if len(failed) > 0 {
+		return poles, failed
+	}
+	return poles, nil
+}
+
+// GetPDUOutlets returns the outlets of the PDU
+func (c *Client) GetPDUOutlets(ctx context.Context) ([]raritan.Resource, error) {
+	return c.list(ctx, outlets)
+}
+
+// GetOutletsInfo returns info for the outlets, missing ones are skipped with BulkErrors returned for them.
+// The power state is read from the outlet state sensor, outlets without one are not available.
+func (c *Client) GetOutletsInfo(ctx context.Context, os []raritan.Resource) ([]raritan.OutletInfo, error) {
+	d, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := c.info(ctx, os)
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+	ret := make([]raritan.OutletInfo, len(ns))
+	for i, n := range ns {
+		var state raritan.Reading
+		if s, ok := n.sensors["outletState"]; ok {
+			state = d.sensors[s.RID].reading
+		}
+		ret[i] = raritan.OutletInfo{
+			Resource: n.Resource,
+			OutletMetadata: raritan.OutletMetadata{
+				Label: n.label,
+			},
+			OutletSettings: raritan.OutletSettings{
+				Name: n.name,
+			},
+			OutletState: raritan.OutletState{
+				Available:  state.Available,
+				PowerState: uint(state.Value),
+			},
+			Sensors: n.sensors,
+		}
+	}
+	return ret, err
+}
+
+// GetPDUOCP returns the overcurrent protectors of the PDU
+func (c *Client) GetPDUOCP(ctx context.Context) ([]raritan.Resource, error) {
+	return c.list(ctx, ocps)
+}
+
+// GetOCPInfo returns info for the overcurrent protectors, missing ones are skipped with BulkErrors returned for them
+func (c *Client) GetOCPInfo(ctx context.Context, res []raritan.Resource) ([]raritan.OCPInfo, error) {
+	ns, err := c.info(ctx, res)
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+	ret := make([]raritan.OCPInfo, len(ns))
+	for i, n := range ns {
+		ret[i] = raritan.OCPInfo{
+			Resource: n.Resource,
+			OCPMetadata: raritan.OCPMetadata{
+				Label: n.label,
+			},
+			OCPSettings: raritan.OCPSettings{
+				Name: n.name,
+			},
+			Sensors: n.sensors,
+		}
+	}
+	return ret, err
+}
+
+// GetPDUPeripheralSlots returns a slot per external sensor of the PDU
+func (c *Client) GetPDUPeripheralSlots(ctx context.Context) ([]raritan.Resource, error) {
+	d, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]raritan.Resource, len(d.externals))
+	for i, e := range d.externals {
+		res[i] = e.Resource
+	}
+	return res, nil
+}
+
+// GetPeripheralsInfo returns info for the external sensors, missing ones are skipped with
+// BulkErrors returned for them
+func (c *Client) GetPeripheralsInfo(ctx context.Context, slots []raritan.Resource) ([]raritan.PeripheralInfo, error) {
+	d, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos := []raritan.PeripheralInfo{}
+	failed := raritan.BulkErrors{}
+	for _, s := range slots {
+		found := false
+		for _, e := range d.externals {
+			if e.RID == s.RID {
+				infos = append(infos, e)
+				found = true
+				break
+			}
+		}
+		if !found {
+			failed = append(failed, missing(s.RID))
+		}
+	}
+	if len(failed) > 0 {
+		return infos, failed
+	}
+	return infos, nil
+}
+
+// sensors of the dump in the same order as sens, nil for missing ones with BulkErrors returned for them
+func (c *Client) sensors(ctx context.Context, sens []raritan.Resource) ([]*sensor, error) {
+	d, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ss := make([]*sensor, len(sens))
+	failed := raritan.BulkErrors{}
+	for i, r := range sens {
+		s, ok := d.sensors[r.RID]
+		if !ok {
+			failed = append(failed, missing(r.RID))
+			continue
+		}
+		ss[i] = s
+	}
+	if len(failed) > 0 {
+		return ss, failed
+	}
+	return ss, nil
+}
+
+// GetSensorReadings returns readings in the same order as sens. Missing sensors are
+// not available, with BulkErrors returned for them.
+func (c *Client) GetSensorReadings(ctx context.Context, sens []raritan.Resource) ([]raritan.Reading, error) {
+	ss, err := c.sensors(ctx, sens)
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+	rs := make([]raritan.Reading, len(sens))
+	for i, s := range ss {
+		if s != nil {
+			rs[i] = s.reading
+		}
+	}
+	return rs, err
+}
+
+// GetSensorsMetadata returns the type and unit of numeric sensors in the same order as sens,
+// nil for other sensors. Missing sensors are nil too, with BulkErrors returned for them.
+func (c *Client) GetSensorsMetadata(ctx context.Context, sens []raritan.Resource) ([]*raritan.SensorMetadata, error) {
+	ss, err := c.sensors(ctx, sens)
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+	ms := make([]*raritan.SensorMetadata, len(sens))
+	for i, s := range ss {
+		if s == nil || s.spec.Readingtype != 0 {
+			continue
+		}
+		ms[i] = &raritan.SensorMetadata{
+			Type:      s.spec,
+			Decdigits: decimalDigits,
+		}
+	}
+	return ms, err
+}
+
+// GetSensorsThresholds returns nil for all sensors, the dump has no thresholds
+func (c *Client) GetSensorsThresholds(ctx context.Context, sens []raritan.Resource) ([]*raritan.SensorThresholds, error) {
+	return make([]*raritan.SensorThresholds, len(sens)), nil
+}
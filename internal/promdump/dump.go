@@ -0,0 +1,420 @@
+package promdump
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/strcase"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"k8s.io/klog/v2"
+)
+
+// Resource types of the resources returned by the client, sensors are typed by their
+// JSON-RPC interface so the registered sensor kinds apply
+const (
+	ResourceInlet         = "promdump.Inlet"
+	ResourceOCP           = "promdump.OverCurrentProtector"
+	ResourceOutlet        = "promdump.Outlet"
+	ResourceExternal      = "promdump.ExternalSensor"
+	ResourceNumericSensor = "sensors.NumericSensor"
+	ResourceStateSensor   = "sensors.StateSensor"
+)
+
+// decimalDigits of readings, which the dump already rounds to the precision of the sensor.
+// Only limits the float noise of scaling them to base units.
+const decimalDigits = 6
+
+// component families of the dump. Resource ids are "<kind>/<label>" for components,
+// "<kind>/<label>/<sensor>" for their sensors and "inlet/<label>/pole/<pole>/<sensor>"
+// for those of inlet poles.
+type component struct {
+	kind    string
+	prefix  string
+	resType string
+}
+
+var (
+	inlets  = component{kind: "inlet", prefix: InletPrefix, resType: ResourceInlet}
+	ocps    = component{kind: "ocp", prefix: OCPPrefix, resType: ResourceOCP}
+	outlets = component{kind: "outlet", prefix: OutletPrefix, resType: ResourceOutlet}
+	// components other than inlet poles, whose families are matched first
+	components = []component{ocps, outlets, inlets}
+
+	// sensorNames by their snake case
+	sensorNames = map[string]string{}
+	// sensorTypes of external sensors by snake case name
+	sensorTypes = map[string]int{}
+	// unitSuffixes by length, longest first
+	unitSuffixes = []int{}
+)
+
+func init() {
+	for n := range Sensors {
+		sensorNames[strcase.ToSnake(n)] = n
+	}
+	for t := 1; raritan.SensorTypeName(t) != raritan.SensorTypeName(0); t++ {
+		sensorTypes[strcase.ToSnake(raritan.SensorTypeName(t))] = t
+	}
+	for u := range Units {
+		unitSuffixes = append(unitSuffixes, u)
+	}
+	sort.Slice(unitSuffixes, func(i, j int) bool {
+		return len(Units[unitSuffixes[i]]) > len(Units[unitSuffixes[j]])
+	})
+}
+
+// node of an inlet, over current protector or outlet in the dump
+type node struct {
+	raritan.Resource
+	label   string
+	name    string
+	sensors raritan.Sensors
+	poles   []raritan.InletPole
+}
+
+// sensor in the dump
+type sensor struct {
+	spec    raritan.SensorTypeSpec
+	reading raritan.Reading
+}
+
+// dump of a PDU, parsed from the exposition format
+type dump struct {
+	info *raritan.PDUInfo
+	// nodes by kind, ordered by label, and by resource id
+	nodes     map[string][]*node
+	byRID     map[string]*node
+	externals []raritan.PeripheralInfo
+	// sensors by resource id
+	sensors map[string]*sensor
+	// skipped families, ordered by name
+	skipped []string
+}
+
+// parseError of a dump that is not in the exposition format or lacks the PDU info
+type parseError struct {
+	err error
+}
+
+func (e parseError) Error() string {
+	return e.err.Error()
+}
+
+func (e parseError) Unwrap() error {
+	return e.err
+}
+
+// parse a dump, samples without a timestamp are taken at now. Dumps that cannot be
+// read return a parseError.
+func parse(r io.Reader, now time.Time) (*dump, error) {
+	var p expfmt.TextParser
+	fams, err := p.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, parseError{fmt.Errorf("Error parsing dump: %w", err)}
+	}
+
+	d := &dump{
+		nodes:   map[string][]*node{},
+		byRID:   map[string]*node{},
+		sensors: map[string]*sensor{},
+	}
+	externals := map[int]raritan.PeripheralInfo{}
+	for name, fam := range fams {
+		switch {
+		case name == FamilyInfo:
+			if len(fam.Metric) > 0 {
+				d.info = pduInfo(labels(fam.Metric[0]))
+			}
+		case strings.HasPrefix(name, PolePrefix):
+			sens, unit := sensorUnit(name[len(PolePrefix):])
+			for _, m := range fam.Metric {
+				d.addPoleSensor(sens, unit, m, now)
+			}
+		case strings.HasPrefix(name, ExternalPrefix):
+			typeName, unit := sensorUnit(name[len(ExternalPrefix):])
+			t, ok := sensorTypes[typeName]
+			if !ok {
+				klog.V(1).Infof("Skipping external sensors of unknown type in %s", name)
+				d.skipped = append(d.skipped, name)
+				continue
+			}
+			for _, m := range fam.Metric {
+				d.addExternal(externals, t, unit, m, now)
+			}
+		default:
+			if c, ok := componentOf(name); ok {
+				sens, unit := sensorUnit(name[len(c.prefix):])
+				for _, m := range fam.Metric {
+					d.addSensor(c, sens, unit, m, now)
+				}
+				continue
+			}
+			klog.V(1).Infof("Skipping unknown family %s", name)
+			d.skipped = append(d.skipped, name)
+		}
+	}
+	if d.info == nil {
+		return nil, parseError{fmt.Errorf("%s missing in dump", FamilyInfo)}
+	}
+
+	sort.Strings(d.skipped)
+	for _, ns := range d.nodes {
+		sort.Slice(ns, func(i, j int) bool {
+			return lessLabel(ns[i].label, ns[j].label)
+		})
+	}
+	ids := make([]int, 0, len(externals))
+	for id := range externals {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		d.externals = append(d.externals, externals[id])
+	}
+	return d, nil
+}
+
+// componentOf a family other than those of inlet poles and external sensors
+func componentOf(family string) (component, bool) {
+	for _, c := range components {
+		if strings.HasPrefix(family, c.prefix) {
+			return c, true
+		}
+	}
+	return component{}, false
+}
+
+// sensorUnit splits the name and unit of a sensor family without prefix
+func sensorUnit(s string) (string, int) {
+	for _, u := range unitSuffixes {
+		if n := strings.TrimSuffix(s, "_"+Units[u]); n != s && n != "" {
+			return n, u
+		}
+	}
+	return s, raritan.UnitNone
+}
+
+// sensorName in the JSON-RPC API of a snake case sensor name
+func sensorName(snake string) string {
+	if n, ok := sensorNames[snake]; ok {
+		return n
+	}
+	return strcase.ToLowerCamel(snake)
+}
+
+// lessLabel orders labels such as "I2" before "I10"
+func lessLabel(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func labels(m *dto.Metric) map[string]string {
+	ls := make(map[string]string, len(m.Label))
+	for _, l := range m.Label {
+		ls[l.GetName()] = l.GetValue()
+	}
+	return ls
+}
+
+func pduInfo(ls map[string]string) *raritan.PDUInfo {
+	return &raritan.PDUInfo{
+		PDUMetadata: raritan.PDUMetadata{
+			Nameplate: raritan.PDUNameplate{
+				Manufacturer: ls[LabelInfoManufacturer],
+				Model:        ls[LabelInfoModel],
+				PartNumber:   ls[LabelInfoPartNumber],
+				SerialNumber: ls[LabelInfoSerial],
+			},
+			HwRevision: ls[LabelInfoHardware],
+			FwRevision: ls[LabelInfoFirmware],
+			MacAddress: ls[LabelInfoMAC],
+		},
+		PDUSettings: raritan.PDUSettings{
+			Name: ls[LabelInfoName],
+		},
+	}
+}
+
+// reading of a sample, NaN values are not available
+func reading(m *dto.Metric, now time.Time) raritan.Reading {
+	var v float64
+	switch {
+	case m.Gauge != nil:
+		v = m.Gauge.GetValue()
+	case m.Counter != nil:
+		v = m.Counter.GetValue()
+	case m.Untyped != nil:
+		v = m.Untyped.GetValue()
+	default:
+		v = math.NaN()
+	}
+	ts := now
+	if m.TimestampMs != nil {
+		ts = time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond))
+	}
+	if math.IsNaN(v) {
+		return raritan.Reading{Timestamp: uint(ts.Unix())}
+	}
+
+	return raritan.Reading{
+		Timestamp: uint(ts.Unix()),
+		Available: true,
+		Value:     v,
+	}
+}
+
+func sensorResource(rid string, numeric bool) raritan.Resource {
+	t := ResourceStateSensor
+	if numeric {
+		t = ResourceNumericSensor
+	}
+	return raritan.Resource{RID: rid, Type: t}
+}
+
+// node of a component by label, added if missing
+func (d *dump) node(c component, label, name string) *node {
+	rid := c.kind + "/" + label
+	if n, ok := d.byRID[rid]; ok {
+		if n.name == "" {
+			n.name = name
+		}
+		return n
+	}
+	n := &node{
+		Resource: raritan.Resource{RID: rid, Type: c.resType},
+		label:    label,
+		name:     name,
+		sensors:  raritan.Sensors{},
+	}
+	d.nodes[c.kind] = append(d.nodes[c.kind], n)
+	d.byRID[rid] = n
+	return n
+}
+
+// addSensor of a component from a sample, samples without a label are skipped
+func (d *dump) addSensor(c component, snake string, unit int, m *dto.Metric, now time.Time) {
+	ls := labels(m)
+	if ls[LabelComponent] == "" {
+		klog.V(1).Infof("Skipping %s sensor %s without %s", c.kind, snake, LabelComponent)
+		return
+	}
+	n := d.node(c, ls[LabelComponent], ls[LabelComponentName])
+	name := sensorName(snake)
+	rid := n.RID + "/" + name
+	d.add(rid, name, unit, m, now)
+	n.sensors[name] = sensorResource(rid, d.sensors[rid].spec.Readingtype == 0)
+}
+
+// addPoleSensor of an inlet pole from a sample, samples without a label or pole are skipped
+func (d *dump) addPoleSensor(snake string, unit int, m *dto.Metric, now time.Time) {
+	ls := labels(m)
+	if ls[LabelComponent] == "" || ls[LabelPole] == "" {
+		klog.V(1).Infof("Skipping pole sensor %s without %s or %s", snake, LabelComponent, LabelPole)
+		return
+	}
+	in := d.node(inlets, ls[LabelComponent], "")
+	var pole *raritan.InletPole
+	for i := range in.poles {
+		if in.poles[i].Label == ls[LabelPole] {
+			pole = &in.poles[i]
+		}
+	}
+	if pole == nil {
+		line := 0
+		for i, l := range Lines {
+			if l == ls[LabelLine] {
+				line = i
+			}
+		}
+		in.poles = append(in.poles, raritan.InletPole{
+			Label:   ls[LabelPole],
+			Line:    line,
+			NodeID:  len(in.poles),
+			Sensors: raritan.Sensors{},
+		})
+		pole = &in.poles[len(in.poles)-1]
+	}
+	name := sensorName(snake)
+	rid := in.RID + "/pole/" + pole.Label + "/" + name
+	d.add(rid, name, unit, m, now)
+	pole.Sensors[name] = sensorResource(rid, d.sensors[rid].spec.Readingtype == 0)
+}
+
+// add the sensor of a component
+func (d *dump) add(rid, name string, unit int, m *dto.Metric, now time.Time) {
+	s, ok := Sensors[name]
+	if !ok {
+		s = Sensor{Numeric: true}
+	}
+	spec := raritan.SensorTypeSpec{Type: s.Type, Unit: unit}
+	if !s.Numeric {
+		spec.Readingtype = 1
+	}
+	d.sensors[rid] = &sensor{
+		spec:    spec,
+		reading: reading(m, now),
+	}
+}
+
+// addExternal sensor from a sample, samples without a valid id are skipped
+func (d *dump) addExternal(externals map[int]raritan.PeripheralInfo, sensorType, unit int, m *dto.Metric, now time.Time) {
+	ls := labels(m)
+	id, err := strconv.Atoi(ls[LabelExternalID])
+	if err != nil {
+		klog.V(1).Infof("Skipping external sensor with invalid %s %q", LabelExternalID, ls[LabelExternalID])
+		return
+	}
+	spec := raritan.SensorTypeSpec{Type: sensorType, Unit: unit}
+	if IsDiscreteType(sensorType) {
+		spec.Readingtype = 1
+	}
+	channel, _ := strconv.Atoi(ls[LabelExternalChannel])
+	rid := "external/" + strconv.Itoa(id)
+	dev := sensorResource(rid+"/sensor", spec.Readingtype == 0)
+	d.sensors[dev.RID] = &sensor{
+		spec:    spec,
+		reading: reading(m, now),
+	}
+	externals[id] = raritan.PeripheralInfo{
+		Resource: raritan.Resource{RID: rid, Type: ResourceExternal},
+		PeripheralDevice: raritan.PeripheralDevice{
+			DeviceID: raritan.PeripheralDeviceID{
+				Serial:  ls[LabelExternalSerial],
+				Type:    spec,
+				Channel: channel,
+			},
+			Position: externalPosition(ls[LabelExternalPort]),
+			Device:   &dev,
+		},
+		PeripheralSettings: raritan.PeripheralSettings{
+			Name:        ls[LabelExternalName],
+			Description: ls[LabelExternalDescription],
+		},
+	}
+}
+
+// externalPosition from the port of an external sensor, e.g. "1" or "1-2" behind a hub
+func externalPosition(port string) []raritan.PeripheralPosition {
+	if port == "" {
+		return nil
+	}
+	parts := strings.Split(port, "-")
+	pos := make([]raritan.PeripheralPosition, len(parts))
+	for i, p := range parts {
+		pos[i] = raritan.PeripheralPosition{
+			PortType: raritan.PortTypeHubPort,
+			Port:     p,
+		}
+	}
+	pos[0].PortType = raritan.PortTypeDevicePort
+	return pos
+}
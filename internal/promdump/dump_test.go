@@ -0,0 +1,191 @@
+package promdump
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+const info = `raritan_pdu_info{name="pdu01",serial="SERIAL"} 1
+`
+
+func TestParse(t *testing.T) {
+	// parsed sensor of the dump
+	type parsed struct {
+		unit      int
+		numeric   bool
+		available bool
+		value     float64
+	}
+	tests := []struct {
+		name    string
+		dump    string
+		sensors map[string]parsed
+		skipped []string
+		err     bool
+	}{
+		{
+			name:    "info only",
+			dump:    info,
+			sensors: map[string]parsed{},
+		},
+		{
+			name: "info missing",
+			dump: `raritan_outlet_current_amperes{label="1",name=""} 1
+`,
+			err: true,
+		},
+		{
+			name: "component sensors",
+			dump: info + `raritan_inlet_voltage_volts{label="I1",name=""} 230
+raritan_outlet_active_power_watts{label="1",name="srv01"} 12.5
+raritan_outlet_outlet_state{label="1",name="srv01"} 1
+raritan_ocp_trip{label="C1",name=""} 0
+`,
+			sensors: map[string]parsed{
+				"inlet/I1/voltage":     {raritan.UnitVolt, true, true, 230},
+				"outlet/1/activePower": {raritan.UnitWatt, true, true, 12.5},
+				"outlet/1/outletState": {raritan.UnitNone, false, true, 1},
+				"ocp/C1/trip":          {raritan.UnitNone, false, true, 0},
+			},
+		},
+		{
+			name: "NaN is not available",
+			dump: info + `raritan_outlet_current_amperes{label="1",name=""} NaN
+`,
+			sensors: map[string]parsed{
+				"outlet/1/current": {raritan.UnitAmpere, true, false, 0},
+			},
+		},
+		{
+			name: "pole sensors",
+			dump: info + `raritan_inlet_pole_current_amperes{label="I1",line="L2",pole="L2"} 3.5
+raritan_inlet_pole_voltage_ln_volts{label="I1",line="L2",pole="L2"} 231
+`,
+			sensors: map[string]parsed{
+				"inlet/I1/pole/L2/current":   {raritan.UnitAmpere, true, true, 3.5},
+				"inlet/I1/pole/L2/voltageLN": {raritan.UnitVolt, true, true, 231},
+			},
+		},
+		{
+			name: "external sensors",
+			dump: info + `raritan_external_sensor_temperature_celsius{id="2",port="1-2",serial="S2",name="",description="",channel="0"} 23.5
+raritan_external_sensor_water_leak{id="3",port="1",serial="S3",name="",description="",channel="0"} 0
+`,
+			sensors: map[string]parsed{
+				"external/2/sensor": {raritan.UnitDegreeCelsius, true, true, 23.5},
+				"external/3/sensor": {raritan.UnitNone, false, true, 0},
+			},
+		},
+		{
+			name: "samples without labels are skipped",
+			dump: info + `raritan_outlet_current_amperes{name=""} 1
+raritan_inlet_pole_current_amperes{label="I1",line="L1"} 1
+raritan_external_sensor_humidity_percent{id="",port="1"} 40
+`,
+			sensors: map[string]parsed{},
+		},
+		{
+			name: "unknown families are skipped",
+			dump: info + `raritan_pdu_uptime_seconds 100
+raritan_external_sensor_flux_capacitor 1
+`,
+			sensors: map[string]parsed{},
+			skipped: []string{"raritan_external_sensor_flux_capacitor", "raritan_pdu_uptime_seconds"},
+		},
+		{
+			name: "invalid format",
+			dump: "raritan_pdu_info{name=\"pdu01\" 1\n",
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parse(strings.NewReader(tt.dump), time.Unix(100, 0))
+			if tt.err {
+				if err == nil {
+					t.Fatal("err = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]parsed, len(d.sensors))
+			for rid, s := range d.sensors {
+				if s.reading.Timestamp != 100 {
+					t.Errorf("%s: timestamp = %d, want 100", rid, s.reading.Timestamp)
+				}
+				got[rid] = parsed{s.spec.Unit, s.spec.Readingtype == 0, s.reading.Available, s.reading.Value}
+			}
+			if !reflect.DeepEqual(got, tt.sensors) {
+				t.Errorf("sensors = %v, want %v", got, tt.sensors)
+			}
+			if !reflect.DeepEqual(d.skipped, tt.skipped) {
+				t.Errorf("skipped = %q, want %q", d.skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+// TestParseTestdata parses the dumps in testdata, whose families must all be recognised
+// and whose sensors must all be known by their JSON-RPC name.
+func TestParseTestdata(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no dumps in testdata")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			d, err := parse(f, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.info.Nameplate.SerialNumber == "" {
+				t.Errorf("%s has no %s", FamilyInfo, LabelInfoSerial)
+			}
+			if len(d.skipped) > 0 {
+				t.Errorf("skipped families %q", d.skipped)
+			}
+			for kind, ns := range d.nodes {
+				for _, n := range ns {
+					if n.label == "" {
+						t.Errorf("%s without label", kind)
+					}
+					for name := range n.sensors {
+						if _, ok := Sensors[name]; !ok {
+							t.Errorf("%s: unknown sensor %s", n.RID, name)
+						}
+					}
+					for _, p := range n.poles {
+						for name := range p.Sensors {
+							if _, ok := Sensors[name]; !ok {
+								t.Errorf("%s: unknown pole sensor %s", n.RID, name)
+							}
+						}
+					}
+				}
+			}
+			for _, e := range d.externals {
+				if e.DeviceID.Serial == "" || len(e.Position) == 0 {
+					t.Errorf("%s: serial %q, position %v", e.RID, e.DeviceID.Serial, e.Position)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package promdump
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/klog/v2"
+)
+
+// MinFirmware serving the dump
+var MinFirmware = []int{4, 0, 10}
+
+// HasDump if firmware, e.g. "4.0.20.5-48035", serves the dump. Unknown firmware is assumed to.
+func HasDump(firmware string) bool {
+	parts := strings.SplitN(firmware, ".", len(MinFirmware)+1)
+	for i, want := range MinFirmware {
+		if i >= len(parts) {
+			return true
+		}
+		// build numbers and suffixes after the version
+		digits := strings.IndexFunc(parts[i], func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+		if digits >= 0 {
+			parts[i] = parts[i][:digits]
+		}
+		v, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return true
+		}
+		if v != want {
+			return v > want
+		}
+	}
+	return true
+}
+
+// Fallback reads a PDU from its dump if the firmware serves one and with JSON-RPC otherwise.
+// The firmware is detected by the first connection check reaching the PDU, until then and on
+// PDUs without a dump or with one that cannot be parsed the methods are those of RPC.
+type Fallback struct {
+	Dump *Client
+	RPC  *raritan.Client
+
+	mux     sync.Mutex
+	backend raritan.Backend
+}
+
+var _ raritan.Backend = &Fallback{}
+
+// detected backend, nil before detection
+func (f *Fallback) detected() raritan.Backend {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.backend
+}
+
+// current backend, RPC before detection
+func (f *Fallback) current() raritan.Backend {
+	if b := f.detected(); b != nil {
+		return b
+	}
+	return f.RPC
+}
+
+// detect the backend from the firmware of the PDU, falling back to JSON-RPC if the dump is not
+// found or cannot be parsed
+func (f *Fallback) detect(ctx context.Context) error {
+	if err := f.RPC.ConnectionCheck(ctx); err != nil {
+		return err
+	}
+	info, err := f.RPC.GetPDUInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	var b raritan.Backend = f.RPC
+	var se rpc.StatusError
+	var pe parseError
+	if !HasDump(info.FwRevision) {
+		klog.Infof("Polling %s with JSON-RPC, firmware %s has no dump", f.RPC.Address(), info.FwRevision)
+	} else if err := f.Dump.ConnectionCheck(ctx); errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		klog.Infof("Polling %s with JSON-RPC, %s not found", f.RPC.Address(), f.Dump.Address())
+	} else if errors.As(err, &pe) {
+		klog.Warningf("Polling %s with JSON-RPC, %s cannot be parsed: %v", f.RPC.Address(), f.Dump.Address(), err)
+	} else if err != nil {
+		return err
+	} else {
+		klog.Infof("Polling %s from %s", f.RPC.Address(), f.Dump.Address())
+		b = f.Dump
+	}
+
+	f.mux.Lock()
+	f.backend = b
+	f.mux.Unlock()
+	return nil
+}
+
+// Address of the PDU
+func (f *Fallback) Address() string {
+	return f.RPC.Address()
+}
+
+// ConnectionCheck of the detected backend, detecting it first if needed
+func (f *Fallback) ConnectionCheck(ctx context.Context) error {
+	if b := f.detected(); b != nil {
+		return b.ConnectionCheck(ctx)
+	}
+	return f.detect(ctx)
+}
+
+func (f *Fallback) GetPDUInfo(ctx context.Context) (*raritan.PDUInfo, error) {
+	return f.current().GetPDUInfo(ctx)
+}
+
+func (f *Fallback) GetSNMPInfo(ctx context.Context) (*raritan.SNMPInfo, error) {
+	return f.current().GetSNMPInfo(ctx)
+}
+
+func (f *Fallback) GetPDUInlets(ctx context.Context) ([]raritan.Resource, error) {
+	return f.current().GetPDUInlets(ctx)
+}
+
+func (f *Fallback) GetInletsInfo(ctx context.Context, ins []raritan.Resource) ([]raritan.InletInfo, error) {
+	return f.current().GetInletsInfo(ctx, ins)
+}
+
+func (f *Fallback) GetInletPoles(ctx context.Context, ins []raritan.Resource) ([][]raritan.InletPole, error) {
+	return f.current().GetInletPoles(ctx, ins)
+}
+
+func (f *Fallback) GetPDUOutlets(ctx context.Context) ([]raritan.Resource, error) {
+	return f.current().GetPDUOutlets(ctx)
+}
+
+func (f *Fallback) GetOutletsInfo(ctx context.Context, os []raritan.Resource) ([]raritan.OutletInfo, error) {
+	return f.current().GetOutletsInfo(ctx, os)
+}
+
+func (f *Fallback) GetPDUOCP(ctx context.Context) ([]raritan.Resource, error) {
+	return f.current().GetPDUOCP(ctx)
+}
+
+func (f *Fallback) GetOCPInfo(ctx context.Context, ocps []raritan.Resource) ([]raritan.OCPInfo, error) {
+	return f.current().GetOCPInfo(ctx, ocps)
+}
+
+func (f *Fallback) GetPDUPeripheralSlots(ctx context.Context) ([]raritan.Resource, error) {
+	return f.current().GetPDUPeripheralSlots(ctx)
+}
+
+func (f *Fallback) GetPeripheralsInfo(ctx context.Context, slots []raritan.Resource) ([]raritan.PeripheralInfo, error) {
+	return f.current().GetPeripheralsInfo(ctx, slots)
+}
+
+func (f *Fallback) GetSensorReadings(ctx context.Context, sens []raritan.Resource) ([]raritan.Reading, error) {
+	return f.current().GetSensorReadings(ctx, sens)
+}
+
+func (f *Fallback) GetSensorsMetadata(ctx context.Context, sens []raritan.Resource) ([]*raritan.SensorMetadata, error) {
+	return f.current().GetSensorsMetadata(ctx, sens)
+}
+
+func (f *Fallback) GetSensorsThresholds(ctx context.Context, sens []raritan.Resource) ([]*raritan.SensorThresholds, error) {
+	return f.current().GetSensorsThresholds(ctx, sens)
+}
@@ -0,0 +1,116 @@
+package promdump
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// newFallback for a PDU with firmware answering the dump with status and body.
+// dumps counts the requests of the dump.
+func newFallback(t *testing.T, firmware string, status int, body string) (f *Fallback, dumps *int32) {
+	t.Helper()
+	dumps = new(int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bulk":
+			// getMetaData and getSettings of the PDU
+			fmt.Fprintf(w, `{"result":{"responses":[`+
+				`{"json":{"result":{"_ret_":{"fwRevision":%q}}},"statcode":200},`+
+				`{"json":{"result":{"_ret_":{"name":"pdu01"}}},"statcode":200}]}}`, firmware)
+		case Path:
+			atomic.AddInt32(dumps, 1)
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Fallback{
+		Dump: NewClient(*u, 5*time.Second, rpc.Auth{}, nil),
+		RPC: &raritan.Client{
+			RPCClient: rpc.NewClient(5*time.Second, rpc.Auth{}, nil),
+			BaseURL:   *u,
+		},
+	}, dumps
+}
+
+func TestFallbackDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		firmware string
+		status   int
+		body     string
+		// dump is true if the dump is read, false for JSON-RPC
+		dump  bool
+		dumps int32
+		err   string
+	}{
+		{name: "dump", firmware: "4.0.20.5-48035", status: http.StatusOK, body: info, dump: true, dumps: 1},
+		{name: "firmware without dump", firmware: "3.6.1.5-46887", status: http.StatusOK, body: info},
+		{name: "dump not found", firmware: "4.0.20.5-48035", status: http.StatusNotFound, dumps: 1},
+		{
+			name:     "dump not in exposition format",
+			firmware: "4.0.20.5-48035",
+			status:   http.StatusOK,
+			body:     "<html><body>Login</body></html>\n",
+			dumps:    1,
+		},
+		{
+			name:     "dump without PDU info",
+			firmware: "4.0.20.5-48035",
+			status:   http.StatusOK,
+			body:     "raritan_device_info{serial=\"SERIAL\"} 1\n",
+			dumps:    1,
+		},
+		{
+			name:     "server error",
+			firmware: "4.0.20.5-48035",
+			status:   http.StatusInternalServerError,
+			dumps:    1,
+			err:      "500 Internal Server Error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, dumps := newFallback(t, tt.firmware, tt.status, tt.body)
+			err := f.ConnectionCheck(context.Background())
+			if n := atomic.LoadInt32(dumps); n != tt.dumps {
+				t.Errorf("%d dump requests, want %d", n, tt.dumps)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %s", err, tt.err)
+				}
+				if f.detected() != nil {
+					t.Error("backend detected despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want raritan.Backend = f.RPC
+			if tt.dump {
+				want = f.Dump
+			}
+			if got := f.detected(); got != want {
+				t.Errorf("backend = %T, want %T", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package promdump
+
+import (
+	"github.com/iancoleman/strcase"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+// Path of the dump on the PDU
+const Path = "/cgi-bin/dump_prometheus.cgi"
+
+// Families of the dump. Component sensors are <component prefix><sensor>[_<unit>] with the
+// JSON-RPC sensor name in snake case, e.g. raritan_inlet_active_power_watts, external sensors
+// <ExternalPrefix><sensor type>[_<unit>], e.g. raritan_external_sensor_temperature_celsius.
+const (
+	FamilyInfo     = "raritan_pdu_info"
+	InletPrefix    = "raritan_inlet_"
+	PolePrefix     = "raritan_inlet_pole_"
+	OCPPrefix      = "raritan_ocp_"
+	OutletPrefix   = "raritan_outlet_"
+	ExternalPrefix = "raritan_external_sensor_"
+)
+
+// Labels of the dump. Info labels are on FamilyInfo, component labels on all component sensors,
+// pole labels in addition on pole sensors and external labels on external sensors.
+const (
+	LabelInfoName         = "name"
+	LabelInfoManufacturer = "manufacturer"
+	LabelInfoModel        = "model"
+	LabelInfoPartNumber   = "part_number"
+	LabelInfoSerial       = "serial"
+	LabelInfoFirmware     = "firmware"
+	LabelInfoHardware     = "hardware"
+	LabelInfoMAC          = "mac"
+
+	LabelComponent     = "label"
+	LabelComponentName = "name"
+	LabelPole          = "pole"
+	LabelLine          = "line"
+
+	LabelExternalID          = "id"
+	LabelExternalSerial      = "serial"
+	LabelExternalName        = "name"
+	LabelExternalDescription = "description"
+	LabelExternalPort        = "port"
+	LabelExternalChannel     = "channel"
+)
+
+// Lines of inlet poles, by the line of the JSON-RPC API
+var Lines = []string{"L1", "L2", "L3", "N"}
+
+// Units by the unit of the JSON-RPC API, sensors without a unit have no suffix
+var Units = map[int]string{
+	raritan.UnitVolt:                "volts",
+	raritan.UnitAmpere:              "amperes",
+	raritan.UnitWatt:                "watts",
+	raritan.UnitVoltAmp:             "volt_amperes",
+	raritan.UnitWattHour:            "watt_hours",
+	raritan.UnitVoltAmpHour:         "volt_ampere_hours",
+	raritan.UnitDegreeCelsius:       "celsius",
+	raritan.UnitHz:                  "hertz",
+	raritan.UnitPercent:             "percent",
+	raritan.UnitMeterPerSec:         "meters_per_second",
+	raritan.UnitPascal:              "pascals",
+	raritan.UnitG:                   "g",
+	raritan.UnitRPM:                 "rpm",
+	raritan.UnitMeter:               "meters",
+	raritan.UnitHour:                "hours",
+	raritan.UnitMinute:              "minutes",
+	raritan.UnitSecond:              "seconds",
+	raritan.UnitVoltAmpReactive:     "volt_amperes_reactive",
+	raritan.UnitVoltAmpReactiveHour: "volt_ampere_reactive_hours",
+	raritan.UnitGramPerCubicMeter:   "grams_per_cubic_meter",
+	raritan.UnitDegree:              "degrees",
+}
+
+// Sensor of inlets, inlet poles, over current protectors and outlets
+type Sensor struct {
+	Numeric bool
+	// Type of the JSON-RPC API
+	Type int
+}
+
+// Sensors by JSON-RPC name, unknown sensors are numeric without a type
+var Sensors = map[string]Sensor{
+	"voltage":                 {Numeric: true, Type: 1},
+	"voltageLN":               {Numeric: true, Type: 1},
+	"current":                 {Numeric: true, Type: 2},
+	"peakCurrent":             {Numeric: true, Type: 2},
+	"maximumCurrent":          {Numeric: true, Type: 2},
+	"residualCurrent":         {Numeric: true, Type: 2},
+	"unbalancedCurrent":       {Numeric: true, Type: 3},
+	"activePower":             {Numeric: true, Type: 4},
+	"reactivePower":           {Numeric: true, Type: 4},
+	"apparentPower":           {Numeric: true, Type: 4},
+	"powerFactor":             {Numeric: true, Type: 5},
+	"displacementPowerFactor": {Numeric: true, Type: 5},
+	"activeEnergy":            {Numeric: true, Type: 6},
+	"apparentEnergy":          {Numeric: true, Type: 6},
+	"lineFrequency":           {Numeric: true, Type: 7},
+	"phaseAngle":              {Numeric: true},
+	"outletState":             {Type: 13},
+	"trip":                    {Type: 14},
+	"surgeProtectorStatus":    {},
+	"residualCurrentStatus":   {},
+	"powerQuality":            {},
+}
+
+// discreteTypes of external sensors, which report a state
+var discreteTypes = map[int]bool{
+	12: true, 13: true, 14: true, 15: true, 16: true, 17: true,
+}
+
+// IsDiscreteType if external sensors of the type report a state
+func IsDiscreteType(t int) bool {
+	return discreteTypes[t]
+}
+
+// SensorFamily of a component sensor
+func SensorFamily(prefix, sensor string, unit int) string {
+	return withUnit(prefix+strcase.ToSnake(sensor), unit)
+}
+
+// ExternalFamily of an external sensor
+func ExternalFamily(sensorType, unit int) string {
+	return withUnit(ExternalPrefix+strcase.ToSnake(raritan.SensorTypeName(sensorType)), unit)
+}
+
+func withUnit(name string, unit int) string {
+	if u, ok := Units[unit]; ok {
+		return name + "_" + u
+	}
+	return name
+}
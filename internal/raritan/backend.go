@@ -0,0 +1,35 @@
+package raritan
+
+import "context"
+
+// Backend discovers and reads the sensors of a PDU. Client implements it with JSON-RPC,
+// snmp.Client with SNMP and promdump.Client with the Prometheus dump. Resources are only
+// meaningful to the backend that returned them.
+type Backend interface {
+	// Address of the PDU for logging
+	Address() string
+	ConnectionCheck(ctx context.Context) error
+	GetPDUInfo(ctx context.Context) (*PDUInfo, error)
+	GetSNMPInfo(ctx context.Context) (*SNMPInfo, error)
+
+	GetPDUInlets(ctx context.Context) ([]Resource, error)
+	GetInletsInfo(ctx context.Context, ins []Resource) ([]InletInfo, error)
+	GetInletPoles(ctx context.Context, ins []Resource) ([][]InletPole, error)
+	GetPDUOutlets(ctx context.Context) ([]Resource, error)
+	GetOutletsInfo(ctx context.Context, os []Resource) ([]OutletInfo, error)
+	GetPDUOCP(ctx context.Context) ([]Resource, error)
+	GetOCPInfo(ctx context.Context, ocps []Resource) ([]OCPInfo, error)
+	GetPDUPeripheralSlots(ctx context.Context) ([]Resource, error)
+	GetPeripheralsInfo(ctx context.Context, slots []Resource) ([]PeripheralInfo, error)
+
+	GetSensorReadings(ctx context.Context, sens []Resource) ([]Reading, error)
+	GetSensorsMetadata(ctx context.Context, sens []Resource) ([]*SensorMetadata, error)
+	GetSensorsThresholds(ctx context.Context, sens []Resource) ([]*SensorThresholds, error)
+}
+
+var _ Backend = &Client{}
+
+// Address of the PDU
+func (c *Client) Address() string {
+	return c.BaseURL.String()
+}
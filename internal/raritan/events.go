@@ -0,0 +1,140 @@
+package raritan
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+var (
+	eventServicePath = mustURL("/event_service")
+)
+
+// Event types without interface versions
+const (
+	EventStateSensorStateChanged   = "sensors.StateSensor.StateChangedEvent"
+	EventNumericSensorStateChanged = "sensors.NumericSensor.StateChangedEvent"
+	EventSensorMetadataChanged     = "sensors.NumericSensor.MetaDataChangedEvent"
+	EventSensorThresholdsChanged   = "sensors.NumericSensor.ThresholdsChangedEvent"
+	EventOutletPowerStateChanged   = "pdumodel.Outlet.PowerStateChangedEvent"
+	EventOutletSettingsChanged     = "pdumodel.Outlet.SettingsChangedEvent"
+	EventInletSettingsChanged      = "pdumodel.Inlet.SettingsChangedEvent"
+	EventOCPSettingsChanged        = "pdumodel.OverCurrentProtector.SettingsChangedEvent"
+	EventPDUSettingsChanged        = "pdumodel.Pdu.SettingsChangedEvent"
+	EventDeviceAdded               = "peripheral.DeviceManager.DeviceAddedEvent"
+	EventDeviceRemoved             = "peripheral.DeviceManager.DeviceRemovedEvent"
+)
+
+// EventChannel of the event service, the PDU queues the demanded events on it until polled
+type EventChannel struct {
+	Resource
+	// Types of the demanded events without interface versions
+	Types []string
+}
+
+// Event received on an event channel
+type Event struct {
+	// Type of the event with interface versions, e.g. "pdumodel.Outlet_2_1_4.PowerStateChangedEvent"
+	Type  string
+	Value EventValue
+}
+
+// EventValue holds the fields of the supported events, those an event does not have are nil
+type EventValue struct {
+	// Source is the resource the event is about
+	Source Resource
+	// NewReading of sensor state changes
+	NewReading *Reading
+	// NewPowerState of outlet power state changes
+	NewPowerState *int
+}
+
+// Name of the event type without interface versions, e.g. "pdumodel.Outlet.PowerStateChangedEvent"
+func (e Event) Name() string {
+	parts := strings.Split(e.Type, ".")
+	for i, p := range parts {
+		parts[i] = interfaceVersion.ReplaceAllString(p, "")
+	}
+	return strings.Join(parts, ".")
+}
+
+type pollEventsResult struct {
+	Return int `json:"_ret_"`
+	Events []Event
+}
+
+// NewEventChannel creates a channel receiving events of types, given without interface versions
+func (c *Client) NewEventChannel(ctx context.Context, types []string) (*EventChannel, error) {
+	ch := &EventChannel{}
+	if _, err := c.call(ctx, *c.BaseURL.ResolveReference(&eventServicePath), rpc.Request{
+		Method: "newChannel",
+	}, &ch.Resource); err != nil {
+		return nil, fmt.Errorf("Error creating event channel: %w", err)
+	}
+	if ch.RID == "" {
+		return nil, fmt.Errorf("Error creating event channel: no channel returned")
+	}
+
+	u, err := url.Parse(ch.RID)
+	if err != nil {
+		return nil, err
+	}
+	var ret int
+	if _, err := c.call(ctx, *c.BaseURL.ResolveReference(u), rpc.Request{
+		Method: "demandEventTypes",
+		Params: map[string]interface{}{"typeIds": types},
+	}, &ret); err != nil {
+		return nil, fmt.Errorf("Error demanding events on %s: %w", ch.RID, err)
+	}
+	if ret != 0 {
+		return nil, fmt.Errorf("demandEventTypes on %s failed with code %d", ch.RID, ret)
+	}
+	ch.Types = types
+	return ch, nil
+}
+
+// CloseEventChannel cancels the demanded event types, so the PDU stops queueing events on the
+// channel and drops it once it is no longer polled
+func (c *Client) CloseEventChannel(ctx context.Context, ch *EventChannel) error {
+	u, err := url.Parse(ch.RID)
+	if err != nil {
+		return err
+	}
+	var ret int
+	if _, err := c.call(ctx, *c.BaseURL.ResolveReference(u), rpc.Request{
+		Method: "cancelEventTypes",
+		Params: map[string]interface{}{"typeIds": ch.Types},
+	}, &ret); err != nil {
+		return fmt.Errorf("Error cancelling events on %s: %w", ch.RID, err)
+	}
+	if ret != 0 {
+		return fmt.Errorf("cancelEventTypes on %s failed with code %d", ch.RID, ret)
+	}
+	return nil
+}
+
+// PollEvents waits for events on the channel. The PDU answers once events are queued or,
+// with none, after its poll timeout, so the client timeout has to be longer than that.
+func (c *Client) PollEvents(ctx context.Context, ch *EventChannel) ([]Event, error) {
+	u, err := url.Parse(ch.RID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.rpcCall(ctx, *c.BaseURL.ResolveReference(u), rpc.Request{
+		Method: "pollEvents",
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &pollEventsResult{}
+	if err := unmarshallResult(r, res); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling events of %s: %w", ch.RID, err)
+	}
+	if res.Return != 0 {
+		return nil, fmt.Errorf("pollEvents on %s failed with code %d", ch.RID, res.Return)
+	}
+	return res.Events, nil
+}
@@ -1,6 +1,19 @@
 package raritan
 
-import "github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// Inlet pole power lines
+const (
+	PowerLineL1 = iota
+	PowerLineL2
+	PowerLineL3
+	PowerLineNeutral
+)
 
 // InletInfo for PDU inlet
 type InletInfo struct {
@@ -8,6 +21,7 @@ type InletInfo struct {
 	InletMetadata
 	InletSettings
 	Sensors
+	Poles []InletPole
 }
 
 // InletMetadata metadata
@@ -21,7 +35,8 @@ type InletSettings struct {
 	Name string
 }
 
-func (c *Client) GetInletsInfo(ins []Resource) ([]InletInfo, error) {
+// GetInletsInfo returns info for the inlets, failed ones are skipped with BulkErrors returned for them
+func (c *Client) GetInletsInfo(ctx context.Context, ins []Resource) ([]InletInfo, error) {
 	reqs := make([]bulkRequest, len(ins)*3)
 	for i, in := range ins {
 		i *= 3
@@ -47,22 +62,111 @@ func (c *Client) GetInletsInfo(ins []Resource) ([]InletInfo, error) {
 			Return: &map[string]*Resource{},
 		}
 	}
-	if _, err := c.bulkCall(reqs); err != nil {
+	_, err := c.bulkCall(ctx, reqs)
+	if err != nil && !IsPartial(err) {
 		return nil, err
 	}
 
-	infos := make([]InletInfo, len(ins))
+	infos := make([]InletInfo, 0, len(ins))
 	for i, in := range ins {
 		j := i * 3
+		if anyFailed(reqs[j : j+3]) {
+			continue
+		}
 		meta := reqs[j].Return.(*InletMetadata)
 		sett := reqs[j+1].Return.(*InletSettings)
 		sens := reqs[j+2].Return.(*map[string]*Resource)
-		infos[i] = InletInfo{
+		infos = append(infos, InletInfo{
 			Resource:      in,
 			InletMetadata: *meta,
 			InletSettings: *sett,
 			Sensors:       filterEmptySensors(*sens),
+		})
+	}
+	return infos, err
+}
+
+// InletPole is a single line of an inlet with its own sensors
+type InletPole struct {
+	Label  string
+	Line   int
+	NodeID int
+	Sensors
+}
+
+// UnmarshalJSON splits the pole fields from its sensor references
+func (p *InletPole) UnmarshalJSON(b []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+
+	sens := map[string]*Resource{}
+	for k, v := range fields {
+		var err error
+		switch k {
+		case "label":
+			err = json.Unmarshal(v, &p.Label)
+		case "line":
+			err = json.Unmarshal(v, &p.Line)
+		case "nodeId":
+			err = json.Unmarshal(v, &p.NodeID)
+		default:
+			res := &Resource{}
+			if string(v) == "null" {
+				res = nil
+			} else if e := json.Unmarshal(v, res); e != nil || res.RID == "" {
+				// not a sensor reference
+				continue
+			}
+			sens[k] = res
+		}
+		if err != nil {
+			return err
+		}
+	}
+	p.Sensors = filterEmptySensors(sens)
+	return nil
+}
+
+// LineName returns the name of the pole's power line
+func (p InletPole) LineName() string {
+	switch p.Line {
+	case PowerLineL1:
+		return "L1"
+	case PowerLineL2:
+		return "L2"
+	case PowerLineL3:
+		return "L3"
+	case PowerLineNeutral:
+		return "N"
+	}
+	return ""
+}
+
+// GetInletPoles returns the poles for each inlet, in the same order as ins.
+// Poles of failed requests are nil, with BulkErrors returned for them.
+func (c *Client) GetInletPoles(ctx context.Context, ins []Resource) ([][]InletPole, error) {
+	reqs := make([]bulkRequest, len(ins))
+	for i, in := range ins {
+		reqs[i] = bulkRequest{
+			RID: in.RID,
+			Request: rpc.Request{
+				Method: "getPoles",
+			},
+			Return: &[]InletPole{},
+		}
+	}
+	_, err := c.bulkCall(ctx, reqs)
+	if err != nil && !IsPartial(err) {
+		return nil, err
+	}
+
+	poles := make([][]InletPole, len(ins))
+	for i, r := range reqs {
+		if r.Err == nil {
+			poles[i] = *r.Return.(*[]InletPole)
 		}
 	}
-	return infos, nil
+	return poles, err
 }
@@ -0,0 +1,66 @@
+package raritan
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInletPoleUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want InletPole
+		line string
+		err  bool
+	}{
+		{
+			name: "sensors",
+			json: `{"label": "L2", "line": 1, "nodeId": 1,
+				"current": {"rid": "/tfwopaque/sensors.NumericSensor:4.0.3/I1L2Current", "type": "sensors.NumericSensor:4.0.3"},
+				"voltage": {"rid": "/tfwopaque/sensors.NumericSensor:4.0.3/I1L2Voltage", "type": "sensors.NumericSensor:4.0.3"}}`,
+			want: InletPole{Label: "L2", Line: PowerLineL2, NodeID: 1, Sensors: Sensors{
+				"current": {RID: "/tfwopaque/sensors.NumericSensor:4.0.3/I1L2Current", Type: "sensors.NumericSensor:4.0.3"},
+				"voltage": {RID: "/tfwopaque/sensors.NumericSensor:4.0.3/I1L2Voltage", Type: "sensors.NumericSensor:4.0.3"},
+			}},
+			line: "L2",
+		},
+		{
+			name: "missing sensors and other fields are skipped",
+			json: `{"label": "N", "line": 3, "nodeId": 3, "current": null, "peakCurrent": {}, "flags": 2}`,
+			want: InletPole{Label: "N", Line: PowerLineNeutral, NodeID: 3, Sensors: Sensors{}},
+			line: "N",
+		},
+		{
+			name: "unknown line",
+			json: `{"label": "X", "line": 7}`,
+			want: InletPole{Label: "X", Line: 7, Sensors: Sensors{}},
+		},
+		{
+			name: "invalid label",
+			json: `{"label": 1}`,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := InletPole{}
+			err := json.Unmarshal([]byte(tt.json), &p)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("unmarshalled %+v, want an error", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, tt.want) {
+				t.Errorf("pole = %+v, want %+v", p, tt.want)
+			}
+			if p.LineName() != tt.line {
+				t.Errorf("line = %q, want %q", p.LineName(), tt.line)
+			}
+		})
+	}
+}
@@ -1,6 +1,13 @@
 package raritan
 
-import "github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
 
 // OutletInfo for PDU outlet
 type OutletInfo struct {
@@ -28,7 +35,8 @@ type OutletState struct {
 	PowerState uint
 }
 
-func (c *Client) GetOutletsInfo(os []Resource) ([]OutletInfo, error) {
+// GetOutletsInfo returns info for the outlets, failed ones are skipped with BulkErrors returned for them
+func (c *Client) GetOutletsInfo(ctx context.Context, os []Resource) ([]OutletInfo, error) {
 	reqs := make([]bulkRequest, len(os)*4)
 	for i, o := range os {
 		i *= 4
@@ -61,24 +69,119 @@ func (c *Client) GetOutletsInfo(os []Resource) ([]OutletInfo, error) {
 			Return: &map[string]*Resource{},
 		}
 	}
-	if _, err := c.bulkCall(reqs); err != nil {
+	_, err := c.bulkCall(ctx, reqs)
+	if err != nil && !IsPartial(err) {
 		return nil, err
 	}
 
-	infos := make([]OutletInfo, len(os))
+	infos := make([]OutletInfo, 0, len(os))
 	for i, in := range os {
 		j := i * 4
+		if anyFailed(reqs[j : j+4]) {
+			continue
+		}
 		meta := reqs[j].Return.(*OutletMetadata)
 		sett := reqs[j+1].Return.(*OutletSettings)
 		stat := reqs[j+2].Return.(*OutletState)
 		sens := reqs[j+3].Return.(*map[string]*Resource)
-		infos[i] = OutletInfo{
+		infos = append(infos, OutletInfo{
 			Resource:       in,
 			OutletMetadata: *meta,
 			OutletSettings: *sett,
 			OutletState:    *stat,
 			Sensors:        filterEmptySensors(*sens),
+		})
+	}
+	return infos, err
+}
+
+// Outlet power states
+const (
+	PowerStateOff = iota
+	PowerStateOn
+)
+
+// PowerAction to perform on an outlet
+type PowerAction string
+
+// Supported outlet power actions
+const (
+	PowerOn    PowerAction = "on"
+	PowerOff   PowerAction = "off"
+	PowerCycle PowerAction = "cycle"
+)
+
+// ParsePowerAction returns the power action for s
+func ParsePowerAction(s string) (PowerAction, error) {
+	switch a := PowerAction(strings.ToLower(s)); a {
+	case PowerOn, PowerOff, PowerCycle:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown power action %q, expected on, off or cycle", s)
+}
+
+// FindOutlet returns the outlet whose label, name or resource id matches ref. It fails if
+// several outlets match, or if the info of some outlets failed and ref is not a resource id,
+// as a failed outlet could match too.
+func (c *Client) FindOutlet(ctx context.Context, ref string) (*OutletInfo, error) {
+	ols, err := c.GetPDUOutlets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := c.GetOutletsInfo(ctx, ols)
+	if err != nil && !IsPartial(err) {
+		return nil, err
+	}
+	var matches []OutletInfo
+	for _, o := range infos {
+		if o.Label == ref || o.RID == ref || (o.Name != "" && o.Name == ref) {
+			matches = append(matches, o)
 		}
 	}
-	return infos, nil
+	switch {
+	case len(matches) > 1:
+		rids := make([]string, len(matches))
+		for i, o := range matches {
+			rids[i] = o.RID
+		}
+		return nil, fmt.Errorf("outlet %q is ambiguous, it matches %s", ref, strings.Join(rids, ", "))
+	case len(matches) == 0 && err != nil:
+		// the outlet may be one that failed
+		return nil, fmt.Errorf("outlet %q not found: %w", ref, err)
+	case len(matches) == 0:
+		return nil, fmt.Errorf("outlet %q not found", ref)
+	case err != nil && matches[0].RID != ref:
+		return nil, fmt.Errorf("outlet %q may be ambiguous, the info of some outlets failed: %w", ref, err)
+	}
+	return &matches[0], nil
+}
+
+// SwitchOutlet performs a power action on an outlet, without retries
+func (c *Client) SwitchOutlet(ctx context.Context, outlet Resource, action PowerAction) error {
+	req := rpc.Request{}
+	switch action {
+	case PowerOn:
+		req.Method = "setPowerState"
+		req.Params = map[string]interface{}{"pstate": PowerStateOn}
+	case PowerOff:
+		req.Method = "setPowerState"
+		req.Params = map[string]interface{}{"pstate": PowerStateOff}
+	case PowerCycle:
+		req.Method = "cyclePowerState"
+	default:
+		return fmt.Errorf("unknown power action %q", action)
+	}
+
+	u, err := url.Parse(outlet.RID)
+	if err != nil {
+		return err
+	}
+	var ret int
+	if _, err := c.mutate(ctx, *c.BaseURL.ResolveReference(u), req, &ret); err != nil {
+		return err
+	}
+	if ret != 0 {
+		return fmt.Errorf("%s on %s failed with code %d", req.Method, outlet.RID, ret)
+	}
+	return nil
 }
@@ -0,0 +1,133 @@
+package raritan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// outletServer answers getOutlets and the bulk calls of GetOutletsInfo for its outlets,
+// the info of outlets in failed returns an RPC error
+type outletServer struct {
+	outlets []OutletInfo
+	failed  map[string]bool
+}
+
+func (s *outletServer) Call(ctx context.Context, u url.URL, req rpc.Request) (*rpc.Response, error) {
+	var ret interface{}
+	switch req.Method {
+	case "getOutlets":
+		rs := make([]Resource, len(s.outlets))
+		for i, o := range s.outlets {
+			rs[i] = o.Resource
+		}
+		return rawResult(map[string]interface{}{"_ret_": rs})
+	case "performBulk":
+		res := bulkResult{}
+		for _, r := range req.Params["requests"].([]map[string]interface{}) {
+			rid := r["rid"].(string)
+			if s.failed[rid] {
+				res.Responses = append(res.Responses, bulkResponse{
+					StatCode: 200,
+					JSON:     &rpc.Response{Error: &rpc.Error{Code: -32000, Message: "failed"}},
+				})
+				continue
+			}
+			for _, o := range s.outlets {
+				if o.RID != rid {
+					continue
+				}
+				switch r["json"].(map[string]interface{})["method"] {
+				case "getMetaData":
+					ret = o.OutletMetadata
+				case "getSettings":
+					ret = o.OutletSettings
+				case "getState":
+					ret = o.OutletState
+				case "getSensors":
+					ret = map[string]*Resource{}
+				}
+			}
+			bs, err := json.Marshal(map[string]interface{}{"_ret_": ret})
+			if err != nil {
+				return nil, err
+			}
+			raw := json.RawMessage(bs)
+			res.Responses = append(res.Responses, bulkResponse{StatCode: 200, JSON: &rpc.Response{Result: &raw}})
+		}
+		return rawResult(res)
+	}
+	return nil, errors.New("unexpected method " + req.Method)
+}
+
+func (s *outletServer) BatchCall(ctx context.Context, u url.URL, reqs []rpc.Request) ([]rpc.Response, error) {
+	return nil, errors.New("not supported")
+}
+
+func rawResult(v interface{}) (*rpc.Response, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(bs)
+	return &rpc.Response{Result: &raw}, nil
+}
+
+func TestFindOutlet(t *testing.T) {
+	outlet := func(rid, label, name string) OutletInfo {
+		return OutletInfo{
+			Resource:       Resource{RID: rid, Type: "pdumodel.Outlet_2_1_4"},
+			OutletMetadata: OutletMetadata{Label: label},
+			OutletSettings: OutletSettings{Name: name},
+		}
+	}
+	outlets := []OutletInfo{
+		outlet("/model/outlet/0", "1", "srv01"),
+		outlet("/model/outlet/1", "2", ""),
+		outlet("/model/outlet/2", "3", "2"),
+	}
+	tests := []struct {
+		name   string
+		ref    string
+		failed []string
+		want   string
+		err    string
+	}{
+		{name: "label", ref: "1", want: "/model/outlet/0"},
+		{name: "name", ref: "srv01", want: "/model/outlet/0"},
+		{name: "resource id", ref: "/model/outlet/2", want: "/model/outlet/2"},
+		{name: "label of one and name of another", ref: "2", err: `outlet "2" is ambiguous, it matches /model/outlet/1, /model/outlet/2`},
+		{name: "not found", ref: "4", err: `outlet "4" not found`},
+		{name: "not found with failed outlets", ref: "4", failed: []string{"/model/outlet/1"}, err: `outlet "4" not found: 4 bulk requests failed`},
+		{name: "label with failed outlets", ref: "1", failed: []string{"/model/outlet/1"}, err: `outlet "1" may be ambiguous`},
+		{name: "resource id with failed outlets", ref: "/model/outlet/0", failed: []string{"/model/outlet/1"}, want: "/model/outlet/0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &outletServer{outlets: outlets, failed: map[string]bool{}}
+			for _, rid := range tt.failed {
+				s.failed[rid] = true
+			}
+			c := &Client{RPCClient: s}
+
+			o, err := c.FindOutlet(context.Background(), tt.ref)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.RID != tt.want {
+				t.Errorf("outlet = %s, want %s", o.RID, tt.want)
+			}
+		})
+	}
+}
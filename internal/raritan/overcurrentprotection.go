@@ -1,6 +1,10 @@
 package raritan
 
-import "github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+import (
+	"context"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
 
 // Info for PDU OverCurrentProtector
 type OCPInfo struct {
@@ -21,7 +25,8 @@ type OCPSettings struct {
 	Name string
 }
 
-func (c *Client) GetOCPInfo(ocps []Resource) ([]OCPInfo, error) {
+// GetOCPInfo returns info for the over current protectors, failed ones are skipped with BulkErrors returned for them
+func (c *Client) GetOCPInfo(ctx context.Context, ocps []Resource) ([]OCPInfo, error) {
 	reqs := make([]bulkRequest, len(ocps)*3)
 	for i, ocp := range ocps {
 		i *= 3
@@ -47,22 +52,26 @@ func (c *Client) GetOCPInfo(ocps []Resource) ([]OCPInfo, error) {
 			Return: &map[string]*Resource{},
 		}
 	}
-	if _, err := c.bulkCall(reqs); err != nil {
+	_, err := c.bulkCall(ctx, reqs)
+	if err != nil && !IsPartial(err) {
 		return nil, err
 	}
 
-	infos := make([]OCPInfo, len(ocps))
+	infos := make([]OCPInfo, 0, len(ocps))
 	for i, in := range ocps {
 		j := i * 3
+		if anyFailed(reqs[j : j+3]) {
+			continue
+		}
 		meta := reqs[j].Return.(*OCPMetadata)
 		sett := reqs[j+1].Return.(*OCPSettings)
 		sens := reqs[j+2].Return.(*map[string]*Resource)
-		infos[i] = OCPInfo{
+		infos = append(infos, OCPInfo{
 			Resource:    in,
 			OCPMetadata: *meta,
 			OCPSettings: *sett,
 			Sensors:     filterEmptySensors(*sens),
-		}
+		})
 	}
-	return infos, nil
+	return infos, err
 }
@@ -1,6 +1,10 @@
 package raritan
 
-import "github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+import (
+	"context"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
 
 var (
 	pduPath = mustURL("/model/pdu/0")
@@ -44,7 +48,7 @@ type Resource struct {
 }
 
 // GetPDUInfo returns info for main PDU entry
-func (c *Client) GetPDUInfo() (*PDUInfo, error) {
+func (c *Client) GetPDUInfo(ctx context.Context) (*PDUInfo, error) {
 	meta := &PDUMetadata{}
 	sett := &PDUSettings{}
 	reqs := []bulkRequest{
@@ -64,7 +68,7 @@ func (c *Client) GetPDUInfo() (*PDUInfo, error) {
 		},
 	}
 
-	if _, err := c.bulkCall(reqs); err != nil {
+	if _, err := c.bulkCall(ctx, reqs); err != nil {
 		return nil, err
 	}
 	return &PDUInfo{
@@ -73,9 +77,9 @@ func (c *Client) GetPDUInfo() (*PDUInfo, error) {
 	}, nil
 }
 
-func (c *Client) GetPDUInlets() ([]Resource, error) {
+func (c *Client) GetPDUInlets(ctx context.Context) ([]Resource, error) {
 	ret := []Resource{}
-	if _, err := c.call(*c.BaseURL.ResolveReference(&pduPath), rpc.Request{
+	if _, err := c.call(ctx, *c.BaseURL.ResolveReference(&pduPath), rpc.Request{
 		Method: "getInlets",
 	}, &ret); err != nil {
 		return nil, err
@@ -84,9 +88,9 @@ func (c *Client) GetPDUInlets() ([]Resource, error) {
 	return ret, nil
 }
 
-func (c *Client) GetPDUOutlets() ([]Resource, error) {
+func (c *Client) GetPDUOutlets(ctx context.Context) ([]Resource, error) {
 	ret := []Resource{}
-	if _, err := c.call(*c.BaseURL.ResolveReference(&pduPath), rpc.Request{
+	if _, err := c.call(ctx, *c.BaseURL.ResolveReference(&pduPath), rpc.Request{
 		Method: "getOutlets",
 	}, &ret); err != nil {
 		return nil, err
@@ -95,9 +99,9 @@ func (c *Client) GetPDUOutlets() ([]Resource, error) {
 	return ret, nil
 }
 
-func (c *Client) GetPDUOCP() ([]Resource, error) {
+func (c *Client) GetPDUOCP(ctx context.Context) ([]Resource, error) {
 	ret := []Resource{}
-	if _, err := c.call(*c.BaseURL.ResolveReference(&pduPath), rpc.Request{
+	if _, err := c.call(ctx, *c.BaseURL.ResolveReference(&pduPath), rpc.Request{
 		Method: "getOverCurrentProtectors",
 	}, &ret); err != nil {
 		return nil, err
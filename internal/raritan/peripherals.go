@@ -0,0 +1,119 @@
+package raritan
+
+import (
+	"context"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+var (
+	peripheralDeviceManagerPath = mustURL("/model/peripheraldevicemanager")
+)
+
+// Peripheral position port types
+const (
+	PortTypeOnboard = iota
+	PortTypeDevicePort
+	PortTypeHubPort
+	PortTypeDaisyChain
+)
+
+// PeripheralInfo for an occupied peripheral device slot
+type PeripheralInfo struct {
+	Resource
+	PeripheralDevice
+	PeripheralSettings
+}
+
+// PeripheralDevice attached to a slot
+type PeripheralDevice struct {
+	DeviceID PeripheralDeviceID
+	Position []PeripheralPosition
+	// Device is the sensor resource, nil if the slot is empty
+	Device *Resource
+}
+
+// PeripheralDeviceID identifying a peripheral device
+type PeripheralDeviceID struct {
+	Serial     string
+	Type       SensorTypeSpec
+	IsActuator bool
+	Channel    int
+}
+
+// PeripheralPosition element, ordered from the PDU outwards
+type PeripheralPosition struct {
+	PortType int
+	Port     string
+}
+
+// PeripheralSettings containing name
+type PeripheralSettings struct {
+	Name        string
+	Description string
+}
+
+// SensorTypeSpec describing a sensor
+type SensorTypeSpec struct {
+	Readingtype int
+	Type        int
+	Unit        int
+}
+
+// GetPDUPeripheralSlots returns the device slots of the peripheral device manager
+func (c *Client) GetPDUPeripheralSlots(ctx context.Context) ([]Resource, error) {
+	ret := []Resource{}
+	if _, err := c.call(ctx, *c.BaseURL.ResolveReference(&peripheralDeviceManagerPath), rpc.Request{
+		Method: "getDeviceSlots",
+	}, &ret); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// GetPeripheralsInfo returns info for occupied slots, empty slots are skipped.
+// Failed slots are skipped too, with BulkErrors returned for them.
+func (c *Client) GetPeripheralsInfo(ctx context.Context, slots []Resource) ([]PeripheralInfo, error) {
+	reqs := make([]bulkRequest, len(slots)*2)
+	for i, s := range slots {
+		i *= 2
+		reqs[i] = bulkRequest{
+			RID: s.RID,
+			Request: rpc.Request{
+				Method: "getDevice",
+			},
+			Return: &PeripheralDevice{},
+		}
+		reqs[i+1] = bulkRequest{
+			RID: s.RID,
+			Request: rpc.Request{
+				Method: "getSettings",
+			},
+			Return: &PeripheralSettings{},
+		}
+	}
+	_, err := c.bulkCall(ctx, reqs)
+	if err != nil && !IsPartial(err) {
+		return nil, err
+	}
+
+	infos := []PeripheralInfo{}
+	for i, s := range slots {
+		j := i * 2
+		if anyFailed(reqs[j : j+2]) {
+			continue
+		}
+		dev := reqs[j].Return.(*PeripheralDevice)
+		sett := reqs[j+1].Return.(*PeripheralSettings)
+		if dev.Device == nil {
+			continue
+		}
+		infos = append(infos, PeripheralInfo{
+			Resource:           s,
+			PeripheralDevice:   *dev,
+			PeripheralSettings: *sett,
+		})
+	}
+	return infos, err
+}
@@ -1,15 +1,18 @@
 package raritan
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 var (
@@ -20,6 +23,15 @@ var (
 type Client struct {
 	RPCClient rpc.Client
 	BaseURL   url.URL
+	// Retry of failed requests, Steps is the number of retries. Only connection
+	// errors and server errors are retried, never RPC errors or calls switching outlets.
+	Retry wait.Backoff
+	// Session to authenticate with instead of basic auth, nil for basic auth
+	Session *Session
+	// BulkSize is the maximum number of requests per bulk call, 0 for no limit
+	BulkSize int
+	// BulkParallelism is the number of bulk calls in flight when requests are split
+	BulkParallelism int
 }
 
 type result struct {
@@ -42,10 +54,129 @@ type bulkRequest struct {
 	Request rpc.Request
 	// Return type to be unmarshalled to, pointer
 	Return interface{}
+	// Err of the request if it failed while others of the bulk call succeeded
+	Err error
 }
 
-func (c *Client) call(url url.URL, req rpc.Request, ret interface{}) (*result, error) {
-	r, err := c.RPCClient.Call(url, req)
+// BulkItemError of a single request of a bulk call
+type BulkItemError struct {
+	RID    string
+	Method string
+	// StatCode of the bulk response, 200 for RPC errors and invalid results
+	StatCode int
+	Err      error
+}
+
+func (e BulkItemError) Error() string {
+	return fmt.Sprintf("%s method %s: %v", e.RID, e.Method, e.Err)
+}
+
+func (e BulkItemError) Unwrap() error {
+	return e.Err
+}
+
+// BulkErrors of the failed requests of a bulk call. Getters returning them also
+// return the results of the requests that succeeded.
+type BulkErrors []BulkItemError
+
+func (e BulkErrors) Error() string {
+	if len(e) == 1 {
+		return "bulk request failed: " + e[0].Error()
+	}
+	return fmt.Sprintf("%d bulk requests failed, first: %v", len(e), e[0])
+}
+
+// IsPartial reports if err only lists failed requests of a bulk call, so the
+// results of the other requests are valid
+func IsPartial(err error) bool {
+	var be BulkErrors
+	return errors.As(err, &be)
+}
+
+// bulkErrors of the failed requests, nil if all succeeded
+func bulkErrors(br []bulkRequest) error {
+	var errs BulkErrors
+	for _, r := range br {
+		var ie BulkItemError
+		if errors.As(r.Err, &ie) {
+			errs = append(errs, ie)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// anyFailed reports if one of the requests failed
+func anyFailed(br []bulkRequest) bool {
+	for _, r := range br {
+		if r.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// rpcCall authenticated by the session, if any, with retries
+func (c *Client) rpcCall(ctx context.Context, url url.URL, req rpc.Request) (*rpc.Response, error) {
+	return c.authCall(ctx, url, req, c.Retry.Steps)
+}
+
+// authCall authenticated by the session, if any, retried up to retries times
+func (c *Client) authCall(ctx context.Context, url url.URL, req rpc.Request, retries int) (*rpc.Response, error) {
+	if c.Session != nil {
+		return c.sessionCall(ctx, url, req, retries)
+	}
+	return c.retryCall(ctx, url, req, retries)
+}
+
+// retryCall retried up to retries times
+func (c *Client) retryCall(ctx context.Context, url url.URL, req rpc.Request, retries int) (*rpc.Response, error) {
+	backoff := c.Retry
+	for retry := 0; ; retry++ {
+		r, err := c.RPCClient.Call(ctx, url, req)
+		if err == nil || retry >= retries || !retryable(ctx, err) {
+			return r, err
+		}
+
+		t := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
+
+// retryable errors are transport errors and server errors, unless ctx is done.
+// Invalid responses are not, the PDU would most likely answer the same again.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se rpc.StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500
+	}
+	var ue *url.Error
+	var ne net.Error
+	return errors.As(err, &ue) || errors.As(err, &ne)
+}
+
+func (c *Client) call(ctx context.Context, url url.URL, req rpc.Request, ret interface{}) (*result, error) {
+	return c.callRetries(ctx, url, req, ret, c.Retry.Steps)
+}
+
+// mutate performs a call changing the state of the PDU. It is not retried, as the PDU
+// may have performed a call that failed, e.g. by timing out.
+func (c *Client) mutate(ctx context.Context, url url.URL, req rpc.Request, ret interface{}) (*result, error) {
+	return c.callRetries(ctx, url, req, ret, 0)
+}
+
+func (c *Client) callRetries(ctx context.Context, url url.URL, req rpc.Request, ret interface{}, retries int) (*result, error) {
+	r, err := c.authCall(ctx, url, req, retries)
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +206,84 @@ func unmarshallResult(r *rpc.Response, ret interface{}) error {
 	return nil
 }
 
-func (c *Client) bulkCall(br []bulkRequest) (*bulkResult, error) {
+// bulkCall performs the requests in chunks of BulkSize, BulkParallelism chunks at a time.
+// If only some requests failed their Err is set and BulkErrors are returned with the result.
+func (c *Client) bulkCall(ctx context.Context, br []bulkRequest) (*bulkResult, error) {
+	size := c.BulkSize
+	if size <= 0 || size > len(br) {
+		size = len(br)
+	}
+	if size == 0 {
+		return &bulkResult{}, nil
+	}
+	chunks := (len(br) + size - 1) / size
+	if chunks == 1 {
+		res, err := c.bulkChunk(ctx, br)
+		if err != nil {
+			return nil, err
+		}
+		return res, bulkErrors(br)
+	}
+
+	parallel := c.BulkParallelism
+	if parallel < 1 {
+		parallel = 1
+	}
+	ctx, cf := context.WithCancel(ctx)
+	defer cf()
+
+	results := make([]*bulkResult, chunks)
+	sem := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	mux := sync.Mutex{}
+	var failed error
+	for i := 0; i < chunks; i++ {
+		end := (i + 1) * size
+		if end > len(br) {
+			end = len(br)
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, chunk []bulkRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			r, err := c.bulkChunk(ctx, chunk)
+			if err != nil {
+				mux.Lock()
+				// the first error is the cause, the other chunks fail as they are cancelled
+				if failed == nil {
+					failed = fmt.Errorf("Error in bulk chunk %d of %d: %w", i+1, chunks, err)
+					cf()
+				}
+				mux.Unlock()
+				return
+			}
+			results[i] = r
+		}(i, br[i*size:end])
+	}
+	wg.Wait()
+	if failed != nil {
+		return nil, failed
+	}
+
+	res := &bulkResult{
+		Responses: make([]bulkResponse, 0, len(br)),
+	}
+	for _, r := range results {
+		if r == nil {
+			// skipped as ctx was cancelled
+			return nil, ctx.Err()
+		}
+		res.Responses = append(res.Responses, r.Responses...)
+	}
+	return res, bulkErrors(br)
+}
+
+// bulkChunk performs the requests in a single bulk call
+func (c *Client) bulkChunk(ctx context.Context, br []bulkRequest) (*bulkResult, error) {
 	reqs := make([]map[string]interface{}, len(br))
 	for i, r := range br {
 		reqs[i] = map[string]interface{}{
@@ -89,7 +297,7 @@ func (c *Client) bulkCall(br []bulkRequest) (*bulkResult, error) {
 		}
 	}
 
-	r, err := c.RPCClient.Call(*c.BaseURL.ResolveReference(&bulkPath), rpc.Request{
+	r, err := c.rpcCall(ctx, *c.BaseURL.ResolveReference(&bulkPath), rpc.Request{
 		Method: "performBulk",
 		Params: map[string]interface{}{
 			"requests": reqs,
@@ -102,26 +310,50 @@ func (c *Client) bulkCall(br []bulkRequest) (*bulkResult, error) {
 	if err := unmarshallResult(r, res); err != nil {
 		return nil, err
 	}
+	if len(res.Responses) != len(br) {
+		return nil, fmt.Errorf("Expected %d bulk responses, got %d", len(br), len(res.Responses))
+	}
 
 	for i, r := range res.Responses {
-		if r.StatCode != 200 {
-			return nil, fmt.Errorf("Bulk response code not 200: %d", r.StatCode)
-		}
-		req := br[i]
-		if req.Return == nil {
-			continue
-		}
+		br[i].Err = decodeBulkResponse(r, br[i])
+	}
+
+	return res, nil
+}
 
-		res := &result{}
-		if err := unmarshallResult(r.JSON, res); err != nil {
-			return nil, fmt.Errorf("Error unmarshalling result for %s method %s: %w", req.RID, req.Request.Method, err)
+// decodeBulkResponse into the Return of req, returns the error of the item
+func decodeBulkResponse(r bulkResponse, req bulkRequest) error {
+	if r.StatCode != 200 {
+		return BulkItemError{
+			RID:      req.RID,
+			Method:   req.Request.Method,
+			StatCode: r.StatCode,
+			Err:      fmt.Errorf("Bulk response code not 200: %d", r.StatCode),
 		}
-		if err := json.Unmarshal(res.Return, req.Return); err != nil {
-			return nil, err
+	}
+	if r.JSON == nil {
+		return BulkItemError{
+			RID:      req.RID,
+			Method:   req.Request.Method,
+			StatCode: r.StatCode,
+			Err:      errors.New("Expected RPC response not nil"),
 		}
 	}
 
-	return res, nil
+	res := &result{}
+	err := unmarshallResult(r.JSON, res)
+	if err == nil && req.Return != nil {
+		err = json.Unmarshal(res.Return, req.Return)
+	}
+	if err != nil {
+		return BulkItemError{
+			RID:      req.RID,
+			Method:   req.Request.Method,
+			StatCode: r.StatCode,
+			Err:      fmt.Errorf("Error unmarshalling result: %w", err),
+		}
+	}
+	return nil
 }
 
 func mustURL(path string) url.URL {
@@ -132,7 +364,7 @@ func mustURL(path string) url.URL {
 	return *u
 }
 
-func (c *Client) ConnectionCheck() error {
+func (c *Client) ConnectionCheck(ctx context.Context) error {
 	var port int
 	if p, err := strconv.Atoi(c.BaseURL.Port()); err != nil || p == 0 {
 		if c.BaseURL.Scheme == "http" {
@@ -146,7 +378,8 @@ func (c *Client) ConnectionCheck() error {
 		port = p
 	}
 
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", c.BaseURL.Hostname(), port), 3*time.Second)
+	dialer := net.Dialer{Timeout: 3 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", c.BaseURL.Hostname(), port))
 	defer func() {
 		if conn != nil {
 			err := conn.Close()
@@ -0,0 +1,377 @@
+package raritan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// bulkServer answers bulk calls, every request returns its RID unless the RID
+// starts with /missing (status 404), /rpcerror (an RPC error) or is /down, which
+// fails the whole bulk call
+type bulkServer struct {
+	mux    sync.Mutex
+	chunks []int
+}
+
+func (s *bulkServer) Call(ctx context.Context, u url.URL, req rpc.Request) (*rpc.Response, error) {
+	reqs := req.Params["requests"].([]map[string]interface{})
+	s.mux.Lock()
+	s.chunks = append(s.chunks, len(reqs))
+	s.mux.Unlock()
+
+	res := bulkResult{}
+	for _, r := range reqs {
+		rid := r["rid"].(string)
+		switch {
+		case rid == "/down":
+			return nil, rpc.StatusError{StatusCode: 503, Status: "503 Service Unavailable"}
+		case strings.HasPrefix(rid, "/missing"):
+			res.Responses = append(res.Responses, bulkResponse{StatCode: 404})
+		case strings.HasPrefix(rid, "/rpcerror"):
+			res.Responses = append(res.Responses, bulkResponse{
+				StatCode: 200,
+				JSON:     &rpc.Response{Error: &rpc.Error{Code: -32601, Message: "Method not found"}},
+			})
+		default:
+			ret := json.RawMessage(fmt.Sprintf(`{"_ret_":%q}`, rid))
+			res.Responses = append(res.Responses, bulkResponse{
+				StatCode: 200,
+				JSON:     &rpc.Response{Result: &ret},
+			})
+		}
+	}
+	bs, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	result := json.RawMessage(bs)
+	return &rpc.Response{Result: &result}, nil
+}
+
+func (s *bulkServer) BatchCall(ctx context.Context, u url.URL, reqs []rpc.Request) ([]rpc.Response, error) {
+	return nil, errors.New("not supported")
+}
+
+func bulkRequests(rids ...string) []bulkRequest {
+	br := make([]bulkRequest, len(rids))
+	for i, rid := range rids {
+		var ret string
+		br[i] = bulkRequest{
+			RID:     rid,
+			Request: rpc.Request{Method: "getMetaData"},
+			Return:  &ret,
+		}
+	}
+	return br
+}
+
+func TestBulkCall(t *testing.T) {
+	tests := []struct {
+		name        string
+		rids        []string
+		size        int
+		parallelism int
+		// chunks are the sizes of the bulk calls, sorted as they run in parallel
+		chunks []int
+		failed []string
+		err    bool
+	}{
+		{
+			name:   "no requests",
+			chunks: nil,
+		},
+		{
+			name:   "single call without limit",
+			rids:   []string{"/a", "/b", "/c"},
+			chunks: []int{3},
+		},
+		{
+			name:   "size above request count",
+			rids:   []string{"/a", "/b"},
+			size:   5,
+			chunks: []int{2},
+		},
+		{
+			name:   "even chunks",
+			rids:   []string{"/a", "/b", "/c", "/d"},
+			size:   2,
+			chunks: []int{2, 2},
+		},
+		{
+			name:        "last chunk smaller, in parallel",
+			rids:        []string{"/a", "/b", "/c", "/d", "/e"},
+			size:        2,
+			parallelism: 3,
+			chunks:      []int{1, 2, 2},
+		},
+		{
+			name:   "failed items in a single call",
+			rids:   []string{"/a", "/missing/1", "/rpcerror/1"},
+			chunks: []int{3},
+			failed: []string{"/missing/1", "/rpcerror/1"},
+		},
+		{
+			name:   "failed items across chunks",
+			rids:   []string{"/missing/1", "/a", "/b", "/rpcerror/1", "/c"},
+			size:   2,
+			chunks: []int{1, 2, 2},
+			failed: []string{"/missing/1", "/rpcerror/1"},
+		},
+		{
+			name: "failed chunk fails the call",
+			rids: []string{"/a", "/b", "/down"},
+			size: 2,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &bulkServer{}
+			c := &Client{
+				RPCClient:       s,
+				BulkSize:        tt.size,
+				BulkParallelism: tt.parallelism,
+			}
+			br := bulkRequests(tt.rids...)
+			res, err := c.bulkCall(context.Background(), br)
+
+			if tt.err {
+				if err == nil || IsPartial(err) {
+					t.Fatalf("err = %v, want the error of the failed chunk", err)
+				}
+				return
+			}
+			if len(tt.failed) == 0 && err != nil {
+				t.Fatalf("err = %v, want nil", err)
+			}
+
+			sort.Ints(s.chunks)
+			if !reflect.DeepEqual(s.chunks, tt.chunks) {
+				t.Errorf("chunks = %v, want %v", s.chunks, tt.chunks)
+			}
+			if len(res.Responses) != len(tt.rids) {
+				t.Errorf("got %d responses, want %d", len(res.Responses), len(tt.rids))
+			}
+
+			failed := []string{}
+			var be BulkErrors
+			if errors.As(err, &be) {
+				for _, ie := range be {
+					failed = append(failed, ie.RID)
+				}
+			}
+			if len(tt.failed) > 0 && !reflect.DeepEqual(failed, tt.failed) {
+				t.Errorf("failed = %v, want %v", failed, tt.failed)
+			}
+
+			// responses are in request order, results of the failed ones are left unset
+			for i, r := range br {
+				ret := *r.Return.(*string)
+				if r.Err != nil {
+					if ret != "" {
+						t.Errorf("failed request %s returned %q", r.RID, ret)
+					}
+					continue
+				}
+				if ret != tt.rids[i] {
+					t.Errorf("request %d returned %q, want %q", i, ret, tt.rids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeBulkResponse(t *testing.T) {
+	result := func(s string) *rpc.Response {
+		r := json.RawMessage(s)
+		return &rpc.Response{Result: &r}
+	}
+	tests := []struct {
+		name     string
+		res      bulkResponse
+		ret      interface{}
+		want     interface{}
+		statCode int
+		err      bool
+	}{
+		{
+			name: "result",
+			res:  bulkResponse{StatCode: 200, JSON: result(`{"_ret_":42}`)},
+			ret:  new(int),
+			want: 42,
+		},
+		{
+			name: "result without return",
+			res:  bulkResponse{StatCode: 200, JSON: result(`{"_ret_":42}`)},
+		},
+		{
+			name:     "status code",
+			res:      bulkResponse{StatCode: 404},
+			statCode: 404,
+			err:      true,
+		},
+		{
+			name:     "no response",
+			res:      bulkResponse{StatCode: 200},
+			statCode: 200,
+			err:      true,
+		},
+		{
+			name:     "rpc error",
+			res:      bulkResponse{StatCode: 200, JSON: &rpc.Response{Error: &rpc.Error{Code: -32601}}},
+			statCode: 200,
+			err:      true,
+		},
+		{
+			name:     "invalid result",
+			res:      bulkResponse{StatCode: 200, JSON: result(`{"_ret_":"not a number"}`)},
+			ret:      new(int),
+			statCode: 200,
+			err:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := bulkRequest{
+				RID:     "/model/outlet/0",
+				Request: rpc.Request{Method: "getState"},
+				Return:  tt.ret,
+			}
+			err := decodeBulkResponse(tt.res, req)
+			if !tt.err {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				if tt.want != nil && reflect.ValueOf(tt.ret).Elem().Interface() != tt.want {
+					t.Errorf("return = %v, want %v", reflect.ValueOf(tt.ret).Elem().Interface(), tt.want)
+				}
+				return
+			}
+
+			var ie BulkItemError
+			if !errors.As(err, &ie) {
+				t.Fatalf("err = %v, want a BulkItemError", err)
+			}
+			if ie.RID != req.RID || ie.Method != req.Request.Method || ie.StatCode != tt.statCode {
+				t.Errorf("err = %+v, want RID %s, method %s and status %d", ie, req.RID, req.Request.Method, tt.statCode)
+			}
+		})
+	}
+}
+
+// failingClient fails every call with err after delay, unless ctx is done first
+type failingClient struct {
+	err   error
+	delay time.Duration
+	calls int32
+}
+
+func (c *failingClient) Call(ctx context.Context, u url.URL, req rpc.Request) (*rpc.Response, error) {
+	atomic.AddInt32(&c.calls, 1)
+	select {
+	case <-ctx.Done():
+		return nil, &url.Error{Op: "Post", URL: u.String(), Err: ctx.Err()}
+	case <-time.After(c.delay):
+		return nil, c.err
+	}
+}
+
+func (c *failingClient) BatchCall(ctx context.Context, u url.URL, reqs []rpc.Request) ([]rpc.Response, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestRetryCallContext(t *testing.T) {
+	unavailable := rpc.StatusError{StatusCode: 503, Status: "503 Service Unavailable"}
+	tests := []struct {
+		name   string
+		client *failingClient
+		// cancel the context after, 0 for a cancelled context
+		cancelAfter time.Duration
+		calls       int32
+		err         error
+	}{
+		{
+			name:        "cancelled during backoff",
+			client:      &failingClient{err: unavailable},
+			cancelAfter: 50 * time.Millisecond,
+			calls:       1,
+			err:         unavailable,
+		},
+		{
+			name:        "cancelled during call",
+			client:      &failingClient{err: unavailable, delay: time.Hour},
+			cancelAfter: 50 * time.Millisecond,
+			calls:       1,
+			err:         context.Canceled,
+		},
+		{
+			name:   "cancelled before",
+			client: &failingClient{err: unavailable, delay: time.Hour},
+			calls:  1,
+			err:    context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{
+				RPCClient: tt.client,
+				// a retry would wait far beyond the test
+				Retry: wait.Backoff{Duration: time.Hour, Steps: 3},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter == 0 {
+				cancel()
+			} else {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+			_, err := c.GetPDUOutlets(ctx)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("returned after %s", d)
+			}
+			if calls := atomic.LoadInt32(&tt.client.calls); calls != tt.calls {
+				t.Errorf("%d calls, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestIsPartial(t *testing.T) {
+	be := BulkErrors{{RID: "/model/outlet/0", Method: "getSettings", Err: errors.New("404 Not Found")}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil},
+		{name: "bulk errors", err: be, want: true},
+		{name: "wrapped bulk errors", err: fmt.Errorf("Error getting outlets: %w", be), want: true},
+		{name: "item error", err: be[0]},
+		{name: "other error", err: errors.New("connection refused")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPartial(tt.err); got != tt.want {
+				t.Errorf("IsPartial(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
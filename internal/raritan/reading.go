@@ -1,8 +1,7 @@
 package raritan
 
 import (
-	"fmt"
-	"strings"
+	"context"
 
 	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
 )
@@ -12,10 +11,54 @@ type Reading struct {
 	Timestamp uint
 	Available bool
 	Value     float64
+	// Status is only set for numeric sensors
+	Status ReadingStatus
+}
+
+// ReadingStatus of a numeric sensor relative to its thresholds
+type ReadingStatus struct {
+	AboveUpperCritical bool
+	AboveUpperWarning  bool
+	BelowLowerWarning  bool
+	BelowLowerCritical bool
+}
+
+// SensorThresholds of a numeric sensor
+type SensorThresholds struct {
+	UpperCriticalActive   bool
+	UpperCritical         float64
+	UpperWarningActive    bool
+	UpperWarning          float64
+	LowerWarningActive    bool
+	LowerWarning          float64
+	LowerCriticalActive   bool
+	LowerCritical         float64
+	AssertionTimeout      int
+	DeassertionHysteresis float64
 }
 
 type Sensors = map[string]Resource
 
+// SensorMetadata for numeric sensors
+type SensorMetadata struct {
+	Type           SensorTypeSpec
+	Decdigits      int
+	Accuracy       float64
+	Resolution     float64
+	Tolerance      float64
+	NoiseThreshold float64
+	Range          struct {
+		Min float64
+		Max float64
+	}
+	ThresholdCaps struct {
+		HasUpperCritical bool
+		HasUpperWarning  bool
+		HasLowerWarning  bool
+		HasLowerCritical bool
+	}
+}
+
 func filterEmptySensors(ss map[string]*Resource) Sensors {
 	s := Sensors{}
 	for k, v := range ss {
@@ -26,38 +69,131 @@ func filterEmptySensors(ss map[string]*Resource) Sensors {
 	return s
 }
 
-func (c *Client) GetSensorReadings(sens []Resource) ([]Reading, error) {
-	reqs := make([]bulkRequest, len(sens))
+// kindReading decodes a reading with the decoder of its sensor kind
+type kindReading struct {
+	kind SensorKind
+	Reading
+}
+
+func (r *kindReading) UnmarshalJSON(b []byte) error {
+	rd, err := r.kind.decode(b)
+	r.Reading = rd
+	return err
+}
+
+// GetSensorReadings returns readings in the same order as sens. Failed readings are
+// not available, with BulkErrors returned for them. Sensors of unknown types are
+// not read and not available either.
+func (c *Client) GetSensorReadings(ctx context.Context, sens []Resource) ([]Reading, error) {
+	reqs := []bulkRequest{}
+	idx := []int{}
 	for i, s := range sens {
-		reqs[i] = bulkRequest{
+		kind, ok := LookupSensorKind(s.Type)
+		if !ok {
+			continue
+		}
+		reqs = append(reqs, bulkRequest{
 			RID: s.RID,
 			Request: rpc.Request{
-				Method: sensorReadingMethod(s),
+				Method: kind.ReadingMethod,
 			},
-			Return: &Reading{},
-		}
+			Return: &kindReading{kind: kind},
+		})
+		idx = append(idx, i)
 	}
 
-	if _, err := c.bulkCall(reqs); err != nil {
+	rs := make([]Reading, len(sens))
+	if len(reqs) == 0 {
+		return rs, nil
+	}
+	_, err := c.bulkCall(ctx, reqs)
+	if err != nil && !IsPartial(err) {
 		return nil, err
 	}
 
-	rs := make([]Reading, len(sens))
 	for i, r := range reqs {
-		rd := r.Return.(*Reading)
-		rs[i] = *rd
+		if r.Err != nil {
+			continue
+		}
+		rs[idx[i]] = r.Return.(*kindReading).Reading
+	}
+
+	return rs, err
+}
+
+// GetSensorsMetadata returns metadata in the same order as sens, nil for sensors without metadata.
+// Failed requests are nil too, with BulkErrors returned for them.
+func (c *Client) GetSensorsMetadata(ctx context.Context, sens []Resource) ([]*SensorMetadata, error) {
+	reqs := []bulkRequest{}
+	idx := []int{}
+	for i, s := range sens {
+		if !isNumericSensor(s) {
+			continue
+		}
+		reqs = append(reqs, bulkRequest{
+			RID: s.RID,
+			Request: rpc.Request{
+				Method: "getMetaData",
+			},
+			Return: &SensorMetadata{},
+		})
+		idx = append(idx, i)
+	}
+
+	ms := make([]*SensorMetadata, len(sens))
+	if len(reqs) == 0 {
+		return ms, nil
+	}
+	_, err := c.bulkCall(ctx, reqs)
+	if err != nil && !IsPartial(err) {
+		return nil, err
 	}
 
-	return rs, nil
+	for i, r := range reqs {
+		if r.Err == nil {
+			ms[idx[i]] = r.Return.(*SensorMetadata)
+		}
+	}
+	return ms, err
 }
 
-// sensors have different reading methods based on type
-func sensorReadingMethod(res Resource) string {
-	if strings.Contains(res.Type, "NumericSensor") {
-		return "getReading"
-	} else if strings.Contains(res.Type, "StateSensor") || strings.Contains(res.Type, "OverCurrentProtectorTripSensor") {
-		return "getState"
+// GetSensorsThresholds returns thresholds in the same order as sens, nil for sensors without thresholds.
+// Failed requests are nil too, with BulkErrors returned for them.
+func (c *Client) GetSensorsThresholds(ctx context.Context, sens []Resource) ([]*SensorThresholds, error) {
+	reqs := []bulkRequest{}
+	idx := []int{}
+	for i, s := range sens {
+		if !isNumericSensor(s) {
+			continue
+		}
+		reqs = append(reqs, bulkRequest{
+			RID: s.RID,
+			Request: rpc.Request{
+				Method: "getThresholds",
+			},
+			Return: &SensorThresholds{},
+		})
+		idx = append(idx, i)
+	}
+
+	ts := make([]*SensorThresholds, len(sens))
+	if len(reqs) == 0 {
+		return ts, nil
+	}
+	_, err := c.bulkCall(ctx, reqs)
+	if err != nil && !IsPartial(err) {
+		return nil, err
+	}
+
+	for i, r := range reqs {
+		if r.Err == nil {
+			ts[idx[i]] = r.Return.(*SensorThresholds)
+		}
 	}
+	return ts, err
+}
 
-	panic(fmt.Sprintf("Unknown Sensor type %s for resource %s", res.Type, res.RID))
+func isNumericSensor(res Resource) bool {
+	k, ok := LookupSensorKind(res.Type)
+	return ok && k.Numeric
 }
@@ -0,0 +1,53 @@
+package raritan
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestKindReadingUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		kind SensorKind
+		json string
+		want Reading
+	}{
+		{
+			name: "numeric",
+			kind: numericSensorKind,
+			json: `{"timestamp": 1600000000, "available": true, "value": 231.5,
+				"status": {"aboveUpperCritical": false, "aboveUpperWarning": true, "belowLowerWarning": false, "belowLowerCritical": false}}`,
+			want: Reading{Timestamp: 1600000000, Available: true, Value: 231.5, Status: ReadingStatus{AboveUpperWarning: true}},
+		},
+		{
+			name: "numeric without status",
+			kind: numericSensorKind,
+			json: `{"timestamp": 1600000000, "available": true, "value": 0.5}`,
+			want: Reading{Timestamp: 1600000000, Available: true, Value: 0.5},
+		},
+		{
+			name: "state",
+			kind: stateSensorKind,
+			json: `{"timestamp": 1600000000, "available": true, "value": 1}`,
+			want: Reading{Timestamp: 1600000000, Available: true, Value: 1},
+		},
+		{
+			name: "unavailable",
+			kind: numericSensorKind,
+			json: `{"timestamp": 1600000000, "available": false, "value": 0}`,
+			want: Reading{Timestamp: 1600000000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := kindReading{kind: tt.kind}
+			if err := json.Unmarshal([]byte(tt.json), &r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r.Reading, tt.want) {
+				t.Errorf("reading = %+v, want %+v", r.Reading, tt.want)
+			}
+		})
+	}
+}
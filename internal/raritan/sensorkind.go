@@ -0,0 +1,82 @@
+package raritan
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// SensorKind describes how to read sensors of an interface type
+type SensorKind struct {
+	// ReadingMethod returns the current reading of the sensor
+	ReadingMethod string
+	// Numeric sensors have metadata and thresholds
+	Numeric bool
+	// Decode the result of ReadingMethod, nil decodes it as a Reading
+	Decode func(json.RawMessage) (Reading, error)
+}
+
+// decode the result of the reading method
+func (k SensorKind) decode(raw json.RawMessage) (Reading, error) {
+	if k.Decode != nil {
+		return k.Decode(raw)
+	}
+	r := Reading{}
+	err := json.Unmarshal(raw, &r)
+	return r, err
+}
+
+var (
+	numericSensorKind = SensorKind{
+		ReadingMethod: "getReading",
+		Numeric:       true,
+	}
+	stateSensorKind = SensorKind{
+		ReadingMethod: "getState",
+	}
+
+	sensorKindsMux sync.RWMutex
+	// sensorKinds by interface name without version, qualified or not
+	sensorKinds = map[string]SensorKind{
+		"NumericSensor":                  numericSensorKind,
+		"AccumulatingNumericSensor":      numericSensorKind,
+		"StateSensor":                    stateSensorKind,
+		"OverCurrentProtectorTripSensor": stateSensorKind,
+		"ResidualCurrentStateSensor":     stateSensorKind,
+	}
+
+	// interfaceVersion suffix of resource types, e.g. _4_0_3
+	interfaceVersion = regexp.MustCompile(`(_\d+)+$`)
+)
+
+// RegisterSensorKind for an interface name without version, e.g. "sensors.NumericSensor" or
+// "NumericSensor" to match it in any namespace. Replaces the kind registered for the name.
+func RegisterSensorKind(name string, k SensorKind) {
+	sensorKindsMux.Lock()
+	defer sensorKindsMux.Unlock()
+	sensorKinds[name] = k
+}
+
+// LookupSensorKind for a resource type such as "sensors.NumericSensor_4_0_3". Kinds registered
+// for the qualified name take precedence over those for the name alone.
+func LookupSensorKind(resType string) (SensorKind, bool) {
+	name := interfaceVersion.ReplaceAllString(resType, "")
+
+	sensorKindsMux.RLock()
+	defer sensorKindsMux.RUnlock()
+	if k, ok := sensorKinds[name]; ok {
+		return k, true
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		k, ok := sensorKinds[name[i+1:]]
+		return k, ok
+	}
+	return SensorKind{}, false
+}
+
+// IsKnownSensor if a kind is registered for the type of res
+func IsKnownSensor(res Resource) bool {
+	_, ok := LookupSensorKind(res.Type)
+	return ok
+}
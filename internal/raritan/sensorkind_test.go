@@ -0,0 +1,50 @@
+package raritan
+
+import "testing"
+
+func TestLookupSensorKind(t *testing.T) {
+	qualified := SensorKind{ReadingMethod: "getValue", Numeric: true}
+	RegisterSensorKind("test.NumericSensor", qualified)
+	t.Cleanup(func() {
+		sensorKindsMux.Lock()
+		delete(sensorKinds, "test.NumericSensor")
+		sensorKindsMux.Unlock()
+	})
+
+	tests := []struct {
+		resType string
+		method  string
+		numeric bool
+		ok      bool
+	}{
+		{resType: "sensors.NumericSensor_4_0_3", method: "getReading", numeric: true, ok: true},
+		{resType: "sensors.AccumulatingNumericSensor_2_1_3", method: "getReading", numeric: true, ok: true},
+		{resType: "sensors.StateSensor_4_0_3", method: "getState", ok: true},
+		{resType: "pdumodel.OverCurrentProtectorTripSensor_1_0_4", method: "getState", ok: true},
+		{resType: "pdumodel.ResidualCurrentStateSensor_2_1_3", method: "getState", ok: true},
+		{resType: "NumericSensor", method: "getReading", numeric: true, ok: true},
+		{resType: "NumericSensor_4_0_3", method: "getReading", numeric: true, ok: true},
+		// the qualified name beats the bare one in its namespace only
+		{resType: "test.NumericSensor_1_0_0", method: "getValue", numeric: true, ok: true},
+		{resType: "test.NumericSensor", method: "getValue", numeric: true, ok: true},
+		{resType: "other.NumericSensor_1_0_0", method: "getReading", numeric: true, ok: true},
+		{resType: "sensors.UnknownSensor_1_0_0"},
+		{resType: "pdumodel.Outlet_2_1_4"},
+		{resType: "UnknownSensor"},
+		{resType: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resType, func(t *testing.T) {
+			k, ok := LookupSensorKind(tt.resType)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if k.ReadingMethod != tt.method || k.Numeric != tt.numeric {
+				t.Errorf("kind = %+v, want method %q and numeric %v", k, tt.method, tt.numeric)
+			}
+			if IsKnownSensor(Resource{Type: tt.resType}) != tt.ok {
+				t.Errorf("IsKnownSensor = %v, want %v", !tt.ok, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package raritan
+
+// Sensor types from sensors.Sensor
+var sensorTypeNames = map[int]string{
+	0:  "unspecified",
+	1:  "voltage",
+	2:  "current",
+	3:  "unbalance",
+	4:  "power",
+	5:  "powerFactor",
+	6:  "energy",
+	7:  "frequency",
+	8:  "temperature",
+	9:  "humidity",
+	10: "airFlow",
+	11: "airPressure",
+	12: "contactClosure",
+	13: "onOffSensor",
+	14: "tripSensor",
+	15: "vibration",
+	16: "waterLeak",
+	17: "smokeDetector",
+	18: "time",
+	19: "dewPoint",
+	20: "absoluteHumidity",
+}
+
+// SensorTypeName returns the name for a sensor type, "unspecified" if unknown
+func SensorTypeName(t int) string {
+	if n, ok := sensorTypeNames[t]; ok {
+		return n
+	}
+	return sensorTypeNames[0]
+}
+
+// Sensor units from sensors.Sensor
+const (
+	UnitNone = iota
+	UnitVolt
+	UnitAmpere
+	UnitWatt
+	UnitVoltAmp
+	UnitWattHour
+	UnitVoltAmpHour
+	UnitDegreeCelsius
+	UnitHz
+	UnitPercent
+	UnitMeterPerSec
+	UnitPascal
+	UnitG
+	UnitRPM
+	UnitMeter
+	UnitHour
+	UnitMinute
+	UnitSecond
+	UnitVoltAmpReactive
+	UnitVoltAmpReactiveHour
+	UnitGramPerCubicMeter
+	UnitDegree
+)
@@ -0,0 +1,142 @@
+package raritan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+var (
+	sessionPath = mustURL("/session")
+)
+
+// Session authenticates calls with a session token instead of basic auth on every
+// request. The token is created on the first call and renewed when it has expired
+// or the PDU rejects it. Safe for concurrent use by copies of a Client.
+type Session struct {
+	mux     sync.Mutex
+	token   string
+	timeout time.Duration
+	expires time.Time
+}
+
+type newSessionResult struct {
+	Session struct {
+		// Timeout of an idle session in seconds
+		Timeout int `json:"timeout"`
+	} `json:"session"`
+	Token string `json:"token"`
+}
+
+// token of the current session, logs in if there is none or it has expired
+func (s *Session) current(ctx context.Context, c *Client) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.token != "" && (s.expires.IsZero() || time.Now().Before(s.expires)) {
+		return s.token, nil
+	}
+
+	// login with basic auth, the token is not used yet
+	r, err := c.retryCall(ctx, *c.BaseURL.ResolveReference(&sessionPath), rpc.Request{
+		Method: "newSession",
+	}, c.Retry.Steps)
+	if err != nil {
+		return "", fmt.Errorf("Error creating session: %w", err)
+	}
+	res := &newSessionResult{}
+	if err := unmarshallResult(r, res); err != nil {
+		return "", fmt.Errorf("Error creating session: %w", err)
+	}
+	if res.Token == "" {
+		return "", errors.New("Error creating session: no token returned")
+	}
+	s.token = res.Token
+	s.timeout = time.Duration(res.Session.Timeout) * time.Second
+	s.touch()
+	return s.token, nil
+}
+
+// touch extends the idle timeout of the session after it was used, must hold mux
+func (s *Session) touch() {
+	if s.timeout > 0 {
+		s.expires = time.Now().Add(s.timeout)
+	} else {
+		s.expires = time.Time{}
+	}
+}
+
+// used extends the session if token is still current
+func (s *Session) used(token string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.token == token {
+		s.touch()
+	}
+}
+
+// invalidate token unless a new session has been created meanwhile
+func (s *Session) invalidate(token string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// sessionCall performs the call with the session token, logging in again once if it was rejected.
+// A rejected call was not performed, so it is repeated even if retries is 0.
+func (c *Client) sessionCall(ctx context.Context, url url.URL, req rpc.Request, retries int) (*rpc.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := c.Session.current(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		r, err := c.retryCall(rpc.WithSessionToken(ctx, token), url, req, retries)
+		var se rpc.StatusError
+		if attempt == 0 && errors.As(err, &se) && se.StatusCode == http.StatusUnauthorized {
+			c.Session.invalidate(token)
+			continue
+		}
+		if err == nil {
+			c.Session.used(token)
+		}
+		return r, err
+	}
+}
+
+// Logout closes the session, if any. Calls afterwards create a new session.
+func (c *Client) Logout(ctx context.Context) error {
+	if c.Session == nil {
+		return nil
+	}
+	c.Session.mux.Lock()
+	token := c.Session.token
+	expired := !c.Session.expires.IsZero() && time.Now().After(c.Session.expires)
+	c.Session.token = ""
+	c.Session.mux.Unlock()
+	if token == "" || expired {
+		return nil
+	}
+
+	r, err := c.RPCClient.Call(rpc.WithSessionToken(ctx, token), *c.BaseURL.ResolveReference(&sessionPath), rpc.Request{
+		Method: "closeCurrentSession",
+	})
+	var se rpc.StatusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusUnauthorized {
+		// closed by the PDU already
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error closing session: %w", err)
+	}
+	if r.IsError() {
+		return fmt.Errorf("Error closing session: %w", r.Error)
+	}
+	return nil
+}
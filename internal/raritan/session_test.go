@@ -0,0 +1,253 @@
+package raritan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
+
+// sessionServer is a PDU accepting basic auth only for newSession and session tokens
+// for everything else, recording the requests as "method auth"
+type sessionServer struct {
+	*httptest.Server
+	mux      sync.Mutex
+	tokens   map[string]bool
+	next     int
+	requests []string
+	// timeout of new sessions in seconds, reject makes every token invalid
+	timeout int
+	reject  bool
+}
+
+func newSessionServer(t *testing.T) *sessionServer {
+	s := &sessionServer{tokens: map[string]bool{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := rpc.Request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		token := r.Header.Get("X-SessionToken")
+		auth := "token " + token
+		if token == "" {
+			user, pass, _ := r.BasicAuth()
+			auth = "basic " + user + ":" + pass
+		}
+		s.requests = append(s.requests, req.Method+" "+auth)
+
+		switch {
+		case req.Method == "newSession":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "test" || pass != "test" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			s.next++
+			token := fmt.Sprintf("t%d", s.next)
+			s.tokens[token] = true
+			fmt.Fprintf(w, `{"result":{"session":{"timeout":%d},"token":%q}}`, s.timeout, token)
+		case !s.tokens[token] || s.reject:
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case req.Method == "closeCurrentSession":
+			delete(s.tokens, token)
+			fmt.Fprint(w, `{"result":{"_ret_":0}}`)
+		default:
+			fmt.Fprint(w, `{"result":{"_ret_":[]}}`)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Requests so far, which are cleared
+func (s *sessionServer) Requests() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	rs := s.requests
+	s.requests = nil
+	return rs
+}
+
+// Expire all sessions, as a reboot of the PDU does
+func (s *sessionServer) Expire() {
+	s.mux.Lock()
+	s.tokens = map[string]bool{}
+	s.mux.Unlock()
+}
+
+func newSessionClient(t *testing.T, s *sessionServer, password string) *Client {
+	t.Helper()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{
+		RPCClient: rpc.NewClient(5*time.Second, rpc.Auth{Username: "test", Password: password}, nil),
+		BaseURL:   *u,
+		Session:   &Session{},
+	}
+}
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		password string
+		timeout  int
+		// prepare the server and client after a first call
+		prepare func(s *sessionServer, c *Client)
+		// requests of the second call
+		requests []string
+		err      string
+	}{
+		{
+			name:     "token reused",
+			requests: []string{"getOutlets token t1"},
+		},
+		{
+			name:     "expired on the PDU",
+			prepare:  func(s *sessionServer, c *Client) { s.Expire() },
+			requests: []string{"getOutlets token t1", "newSession basic test:test", "getOutlets token t2"},
+		},
+		{
+			name:    "idle timeout passed",
+			timeout: 60,
+			prepare: func(s *sessionServer, c *Client) {
+				c.Session.expires = time.Now().Add(-time.Second)
+			},
+			requests: []string{"newSession basic test:test", "getOutlets token t2"},
+		},
+		{
+			name:     "rejected after login",
+			prepare:  func(s *sessionServer, c *Client) { s.reject = true },
+			requests: []string{"getOutlets token t1", "newSession basic test:test", "getOutlets token t2"},
+			err:      "401 Unauthorized",
+		},
+		{
+			name:     "logout",
+			prepare:  func(s *sessionServer, c *Client) { c.Logout(ctx) },
+			requests: []string{"newSession basic test:test", "getOutlets token t2"},
+		},
+		{
+			name:     "login failed",
+			password: "wrong",
+			requests: []string{"newSession basic test:wrong"},
+			err:      "Error creating session: 401 Unauthorized",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSessionServer(t)
+			s.timeout = tt.timeout
+			password := tt.password
+			if password == "" {
+				password = "test"
+			}
+			c := newSessionClient(t, s, password)
+
+			_, err := c.GetPDUOutlets(ctx)
+			if tt.password == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := []string{"newSession basic test:test", "getOutlets token t1"}
+				if rs := s.Requests(); fmt.Sprint(rs) != fmt.Sprint(want) {
+					t.Fatalf("first call requests = %q, want %q", rs, want)
+				}
+			}
+			s.Requests()
+			if tt.prepare != nil {
+				tt.prepare(s, c)
+				s.Requests()
+			}
+
+			_, err = c.GetPDUOutlets(ctx)
+			if tt.err == "" && err != nil {
+				t.Errorf("err = %v", err)
+			} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("err = %v, want %s", err, tt.err)
+			}
+			if rs := s.Requests(); fmt.Sprint(rs) != fmt.Sprint(tt.requests) {
+				t.Errorf("requests = %q, want %q", rs, tt.requests)
+			}
+		})
+	}
+}
+
+func TestSessionConcurrentLogin(t *testing.T) {
+	s := newSessionServer(t)
+	c := newSessionClient(t, s, "test")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// copies of the client share the session
+			c := *c
+			_, err := c.GetPDUOutlets(context.Background())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	logins := 0
+	for _, r := range s.Requests() {
+		if r == "newSession basic test:test" {
+			logins++
+		}
+	}
+	if logins != 1 {
+		t.Errorf("%d logins, want 1", logins)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	s := newSessionServer(t)
+	c := newSessionClient(t, s, "test")
+
+	// no session yet
+	if err := c.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rs := s.Requests(); len(rs) > 0 {
+		t.Errorf("requests = %q, want none", rs)
+	}
+
+	if _, err := c.GetPDUOutlets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Requests()
+	if err := c.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rs := s.Requests(); fmt.Sprint(rs) != fmt.Sprint([]string{"closeCurrentSession token t1"}) {
+		t.Errorf("requests = %q, want closeCurrentSession with t1", rs)
+	}
+
+	// a session closed by the PDU already is not an error
+	if _, err := c.GetPDUOutlets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Expire()
+	if err := c.Logout(ctx); err != nil {
+		t.Errorf("logout of expired session: %v", err)
+	}
+}
@@ -1,6 +1,10 @@
 package raritan
 
-import "github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+import (
+	"context"
+
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/rpc"
+)
 
 var (
 	snmpPath = mustURL("/snmp")
@@ -23,7 +27,7 @@ type SNMPConfiguration struct {
 }
 
 // GetSNMPInfo returns SNMP info
-func (c *Client) GetSNMPInfo() (*SNMPInfo, error) {
+func (c *Client) GetSNMPInfo(ctx context.Context) (*SNMPInfo, error) {
 	snmpConfig := &SNMPConfiguration{}
 	reqs := []bulkRequest{
 		{
@@ -35,7 +39,7 @@ func (c *Client) GetSNMPInfo() (*SNMPInfo, error) {
 		},
 	}
 
-	if _, err := c.bulkCall(reqs); err != nil {
+	if _, err := c.bulkCall(ctx, reqs); err != nil {
 		return nil, err
 	}
 	return &SNMPInfo{
@@ -0,0 +1,287 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNoInteraction is returned on replay for requests missing from the cassette
+var ErrNoInteraction = errors.New("no recorded interaction")
+
+// Interaction is a request with its response or error, cassettes hold one per line as JSON
+type Interaction struct {
+	Time time.Time `json:"time"`
+	// Path of the endpoint, the host is not recorded so cassettes replay against any address
+	Path     string    `json:"path"`
+	Request  Request   `json:"request"`
+	Response *Response `json:"response,omitempty"`
+	// Error of the call if there was no response
+	Error string `json:"error,omitempty"`
+	// StatusCode of the HTTP response if the error is a non 2xx status
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+func (i Interaction) key() (string, error) {
+	params, err := json.Marshal(i.Request.Params)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s", i.Path, i.Request.Method, params), nil
+}
+
+type recorder struct {
+	Client
+	path string
+	mux  sync.Mutex
+}
+
+// NewRecorder wraps an RPC client to append every call to the cassette file at path
+func NewRecorder(c Client, path string) Client {
+	return &recorder{
+		Client: c,
+		path:   path,
+	}
+}
+
+func (r *recorder) Call(ctx context.Context, u url.URL, req Request) (*Response, error) {
+	res, err := r.Client.Call(ctx, u, req)
+	// cancelled calls say nothing about the PDU
+	if ctx.Err() != nil {
+		return res, err
+	}
+
+	i := Interaction{
+		Time:     time.Now().UTC(),
+		Path:     u.Path,
+		Request:  req,
+		Response: res,
+	}
+	if err != nil {
+		i.Error = err.Error()
+		se := StatusError{}
+		if errors.As(err, &se) {
+			i.StatusCode = se.StatusCode
+		}
+	}
+	if werr := r.write(i); werr != nil {
+		return nil, fmt.Errorf("Error recording interaction: %w", werr)
+	}
+	return res, err
+}
+
+func (r *recorder) write(i Interaction) error {
+	bs, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(bs, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadCassette reads all interactions from a cassette file
+func LoadCassette(path string) ([]Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening cassette: %w", err)
+	}
+	defer f.Close()
+
+	is := []Interaction{}
+	s := bufio.NewScanner(f)
+	// bulk responses of large PDUs easily exceed the default token size
+	s.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		i := Interaction{}
+		if err := json.Unmarshal(s.Bytes(), &i); err != nil {
+			return nil, fmt.Errorf("Error parsing cassette %s line %d: %w", path, line, err)
+		}
+		is = append(is, i)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading cassette %s: %w", path, err)
+	}
+	return is, nil
+}
+
+// Replayer answers calls from recorded interactions. Identical requests are answered in
+// recorded order, the last one is repeated once all have been replayed. Bulk requests
+// not recorded as a whole are answered from the single requests of recorded bulk calls.
+type Replayer struct {
+	mux          sync.Mutex
+	interactions map[string][]Interaction
+	bulk         map[string][]bulkEntry
+	next         map[string]int
+}
+
+// bulkMethod of the Raritan bulk endpoint
+const bulkMethod = "performBulk"
+
+type bulkParams struct {
+	Requests []struct {
+		RID  string  `json:"rid"`
+		JSON Request `json:"json"`
+	} `json:"requests"`
+}
+
+type bulkEntry struct {
+	StatCode int
+	JSON     *Response
+}
+
+type bulkResult struct {
+	Responses []bulkEntry
+}
+
+// bulkKey of a single request in a bulk call, distinct from the keys of direct calls
+func bulkKey(rid string, req Request) (string, error) {
+	k, err := Interaction{Path: rid, Request: req}.key()
+	return "bulk " + k, err
+}
+
+// decodeBulk converts bulk params of any type by a round trip through JSON
+func decodeBulk(params interface{}, ret interface{}) error {
+	bs, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, ret)
+}
+
+// NewReplayer for the interactions of a cassette
+func NewReplayer(is []Interaction) (*Replayer, error) {
+	r := &Replayer{
+		interactions: map[string][]Interaction{},
+		bulk:         map[string][]bulkEntry{},
+		next:         map[string]int{},
+	}
+	for _, i := range is {
+		k, err := i.key()
+		if err != nil {
+			return nil, err
+		}
+		r.interactions[k] = append(r.interactions[k], i)
+
+		if i.Request.Method != bulkMethod || i.Response == nil || i.Response.Result == nil {
+			continue
+		}
+		params := bulkParams{}
+		if err := decodeBulk(i.Request.Params, &params); err != nil {
+			return nil, fmt.Errorf("Error decoding recorded bulk request: %w", err)
+		}
+		res := bulkResult{}
+		if err := json.Unmarshal(*i.Response.Result, &res); err != nil {
+			return nil, fmt.Errorf("Error decoding recorded bulk response: %w", err)
+		}
+		if len(res.Responses) != len(params.Requests) {
+			continue
+		}
+		for j, req := range params.Requests {
+			k, err := bulkKey(req.RID, req.JSON)
+			if err != nil {
+				return nil, err
+			}
+			r.bulk[k] = append(r.bulk[k], res.Responses[j])
+		}
+	}
+	return r, nil
+}
+
+// advance returns the index to replay for k out of n recordings
+func (r *Replayer) advance(k string, n int) int {
+	i := r.next[k]
+	if i < n-1 {
+		r.next[k] = i + 1
+	}
+	return i
+}
+
+// Call returns the recorded response for the path and request of the call
+func (r *Replayer) Call(ctx context.Context, u url.URL, req Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	k, err := Interaction{Path: u.Path, Request: req}.key()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	is, ok := r.interactions[k]
+	if !ok {
+		if req.Method == bulkMethod {
+			return r.replayBulk(u, req)
+		}
+		return nil, fmt.Errorf("%w for %s method %s", ErrNoInteraction, u.Path, req.Method)
+	}
+
+	i := is[r.advance(k, len(is))]
+	if i.StatusCode != 0 {
+		return nil, StatusError{
+			StatusCode: i.StatusCode,
+			Status:     i.Error,
+		}
+	}
+	if i.Error != "" {
+		return nil, errors.New(i.Error)
+	}
+	return i.Response, nil
+}
+
+// replayBulk composes a bulk response from recorded single requests
+func (r *Replayer) replayBulk(u url.URL, req Request) (*Response, error) {
+	params := bulkParams{}
+	if err := decodeBulk(req.Params, &params); err != nil {
+		return nil, fmt.Errorf("Error decoding bulk request: %w", err)
+	}
+
+	res := bulkResult{
+		Responses: make([]bulkEntry, len(params.Requests)),
+	}
+	for j, br := range params.Requests {
+		k, err := bulkKey(br.RID, br.JSON)
+		if err != nil {
+			return nil, err
+		}
+		es, ok := r.bulk[k]
+		if !ok {
+			return nil, fmt.Errorf("%w for %s method %s in bulk request", ErrNoInteraction, br.RID, br.JSON.Method)
+		}
+		res.Responses[j] = es[r.advance(k, len(es))]
+	}
+
+	bs, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	result := json.RawMessage(bs)
+	return &Response{
+		Result: &result,
+	}, nil
+}
+
+// BatchCall fails as batch calls are not recorded
+func (r *Replayer) BatchCall(ctx context.Context, u url.URL, reqs []Request) ([]Response, error) {
+	return nil, fmt.Errorf("%w for batch call to %s", ErrNoInteraction, u.Path)
+}
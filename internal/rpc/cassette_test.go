@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayerCall(t *testing.T) {
+	result := json.RawMessage(`{"_ret_":1}`)
+	is := []Interaction{
+		{Path: "/model/pdu/0", Request: Request{Method: "getMetaData"}, Response: &Response{Result: &result}},
+		{Path: "/model/pdu/0", Request: Request{Method: "getSettings"}, Error: "connection refused"},
+		{Path: "/model/pdu/0", Request: Request{Method: "getInlets"}, Error: "401 Unauthorized", StatusCode: 401},
+	}
+	r, err := NewReplayer(is)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		result string
+		status int
+		err    string
+	}{
+		{name: "response", method: "getMetaData", result: `{"_ret_":1}`},
+		{name: "error", method: "getSettings", err: "connection refused"},
+		{name: "status error", method: "getInlets", status: 401, err: "401 Unauthorized"},
+		{name: "missing", method: "getOutlets", err: "no recorded interaction for /model/pdu/0 method getOutlets"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := r.Call(context.Background(), url.URL{Path: "/model/pdu/0"}, Request{Method: tt.method})
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("err = %v, want %s", err, tt.err)
+				}
+				se := StatusError{}
+				if errors.As(err, &se) != (tt.status != 0) || se.StatusCode != tt.status {
+					t.Errorf("status = %d, want %d", se.StatusCode, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(*res.Result) != tt.result {
+				t.Errorf("result = %s, want %s", *res.Result, tt.result)
+			}
+		})
+	}
+}
+
+type statusClient struct {
+	Client
+	err error
+}
+
+func (c statusClient) Call(ctx context.Context, u url.URL, req Request) (*Response, error) {
+	return nil, c.err
+}
+
+func TestRecorderStatusError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+	rec := NewRecorder(statusClient{err: StatusError{StatusCode: 503, Status: "503 Service Unavailable"}}, path)
+	if _, err := rec.Call(context.Background(), url.URL{Path: "/bulk"}, Request{Method: "performBulk"}); err == nil {
+		t.Fatal("recorded call succeeded")
+	}
+
+	is, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(is) != 1 || is[0].StatusCode != 503 || is[0].Error != "503 Service Unavailable" {
+		t.Fatalf("interactions = %+v, want one with status 503", is)
+	}
+}
+
+func TestReplayerBatchCall(t *testing.T) {
+	r, err := NewReplayer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.BatchCall(context.Background(), url.URL{Path: "/bulk"}, []Request{{Method: "getMetaData"}}); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("err = %v, want ErrNoInteraction", err)
+	}
+}
@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -13,8 +14,8 @@ import (
 
 // Client for calling JSON RPC endpoints
 type Client interface {
-	Call(url.URL, Request) (*Response, error)
-	BatchCall(url.URL, []Request) ([]Response, error)
+	Call(context.Context, url.URL, Request) (*Response, error)
+	BatchCall(context.Context, url.URL, []Request) ([]Response, error)
 }
 
 // Request RPC attributes
@@ -47,12 +48,29 @@ func (e Error) Error() string {
 	return fmt.Sprintf("RPC Error, Code: %d, \"%s\", Data: %v", e.Code, e.Message, e.Data)
 }
 
+// StatusError for responses with a non 2xx HTTP status
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e StatusError) Error() string {
+	return e.Status
+}
+
 // Auth settings for requests
 type Auth struct {
 	Username string
 	Password string
 }
 
+type sessionTokenKey struct{}
+
+// WithSessionToken authenticates calls with ctx by the session token instead of basic auth
+func WithSessionToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, sessionTokenKey{}, token)
+}
+
 // Body contains standard RPC body fields
 type Body struct {
 	Version string `json:"jsonrpc"`
@@ -85,20 +103,20 @@ func (r Response) IsError() bool {
 	return r.Error != nil
 }
 
-// NewClient returns a new JSON RPC client
-func NewClient(timeout time.Duration, auth Auth) Client {
+// NewClient returns a new JSON RPC client, tlsConfig nil verifies with the system roots
+func NewClient(timeout time.Duration, auth Auth, tlsConfig *tls.Config) Client {
 	return &client{
 		httpClient: &http.Client{
 			Timeout: timeout,
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				TLSClientConfig: tlsConfig,
 			},
 		},
 		auth: auth,
 	}
 }
 
-func (c *client) Call(url url.URL, req Request) (*Response, error) {
+func (c *client) Call(ctx context.Context, url url.URL, req Request) (*Response, error) {
 	bs, err := json.Marshal(request{
 		Request: req,
 		Body: Body{
@@ -109,11 +127,15 @@ func (c *client) Call(url url.URL, req Request) (*Response, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error marshalling JSON: %w", err)
 	}
-	r, err := http.NewRequest("POST", url.String(), bytes.NewReader(bs))
+	r, err := http.NewRequestWithContext(ctx, "POST", url.String(), bytes.NewReader(bs))
 	if err != nil {
 		return nil, fmt.Errorf("Error creating JSON RPC request: %w", err)
 	}
-	r.SetBasicAuth(c.auth.Username, c.auth.Password)
+	if token, ok := ctx.Value(sessionTokenKey{}).(string); ok {
+		r.Header.Set("X-SessionToken", token)
+	} else {
+		r.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
 
 	res, err := c.httpClient.Do(r)
 	if err != nil {
@@ -122,7 +144,10 @@ func (c *client) Call(url url.URL, req Request) (*Response, error) {
 	defer res.Body.Close()
 
 	if res.StatusCode < 200 || res.StatusCode > 299 {
-		return nil, fmt.Errorf("%s", res.Status)
+		return nil, StatusError{
+			StatusCode: res.StatusCode,
+			Status:     res.Status,
+		}
 	}
 
 	response := &Response{}
@@ -133,7 +158,7 @@ func (c *client) Call(url url.URL, req Request) (*Response, error) {
 	return response, nil
 }
 
-func (c *client) BatchCall(url url.URL, reqs []Request) ([]Response, error) {
+func (c *client) BatchCall(ctx context.Context, url url.URL, reqs []Request) ([]Response, error) {
 	// Raritan RPC doesn't support the standardised batch call so I haven't written it
 	panic("Not implemented")
 }
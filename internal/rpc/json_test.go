@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClientCallContext(t *testing.T) {
+	// the server holds requests until they are cancelled or the test ends
+	cancelled := make(chan struct{}, 1)
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server only notices a closed connection once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		// cancel the context after the request is sent
+		cancelAfter time.Duration
+		err         error
+		// sent is false if the request must not reach the server
+		sent bool
+	}{
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			err:  context.DeadlineExceeded,
+			sent: true,
+		},
+		{
+			name:        "cancelled in flight",
+			ctx:         func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			cancelAfter: 50 * time.Millisecond,
+			err:         context.Canceled,
+			sent:        true,
+		},
+		{
+			name: "cancelled before",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			err: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the client timeout is far beyond the test, only the context ends the call
+			c := NewClient(time.Minute, Auth{}, nil)
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+			_, err := c.Call(ctx, *u, Request{Method: "getReading"})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("call returned after %s", d)
+			}
+
+			select {
+			case <-cancelled:
+				if !tt.sent {
+					t.Error("request reached the server")
+				}
+			case <-time.After(time.Second):
+				if tt.sent {
+					t.Error("server did not see the request cancelled")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+// Package snmp reads Raritan PDUs with SNMP using the PDU2-MIB, as an alternative to JSON-RPC
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+// SNMP versions
+const (
+	Version2c = "2c"
+	Version3  = "3"
+)
+
+var (
+	authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+		"":       gosnmp.NoAuth,
+		"MD5":    gosnmp.MD5,
+		"SHA":    gosnmp.SHA,
+		"SHA224": gosnmp.SHA224,
+		"SHA256": gosnmp.SHA256,
+		"SHA384": gosnmp.SHA384,
+		"SHA512": gosnmp.SHA512,
+	}
+	privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+		"":        gosnmp.NoPriv,
+		"DES":     gosnmp.DES,
+		"AES":     gosnmp.AES,
+		"AES192":  gosnmp.AES192,
+		"AES256":  gosnmp.AES256,
+		"AES192C": gosnmp.AES192C,
+		"AES256C": gosnmp.AES256C,
+	}
+)
+
+// Config of the SNMP agent of a PDU
+type Config struct {
+	Port uint16
+	// Version is 2c or 3
+	Version   string
+	Community string
+	// Username, protocols and passwords for SNMPv3, empty protocols disable authentication or privacy
+	Username     string
+	AuthProtocol string
+	AuthPassword string
+	PrivProtocol string
+	PrivPassword string
+	ContextName  string
+	// PDUID of the PDU within a daisy chain, 1 for the first
+	PDUID int
+	// Timeout of a request, Retries after a timeout
+	Timeout time.Duration
+	Retries int
+}
+
+// Client reads a PDU over SNMP. Safe for concurrent use, requests are sent one at a time.
+type Client struct {
+	host string
+	conf Config
+
+	mux       sync.Mutex
+	snmp      *gosnmp.GoSNMP
+	connected bool
+}
+
+var _ raritan.Backend = &Client{}
+
+// NewClient for the agent on host
+func NewClient(host string, conf Config) (*Client, error) {
+	g := &gosnmp.GoSNMP{
+		Target:             host,
+		Port:               conf.Port,
+		Transport:          "udp",
+		Community:          conf.Community,
+		Timeout:            conf.Timeout,
+		Retries:            conf.Retries,
+		ExponentialTimeout: false,
+		MaxOids:            gosnmp.MaxOids,
+		ContextName:        conf.ContextName,
+	}
+	if g.Port == 0 {
+		g.Port = 161
+	}
+
+	switch conf.Version {
+	case Version2c, "":
+		g.Version = gosnmp.Version2c
+	case Version3:
+		auth, ok := authProtocols[strings.ToUpper(conf.AuthProtocol)]
+		if !ok {
+			return nil, fmt.Errorf("unknown auth protocol %q", conf.AuthProtocol)
+		}
+		priv, ok := privProtocols[strings.ToUpper(conf.PrivProtocol)]
+		if !ok {
+			return nil, fmt.Errorf("unknown privacy protocol %q", conf.PrivProtocol)
+		}
+		if conf.Username == "" {
+			return nil, fmt.Errorf("username is required for SNMPv3")
+		}
+		g.Version = gosnmp.Version3
+		g.SecurityModel = gosnmp.UserSecurityModel
+		switch {
+		case auth == gosnmp.NoAuth && priv != gosnmp.NoPriv:
+			return nil, fmt.Errorf("privacy requires an auth protocol")
+		case priv != gosnmp.NoPriv:
+			g.MsgFlags = gosnmp.AuthPriv
+		case auth != gosnmp.NoAuth:
+			g.MsgFlags = gosnmp.AuthNoPriv
+		default:
+			g.MsgFlags = gosnmp.NoAuthNoPriv
+		}
+		g.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 conf.Username,
+			AuthenticationProtocol:   auth,
+			AuthenticationPassphrase: conf.AuthPassword,
+			PrivacyProtocol:          priv,
+			PrivacyPassphrase:        conf.PrivPassword,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %q", conf.Version)
+	}
+
+	if conf.PDUID == 0 {
+		conf.PDUID = 1
+	}
+	return &Client{
+		host: host,
+		conf: conf,
+		snmp: g,
+	}, nil
+}
+
+// Address of the agent
+func (c *Client) Address() string {
+	return "snmp://" + net.JoinHostPort(c.host, strconv.Itoa(int(c.snmp.Port)))
+}
+
+// do runs f with the connection while holding the lock, requests are cancelled with ctx
+func (c *Client) do(ctx context.Context, f func(g *gosnmp.GoSNMP) error) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.snmp.Context = ctx
+	if !c.connected {
+		if err := c.snmp.Connect(); err != nil {
+			return fmt.Errorf("Error connecting to %s: %w", c.Address(), err)
+		}
+		c.connected = true
+	}
+	err := f(c.snmp)
+	c.snmp.Context = context.Background()
+	return err
+}
+
+// get the values of oids, missing ones are nil
+func (c *Client) get(ctx context.Context, oids []string) (map[string]*gosnmp.SnmpPDU, error) {
+	vals := make(map[string]*gosnmp.SnmpPDU, len(oids))
+	err := c.do(ctx, func(g *gosnmp.GoSNMP) error {
+		for start := 0; start < len(oids); start += g.MaxOids {
+			end := start + g.MaxOids
+			if end > len(oids) {
+				end = len(oids)
+			}
+			res, err := g.Get(oids[start:end])
+			if err != nil {
+				return fmt.Errorf("Error getting %d OIDs from %s: %w", end-start, c.Address(), err)
+			}
+			if res.Error != gosnmp.NoError {
+				return fmt.Errorf("Error getting %d OIDs from %s: %s at %d", end-start, c.Address(), res.Error, res.ErrorIndex)
+			}
+			for i := range res.Variables {
+				v := res.Variables[i]
+				if v.Type == gosnmp.NoSuchObject || v.Type == gosnmp.NoSuchInstance || v.Type == gosnmp.Null {
+					continue
+				}
+				vals[normalizeOID(v.Name)] = &v
+			}
+		}
+		return nil
+	})
+	return vals, err
+}
+
+// walk the subtree of oid, results are keyed by the index after oid
+func (c *Client) walk(ctx context.Context, oid string) (map[string]gosnmp.SnmpPDU, error) {
+	vals := map[string]gosnmp.SnmpPDU{}
+	err := c.do(ctx, func(g *gosnmp.GoSNMP) error {
+		return g.BulkWalk(oid, func(v gosnmp.SnmpPDU) error {
+			name := normalizeOID(v.Name)
+			if strings.HasPrefix(name, oid+".") {
+				vals[strings.TrimPrefix(name, oid+".")] = v
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error walking %s on %s: %w", oid, c.Address(), err)
+	}
+	return vals, nil
+}
+
+// ConnectionCheck reads the uptime of the agent
+func (c *Client) ConnectionCheck(ctx context.Context) error {
+	vals, err := c.get(ctx, []string{OIDSysUpTime})
+	if err != nil {
+		return err
+	}
+	if vals[OIDSysUpTime] == nil {
+		return fmt.Errorf("no sysUpTime from %s", c.Address())
+	}
+	return nil
+}
+
+func normalizeOID(oid string) string {
+	if !strings.HasPrefix(oid, ".") {
+		return "." + oid
+	}
+	return oid
+}
+
+// oid joins an OID with indexes
+func oid(base string, idx ...int) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, i := range idx {
+		b.WriteByte('.')
+		b.WriteString(strconv.Itoa(i))
+	}
+	return b.String()
+}
+
+// parseIndex of a table entry into its numbers
+func parseIndex(idx string) ([]int, error) {
+	parts := strings.Split(idx, ".")
+	is := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index %q: %w", idx, err)
+		}
+		is[i] = n
+	}
+	return is, nil
+}
+
+func toInt(v *gosnmp.SnmpPDU) int64 {
+	if v == nil {
+		return 0
+	}
+	return gosnmp.ToBigInt(v.Value).Int64()
+}
+
+func toString(v *gosnmp.SnmpPDU) string {
+	if v == nil {
+		return ""
+	}
+	switch b := v.Value.(type) {
+	case []byte:
+		return string(b)
+	case string:
+		return b
+	default:
+		return fmt.Sprint(v.Value)
+	}
+}
+
+func toBytes(v *gosnmp.SnmpPDU) []byte {
+	if v == nil {
+		return nil
+	}
+	if b, ok := v.Value.([]byte); ok {
+		return b
+	}
+	return nil
+}
+
+// scaled value of an integer with decimal digits
+func scaled(v *gosnmp.SnmpPDU, digits int) float64 {
+	f, _ := new(big.Float).SetInt(gosnmp.ToBigInt(v.Value)).Float64()
+	return f / math.Pow10(digits)
+}
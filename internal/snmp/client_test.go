@@ -0,0 +1,65 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestScaled(t *testing.T) {
+	tests := []struct {
+		name   string
+		pdu    gosnmp.SnmpPDU
+		digits int
+		want   float64
+	}{
+		{name: "gauge", pdu: gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint(5125)}, digits: 3, want: 5.125},
+		{name: "no digits", pdu: gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint(230)}, want: 230},
+		{name: "negative integer", pdu: gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: -55}, digits: 1, want: -5.5},
+		{name: "gauge above int32", pdu: gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint(4294967295)}, digits: 2, want: 42949672.95},
+		{name: "counter64", pdu: gosnmp.SnmpPDU{Type: gosnmp.Counter64, Value: uint64(1 << 40)}, want: 1 << 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scaled(&tt.pdu, tt.digits); got != tt.want {
+				t.Errorf("scaled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		rid string
+		// config OID of the units column for PDU 1, empty for invalid ids
+		oid string
+	}{
+		{rid: "inlet/1/4", oid: OIDInletSensorConfiguration + ".6.1.1.4"},
+		{rid: "pole/1/2/1", oid: OIDInletPoleSensorConfiguration + ".6.1.1.2.1"},
+		{rid: "outlet/12/14", oid: OIDOutletSensorConfiguration + ".6.1.12.14"},
+		// external sensors are indexed by their id only
+		{rid: "external/3/10", oid: OIDExternalSensorConfiguration + ".16.1.3"},
+		{rid: "outlet/1", oid: OIDOutletSensorConfiguration + ".6.1.1"},
+		{rid: "pole/1"},
+		{rid: "outlet/x/1"},
+		{rid: "fan/1"},
+		{rid: "inlet/1/4/2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rid, func(t *testing.T) {
+			r, err := parseRef(tt.rid)
+			if tt.oid == "" {
+				if err == nil {
+					t.Fatalf("ref = %+v, want an error", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.configOID(1, r.columns.Units); got != tt.oid {
+				t.Errorf("config OID = %s, want %s", got, tt.oid)
+			}
+		})
+	}
+}
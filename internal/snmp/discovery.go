@@ -0,0 +1,642 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+// Resource types of the resources returned by the client, sensors are typed by their
+// JSON-RPC interface so the registered sensor kinds apply
+const (
+	ResourceInlet         = "snmp.Inlet"
+	ResourceInletPole     = "snmp.InletPole"
+	ResourceOCP           = "snmp.OverCurrentProtector"
+	ResourceOutlet        = "snmp.Outlet"
+	ResourceExternal      = "snmp.ExternalSensor"
+	ResourceNumericSensor = "sensors.NumericSensor"
+	ResourceStateSensor   = "sensors.StateSensor"
+)
+
+// component table of the PDU2-MIB. Resource ids are "<kind>/<id>" for components and
+// "<kind>/<id>/<sensorType>" for their sensors, poles are identified by "pole/<inletId>/<poleIndex>".
+type component struct {
+	kind         string
+	resType      string
+	label        string
+	name         string
+	config       string
+	measurements string
+	columns      SensorColumns
+}
+
+var (
+	inlets = component{
+		kind:         "inlet",
+		resType:      ResourceInlet,
+		label:        OIDInletLabel,
+		name:         OIDInletName,
+		config:       OIDInletSensorConfiguration,
+		measurements: OIDInletSensorMeasurements,
+		columns:      ComponentSensorColumns,
+	}
+	// poles are listed by their line, they have no label or name
+	poles = component{
+		kind:         "pole",
+		resType:      ResourceInletPole,
+		label:        OIDInletPoleLine,
+		config:       OIDInletPoleSensorConfiguration,
+		measurements: OIDInletPoleSensorMeasurements,
+		columns:      ComponentSensorColumns,
+	}
+	ocps = component{
+		kind:         "ocp",
+		resType:      ResourceOCP,
+		label:        OIDOCPLabel,
+		name:         OIDOCPName,
+		config:       OIDOCPSensorConfiguration,
+		measurements: OIDOCPSensorMeasurements,
+		columns:      ComponentSensorColumns,
+	}
+	outlets = component{
+		kind:         "outlet",
+		resType:      ResourceOutlet,
+		label:        OIDOutletLabel,
+		name:         OIDOutletName,
+		config:       OIDOutletSensorConfiguration,
+		measurements: OIDOutletSensorMeasurements,
+		columns:      ComponentSensorColumns,
+	}
+	// externals are configured in a single table with a row per sensor
+	externals = component{
+		kind:         "external",
+		resType:      ResourceExternal,
+		config:       OIDExternalSensorConfiguration,
+		measurements: OIDExternalSensorMeasurements,
+		columns:      ExternalSensorColumns,
+	}
+
+	components = map[string]component{
+		inlets.kind:    inlets,
+		poles.kind:     poles,
+		ocps.kind:      ocps,
+		outlets.kind:   outlets,
+		externals.kind: externals,
+	}
+)
+
+// ref to a component or sensor, parsed from its resource id
+type ref struct {
+	component
+	id int
+	// pole index of inlet poles, id is that of their inlet
+	pole int
+	// sensorType of sensors, 0 for components
+	sensorType int
+}
+
+// ids of a component in its resource id, poles have the inlet and pole index
+func (c component) ids() int {
+	if c.kind == poles.kind {
+		return 2
+	}
+	return 1
+}
+
+func parseRef(rid string) (ref, error) {
+	parts := strings.Split(rid, "/")
+	c, ok := components[parts[0]]
+	if !ok || len(parts) < c.ids()+1 || len(parts) > c.ids()+2 {
+		return ref{}, fmt.Errorf("invalid resource id %q", rid)
+	}
+	ids := make([]int, len(parts)-1)
+	for i, p := range parts[1:] {
+		var err error
+		if ids[i], err = strconv.Atoi(p); err != nil {
+			return ref{}, fmt.Errorf("invalid resource id %q", rid)
+		}
+	}
+	r := ref{component: c, id: ids[0]}
+	if c.kind == poles.kind {
+		r.pole = ids[1]
+	}
+	if len(ids) > c.ids() {
+		r.sensorType = ids[c.ids()]
+	}
+	return r, nil
+}
+
+// index of the ref in the tables of its component
+func (r ref) index(pduID int) []int {
+	idx := []int{pduID, r.id}
+	if r.kind == poles.kind {
+		idx = append(idx, r.pole)
+	}
+	if r.sensorType == 0 || r.kind == externals.kind {
+		return idx
+	}
+	return append(idx, r.sensorType)
+}
+
+// configOID of a column of the sensor configuration entry
+func (r ref) configOID(pduID, column int) string {
+	return oid(r.config+"."+strconv.Itoa(column), r.index(pduID)...)
+}
+
+// measurementOID of a column of the sensor measurement entry
+func (r ref) measurementOID(pduID, column int) string {
+	return oid(r.measurements+"."+strconv.Itoa(column), r.index(pduID)...)
+}
+
+func componentRID(kind string, ids ...int) string {
+	rid := kind
+	for _, id := range ids {
+		rid += "/" + strconv.Itoa(id)
+	}
+	return rid
+}
+
+func sensorResource(component string, sensorType int) raritan.Resource {
+	t := ResourceStateSensor
+	if SensorTypes[sensorType].Numeric {
+		t = ResourceNumericSensor
+	}
+	return raritan.Resource{
+		RID:  component + "/" + strconv.Itoa(sensorType),
+		Type: t,
+	}
+}
+
+// list the components of the PDU by walking the label column, ordered by id
+func (c *Client) list(ctx context.Context, comp component) ([]raritan.Resource, error) {
+	vals, err := c.walk(ctx, comp.label)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for idx := range vals {
+		is, err := parseIndex(idx)
+		if err != nil || len(is) != 2 || is[0] != c.conf.PDUID {
+			continue
+		}
+		ids = append(ids, is[1])
+	}
+	sort.Ints(ids)
+
+	res := make([]raritan.Resource, len(ids))
+	for i, id := range ids {
+		res[i] = raritan.Resource{
+			RID:  componentRID(comp.kind, id),
+			Type: comp.resType,
+		}
+	}
+	return res, nil
+}
+
+// sensors of the components by resource id, from walking the units column of the sensor configuration.
+// Sensors in units without a JSON-RPC unit are skipped.
+func (c *Client) sensors(ctx context.Context, comp component) (map[string]raritan.Sensors, error) {
+	vals, err := c.walk(ctx, oid(comp.config, comp.columns.Units))
+	if err != nil {
+		return nil, err
+	}
+	n := comp.ids() + 2
+	sens := map[string]raritan.Sensors{}
+	for idx, v := range vals {
+		is, err := parseIndex(idx)
+		if err != nil || len(is) != n || is[0] != c.conf.PDUID {
+			continue
+		}
+		typ := is[n-1]
+		st, ok := SensorTypes[typ]
+		if !ok || st.Name == "" {
+			continue
+		}
+		rid := componentRID(comp.kind, is[1:n-1]...)
+		res := sensorResource(rid, typ)
+		if _, ok := unit(res.RID, &v); !ok {
+			continue
+		}
+		if sens[rid] == nil {
+			sens[rid] = raritan.Sensors{}
+		}
+		sens[rid][st.Name] = res
+	}
+	return sens, nil
+}
+
+// componentInfo common to inlets, outlets and overcurrent protectors
+type componentInfo struct {
+	raritan.Resource
+	label   string
+	name    string
+	vals    map[string]*gosnmp.SnmpPDU
+	sensors raritan.Sensors
+}
+
+// info of the components with the label, name and extra columns of each. Components
+// without a label are skipped, with BulkErrors returned for them.
+func (c *Client) info(ctx context.Context, comp component, res []raritan.Resource, extra ...func(r ref) string) ([]componentInfo, error) {
+	refs := make([]ref, len(res))
+	oids := []string{}
+	for i, r := range res {
+		ref, err := parseRef(r.RID)
+		if err != nil || ref.kind != comp.kind {
+			return nil, fmt.Errorf("not a resource of %s: %q", comp.kind, r.RID)
+		}
+		refs[i] = ref
+		idx := []int{c.conf.PDUID, ref.id}
+		oids = append(oids, oid(comp.label, idx...), oid(comp.name, idx...))
+		for _, e := range extra {
+			oids = append(oids, e(ref))
+		}
+	}
+	if len(oids) == 0 {
+		return []componentInfo{}, nil
+	}
+	vals, err := c.get(ctx, oids)
+	if err != nil {
+		return nil, err
+	}
+	sens, err := c.sensors(ctx, comp)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]componentInfo, 0, len(res))
+	failed := raritan.BulkErrors{}
+	for i, r := range res {
+		idx := []int{c.conf.PDUID, refs[i].id}
+		label := vals[oid(comp.label, idx...)]
+		if label == nil {
+			failed = append(failed, missing(r.RID, oid(comp.label, idx...)))
+			continue
+		}
+		s := sens[r.RID]
+		if s == nil {
+			s = raritan.Sensors{}
+		}
+		infos = append(infos, componentInfo{
+			Resource: r,
+			label:    toString(label),
+			name:     toString(vals[oid(comp.name, idx...)]),
+			vals:     vals,
+			sensors:  s,
+		})
+	}
+	if len(failed) > 0 {
+		return infos, failed
+	}
+	return infos, nil
+}
+
+// missing value of a resource, reported like a failed request of a bulk call
+func missing(rid, oid string) raritan.BulkItemError {
+	return raritan.BulkItemError{
+		RID:    rid,
+		Method: "get",
+		Err:    fmt.Errorf("no such instance %s", oid),
+	}
+}
+
+// GetPDUInlets returns the inlets of the PDU
+func (c *Client) GetPDUInlets(ctx context.Context) ([]raritan.Resource, error) {
+	return c.list(ctx, inlets)
+}
+
+// GetInletsInfo returns info for the inlets, failed ones are skipped with BulkErrors returned for them
+func (c *Client) GetInletsInfo(ctx context.Context, ins []raritan.Resource) ([]raritan.InletInfo, error) {
+	plug := func(r ref) string {
+		return oid(OIDInletPlug, c.conf.PDUID, r.id)
+	}
+	infos, err := c.info(ctx, inlets, ins, plug)
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+	ret := make([]raritan.InletInfo, len(infos))
+	for i, in := range infos {
+		ref, _ := parseRef(in.RID)
+		ret[i] = raritan.InletInfo{
+			Resource: in.Resource,
+			InletMetadata: raritan.InletMetadata{
+				Label:    in.label,
+				PlugType: toString(in.vals[plug(ref)]),
+			},
+			InletSettings: raritan.InletSettings{
+				Name: in.name,
+			},
+			Sensors: in.sensors,
+		}
+	}
+	return ret, err
+}
+
+// GetInletPoles returns the poles for each inlet, in the same order as ins. Inlets without
+// poles have none, poles are labeled by their line like in JSON-RPC.
+func (c *Client) GetInletPoles(ctx context.Context, ins []raritan.Resource) ([][]raritan.InletPole, error) {
+	lines, err := c.walk(ctx, OIDInletPoleLine)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := c.walk(ctx, OIDInletPoleNode)
+	if err != nil {
+		return nil, err
+	}
+	sens, err := c.sensors(ctx, poles)
+	if err != nil {
+		return nil, err
+	}
+
+	// pole indexes by inlet id
+	byInlet := map[int][]int{}
+	for idx := range lines {
+		is, err := parseIndex(idx)
+		if err != nil || len(is) != 3 || is[0] != c.conf.PDUID {
+			continue
+		}
+		byInlet[is[1]] = append(byInlet[is[1]], is[2])
+	}
+
+	ret := make([][]raritan.InletPole, len(ins))
+	for i, in := range ins {
+		r, err := parseRef(in.RID)
+		if err != nil || r.kind != inlets.kind {
+			return nil, fmt.Errorf("not an inlet: %q", in.RID)
+		}
+		ids := byInlet[r.id]
+		sort.Ints(ids)
+		ret[i] = make([]raritan.InletPole, len(ids))
+		for j, id := range ids {
+			idx := fmt.Sprintf("%d.%d.%d", c.conf.PDUID, r.id, id)
+			line, node := lines[idx], nodes[idx]
+			pole := raritan.InletPole{
+				Line:    int(toInt(&line)) - lineL1,
+				NodeID:  int(toInt(&node)),
+				Sensors: sens[componentRID(poles.kind, r.id, id)],
+			}
+			pole.Label = pole.LineName()
+			if pole.Sensors == nil {
+				pole.Sensors = raritan.Sensors{}
+			}
+			ret[i][j] = pole
+		}
+	}
+	return ret, nil
+}
+
+// GetPDUOutlets returns the outlets of the PDU
+func (c *Client) GetPDUOutlets(ctx context.Context) ([]raritan.Resource, error) {
+	return c.list(ctx, outlets)
+}
+
+// GetOutletsInfo returns info for the outlets, failed ones are skipped with BulkErrors returned for them.
+// The power state is read from the outlet state sensor, outlets without one are not available.
+func (c *Client) GetOutletsInfo(ctx context.Context, os []raritan.Resource) ([]raritan.OutletInfo, error) {
+	receptacle := func(r ref) string {
+		return oid(OIDOutletReceptacle, c.conf.PDUID, r.id)
+	}
+	state := func(r ref) string {
+		r.sensorType = sensorTypeOnOff
+		return r.measurementOID(c.conf.PDUID, MeasurementState)
+	}
+	infos, err := c.info(ctx, outlets, os, receptacle, state)
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+	ret := make([]raritan.OutletInfo, len(infos))
+	for i, o := range infos {
+		ref, _ := parseRef(o.RID)
+		s := o.vals[state(ref)]
+		ret[i] = raritan.OutletInfo{
+			Resource: o.Resource,
+			OutletMetadata: raritan.OutletMetadata{
+				Label:          o.label,
+				ReceptacleType: toString(o.vals[receptacle(ref)]),
+			},
+			OutletSettings: raritan.OutletSettings{
+				Name: o.name,
+			},
+			OutletState: raritan.OutletState{
+				Available:  s != nil && toInt(s) != StateUnavailable,
+				PowerState: uint(stateValue(toInt(s))),
+			},
+			Sensors: o.sensors,
+		}
+	}
+	return ret, err
+}
+
+// GetPDUOCP returns the overcurrent protectors of the PDU
+func (c *Client) GetPDUOCP(ctx context.Context) ([]raritan.Resource, error) {
+	return c.list(ctx, ocps)
+}
+
+// GetOCPInfo returns info for the overcurrent protectors, failed ones are skipped with BulkErrors returned for them
+func (c *Client) GetOCPInfo(ctx context.Context, res []raritan.Resource) ([]raritan.OCPInfo, error) {
+	infos, err := c.info(ctx, ocps, res)
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+	ret := make([]raritan.OCPInfo, len(infos))
+	for i, o := range infos {
+		ret[i] = raritan.OCPInfo{
+			Resource: o.Resource,
+			OCPMetadata: raritan.OCPMetadata{
+				Label: o.label,
+			},
+			OCPSettings: raritan.OCPSettings{
+				Name: o.name,
+			},
+			Sensors: o.sensors,
+		}
+	}
+	return ret, err
+}
+
+// GetPDUPeripheralSlots returns a slot per external sensor of the PDU
+func (c *Client) GetPDUPeripheralSlots(ctx context.Context) ([]raritan.Resource, error) {
+	vals, err := c.walk(ctx, oid(OIDExternalSensorConfiguration, ExternalSensorType))
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for idx := range vals {
+		is, err := parseIndex(idx)
+		if err != nil || len(is) != 2 || is[0] != c.conf.PDUID {
+			continue
+		}
+		ids = append(ids, is[1])
+	}
+	sort.Ints(ids)
+
+	res := make([]raritan.Resource, len(ids))
+	for i, id := range ids {
+		res[i] = raritan.Resource{
+			RID:  componentRID(externals.kind, id),
+			Type: externals.resType,
+		}
+	}
+	return res, nil
+}
+
+// GetPeripheralsInfo returns info for the external sensors, those of unknown types are skipped.
+// Failed ones are skipped too, with BulkErrors returned for them.
+func (c *Client) GetPeripheralsInfo(ctx context.Context, slots []raritan.Resource) ([]raritan.PeripheralInfo, error) {
+	columns := []int{
+		ExternalSensorType, ExternalSensorSerialNumber, ExternalSensorName, ExternalSensorDescription,
+		ExternalSensorChannel, ExternalSensorPort, externals.columns.Units,
+	}
+	refs := make([]ref, len(slots))
+	oids := []string{}
+	for i, s := range slots {
+		r, err := parseRef(s.RID)
+		if err != nil || r.kind != externals.kind {
+			return nil, fmt.Errorf("not an external sensor: %q", s.RID)
+		}
+		refs[i] = r
+		for _, col := range columns {
+			oids = append(oids, r.configOID(c.conf.PDUID, col))
+		}
+	}
+	if len(oids) == 0 {
+		return []raritan.PeripheralInfo{}, nil
+	}
+	vals, err := c.get(ctx, oids)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []raritan.PeripheralInfo{}
+	failed := raritan.BulkErrors{}
+	for i, s := range slots {
+		r := refs[i]
+		col := func(column int) *gosnmp.SnmpPDU {
+			return vals[r.configOID(c.conf.PDUID, column)]
+		}
+		typ := col(ExternalSensorType)
+		if typ == nil {
+			failed = append(failed, missing(s.RID, r.configOID(c.conf.PDUID, ExternalSensorType)))
+			continue
+		}
+		st, ok := SensorTypes[int(toInt(typ))]
+		if !ok || st.Type == 0 {
+			continue
+		}
+		dev := sensorResource(s.RID, int(toInt(typ)))
+		u, ok := unit(dev.RID, col(externals.columns.Units))
+		if !ok {
+			continue
+		}
+		spec := raritan.SensorTypeSpec{
+			Type: st.Type,
+			Unit: u,
+		}
+		if !st.Numeric {
+			spec.Readingtype = 1
+		}
+		infos = append(infos, raritan.PeripheralInfo{
+			Resource: s,
+			PeripheralDevice: raritan.PeripheralDevice{
+				DeviceID: raritan.PeripheralDeviceID{
+					Serial:  toString(col(ExternalSensorSerialNumber)),
+					Type:    spec,
+					Channel: int(toInt(col(ExternalSensorChannel))),
+				},
+				Position: externalPosition(toString(col(ExternalSensorPort))),
+				Device:   &dev,
+			},
+			PeripheralSettings: raritan.PeripheralSettings{
+				Name:        toString(col(ExternalSensorName)),
+				Description: toString(col(ExternalSensorDescription)),
+			},
+		})
+	}
+	if len(failed) > 0 {
+		return infos, failed
+	}
+	return infos, nil
+}
+
+// externalPosition from the port of an external sensor, e.g. "1" or "1-2" behind a hub
+func externalPosition(port string) []raritan.PeripheralPosition {
+	if port == "" {
+		return nil
+	}
+	parts := strings.Split(port, "-")
+	pos := make([]raritan.PeripheralPosition, len(parts))
+	for i, p := range parts {
+		pos[i] = raritan.PeripheralPosition{
+			PortType: raritan.PortTypeHubPort,
+			Port:     p,
+		}
+	}
+	pos[0].PortType = raritan.PortTypeDevicePort
+	return pos
+}
+
+// GetPDUInfo returns the nameplate and name of the PDU
+func (c *Client) GetPDUInfo(ctx context.Context) (*raritan.PDUInfo, error) {
+	oids := []string{
+		oid(OIDPDUManufacturer, c.conf.PDUID),
+		oid(OIDPDUModel, c.conf.PDUID),
+		oid(OIDPDUSerialNumber, c.conf.PDUID),
+		oid(OIDPDUMACAddress, c.conf.PDUID),
+		oid(OIDPDUName, c.conf.PDUID),
+	}
+	vals, err := c.get(ctx, oids)
+	if err != nil {
+		return nil, err
+	}
+	if vals[oids[1]] == nil {
+		return nil, fmt.Errorf("no PDU %d on %s", c.conf.PDUID, c.Address())
+	}
+
+	// the firmware of the main controller, board type 1
+	boards, err := c.walk(ctx, oid(OIDBoardFirmwareVersion, c.conf.PDUID, 1))
+	if err != nil {
+		return nil, err
+	}
+	firmware := ""
+	for _, v := range boards {
+		firmware = toString(&v)
+		break
+	}
+
+	info := &raritan.PDUInfo{}
+	info.Nameplate = raritan.PDUNameplate{
+		Manufacturer: toString(vals[oids[0]]),
+		Model:        toString(vals[oids[1]]),
+		SerialNumber: toString(vals[oids[2]]),
+	}
+	if mac := toBytes(vals[oids[3]]); len(mac) == 6 {
+		info.MacAddress = net.HardwareAddr(mac).String()
+	} else {
+		info.MacAddress = toString(vals[oids[3]])
+	}
+	info.FwRevision = firmware
+	info.Name = toString(vals[oids[4]])
+	return info, nil
+}
+
+// GetSNMPInfo returns the system group of the agent
+func (c *Client) GetSNMPInfo(ctx context.Context) (*raritan.SNMPInfo, error) {
+	vals, err := c.get(ctx, []string{OIDSysContact, OIDSysName, OIDSysLocation})
+	if err != nil {
+		return nil, err
+	}
+	return &raritan.SNMPInfo{
+		SNMPConfiguration: raritan.SNMPConfiguration{
+			SysContact:  toString(vals[OIDSysContact]),
+			SysName:     toString(vals[OIDSysName]),
+			SysLocation: toString(vals[OIDSysLocation]),
+			V2Enabled:   c.snmp.Version == gosnmp.Version2c,
+			V3Enabled:   c.snmp.Version == gosnmp.Version3,
+		},
+	}, nil
+}
@@ -0,0 +1,244 @@
+package snmp
+
+import (
+	"github.com/gosnmp/gosnmp"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+	"k8s.io/klog/v2"
+)
+
+// OIDs of the SNMPv2-MIB system group
+const (
+	OIDSysUpTime   = ".1.3.6.1.2.1.1.3.0"
+	OIDSysContact  = ".1.3.6.1.2.1.1.4.0"
+	OIDSysName     = ".1.3.6.1.2.1.1.5.0"
+	OIDSysLocation = ".1.3.6.1.2.1.1.6.0"
+)
+
+// OIDs of the Raritan PDU2-MIB, tables are given by their entry
+const (
+	OIDPDU2 = ".1.3.6.1.4.1.13742.6"
+
+	// nameplateEntry indexed by pduId
+	OIDPDUManufacturer = OIDPDU2 + ".3.2.1.1.2"
+	OIDPDUModel        = OIDPDU2 + ".3.2.1.1.3"
+	OIDPDUSerialNumber = OIDPDU2 + ".3.2.1.1.4"
+	// unitConfigurationEntry indexed by pduId
+	OIDPDUMACAddress = OIDPDU2 + ".3.2.2.1.11"
+	OIDPDUName       = OIDPDU2 + ".3.2.2.1.13"
+	// boardEntry indexed by pduId, boardType and boardIndex
+	OIDBoardFirmwareVersion = OIDPDU2 + ".3.2.3.1.6"
+
+	// inletConfigurationEntry indexed by pduId and inletId
+	OIDInletLabel = OIDPDU2 + ".3.3.3.1.2"
+	OIDInletName  = OIDPDU2 + ".3.3.3.1.3"
+	OIDInletPlug  = OIDPDU2 + ".3.3.3.1.4"
+	// inletSensorConfigurationEntry indexed by pduId, inletId and sensorType
+	OIDInletSensorConfiguration = OIDPDU2 + ".3.3.4.1"
+	// inletPoleConfigurationEntry indexed by pduId, inletId and inletPoleIndex
+	OIDInletPoleLine = OIDPDU2 + ".3.3.5.1.2"
+	OIDInletPoleNode = OIDPDU2 + ".3.3.5.1.3"
+	// inletPoleSensorConfigurationEntry indexed by pduId, inletId, inletPoleIndex and sensorType
+	OIDInletPoleSensorConfiguration = OIDPDU2 + ".3.3.6.1"
+
+	// overCurrentProtectorConfigurationEntry indexed by pduId and overCurrentProtectorIndex
+	OIDOCPLabel = OIDPDU2 + ".3.4.3.1.2"
+	OIDOCPName  = OIDPDU2 + ".3.4.3.1.3"
+	// overCurrentProtectorSensorConfigurationEntry indexed by pduId, overCurrentProtectorIndex and sensorType
+	OIDOCPSensorConfiguration = OIDPDU2 + ".3.4.4.1"
+
+	// outletConfigurationEntry indexed by pduId and outletId
+	OIDOutletLabel      = OIDPDU2 + ".3.5.3.1.2"
+	OIDOutletName       = OIDPDU2 + ".3.5.3.1.3"
+	OIDOutletReceptacle = OIDPDU2 + ".3.5.3.1.4"
+	// outletSensorConfigurationEntry indexed by pduId, outletId and sensorType
+	OIDOutletSensorConfiguration = OIDPDU2 + ".3.5.4.1"
+
+	// externalSensorConfigurationEntry indexed by pduId and sensorID
+	OIDExternalSensorConfiguration = OIDPDU2 + ".3.6.3.1"
+
+	// measurement entries, indexed like their configuration
+	OIDInletSensorMeasurements     = OIDPDU2 + ".5.2.3.1"
+	OIDInletPoleSensorMeasurements = OIDPDU2 + ".5.2.4.1"
+	OIDOCPSensorMeasurements       = OIDPDU2 + ".5.3.3.1"
+	OIDOutletSensorMeasurements    = OIDPDU2 + ".5.4.3.1"
+	OIDExternalSensorMeasurements  = OIDPDU2 + ".5.5.3.1"
+)
+
+// SensorColumns of a sensor configuration entry
+type SensorColumns struct {
+	Units                  int
+	DecimalDigits          int
+	Accuracy               int
+	Resolution             int
+	Tolerance              int
+	Maximum                int
+	Minimum                int
+	Hysteresis             int
+	StateChangeDelay       int
+	LowerCriticalThreshold int
+	LowerWarningThreshold  int
+	UpperCriticalThreshold int
+	UpperWarningThreshold  int
+	EnabledThresholds      int
+}
+
+// Columns of the sensor configuration and measurement entries
+var (
+	// ComponentSensorColumns of inlet, inlet pole, overcurrent protector and outlet sensors
+	ComponentSensorColumns = SensorColumns{
+		Units: 6, DecimalDigits: 7, Accuracy: 8, Resolution: 9, Tolerance: 10, Maximum: 11, Minimum: 12,
+		Hysteresis: 13, StateChangeDelay: 14, LowerCriticalThreshold: 21, LowerWarningThreshold: 22,
+		UpperCriticalThreshold: 23, UpperWarningThreshold: 24, EnabledThresholds: 25,
+	}
+	// ExternalSensorColumns of external sensors, which are configured in the same table as their device
+	ExternalSensorColumns = SensorColumns{
+		Units: 16, DecimalDigits: 17, Accuracy: 18, Resolution: 19, Tolerance: 20, Maximum: 21, Minimum: 22,
+		Hysteresis: 23, StateChangeDelay: 24, LowerCriticalThreshold: 31, LowerWarningThreshold: 32,
+		UpperCriticalThreshold: 33, UpperWarningThreshold: 34, EnabledThresholds: 35,
+	}
+)
+
+// Columns of externalSensorConfigurationEntry describing the device
+const (
+	ExternalSensorType         = 2
+	ExternalSensorSerialNumber = 3
+	ExternalSensorName         = 4
+	ExternalSensorDescription  = 5
+	ExternalSensorChannel      = 9
+	ExternalSensorPort         = 10
+)
+
+// Columns of the sensor measurement entries
+const (
+	MeasurementIsAvailable = 2
+	MeasurementState       = 3
+	MeasurementValue       = 4
+	MeasurementTimeStamp   = 5
+)
+
+// Bits of the enabled thresholds, BITS are numbered from the most significant bit of the first octet
+const (
+	ThresholdLowerCritical = 0x80
+	ThresholdLowerWarning  = 0x40
+	ThresholdUpperWarning  = 0x20
+	ThresholdUpperCritical = 0x10
+)
+
+// Values of sensorStateEnumeration used by the exporter
+const (
+	StateUnavailable        = -1
+	StateBelowLowerCritical = 2
+	StateBelowLowerWarning  = 3
+	StateNormal             = 4
+	StateAboveUpperWarning  = 5
+	StateAboveUpperCritical = 6
+	StateOn                 = 7
+	StateOff                = 8
+)
+
+// stateValues of discrete sensor states as JSON-RPC reports them, other states are
+// reported as their sensorStateEnumeration value
+var stateValues = map[int64]float64{
+	0:        0, // open
+	1:        1, // closed
+	StateOn:  1,
+	StateOff: 0,
+	9:        1, // detected
+	10:       0, // notDetected
+	11:       1, // alarmed
+	12:       0, // ok
+	15:       1, // yes
+	16:       0, // no
+}
+
+func stateValue(state int64) float64 {
+	if v, ok := stateValues[state]; ok {
+		return v
+	}
+	return float64(state)
+}
+
+// SensorType of sensorTypeEnumeration
+type SensorType struct {
+	// Name of the sensor in the JSON-RPC API, empty for external sensors
+	Name string
+	// Numeric sensors report a value, others only a state
+	Numeric bool
+	// Type of the sensor in the JSON-RPC API, used to name external sensors
+	Type int
+}
+
+// sensorTypeOnOff of the outlet state sensor
+const sensorTypeOnOff = 14
+
+// SensorTypes of sensorTypeEnumeration, others are skipped
+var SensorTypes = map[int]SensorType{
+	1:  {Name: "current", Numeric: true, Type: 2},
+	2:  {Name: "peakCurrent", Numeric: true, Type: 2},
+	3:  {Name: "unbalancedCurrent", Numeric: true, Type: 3},
+	4:  {Name: "voltage", Numeric: true, Type: 1},
+	5:  {Name: "activePower", Numeric: true, Type: 4},
+	6:  {Name: "apparentPower", Numeric: true, Type: 4},
+	7:  {Name: "powerFactor", Numeric: true, Type: 5},
+	8:  {Name: "activeEnergy", Numeric: true, Type: 6},
+	9:  {Name: "apparentEnergy", Numeric: true, Type: 6},
+	10: {Numeric: true, Type: 8},
+	11: {Numeric: true, Type: 9},
+	12: {Numeric: true, Type: 10},
+	13: {Numeric: true, Type: 11},
+	14: {Name: "outletState", Type: 13},
+	15: {Name: "trip", Type: 14},
+	16: {Type: 15},
+	17: {Type: 16},
+	18: {Type: 17},
+	20: {Type: 12},
+	22: {Name: "surgeProtectorStatus"},
+	23: {Name: "lineFrequency", Numeric: true, Type: 7},
+	24: {Name: "phaseAngle", Numeric: true},
+	25: {Name: "voltageLN", Numeric: true, Type: 1},
+	26: {Name: "residualCurrent", Numeric: true, Type: 2},
+	27: {Name: "residualCurrentStatus"},
+	28: {Numeric: true, Type: 20},
+	29: {Name: "reactivePower", Numeric: true, Type: 4},
+	32: {Name: "powerQuality"},
+	35: {Name: "displacementPowerFactor", Numeric: true, Type: 5},
+}
+
+// lineL1 of lineEnumeration, the lines follow in the order of the JSON-RPC power lines
+const lineL1 = 1
+
+// Units of sensorUnitsEnumeration mapped to the JSON-RPC units, sensors in other units are skipped
+var Units = map[int]int{
+	-1: raritan.UnitNone,
+	1:  raritan.UnitVolt,
+	2:  raritan.UnitAmpere,
+	3:  raritan.UnitWatt,
+	4:  raritan.UnitVoltAmp,
+	5:  raritan.UnitWattHour,
+	6:  raritan.UnitVoltAmpHour,
+	7:  raritan.UnitDegreeCelsius,
+	8:  raritan.UnitHz,
+	9:  raritan.UnitPercent,
+	10: raritan.UnitMeterPerSec,
+	11: raritan.UnitPascal,
+	13: raritan.UnitG,
+	18: raritan.UnitMeter,
+	19: raritan.UnitRPM,
+	20: raritan.UnitDegree,
+	23: raritan.UnitVoltAmpReactive,
+	24: raritan.UnitVoltAmpReactiveHour,
+	25: raritan.UnitGramPerCubicMeter,
+}
+
+// unit of a sensor from its sensorUnitsEnumeration value, units without a JSON-RPC unit are logged and not ok
+func unit(rid string, v *gosnmp.SnmpPDU) (int, bool) {
+	if v == nil {
+		klog.Warningf("Skipping sensor %s without units", rid)
+		return 0, false
+	}
+	u, ok := Units[int(toInt(v))]
+	if !ok {
+		klog.Warningf("Skipping sensor %s in units %d without a JSON-RPC unit", rid, toInt(v))
+	}
+	return u, ok
+}
@@ -0,0 +1,243 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/tanenbaum/raritan-pdu-exporter/internal/raritan"
+)
+
+// row of values read for a sensor, in the order of the requested OIDs
+type row []*gosnmp.SnmpPDU
+
+// rows of the sensors accepted by want, nil for the others. Sensors missing the first
+// OID are nil too, with BulkErrors returned for them.
+func (c *Client) rows(ctx context.Context, sens []raritan.Resource, want func(raritan.Resource) bool, oids func(r ref) []string) ([]row, error) {
+	refs := make([]*ref, len(sens))
+	all := []string{}
+	for i, s := range sens {
+		if !want(s) {
+			continue
+		}
+		r, err := parseRef(s.RID)
+		if err != nil || r.sensorType == 0 {
+			return nil, fmt.Errorf("not a sensor: %q", s.RID)
+		}
+		refs[i] = &r
+		all = append(all, oids(r)...)
+	}
+
+	rows := make([]row, len(sens))
+	if len(all) == 0 {
+		return rows, nil
+	}
+	vals, err := c.get(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := raritan.BulkErrors{}
+	for i, r := range refs {
+		if r == nil {
+			continue
+		}
+		os := oids(*r)
+		if vals[os[0]] == nil {
+			failed = append(failed, missing(sens[i].RID, os[0]))
+			continue
+		}
+		rows[i] = make(row, len(os))
+		for j, o := range os {
+			rows[i][j] = vals[o]
+		}
+	}
+	if len(failed) > 0 {
+		return rows, failed
+	}
+	return rows, nil
+}
+
+func isNumericSensor(res raritan.Resource) bool {
+	k, ok := raritan.LookupSensorKind(res.Type)
+	return ok && k.Numeric
+}
+
+// GetSensorReadings returns readings in the same order as sens. Failed readings are
+// not available, with BulkErrors returned for them. Numeric values are scaled by the
+// decimal digits of the sensor, discrete states are mapped to their JSON-RPC values.
+func (c *Client) GetSensorReadings(ctx context.Context, sens []raritan.Resource) ([]raritan.Reading, error) {
+	rows, err := c.rows(ctx, sens, raritan.IsKnownSensor, func(r ref) []string {
+		return []string{
+			r.measurementOID(c.conf.PDUID, MeasurementIsAvailable),
+			r.measurementOID(c.conf.PDUID, MeasurementState),
+			r.measurementOID(c.conf.PDUID, MeasurementValue),
+			r.measurementOID(c.conf.PDUID, MeasurementTimeStamp),
+			r.configOID(c.conf.PDUID, r.columns.DecimalDigits),
+		}
+	})
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+
+	rs := make([]raritan.Reading, len(sens))
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		available, state, value, timestamp, digits := row[0], row[1], row[2], row[3], row[4]
+		if toInt(available) != 1 || state == nil || toInt(state) == StateUnavailable {
+			continue
+		}
+		r := raritan.Reading{
+			Timestamp: uint(toInt(timestamp)),
+			Available: true,
+		}
+		if isNumericSensor(sens[i]) {
+			if value == nil {
+				continue
+			}
+			r.Value = scaled(value, int(toInt(digits)))
+			r.Status = readingStatus(toInt(state))
+		} else {
+			r.Value = stateValue(toInt(state))
+		}
+		rs[i] = r
+	}
+	return rs, err
+}
+
+// readingStatus of a numeric sensor state, crossing a critical threshold implies the warning one
+func readingStatus(state int64) raritan.ReadingStatus {
+	return raritan.ReadingStatus{
+		AboveUpperCritical: state == StateAboveUpperCritical,
+		AboveUpperWarning:  state == StateAboveUpperCritical || state == StateAboveUpperWarning,
+		BelowLowerWarning:  state == StateBelowLowerCritical || state == StateBelowLowerWarning,
+		BelowLowerCritical: state == StateBelowLowerCritical,
+	}
+}
+
+// GetSensorsMetadata returns metadata in the same order as sens, nil for sensors without metadata
+// or in units without a JSON-RPC unit.
+// Failed sensors are nil too, with BulkErrors returned for them.
+func (c *Client) GetSensorsMetadata(ctx context.Context, sens []raritan.Resource) ([]*raritan.SensorMetadata, error) {
+	rows, err := c.rows(ctx, sens, isNumericSensor, func(r ref) []string {
+		cols := r.columns
+		return []string{
+			r.configOID(c.conf.PDUID, cols.Units),
+			r.configOID(c.conf.PDUID, cols.DecimalDigits),
+			r.configOID(c.conf.PDUID, cols.Accuracy),
+			r.configOID(c.conf.PDUID, cols.Resolution),
+			r.configOID(c.conf.PDUID, cols.Tolerance),
+			r.configOID(c.conf.PDUID, cols.Minimum),
+			r.configOID(c.conf.PDUID, cols.Maximum),
+			r.configOID(c.conf.PDUID, cols.EnabledThresholds),
+		}
+	})
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+
+	ms := make([]*raritan.SensorMetadata, len(sens))
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		ref, _ := parseRef(sens[i].RID)
+		units, digits, accuracy, resolution, tolerance, min, max, enabled := row[0], row[1], row[2], row[3], row[4], row[5], row[6], row[7]
+		u, ok := unit(sens[i].RID, units)
+		if !ok {
+			continue
+		}
+		d := int(toInt(digits))
+		m := &raritan.SensorMetadata{
+			Type: raritan.SensorTypeSpec{
+				Type: SensorTypes[ref.sensorType].Type,
+				Unit: u,
+			},
+			Decdigits: d,
+		}
+		// accuracy is given in hundredths of a percent
+		if accuracy != nil {
+			m.Accuracy = float64(toInt(accuracy)) / 100
+		}
+		if resolution != nil {
+			m.Resolution = scaled(resolution, d)
+		}
+		if tolerance != nil {
+			m.Tolerance = scaled(tolerance, d)
+		}
+		if min != nil {
+			m.Range.Min = scaled(min, d)
+		}
+		if max != nil {
+			m.Range.Max = scaled(max, d)
+		}
+		bits := enabledThresholds(enabled)
+		m.ThresholdCaps.HasUpperCritical = bits&ThresholdUpperCritical != 0
+		m.ThresholdCaps.HasUpperWarning = bits&ThresholdUpperWarning != 0
+		m.ThresholdCaps.HasLowerWarning = bits&ThresholdLowerWarning != 0
+		m.ThresholdCaps.HasLowerCritical = bits&ThresholdLowerCritical != 0
+		ms[i] = m
+	}
+	return ms, err
+}
+
+// GetSensorsThresholds returns thresholds in the same order as sens, nil for sensors without thresholds.
+// Failed sensors are nil too, with BulkErrors returned for them.
+func (c *Client) GetSensorsThresholds(ctx context.Context, sens []raritan.Resource) ([]*raritan.SensorThresholds, error) {
+	rows, err := c.rows(ctx, sens, isNumericSensor, func(r ref) []string {
+		cols := r.columns
+		return []string{
+			r.configOID(c.conf.PDUID, cols.EnabledThresholds),
+			r.configOID(c.conf.PDUID, cols.DecimalDigits),
+			r.configOID(c.conf.PDUID, cols.UpperCriticalThreshold),
+			r.configOID(c.conf.PDUID, cols.UpperWarningThreshold),
+			r.configOID(c.conf.PDUID, cols.LowerWarningThreshold),
+			r.configOID(c.conf.PDUID, cols.LowerCriticalThreshold),
+			r.configOID(c.conf.PDUID, cols.Hysteresis),
+			r.configOID(c.conf.PDUID, cols.StateChangeDelay),
+		}
+	})
+	if err != nil && !raritan.IsPartial(err) {
+		return nil, err
+	}
+
+	ts := make([]*raritan.SensorThresholds, len(sens))
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		enabled, digits, uc, uw, lw, lc, hysteresis, delay := row[0], row[1], row[2], row[3], row[4], row[5], row[6], row[7]
+		d := int(toInt(digits))
+		value := func(v *gosnmp.SnmpPDU) float64 {
+			if v == nil {
+				return 0
+			}
+			return scaled(v, d)
+		}
+		bits := enabledThresholds(enabled)
+		ts[i] = &raritan.SensorThresholds{
+			UpperCriticalActive:   bits&ThresholdUpperCritical != 0 && uc != nil,
+			UpperCritical:         value(uc),
+			UpperWarningActive:    bits&ThresholdUpperWarning != 0 && uw != nil,
+			UpperWarning:          value(uw),
+			LowerWarningActive:    bits&ThresholdLowerWarning != 0 && lw != nil,
+			LowerWarning:          value(lw),
+			LowerCriticalActive:   bits&ThresholdLowerCritical != 0 && lc != nil,
+			LowerCritical:         value(lc),
+			AssertionTimeout:      int(toInt(delay)),
+			DeassertionHysteresis: value(hysteresis),
+		}
+	}
+	return ts, err
+}
+
+// enabledThresholds from the first octet of the BITS value
+func enabledThresholds(v *gosnmp.SnmpPDU) byte {
+	b := toBytes(v)
+	if len(b) == 0 {
+		return 0
+	}
+	return b[0]
+}